@@ -3,19 +3,31 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/internal/broker"
 	"github.com/Salam4nder/chat/internal/chat"
+	"github.com/Salam4nder/chat/internal/cluster"
 	"github.com/Salam4nder/chat/internal/config"
 	"github.com/Salam4nder/chat/internal/db/cql"
 	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
 	"github.com/Salam4nder/chat/internal/event"
-	"github.com/Salam4nder/chat/internal/http/handler/health"
+	"github.com/Salam4nder/chat/internal/health"
+	"github.com/Salam4nder/chat/internal/http/handler/admin"
+	healthhandler "github.com/Salam4nder/chat/internal/http/handler/health"
+	"github.com/Salam4nder/chat/internal/http/handler/webhook"
 	"github.com/Salam4nder/chat/internal/http/handler/websocket"
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/Salam4nder/chat/internal/tracing"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -33,6 +45,11 @@ const (
 	// writes of the response. It is reset whenever a new
 	// request's header is read.
 	httpWriteTimeout = 10 * time.Second
+	// adminWriteTimeout is longer than httpWriteTimeout because
+	// erasing a user can take minutes.
+	adminWriteTimeout = 6 * time.Minute
+	// shutdownReason is sent to sessions in the going-away close frame.
+	shutdownReason = "server shutting down, reconnect"
 	// environmentDev is the development environment.
 	environmentDev = "dev"
 )
@@ -46,49 +63,242 @@ func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	config, err := config.New()
 	exitOnError(err)
+	// The node ID names the embedded NATS server and the subjects
+	// the node is reached on, so it is checked before either starts.
+	if config.Cluster.NodeID != "" {
+		exitOnError(cluster.ValidateNodeID(config.Cluster.NodeID))
+	}
 	if config.Environment == environmentDev {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
+	log.Logger = log.Logger.Hook(tracing.LogHook{})
 	go config.Watch()
 
+	// Tracing.
+	shutdownTracing, err := tracing.Setup(context.Background(), config.ServiceName, config.Tracing)
+	exitOnError(err)
+
+	// Readiness checks.
+	healthRegistry := health.NewRegistry(config.ServiceName)
+
 	// ScyllaDB.
-	cluster := cql.NewClusterConfig(config.ScyllaDB)
-	err = cluster.PingWithTimeout(scyllaTimeout, interruptCh)
+	scyllaCluster := cql.NewClusterConfig(config.ScyllaDB)
+	err = scyllaCluster.PingWithTimeout(scyllaTimeout, interruptCh)
 	exitOnError(err)
-	scyllaSession, err := cluster.Inner().CreateSession()
+	scyllaSession, err := scyllaCluster.Inner().CreateSession()
 	exitOnError(err)
 
-	// NATS.
-	natsClient, err := nats.Connect(
-		config.NATS.Addr(),
-		nats.Timeout(natsTimeout),
-		nats.RetryOnFailedConnect(true),
-		nats.MaxReconnects(20),
-	)
+	err = healthRegistry.Register("scylla", cql.HealthCheck(scyllaSession))
 	exitOnError(err)
 
+	// Broker, NATS unless a single node runs in memory.
+	var (
+		msgBroker  broker.Broker
+		natsClient *nats.Conn
+		natsServer *server.Server
+	)
+	switch config.Broker {
+	case broker.KindNATS, "":
+		natsURL := config.NATS.Addr()
+		if config.NATS.Embedded {
+			natsServer, err = startEmbeddedNATS(config.NATS, config.Cluster.NodeID)
+			exitOnError(err)
+			natsURL = natsServer.ClientURL()
+			log.Info().Str("url", natsURL).Msg("main: started embedded nats server")
+		}
+		natsOptions := append([]nats.Option{
+			nats.Timeout(natsTimeout),
+			nats.RetryOnFailedConnect(true),
+			nats.MaxReconnects(20),
+		}, metrics.NATSOptions()...)
+		natsClient, err = nats.Connect(natsURL, natsOptions...)
+		exitOnError(err)
+		msgBroker = broker.NewNATS(natsClient)
+		err = healthRegistry.Register("nats", natsCheck(natsClient))
+		exitOnError(err)
+	case broker.KindMemory:
+		msgBroker = broker.NewMemory()
+	default:
+		exitOnError(fmt.Errorf("%w: %q", broker.ErrKindInvalid, config.Broker))
+	}
+
 	// Repos.
-	// userRepo := db.NewScyllaUserRepository(scyllaSession)
+	userRepo := db.NewScyllaUserRepository(scyllaSession)
 	messageRepo := db.NewScyllaMessageRepository(scyllaSession)
+	webhookRepo := db.NewScyllaWebhookRepository(scyllaSession)
+	serviceAccountRepo := db.NewScyllaServiceAccountRepository(scyllaSession)
+	roomPolicyRepo := db.NewScyllaRoomPolicyRepository(scyllaSession)
+	outboxRepo := db.NewScyllaOutboxRepository(scyllaSession)
+	eventRepo := db.NewScyllaEventRepository(scyllaSession)
+	roomRoleRepo := db.NewScyllaRoomRoleRepository(scyllaSession)
 
 	// In-memory event registry.
-	eventRegistry := event.NewRegistry()
+	eventRegistry := event.NewRegistry(event.Config{
+		Workers:   config.Events.Workers,
+		QueueSize: config.Events.QueueSize,
+	})
+
+	// Slash commands.
+	commandRegistry := chat.NewCommandRegistry()
+	err = chat.RegisterBuiltinCommands(commandRegistry, userRepo)
+	exitOnError(err)
+
+	wireFormat, err := wire.ParseFormat(config.WireFormat)
+	exitOnError(err)
+
+	// Cluster control plane, started once every op is registered.
+	controlPlane, err := cluster.New(msgBroker, cluster.Config{
+		NodeID:            config.Cluster.NodeID,
+		HeartbeatInterval: config.Cluster.HeartbeatInterval,
+		Timeout:           config.Cluster.RequestTimeout,
+		Format:            wireFormat,
+	})
+	exitOnError(err)
+
+	// Room traffic between nodes.
+	wireCodec := chat.Wire{Format: wireFormat, NodeID: controlPlane.NodeID()}
+
+	// Room messages go through a durable stream if enabled,
+	// the broker otherwise.
+	var (
+		messagePublisher chat.Publisher = msgBroker
+		roomStream       *chat.RoomStream
+	)
+	if config.NATS.JetStream.Enabled {
+		if natsClient == nil {
+			exitOnError(errors.New("main: jetStream needs the nats broker"))
+		}
+		// A random node ID would leave the durable consumer behind on
+		// restart and start a new one that misses what came in between.
+		if config.Cluster.NodeID == "" {
+			exitOnError(errors.New("main: jetStream needs cluster.nodeID"))
+		}
+		roomStream, err = chat.NewRoomStream(natsClient, chat.StreamConfig{
+			Name:              config.NATS.JetStream.Stream,
+			MaxAge:            config.NATS.JetStream.MaxAge,
+			MaxBytes:          config.NATS.JetStream.MaxBytes,
+			Replicas:          config.NATS.JetStream.Replicas,
+			Consumer:          controlPlane.NodeID(),
+			AckWait:           config.NATS.JetStream.AckWait,
+			InactiveThreshold: config.NATS.JetStream.InactiveThreshold,
+			ResumeLimit:       config.NATS.JetStream.ResumeLimit,
+		})
+		exitOnError(err)
+		messagePublisher = roomStream
+	}
 
 	// Services.
-	messageService := chat.NewMessageService(messageRepo, natsClient)
-	sessionService := chat.NewSessionService(natsClient, eventRegistry)
+	deadLetters := event.NewDeadLetters(config.Events.DeadLetters)
+	authService := auth.NewService(serviceAccountRepo)
+	retentionService := chat.NewRetentionService(
+		roomPolicyRepo,
+		messageRepo,
+		config.Retention.DefaultDays,
+	)
+	messageService := chat.NewMessageService(
+		messageRepo,
+		outboxRepo,
+		messagePublisher,
+		retentionService,
+		wireCodec,
+		chat.ChatRomoms,
+		config.Idempotency.Window,
+	)
+	erasureService, err := chat.NewErasureService(
+		messageRepo,
+		userRepo,
+		eventRepo,
+		outboxRepo,
+		roomRoleRepo,
+		roomStream,
+		retentionService,
+		deadLetters,
+		controlPlane,
+		[]byte(config.Erasure.ReportKey),
+	)
+	exitOnError(err)
+	adminService := chat.NewAdminService(controlPlane, chat.ChatRomoms, roomRoleRepo)
+	interactionService := chat.NewInteractionService(msgBroker, wireCodec)
+	sessionService := chat.NewSessionService(msgBroker, eventRegistry, commandRegistry, roomRoleRepo, roomStream)
+	webhookService := chat.NewWebhookService(
+		webhookRepo,
+		eventRegistry,
+		config.Webhooks.RateLimit,
+		config.Webhooks.Burst,
+	)
 
 	// Subscribers.
-	eventRegistry.Subscribe(chat.SessionConnectedEvent, sessionService.HandleSessionConnectedEvent)
-	eventRegistry.Subscribe(chat.MessageCreatedInRoomEvent, messageService.HandleMessageCreatedInRoomEvent)
+	middleware := func(name string) []event.Middleware {
+		return []event.Middleware{
+			event.Logging(name),
+			event.Metrics(),
+			deadLetters.Middleware(name),
+			event.Retry(event.RetryConfig{
+				Attempts:   config.Events.RetryAttempts,
+				Backoff:    config.Events.RetryBackoff,
+				MaxBackoff: config.Events.RetryMaxBackoff,
+			}),
+			event.Timeout(config.Events.HandlerTimeout),
+			event.Recover(),
+		}
+	}
+	_, sessionErr := event.Subscribe(eventRegistry, chat.SessionConnected,
+		sessionService.HandleSessionConnectedEvent, middleware("session")...)
+	_, messageErr := event.Subscribe(eventRegistry, chat.MessageCreatedInRoom,
+		messageService.HandleMessageCreatedInRoomEvent, middleware("message")...)
+	_, interactionErr := event.Subscribe(eventRegistry, chat.InteractionCreated,
+		interactionService.HandleInteractionCreatedEvent, middleware("interaction")...)
+	err = errors.Join(sessionErr, messageErr, interactionErr)
+	exitOnError(err)
 
-	natsChan := make(chan *nats.Msg, 64)
-	messageSub, err := natsClient.ChanSubscribe(chat.MessageCreatedInRoomEvent, natsChan)
+	// Event store.
+	var eventStore event.Store
+	if config.Events.Store {
+		store := chat.NewEventStore(eventRepo)
+		err = chat.KeepEvents(eventRegistry, store, middleware)
+		exitOnError(err)
+		eventStore = store
+	}
+
+	// Rooms subscribe to their subjects while they have local sessions.
+	// With a room stream, messages come through the durable consumer of
+	// the node instead, which covers every room.
+	roomChan := make(chan *broker.Msg, 64)
+	roomKinds := []string{chat.SubjectInteraction}
+	var messageSub broker.Subscription
+	if roomStream != nil {
+		messageSub, err = roomStream.Subscribe(func(msg *broker.Msg) { roomChan <- msg })
+		exitOnError(err)
+	} else {
+		roomKinds = append(roomKinds, chat.SubjectMessage)
+	}
+	chat.ChatRomoms.SetSubscriber(chat.ChanSubscriber(msgBroker, roomChan, roomKinds...))
+
+	// Concurrent-safe registry of chat rooms.
+	go chat.ChatRomoms.Run(roomChan)
+
+	// Cluster control plane.
+	err = errors.Join(
+		adminService.Register(),
+		erasureService.Register(),
+		retentionService.Register(controlPlane),
+	)
+	exitOnError(err)
+	err = controlPlane.Start()
+	exitOnError(err)
+	err = healthRegistry.Register("cluster", controlPlane.Check)
 	exitOnError(err)
 
-	// Concurrent-safe map of chat rooms.
-	chat.ChatRomoms = make(map[string]*chat.Room)
-	go chat.ChatRomoms.Run(natsChan, interruptCh)
+	// Background jobs.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	go retentionService.Run(jobsCtx, config.Retention.JobInterval)
+	outboxRelay := chat.NewOutboxRelay(
+		outboxRepo,
+		messagePublisher,
+		config.Outbox.RelayDelay,
+		config.Outbox.BatchSize,
+	)
+	go outboxRelay.Run(jobsCtx, config.Outbox.RelayInterval)
 
 	// HTTP server.
 	server := &http.Server{
@@ -97,10 +307,13 @@ func main() {
 		ReadTimeout:  httpReadTimeout,
 		WriteTimeout: httpWriteTimeout,
 	}
-	healthHandler := health.NewHandler(scyllaSession)
-	websocketHandler := websocket.NewHandler(eventRegistry)
-	http.HandleFunc("/health", healthHandler.Health)
+	healthHandler := healthhandler.NewHandler(healthRegistry)
+	websocketHandler := websocket.NewHandler(eventRegistry, authService, healthRegistry)
+	webhookHandler := webhook.NewHandler(webhookService, authService)
+	healthHandler.Register(http.DefaultServeMux)
 	http.HandleFunc("/chat", websocketHandler.HandleConnect)
+	http.HandleFunc("/webhooks", webhookHandler.HandleCreate)
+	http.HandleFunc(webhook.ExecutePath, webhookHandler.HandleExecute)
 	go func() {
 		log.Info().
 			Str("addr", config.HTTPServer.Addr()).
@@ -112,21 +325,80 @@ func main() {
 			}
 		}
 	}()
+
+	// Admin HTTP server, on a separate listener.
+	adminMux := http.NewServeMux()
+	admin.NewHandler(authService, adminService, retentionService, erasureService, deadLetters, eventStore).
+		Register(adminMux)
+	// Metrics are served without authentication so scrapers need no key;
+	// the admin listener should not be exposed publicly.
+	adminMux.Handle(metrics.Path, metrics.Handler())
+	adminServer := &http.Server{
+		Addr:         config.AdminServer.Addr(),
+		Handler:      adminMux,
+		ReadTimeout:  httpReadTimeout,
+		WriteTimeout: adminWriteTimeout,
+	}
+	go func() {
+		log.Info().
+			Str("addr", config.AdminServer.Addr()).
+			Msg("main: serving admin http server...")
+
+		if err := adminServer.ListenAndServe(); err != nil {
+			if !errors.Is(err, http.ErrServerClosed) {
+				exitOnError(err)
+			}
+		}
+	}()
+	healthRegistry.MarkStarted()
 	log.Info().Str("service", config.ServiceName).Send()
 
 	<-interruptCh
 	log.Info().Msg("main: cleaning up...")
-	scyllaSession.Close()
-	if err := messageSub.Unsubscribe(); err != nil {
-		log.Error().Err(err).Msg("main: failed to unsubscribe from nats")
-	}
-	close(natsChan)
-	natsClient.Close()
-	if err = server.Shutdown(context.Background()); err != nil {
+	// Stop receiving traffic first: readiness fails and upgrades are refused.
+	healthRegistry.MarkDraining()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelShutdown()
+	stopJobs()
+
+	// Tell every session to reconnect elsewhere.
+	closed := chat.ChatRomoms.Shutdown(shutdownReason)
+	log.Info().Int("sessions", closed).Msg("main: closed websocket sessions")
+
+	// Finish in-flight requests, then in-flight persists and publishes.
+	if err = server.Shutdown(shutdownCtx); err != nil {
 		log.Error().
 			Err(err).
 			Msg("main: failed to shutdown HTTP server")
 	}
+	if err = adminServer.Shutdown(shutdownCtx); err != nil {
+		log.Error().
+			Err(err).
+			Msg("main: failed to shutdown admin HTTP server")
+	}
+	if err := eventRegistry.Close(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("main: abandoned in-flight events")
+	}
+
+	// Only then release the broker and ScyllaDB.
+	if err := controlPlane.Close(); err != nil {
+		log.Error().Err(err).Msg("main: failed to close cluster control plane")
+	}
+	if messageSub != nil {
+		if err := messageSub.Unsubscribe(); err != nil {
+			log.Error().Err(err).Msg("main: failed to unsubscribe from room stream")
+		}
+	}
+	if err := msgBroker.Drain(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("main: failed to drain broker")
+	}
+	if natsServer != nil {
+		natsServer.Shutdown()
+	}
+	scyllaSession.Close()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("main: failed to flush traces")
+	}
 	log.Info().Msg("main: cleanup finished")
 
 	os.Exit(0)
@@ -140,3 +412,40 @@ func exitOnError(err error) {
 		os.Exit(1)
 	}
 }
+
+// startEmbeddedNATS starts the NATS server configured by cfg in the
+// process, named after the node.
+func startEmbeddedNATS(cfg config.NATS, nodeID string) (*server.Server, error) {
+	port, err := strconv.Atoi(cfg.Port)
+	if err != nil {
+		return nil, fmt.Errorf("main: nats port %q, %w", cfg.Port, err)
+	}
+	var clusterPort int
+	if cfg.Cluster.Port != "" {
+		if clusterPort, err = strconv.Atoi(cfg.Cluster.Port); err != nil {
+			return nil, fmt.Errorf("main: nats cluster port %q, %w", cfg.Cluster.Port, err)
+		}
+	}
+	return broker.StartEmbedded(broker.EmbeddedConfig{
+		Name:     nodeID,
+		Host:     cfg.Host,
+		Port:     port,
+		StoreDir: cfg.StoreDir,
+		Cluster: broker.EmbeddedCluster{
+			Name:   cfg.Cluster.Name,
+			Host:   cfg.Cluster.Host,
+			Port:   clusterPort,
+			Routes: cfg.Cluster.Routes,
+		},
+	})
+}
+
+// natsCheck reports whether the NATS connection is up.
+func natsCheck(nc *nats.Conn) health.Check {
+	return func(context.Context) error {
+		if !nc.IsConnected() {
+			return fmt.Errorf("nats: %s", nc.Status())
+		}
+		return nil
+	}
+}
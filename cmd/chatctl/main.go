@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const timeout = 5 * time.Minute
+
+const usage = `usage: chatctl [-addr url] [-apiKey key] <command> [flags]
+
+The API key defaults to $CHATCTL_API_KEY and needs the admin scope.
+
+commands:
+  nodes
+  cluster-rooms
+  locate     -user <id>
+  rooms
+  sessions   -room <id>
+  announce   -room <id> -text <text>
+  role       -room <id> -user <id>
+  moderator  -room <id> -user <id> [-revoke]
+  disconnect -user <id> [-room <id>] [-reason <text>]
+  erase      -user <id> [-mode delete|anonymise]
+  verify-report -file <report.json>
+  deadletters
+  replay     -id <deadLetterID>
+  discard    -id <deadLetterID>
+  events     -name <event> -from <time> [-to <time>] [-limit <n>]
+`
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	addr := flag.String("addr", "http://localhost:8081", "admin API address")
+	apiKey := flag.String("apiKey", os.Getenv("CHATCTL_API_KEY"), "admin API key")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := client{addr: strings.TrimRight(*addr, "/"), apiKey: *apiKey}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	user := flags.String("user", "", "user ID")
+	room := flags.String("room", "", "room ID")
+	text := flags.String("text", "", "announcement text")
+	reason := flags.String("reason", "", "reason shown to disconnected users")
+	mode := flags.String("mode", "delete", "erasure mode, delete or anonymise")
+	id := flags.String("id", "", "dead letter ID")
+	name := flags.String("name", "", "event name")
+	from := flags.String("from", "", "RFC 3339 start of the event range")
+	to := flags.String("to", "", "RFC 3339 end of the event range, defaults to now")
+	limit := flags.String("limit", "", "maximum number of events")
+	file := flags.String("file", "", "erasure report file")
+	revoke := flags.Bool("revoke", false, "revoke moderation instead of granting it")
+	exitOnError(flags.Parse(args[1:]))
+
+	require := func(values ...string) {
+		for _, v := range values {
+			if v == "" {
+				flag.Usage()
+				os.Exit(2)
+			}
+		}
+	}
+
+	switch args[0] {
+	case "nodes":
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/cluster/nodes", nil))
+
+	case "cluster-rooms":
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/cluster/rooms", nil))
+
+	case "locate":
+		require(*user)
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/users/"+url.PathEscape(*user)+"/locate", nil))
+
+	case "rooms":
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/rooms", nil))
+
+	case "sessions":
+		require(*room)
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/rooms/"+url.PathEscape(*room)+"/sessions", nil))
+
+	case "announce":
+		require(*room, *text)
+		exitOnError(c.do(ctx, http.MethodPost, "/admin/rooms/"+url.PathEscape(*room)+"/announce",
+			map[string]string{"text": *text}))
+
+	case "role", "moderator":
+		require(*room, *user)
+		method := http.MethodGet
+		if args[0] == "moderator" {
+			method = http.MethodPut
+			if *revoke {
+				method = http.MethodDelete
+			}
+		}
+		exitOnError(c.do(ctx, method, "/admin/rooms/"+url.PathEscape(*room)+"/moderators/"+url.PathEscape(*user), nil))
+
+	case "disconnect":
+		require(*user)
+		exitOnError(c.do(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(*user)+"/disconnect",
+			map[string]string{"roomID": *room, "reason": *reason}))
+
+	case "erase":
+		require(*user)
+		path := "/admin/users/" + url.PathEscape(*user) + "/erase?mode=" + url.QueryEscape(*mode)
+		exitOnError(c.do(ctx, http.MethodPost, path, nil))
+
+	case "verify-report":
+		require(*file)
+		report, err := os.ReadFile(*file)
+		exitOnError(err)
+		exitOnError(c.do(ctx, http.MethodPost, "/admin/erasure-reports/verify", json.RawMessage(report)))
+
+	case "deadletters":
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/deadletters", nil))
+
+	case "replay":
+		require(*id)
+		exitOnError(c.do(ctx, http.MethodPost, "/admin/deadletters/"+url.PathEscape(*id)+"/replay", nil))
+
+	case "discard":
+		require(*id)
+		exitOnError(c.do(ctx, http.MethodDelete, "/admin/deadletters/"+url.PathEscape(*id), nil))
+
+	case "events":
+		require(*name, *from)
+		query := url.Values{"name": {*name}, "from": {*from}}
+		if *to != "" {
+			query.Set("to", *to)
+		}
+		if *limit != "" {
+			query.Set("limit", *limit)
+		}
+		exitOnError(c.do(ctx, http.MethodGet, "/admin/events?"+query.Encode(), nil))
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// client calls the admin API and prints its responses.
+type client struct {
+	addr   string
+	apiKey string
+}
+
+func (x client) do(ctx context.Context, method, path string, body any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, x.addr+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+x.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(out.String()))
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out.Bytes(), "", "  "); err != nil {
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, indented.String())
+	return err
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		log.Error().Err(err).Msg("chatctl: failed")
+		os.Exit(1)
+	}
+}
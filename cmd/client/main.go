@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
-	"github.com/gorilla/websocket"
+	"github.com/Salam4nder/chat/pkg/client"
+	"github.com/Salam4nder/chat/pkg/protocol"
 )
 
 var (
@@ -17,6 +19,7 @@ var (
 	roomID       = flag.String("roomID", "", "room ID")
 	userID       = flag.String("userID", "", "user ID")
 	friendlyName = flag.String("name", "", "display name")
+	apiKey       = flag.String("apiKey", "", "service account API key")
 )
 
 func main() {
@@ -26,27 +29,28 @@ func main() {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	u := url.URL{
-		Scheme:   "ws",
-		Host:     *addr,
-		Path:     "/chat",
-		RawQuery: fmt.Sprintf("%s=%s&%s=%s&%s=%s", "roomID", *roomID, "name", *friendlyName, "userID", *userID),
+	cfg := client.Config{
+		Addr:   *addr,
+		RoomID: *roomID,
+		UserID: *userID,
+		Name:   *friendlyName,
+		APIKey: *apiKey,
 	}
-	log.Printf("client: connecting to %s", u.String())
+	log.Printf("client: connecting to %s", cfg.URL())
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, err := client.Dial(context.Background(), cfg)
 	if err != nil {
 		log.Fatal("dial:", err)
 	}
-	defer conn.Close()
 
 	go func() {
 		for {
-			_, _, err := conn.ReadMessage()
+			frame, err := conn.Read()
 			if err != nil {
 				log.Println("read:", err)
 				return
 			}
+			printFrame(frame)
 		}
 	}()
 
@@ -56,18 +60,13 @@ func main() {
 		for {
 			select {
 			case t := <-inputStr:
-				err := conn.WriteMessage(websocket.TextMessage, []byte(t))
-				if err != nil {
+				if err := conn.Send(t); err != nil {
 					log.Println("write:", err)
 					return
 				}
 			case <-interrupt:
 				log.Println("interrupt")
-				err := conn.WriteMessage(
-					websocket.CloseMessage,
-					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
-				)
-				if err != nil {
+				if err := conn.Close(); err != nil {
 					log.Println("write close:", err)
 					os.Exit(1)
 				}
@@ -91,6 +90,22 @@ func main() {
 			break
 		}
 
-		inputStr <- string(buffer[:n])
+		inputStr <- strings.TrimSuffix(string(buffer[:n]), "\n")
+	}
+}
+
+func printFrame(frame protocol.Frame) {
+	switch frame.Type {
+	case protocol.FrameMessage:
+		fmt.Printf("%s: %s\n", frame.Author, frame.Body)
+		for _, c := range frame.Components {
+			fmt.Printf("  [%s %s] %s\n", c.Type, c.ID, c.Label)
+		}
+	case protocol.FrameNotice:
+		fmt.Printf("* %s\n", frame.Body)
+	case protocol.FrameInteraction:
+		if frame.Interaction != nil {
+			fmt.Printf("* %s clicked %s\n", frame.Interaction.Author, frame.Interaction.ComponentID)
+		}
 	}
 }
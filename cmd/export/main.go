@@ -0,0 +1,132 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/config"
+	"github.com/Salam4nder/chat/internal/db/cql"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/export"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const timeout = 30 * time.Second
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	roomID := flag.String("room", "", "room ID")
+	format := flag.String("format", string(export.JSONL), "jsonl, csv, markdown or html")
+	from := flag.String("from", "", "only messages at or after this RFC 3339 time")
+	to := flag.String("to", "", "only messages at or before this RFC 3339 time")
+	sender := flag.String("sender", "", "only messages of this sender name or user ID")
+	out := flag.String("out", "", "output file, stdout if empty")
+	compress := flag.Bool("gzip", false, "gzip the output")
+	pageSize := flag.Int("pageSize", db.DefaultPageSize, "messages read at once")
+	flag.Parse()
+
+	if *roomID == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := export.Options{
+		RoomID:   *roomID,
+		Sender:   *sender,
+		PageSize: *pageSize,
+	}
+	var err error
+	if opts.From, err = parseTime(*from); err == nil {
+		opts.To, err = parseTime(*to)
+	}
+	if err == nil {
+		err = run(opts, export.Format(*format), *out, *compress)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("export cmd: failed")
+		os.Exit(1)
+	}
+}
+
+// run exports a room. It returns errors instead of exiting,
+// so that its defers close the session and the output file.
+func run(opts export.Options, format export.Format, out string, compress bool) (err error) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	config, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	cluster := cql.NewClusterConfig(config.ScyllaDB)
+	if err := cluster.PingWithTimeout(timeout, interrupt); err != nil {
+		return err
+	}
+	session, err := cluster.Inner().CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = f
+	}
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	writer, err := export.NewWriter(format, w, fmt.Sprintf("Room %s", opts.RoomID))
+	if err != nil {
+		return err
+	}
+
+	count, err := export.Export(ctx, db.NewScyllaMessageRepository(session), opts, writer)
+	if err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Int("messages", count).Msg("export cmd: done")
+	return nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/chat"
+	"github.com/Salam4nder/chat/internal/config"
+	"github.com/Salam4nder/chat/internal/db/cql"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/slackimport"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const timeout = 30 * time.Second
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	archivePath := flag.String("zip", "", "Slack export zip")
+	mappingPath := flag.String("mapping", "", "JSON file mapping channels to rooms and users to user IDs")
+	checkpointPath := flag.String("checkpoint", "", "checkpoint file, defaults to <zip>.checkpoint")
+	flag.Parse()
+
+	if *archivePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *checkpointPath == "" {
+		*checkpointPath = slackimport.DefaultCheckpointPath(*archivePath)
+	}
+
+	var mapping slackimport.Mapping
+	if *mappingPath != "" {
+		var err error
+		mapping, err = slackimport.LoadMapping(*mappingPath)
+		exitOnError(err)
+	}
+	checkpoint, err := slackimport.LoadCheckpoint(*checkpointPath)
+	exitOnError(err)
+
+	zr, err := zip.OpenReader(*archivePath)
+	exitOnError(err)
+	defer zr.Close()
+	archive, err := slackimport.OpenArchive(&zr.Reader)
+	exitOnError(err)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	config, err := config.New()
+	exitOnError(err)
+
+	cluster := cql.NewClusterConfig(config.ScyllaDB)
+	err = cluster.PingWithTimeout(timeout, interrupt)
+	exitOnError(err)
+	session, err := cluster.Inner().CreateSession()
+	exitOnError(err)
+	defer session.Close()
+
+	messageRepo := db.NewScyllaMessageRepository(session)
+	retention := chat.NewRetentionService(
+		db.NewScyllaRoomPolicyRepository(session),
+		messageRepo,
+		config.Retention.DefaultDays,
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	report, err := slackimport.NewImporter(messageRepo, retention, mapping).
+		Import(ctx, archive, checkpoint)
+	printJSON(report)
+	exitOnError(err)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	exitOnError(enc.Encode(v))
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		log.Error().Err(err).Msg("import cmd: failed")
+		os.Exit(1)
+	}
+}
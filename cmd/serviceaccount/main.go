@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/internal/config"
+	"github.com/Salam4nder/chat/internal/db/cql"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const timeout = 30 * time.Second
+
+const usage = `usage: serviceaccount <command> [flags]
+
+commands:
+  create-account -name <name>
+  create-key     -account <id> -scopes rooms:read,rooms:post[,moderate,admin] [-rooms <id,id>]
+  list-keys      -account <id>
+  rotate-key     -key <id>
+  revoke-key     -key <id>
+`
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	config, err := config.New()
+	exitOnError(err)
+
+	cluster := cql.NewClusterConfig(config.ScyllaDB)
+	err = cluster.PingWithTimeout(timeout, interrupt)
+	exitOnError(err)
+	session, err := cluster.Inner().CreateSession()
+	exitOnError(err)
+	defer session.Close()
+
+	service := auth.NewService(db.NewScyllaServiceAccountRepository(session))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	name := flags.String("name", "", "service account name")
+	account := flags.String("account", "", "service account ID")
+	key := flags.String("key", "", "API key ID")
+	scopes := flags.String("scopes", "", "comma separated scopes")
+	rooms := flags.String("rooms", "", "comma separated room IDs, empty for all rooms")
+	exitOnError(flags.Parse(os.Args[2:]))
+
+	switch os.Args[1] {
+	case "create-account":
+		id, err := service.CreateAccount(ctx, *name)
+		exitOnError(err)
+		printJSON(map[string]string{"id": id.String(), "name": *name})
+
+	case "create-key":
+		k, secret, err := service.CreateKey(ctx, parseID(*account), parseScopes(*scopes), split(*rooms))
+		exitOnError(err)
+		printJSON(map[string]any{"key": k, "apiKey": secret})
+
+	case "list-keys":
+		keys, err := service.ListKeys(ctx, parseID(*account))
+		exitOnError(err)
+		printJSON(keys)
+
+	case "rotate-key":
+		k, secret, err := service.RotateKey(ctx, parseID(*key))
+		exitOnError(err)
+		printJSON(map[string]any{"key": k, "apiKey": secret})
+
+	case "revoke-key":
+		exitOnError(service.RevokeKey(ctx, parseID(*key)))
+		printJSON(map[string]string{"revoked": *key})
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func parseID(s string) uuid.UUID {
+	id, err := uuid.Parse(s)
+	exitOnError(err)
+	return id
+}
+
+func parseScopes(s string) []auth.Scope {
+	var scopes []auth.Scope
+	for _, scope := range split(s) {
+		scopes = append(scopes, auth.Scope(scope))
+	}
+	return scopes
+}
+
+func split(s string) []string {
+	var parts []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	exitOnError(enc.Encode(v))
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		log.Error().Err(err).Msg("serviceaccount cmd: failed")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,396 @@
+// Package auth provides service accounts and scoped API keys
+// for non-human identities such as bots, webhooks and admin tooling.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	// ScopeRoomsRead allows joining rooms and reading messages.
+	ScopeRoomsRead Scope = "rooms:read"
+	// ScopeRoomsPost allows posting messages to rooms.
+	ScopeRoomsPost Scope = "rooms:post"
+	// ScopeModerate allows moderation actions such as managing webhooks.
+	ScopeModerate Scope = "moderate"
+	// ScopeAdmin allows everything, in every room.
+	ScopeAdmin Scope = "admin"
+)
+
+const (
+	// keyPrefix prefixes every API key.
+	keyPrefix = "chat"
+	// secretSize is the size of an API key secret in bytes.
+	secretSize = 32
+	// touchInterval throttles last used updates of a key.
+	touchInterval = time.Minute
+	// touchTimeout is the maximum duration of a last used update.
+	touchTimeout = 5 * time.Second
+)
+
+var (
+	ErrUnauthenticated = errors.New("auth: api key missing")
+	ErrKeyInvalid      = errors.New("auth: api key invalid")
+	ErrKeyRevoked      = errors.New("auth: api key revoked")
+	ErrForbidden       = errors.New("auth: api key lacks scope")
+	ErrScopeInvalid    = errors.New("auth: scope invalid")
+	ErrNameInvalid     = errors.New("auth: service account name invalid")
+	// ErrKeyRequired is returned when a service account connects
+	// by its ID alone instead of with an API key.
+	ErrKeyRequired = errors.New("auth: service account requires an api key")
+)
+
+// ValidScope returns nil if s is a known scope.
+func ValidScope(s Scope) error {
+	switch s {
+	case ScopeRoomsRead, ScopeRoomsPost, ScopeModerate, ScopeAdmin:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrScopeInvalid, s)
+	}
+}
+
+// Principal is an authenticated service account.
+type Principal struct {
+	AccountID uuid.UUID
+	Name      string
+	KeyID     uuid.UUID
+	Scopes    []Scope
+	// Rooms restricts room scoped permissions to the given rooms.
+	// An empty list means every room.
+	Rooms []string
+}
+
+// Allows reports whether the principal has the given scope in the given room.
+// Pass an empty roomID for permissions that are not room scoped.
+func (x Principal) Allows(scope Scope, roomID string) bool {
+	for _, s := range x.Scopes {
+		if s == ScopeAdmin {
+			return true
+		}
+	}
+
+	var hasScope bool
+	for _, s := range x.Scopes {
+		if s == scope {
+			hasScope = true
+			break
+		}
+	}
+	if !hasScope {
+		return false
+	}
+	if roomID == "" || len(x.Rooms) == 0 {
+		return true
+	}
+	for _, room := range x.Rooms {
+		if room == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// Require returns ErrForbidden if the principal lacks the given
+// scope in the given room.
+func (x Principal) Require(scope Scope, roomID string) error {
+	if !x.Allows(scope, roomID) {
+		return fmt.Errorf("%w: %s", ErrForbidden, scope)
+	}
+	return nil
+}
+
+// Key describes an API key without its secret.
+type Key struct {
+	ID         uuid.UUID
+	AccountID  uuid.UUID
+	Scopes     []Scope
+	Rooms      []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// Service manages service accounts and authenticates API keys.
+type Service struct {
+	repo db.ServiceAccountRepository
+}
+
+// NewService returns a new instance of Service.
+func NewService(repo db.ServiceAccountRepository) *Service {
+	return &Service{repo: repo}
+}
+
+// CreateAccount creates a new service account.
+func (x *Service) CreateAccount(ctx context.Context, name string) (uuid.UUID, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return uuid.Nil, ErrNameInvalid
+	}
+
+	id := gocql.TimeUUID()
+	if err := x.repo.CreateServiceAccount(ctx, db.ServiceAccount{
+		ID:        id,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return uuid.Nil, fmt.Errorf("auth: creating service account, %w", err)
+	}
+
+	return uuid.UUID(id), nil
+}
+
+// RequireUser returns ErrKeyRequired if id belongs to a service account,
+// so that users who only claim an ID cannot act as one.
+func (x *Service) RequireUser(ctx context.Context, id uuid.UUID) error {
+	_, err := x.repo.ReadServiceAccount(ctx, gocql.UUID(id))
+	switch {
+	case err == nil:
+		return ErrKeyRequired
+	case errors.Is(err, db.ErrServiceAccountNotFound):
+		return nil
+	default:
+		return fmt.Errorf("auth: reading service account, %w", err)
+	}
+}
+
+// CreateKey creates a new API key for a service account.
+// The returned secret key is only available at creation time.
+func (x *Service) CreateKey(
+	ctx context.Context,
+	accountID uuid.UUID,
+	scopes []Scope,
+	rooms []string,
+) (Key, string, error) {
+	if len(scopes) == 0 {
+		return Key{}, "", ErrScopeInvalid
+	}
+	for _, s := range scopes {
+		if err := ValidScope(s); err != nil {
+			return Key{}, "", err
+		}
+	}
+	if _, err := x.repo.ReadServiceAccount(ctx, gocql.UUID(accountID)); err != nil {
+		return Key{}, "", fmt.Errorf("auth: reading service account, %w", err)
+	}
+
+	raw := make([]byte, secretSize)
+	if _, err := rand.Read(raw); err != nil {
+		return Key{}, "", fmt.Errorf("auth: generating secret, %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	hash := sha256.Sum256([]byte(secret))
+
+	key := Key{
+		ID:        uuid.UUID(gocql.TimeUUID()),
+		AccountID: accountID,
+		Scopes:    scopes,
+		Rooms:     rooms,
+		CreatedAt: time.Now().UTC(),
+	}
+	scopeStrings := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		scopeStrings = append(scopeStrings, string(s))
+	}
+	if err := x.repo.CreateAPIKey(ctx, db.APIKey{
+		ID:        gocql.UUID(key.ID),
+		AccountID: gocql.UUID(accountID),
+		Hash:      hash[:],
+		Scopes:    scopeStrings,
+		Rooms:     rooms,
+		CreatedAt: key.CreatedAt,
+	}); err != nil {
+		return Key{}, "", fmt.Errorf("auth: creating api key, %w", err)
+	}
+
+	return key, formatKey(key.ID, secret), nil
+}
+
+// RotateKey creates a new key with the same account, scopes and rooms
+// and revokes the old one.
+func (x *Service) RotateKey(ctx context.Context, keyID uuid.UUID) (Key, string, error) {
+	old, err := x.repo.ReadAPIKey(ctx, gocql.UUID(keyID))
+	if err != nil {
+		return Key{}, "", fmt.Errorf("auth: reading api key, %w", err)
+	}
+	if !old.RevokedAt.IsZero() {
+		return Key{}, "", ErrKeyRevoked
+	}
+
+	key, secret, err := x.CreateKey(ctx, uuid.UUID(old.AccountID), toKey(old).Scopes, old.Rooms)
+	if err != nil {
+		return Key{}, "", err
+	}
+	if err := x.RevokeKey(ctx, keyID); err != nil {
+		return Key{}, "", err
+	}
+
+	return key, secret, nil
+}
+
+// RevokeKey revokes an API key. Revoked keys fail authentication.
+func (x *Service) RevokeKey(ctx context.Context, keyID uuid.UUID) error {
+	if err := x.repo.RevokeAPIKey(ctx, gocql.UUID(keyID), time.Now().UTC()); err != nil {
+		return fmt.Errorf("auth: revoking api key, %w", err)
+	}
+	return nil
+}
+
+// ListKeys lists the API keys of a service account.
+func (x *Service) ListKeys(ctx context.Context, accountID uuid.UUID) ([]Key, error) {
+	rows, err := x.repo.ReadAPIKeysByAccount(ctx, gocql.UUID(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("auth: listing api keys, %w", err)
+	}
+
+	keys := make([]Key, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, toKey(row))
+	}
+	return keys, nil
+}
+
+// Authenticate verifies an API key and returns its principal.
+func (x *Service) Authenticate(ctx context.Context, apiKey string) (Principal, error) {
+	keyID, secret, err := parseKey(apiKey)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	row, err := x.repo.ReadAPIKey(ctx, gocql.UUID(keyID))
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return Principal{}, ErrKeyInvalid
+		}
+		return Principal{}, fmt.Errorf("auth: reading api key, %w", err)
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], row.Hash) != 1 {
+		return Principal{}, ErrKeyInvalid
+	}
+	if !row.RevokedAt.IsZero() {
+		return Principal{}, ErrKeyRevoked
+	}
+
+	account, err := x.repo.ReadServiceAccount(ctx, row.AccountID)
+	if err != nil {
+		return Principal{}, fmt.Errorf("auth: reading service account, %w", err)
+	}
+
+	if time.Since(row.LastUsedAt) > touchInterval {
+		go x.touch(row.ID)
+	}
+
+	key := toKey(row)
+	return Principal{
+		AccountID: key.AccountID,
+		Name:      account.Name,
+		KeyID:     key.ID,
+		Scopes:    key.Scopes,
+		Rooms:     key.Rooms,
+	}, nil
+}
+
+// AuthenticateRequest authenticates the API key of an HTTP request.
+// It returns ErrUnauthenticated if the request carries no key.
+func (x *Service) AuthenticateRequest(r *http.Request) (Principal, error) {
+	apiKey, ok := KeyFromRequest(r)
+	if !ok {
+		return Principal{}, ErrUnauthenticated
+	}
+	return x.Authenticate(r.Context(), apiKey)
+}
+
+// KeyFromRequest extracts an API key from the Authorization bearer
+// token or the X-API-Key header.
+func KeyFromRequest(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+			return strings.TrimSpace(token), true
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+// StatusCode maps an authentication error to an HTTP status code.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnauthenticated),
+		errors.Is(err, ErrKeyInvalid),
+		errors.Is(err, ErrKeyRevoked),
+		errors.Is(err, ErrKeyRequired):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (x *Service) touch(id gocql.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), touchTimeout)
+	defer cancel()
+
+	if err := x.repo.TouchAPIKey(ctx, id, time.Now().UTC()); err != nil {
+		log.Error().Err(err).Msg("auth: touching api key")
+	}
+}
+
+// formatKey formats an API key as chat_<key ID>_<secret>.
+func formatKey(id uuid.UUID, secret string) string {
+	return keyPrefix + "_" + hex.EncodeToString(id[:]) + "_" + secret
+}
+
+func parseKey(apiKey string) (uuid.UUID, string, error) {
+	parts := strings.SplitN(apiKey, "_", 3)
+	if len(parts) != 3 || parts[0] != keyPrefix || parts[2] == "" {
+		return uuid.Nil, "", ErrKeyInvalid
+	}
+	raw, err := hex.DecodeString(parts[1])
+	if err != nil || len(raw) != len(uuid.UUID{}) {
+		return uuid.Nil, "", ErrKeyInvalid
+	}
+
+	var id uuid.UUID
+	copy(id[:], raw)
+	return id, parts[2], nil
+}
+
+func toKey(row db.APIKey) Key {
+	scopes := make([]Scope, 0, len(row.Scopes))
+	for _, s := range row.Scopes {
+		scopes = append(scopes, Scope(s))
+	}
+	return Key{
+		ID:         uuid.UUID(row.ID),
+		AccountID:  uuid.UUID(row.AccountID),
+		Scopes:     scopes,
+		Rooms:      row.Rooms,
+		CreatedAt:  row.CreatedAt,
+		LastUsedAt: row.LastUsedAt,
+		RevokedAt:  row.RevokedAt,
+	}
+}
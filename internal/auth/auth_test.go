@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// accountRepo is a ServiceAccountRepository keeping rows in memory.
+type accountRepo struct {
+	mu       sync.Mutex
+	accounts map[gocql.UUID]db.ServiceAccount
+	keys     map[gocql.UUID]db.APIKey
+	err      error
+}
+
+func newAccountRepo() *accountRepo {
+	return &accountRepo{
+		accounts: make(map[gocql.UUID]db.ServiceAccount),
+		keys:     make(map[gocql.UUID]db.APIKey),
+	}
+}
+
+func (x *accountRepo) CreateServiceAccount(_ context.Context, params db.ServiceAccount) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.accounts[params.ID] = params
+	return nil
+}
+
+func (x *accountRepo) ReadServiceAccount(_ context.Context, id gocql.UUID) (db.ServiceAccount, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.err != nil {
+		return db.ServiceAccount{}, x.err
+	}
+	account, ok := x.accounts[id]
+	if !ok {
+		return db.ServiceAccount{}, db.ErrServiceAccountNotFound
+	}
+	return account, nil
+}
+
+func (x *accountRepo) CreateAPIKey(_ context.Context, params db.APIKey) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.keys[params.ID] = params
+	return nil
+}
+
+func (x *accountRepo) ReadAPIKey(_ context.Context, id gocql.UUID) (db.APIKey, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key, ok := x.keys[id]
+	if !ok {
+		return db.APIKey{}, db.ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (x *accountRepo) ReadAPIKeysByAccount(_ context.Context, accountID gocql.UUID) ([]db.APIKey, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var keys []db.APIKey
+	for _, key := range x.keys {
+		if key.AccountID == accountID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (x *accountRepo) TouchAPIKey(_ context.Context, id gocql.UUID, at time.Time) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := x.keys[id]
+	key.LastUsedAt = at
+	x.keys[id] = key
+	return nil
+}
+
+func (x *accountRepo) RevokeAPIKey(_ context.Context, id gocql.UUID, at time.Time) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := x.keys[id]
+	key.RevokedAt = at
+	x.keys[id] = key
+	return nil
+}
+
+func Test_Principal_Allows(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		principal Principal
+		scope     Scope
+		roomID    string
+		allowed   bool
+	}{
+		{
+			name:      "Scope in every room",
+			principal: Principal{Scopes: []Scope{ScopeRoomsRead}},
+			scope:     ScopeRoomsRead,
+			roomID:    "room-1",
+			allowed:   true,
+		},
+		{
+			name:      "Missing scope",
+			principal: Principal{Scopes: []Scope{ScopeRoomsRead}},
+			scope:     ScopeRoomsPost,
+			roomID:    "room-1",
+		},
+		{
+			name:      "Listed room",
+			principal: Principal{Scopes: []Scope{ScopeRoomsPost}, Rooms: []string{"room-1", "room-2"}},
+			scope:     ScopeRoomsPost,
+			roomID:    "room-2",
+			allowed:   true,
+		},
+		{
+			name:      "Unlisted room",
+			principal: Principal{Scopes: []Scope{ScopeRoomsPost}, Rooms: []string{"room-1"}},
+			scope:     ScopeRoomsPost,
+			roomID:    "room-2",
+		},
+		{
+			name:      "Not room scoped",
+			principal: Principal{Scopes: []Scope{ScopeModerate}, Rooms: []string{"room-1"}},
+			scope:     ScopeModerate,
+			allowed:   true,
+		},
+		{
+			name:      "Admin",
+			principal: Principal{Scopes: []Scope{ScopeAdmin}, Rooms: []string{"room-1"}},
+			scope:     ScopeModerate,
+			roomID:    "room-2",
+			allowed:   true,
+		},
+		{
+			name:   "No scopes",
+			scope:  ScopeRoomsRead,
+			roomID: "room-1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.allowed, tc.principal.Allows(tc.scope, tc.roomID))
+			err := tc.principal.Require(tc.scope, tc.roomID)
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrForbidden)
+			}
+		})
+	}
+}
+
+func Test_ParseKey(t *testing.T) {
+	id := uuid.New()
+	key := formatKey(id, "secret_with_underscores")
+	require.True(t, strings.HasPrefix(key, keyPrefix+"_"))
+
+	gotID, secret, err := parseKey(key)
+	require.NoError(t, err)
+	require.Equal(t, id, gotID)
+	require.Equal(t, "secret_with_underscores", secret)
+
+	for _, invalid := range []string{
+		"",
+		"chat",
+		"chat_" + strings.Repeat("ab", 16),
+		"chat_" + strings.Repeat("ab", 16) + "_",
+		"key_" + strings.Repeat("ab", 16) + "_secret",
+		"chat_zz_secret",
+		"chat_abcd_secret",
+	} {
+		_, _, err := parseKey(invalid)
+		require.ErrorIs(t, err, ErrKeyInvalid, invalid)
+	}
+}
+
+func Test_KeyFromRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		key    string
+		ok     bool
+	}{
+		{name: "None", header: http.Header{}},
+		{name: "Bearer", header: http.Header{"Authorization": {"Bearer k1"}}, key: "k1", ok: true},
+		{name: "Bearer any case", header: http.Header{"Authorization": {"bearer k1"}}, key: "k1", ok: true},
+		{name: "Basic", header: http.Header{"Authorization": {"Basic abc"}}},
+		{name: "Empty bearer", header: http.Header{"Authorization": {"Bearer "}}},
+		{name: "X-API-Key", header: http.Header{"X-Api-Key": {"k2"}}, key: "k2", ok: true},
+		{
+			name:   "Bearer first",
+			header: http.Header{"Authorization": {"Bearer k1"}, "X-Api-Key": {"k2"}},
+			key:    "k1",
+			ok:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tc.header
+			key, ok := KeyFromRequest(r)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.key, key)
+		})
+	}
+}
+
+func Test_StatusCode(t *testing.T) {
+	require.Equal(t, http.StatusForbidden, StatusCode(ErrForbidden))
+	require.Equal(t, http.StatusUnauthorized, StatusCode(ErrUnauthenticated))
+	require.Equal(t, http.StatusUnauthorized, StatusCode(ErrKeyInvalid))
+	require.Equal(t, http.StatusUnauthorized, StatusCode(ErrKeyRevoked))
+	require.Equal(t, http.StatusUnauthorized, StatusCode(ErrKeyRequired))
+	require.Equal(t, http.StatusInternalServerError, StatusCode(errors.New("boom")))
+}
+
+func Test_Service_Keys(t *testing.T) {
+	ctx := context.Background()
+	repo := newAccountRepo()
+	service := NewService(repo)
+
+	_, err := service.CreateAccount(ctx, " ")
+	require.ErrorIs(t, err, ErrNameInvalid)
+	accountID, err := service.CreateAccount(ctx, "deploy-bot")
+	require.NoError(t, err)
+
+	t.Run("Create", func(t *testing.T) {
+		_, _, err := service.CreateKey(ctx, accountID, nil, nil)
+		require.ErrorIs(t, err, ErrScopeInvalid)
+		_, _, err = service.CreateKey(ctx, accountID, []Scope{"rooms:delete"}, nil)
+		require.ErrorIs(t, err, ErrScopeInvalid)
+		_, _, err = service.CreateKey(ctx, uuid.New(), []Scope{ScopeRoomsRead}, nil)
+		require.ErrorIs(t, err, db.ErrServiceAccountNotFound)
+	})
+
+	key, secret, err := service.CreateKey(ctx, accountID, []Scope{ScopeRoomsRead}, []string{"room-1"})
+	require.NoError(t, err)
+
+	t.Run("Authenticate", func(t *testing.T) {
+		principal, err := service.Authenticate(ctx, secret)
+		require.NoError(t, err)
+		require.Equal(t, accountID, principal.AccountID)
+		require.Equal(t, "deploy-bot", principal.Name)
+		require.Equal(t, key.ID, principal.KeyID)
+		require.Equal(t, []Scope{ScopeRoomsRead}, principal.Scopes)
+		require.Equal(t, []string{"room-1"}, principal.Rooms)
+
+		_, err = service.Authenticate(ctx, secret+"x")
+		require.ErrorIs(t, err, ErrKeyInvalid)
+		_, err = service.Authenticate(ctx, formatKey(uuid.New(), "secret"))
+		require.ErrorIs(t, err, ErrKeyInvalid)
+		_, err = service.Authenticate(ctx, "not a key")
+		require.ErrorIs(t, err, ErrKeyInvalid)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, err = service.AuthenticateRequest(r)
+		require.ErrorIs(t, err, ErrUnauthenticated)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		_, err = service.AuthenticateRequest(r)
+		require.NoError(t, err)
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		rotated, rotatedSecret, err := service.RotateKey(ctx, key.ID)
+		require.NoError(t, err)
+		require.NotEqual(t, key.ID, rotated.ID)
+		require.Equal(t, key.Scopes, rotated.Scopes)
+		require.Equal(t, key.Rooms, rotated.Rooms)
+
+		_, err = service.Authenticate(ctx, secret)
+		require.ErrorIs(t, err, ErrKeyRevoked)
+		_, err = service.Authenticate(ctx, rotatedSecret)
+		require.NoError(t, err)
+		_, _, err = service.RotateKey(ctx, key.ID)
+		require.ErrorIs(t, err, ErrKeyRevoked)
+
+		keys, err := service.ListKeys(ctx, accountID)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		key, secret, err := service.CreateKey(ctx, accountID, []Scope{ScopeAdmin}, nil)
+		require.NoError(t, err)
+		require.NoError(t, service.RevokeKey(ctx, key.ID))
+		_, err = service.Authenticate(ctx, secret)
+		require.ErrorIs(t, err, ErrKeyRevoked)
+	})
+}
+
+func Test_Service_RequireUser(t *testing.T) {
+	ctx := context.Background()
+	repo := newAccountRepo()
+	service := NewService(repo)
+	accountID, err := service.CreateAccount(ctx, "deploy-bot")
+	require.NoError(t, err)
+
+	require.NoError(t, service.RequireUser(ctx, uuid.New()))
+	require.ErrorIs(t, service.RequireUser(ctx, accountID), ErrKeyRequired)
+
+	repo.err = errors.New("unavailable")
+	err = service.RequireUser(ctx, uuid.New())
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrKeyRequired)
+}
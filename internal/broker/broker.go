@@ -0,0 +1,120 @@
+// Package broker moves messages between the nodes of a chat cluster.
+//
+// A Broker publishes messages on subjects, delivers them to the
+// subscriptions whose subject matches, and sends requests that expect
+// a single reply. Subjects are dot separated tokens; in subscriptions,
+// "*" matches one token and a trailing ">" matches one or more.
+//
+// NATS connects nodes through a NATS server. Memory keeps messages in
+// the process, for tests and single-node deployments.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of brokers.
+const (
+	KindNATS   = "nats"
+	KindMemory = "memory"
+)
+
+const inboxPrefix = "_INBOX."
+
+var (
+	ErrKindInvalid    = errors.New("broker: kind invalid")
+	ErrClosed         = errors.New("broker: closed")
+	ErrSubjectInvalid = errors.New("broker: subject invalid")
+	ErrNoResponders   = errors.New("broker: no responders")
+)
+
+// Broker publishes and delivers messages.
+type Broker interface {
+	// Publish publishes msg on msg.Subject.
+	Publish(ctx context.Context, msg *Msg) error
+	// Subscribe calls h with the messages published on subjects
+	// matching subject, one at a time and in order.
+	Subscribe(subject string, h Handler) (Subscription, error)
+	// Request publishes msg with a reply subject and waits for the
+	// first reply until ctx is done. It returns ErrNoResponders if
+	// no subscription matched msg.Subject.
+	Request(ctx context.Context, msg *Msg) (*Msg, error)
+	// Drain delivers the messages already received to their
+	// subscriptions, then closes the broker. It returns once it is
+	// closed or ctx is done, in which case it closes the broker
+	// right away.
+	Drain(ctx context.Context) error
+}
+
+// Handler handles a delivered message.
+type Handler func(msg *Msg)
+
+// Subscription is a subscription to a subject.
+type Subscription interface {
+	// Unsubscribe stops the delivery of messages, dropping those
+	// not yet handled.
+	Unsubscribe() error
+}
+
+// Header holds message headers.
+type Header map[string][]string
+
+// Get returns the first value of key.
+func (x Header) Get(key string) string {
+	if values := x[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Set sets the value of key.
+func (x Header) Set(key, value string) {
+	x[key] = []string{value}
+}
+
+// Msg is a message.
+type Msg struct {
+	Subject string
+	// Reply is the subject a reply is published on, if any.
+	Reply  string
+	Header Header
+	Data   []byte
+	// Seq is the stream sequence of a message delivered by a stream
+	// consumer, zero otherwise.
+	Seq uint64
+
+	ack func() error
+}
+
+// NewMsg returns a message on subject with empty headers.
+func NewMsg(subject string) *Msg {
+	return &Msg{Subject: subject, Header: make(Header)}
+}
+
+// Ack acknowledges a message delivered by a stream consumer.
+// It does nothing for other messages.
+func (x *Msg) Ack() error {
+	if x.ack == nil {
+		return nil
+	}
+	return x.ack()
+}
+
+// Respond publishes data on the reply subject of msg.
+func Respond(ctx context.Context, b Broker, msg *Msg, data []byte) error {
+	if msg.Reply == "" {
+		return fmt.Errorf("%w: no reply subject", ErrSubjectInvalid)
+	}
+	reply := NewMsg(msg.Reply)
+	reply.Data = data
+	return b.Publish(ctx, reply)
+}
+
+// NewInbox returns a unique subject to receive replies on.
+func NewInbox() string {
+	return inboxPrefix + uuid.NewString()
+}
@@ -0,0 +1,222 @@
+// Package brokertest is a conformance suite for broker.Broker
+// implementations.
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/stretchr/testify/require"
+)
+
+// wait bounds the wait for a delivery.
+const wait = 5 * time.Second
+
+// Run runs the conformance suite. newBroker returns a new broker for
+// every test; brokers that are not drained by a test are drained by
+// newBroker's cleanup, if any.
+func Run(t *testing.T, newBroker func(t *testing.T) broker.Broker) {
+	t.Run("Publish and subscribe", func(t *testing.T) {
+		b := newBroker(t)
+		ch := make(chan *broker.Msg, 16)
+		sub, err := b.Subscribe("test.room.1", func(msg *broker.Msg) { ch <- msg })
+		require.NoError(t, err)
+		defer unsubscribe(t, sub)
+		roundTrip(t, b)
+
+		msg := broker.NewMsg("test.room.1")
+		msg.Header.Set("Traceparent", "00-1")
+		msg.Data = []byte("hello")
+		require.NoError(t, b.Publish(context.Background(), msg))
+
+		got := receive(t, ch)
+		require.Equal(t, "test.room.1", got.Subject)
+		require.Equal(t, "00-1", got.Header.Get("Traceparent"))
+		require.Equal(t, []byte("hello"), got.Data)
+		require.NoError(t, got.Ack())
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		b := newBroker(t)
+		ch := make(chan *broker.Msg, 128)
+		sub, err := b.Subscribe("test.order", func(msg *broker.Msg) { ch <- msg })
+		require.NoError(t, err)
+		defer unsubscribe(t, sub)
+		roundTrip(t, b)
+
+		for i := 0; i < 100; i++ {
+			publish(t, b, "test.order", fmt.Sprint(i))
+		}
+		for i := 0; i < 100; i++ {
+			require.Equal(t, fmt.Sprint(i), string(receive(t, ch).Data))
+		}
+	})
+
+	t.Run("Wildcards", func(t *testing.T) {
+		b := newBroker(t)
+		one := make(chan *broker.Msg, 16)
+		all := make(chan *broker.Msg, 16)
+		oneSub, err := b.Subscribe("test.*.msg", func(msg *broker.Msg) { one <- msg })
+		require.NoError(t, err)
+		defer unsubscribe(t, oneSub)
+		allSub, err := b.Subscribe("test.>", func(msg *broker.Msg) { all <- msg })
+		require.NoError(t, err)
+		defer unsubscribe(t, allSub)
+		roundTrip(t, b)
+
+		publish(t, b, "test.a.msg", "1")
+		publish(t, b, "test.a.interaction", "2")
+		publish(t, b, "test.a.msg.extra", "3")
+		publish(t, b, "other.a.msg", "4")
+
+		require.Equal(t, "1", string(receive(t, one).Data))
+		for _, want := range []string{"1", "2", "3"} {
+			require.Equal(t, want, string(receive(t, all).Data))
+		}
+		none(t, one)
+		none(t, all)
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		b := newBroker(t)
+		ch := make(chan *broker.Msg, 16)
+		sub, err := b.Subscribe("test.unsubscribe", func(msg *broker.Msg) { ch <- msg })
+		require.NoError(t, err)
+		roundTrip(t, b)
+
+		publish(t, b, "test.unsubscribe", "before")
+		require.Equal(t, "before", string(receive(t, ch).Data))
+
+		require.NoError(t, sub.Unsubscribe())
+		roundTrip(t, b)
+		publish(t, b, "test.unsubscribe", "after")
+		none(t, ch)
+	})
+
+	t.Run("Request reply", func(t *testing.T) {
+		b := newBroker(t)
+		sub, err := b.Subscribe("test.echo", func(msg *broker.Msg) {
+			_ = broker.Respond(context.Background(), b, msg, append([]byte("echo "), msg.Data...))
+		})
+		require.NoError(t, err)
+		defer unsubscribe(t, sub)
+		roundTrip(t, b)
+
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+		req := broker.NewMsg("test.echo")
+		req.Data = []byte("ping")
+		reply, err := b.Request(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, "echo ping", string(reply.Data))
+	})
+
+	t.Run("Request without responders", func(t *testing.T) {
+		b := newBroker(t)
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+
+		_, err := b.Request(ctx, broker.NewMsg("test.nobody"))
+		require.ErrorIs(t, err, broker.ErrNoResponders)
+	})
+
+	t.Run("Request timeout", func(t *testing.T) {
+		b := newBroker(t)
+		sub, err := b.Subscribe("test.silent", func(*broker.Msg) {})
+		require.NoError(t, err)
+		defer unsubscribe(t, sub)
+		roundTrip(t, b)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = b.Request(ctx, broker.NewMsg("test.silent"))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		b := newBroker(t)
+		var (
+			mu   sync.Mutex
+			seen []string
+		)
+		_, err := b.Subscribe("test.drain", func(msg *broker.Msg) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			seen = append(seen, string(msg.Data))
+			mu.Unlock()
+		})
+		require.NoError(t, err)
+		roundTrip(t, b)
+
+		for i := 0; i < 20; i++ {
+			publish(t, b, "test.drain", fmt.Sprint(i))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+		require.NoError(t, b.Drain(ctx))
+
+		mu.Lock()
+		require.Len(t, seen, 20)
+		mu.Unlock()
+
+		err = b.Publish(context.Background(), broker.NewMsg("test.drain"))
+		require.True(t, errors.Is(err, broker.ErrClosed), "publish after drain: %v", err)
+	})
+}
+
+func publish(t *testing.T, b broker.Broker, subject, data string) {
+	t.Helper()
+
+	msg := broker.NewMsg(subject)
+	msg.Data = []byte(data)
+	require.NoError(t, b.Publish(context.Background(), msg))
+}
+
+// roundTrip makes sure a broker has processed the subscriptions made so
+// far by a round trip through it, as a remote server handles them
+// asynchronously.
+func roundTrip(t *testing.T, b broker.Broker) {
+	t.Helper()
+
+	inbox := broker.NewInbox()
+	ch := make(chan *broker.Msg, 1)
+	sub, err := b.Subscribe(inbox, func(msg *broker.Msg) { ch <- msg })
+	require.NoError(t, err)
+	defer unsubscribe(t, sub)
+
+	publish(t, b, inbox, "")
+	receive(t, ch)
+}
+
+func receive(t *testing.T, ch chan *broker.Msg) *broker.Msg {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(wait):
+		t.Fatal("no message delivered")
+		return nil
+	}
+}
+
+// none fails if ch receives a message within a short wait.
+func none(t *testing.T, ch chan *broker.Msg) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected message on %s", msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func unsubscribe(t *testing.T, sub broker.Subscription) {
+	t.Helper()
+	require.NoError(t, sub.Unsubscribe())
+}
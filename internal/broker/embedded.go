@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// embeddedStartTimeout bounds the wait for an embedded server
+// to accept connections.
+const embeddedStartTimeout = 10 * time.Second
+
+// RandomPort makes an embedded server listen on a free port.
+const RandomPort = server.RANDOM_PORT
+
+var ErrEmbeddedNotReady = errors.New("broker: embedded nats server not ready")
+
+// EmbeddedConfig configures a NATS server run in the process.
+type EmbeddedConfig struct {
+	// Name identifies the server in a cluster. JetStream in a cluster
+	// needs a unique name per server; a random one is used if empty.
+	Name string
+	Host string
+	Port int
+	// StoreDir enables JetStream, storing streams in the directory.
+	StoreDir string
+	// Cluster connects the server to other servers, if Cluster.Port
+	// is set.
+	Cluster EmbeddedCluster
+}
+
+// EmbeddedCluster configures the routes of an embedded server.
+type EmbeddedCluster struct {
+	// Name is the same on every server of the cluster.
+	Name string
+	Host string
+	Port int
+	// Routes are the cluster URLs of other servers,
+	// nats-route://host:port.
+	Routes []string
+}
+
+// StartEmbedded starts a NATS server in the process and returns once
+// it accepts connections. Connect to srv.ClientURL() and shut it down
+// once the connection is closed.
+func StartEmbedded(cfg EmbeddedConfig) (*server.Server, error) {
+	opts := &server.Options{
+		ServerName: cfg.Name,
+		Host:       cfg.Host,
+		Port:       cfg.Port,
+		NoLog:      true,
+		NoSigs:     true,
+	}
+	if cfg.StoreDir != "" {
+		opts.JetStream = true
+		opts.StoreDir = cfg.StoreDir
+	}
+	if cfg.Cluster.Port != 0 {
+		opts.Cluster = server.ClusterOpts{
+			Name: cfg.Cluster.Name,
+			Host: cfg.Cluster.Host,
+			Port: cfg.Cluster.Port,
+		}
+		opts.Routes = server.RoutesFromStr(strings.Join(cfg.Cluster.Routes, ","))
+	}
+
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		return nil, fmt.Errorf("broker: creating embedded nats server, %w", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(embeddedStartTimeout) {
+		srv.Shutdown()
+		return nil, ErrEmbeddedNotReady
+	}
+	return srv, nil
+}
@@ -0,0 +1,297 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var _ Broker = (*Memory)(nil)
+
+// Memory is a Broker within the process. Every subscription has its
+// own queue and goroutine, so a slow handler does not hold up
+// publishers or other subscriptions.
+type Memory struct {
+	mu       sync.RWMutex
+	subs     map[*memorySub]struct{}
+	draining bool
+	closed   bool
+}
+
+// NewMemory returns an in-process Broker.
+func NewMemory() *Memory {
+	return &Memory{subs: make(map[*memorySub]struct{})}
+}
+
+// Publish delivers msg to the subscriptions matching msg.Subject.
+func (x *Memory) Publish(_ context.Context, msg *Msg) error {
+	_, err := x.publish(msg)
+	return err
+}
+
+// publish returns the number of subscriptions msg was delivered to.
+func (x *Memory) publish(msg *Msg) (int, error) {
+	if !validSubject(msg.Subject, false) {
+		return 0, fmt.Errorf("%w: %q", ErrSubjectInvalid, msg.Subject)
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.closed {
+		return 0, ErrClosed
+	}
+	var n int
+	for sub := range x.subs {
+		if matchSubject(sub.subject, msg.Subject) && sub.push(copyMsg(msg)) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Subscribe calls h with the messages published on subjects
+// matching subject.
+func (x *Memory) Subscribe(subject string, h Handler) (Subscription, error) {
+	if !validSubject(subject, true) {
+		return nil, fmt.Errorf("%w: %q", ErrSubjectInvalid, subject)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.closed || x.draining {
+		return nil, ErrClosed
+	}
+	sub := &memorySub{
+		broker:  x,
+		subject: subject,
+		handler: h,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	x.subs[sub] = struct{}{}
+	go sub.run()
+	return sub, nil
+}
+
+// Request publishes msg and waits for the first reply.
+func (x *Memory) Request(ctx context.Context, msg *Msg) (*Msg, error) {
+	replies := make(chan *Msg, 1)
+	inbox := NewInbox()
+	sub, err := x.Subscribe(inbox, func(reply *Msg) {
+		select {
+		case replies <- reply:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	req := copyMsg(msg)
+	req.Reply = inbox
+	n, err := x.publish(req)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrNoResponders
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Drain stops new subscriptions, lets every subscription handle the
+// messages it queued, then closes the broker.
+func (x *Memory) Drain(ctx context.Context) error {
+	x.mu.Lock()
+	if x.closed {
+		x.mu.Unlock()
+		return ErrClosed
+	}
+	x.draining = true
+	subs := make([]*memorySub, 0, len(x.subs))
+	for sub := range x.subs {
+		subs = append(subs, sub)
+	}
+	x.mu.Unlock()
+
+	// Handlers may still publish, for instance replies, while the
+	// subscriptions drain.
+	for _, sub := range subs {
+		sub.close(true)
+	}
+	defer x.close()
+	for _, sub := range subs {
+		select {
+		case <-sub.done:
+		case <-ctx.Done():
+			for _, sub := range subs {
+				sub.close(false)
+			}
+			return fmt.Errorf("broker: draining memory, %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+func (x *Memory) close() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.closed = true
+	x.subs = make(map[*memorySub]struct{})
+}
+
+func (x *Memory) remove(sub *memorySub) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(x.subs, sub)
+}
+
+// memorySub is a subscription of a Memory broker.
+type memorySub struct {
+	broker  *Memory
+	subject string
+	handler Handler
+
+	mu       sync.Mutex
+	queue    []*Msg
+	draining bool
+	closed   bool
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+// push queues msg. It reports false if the subscription is closed
+// or draining.
+func (x *memorySub) push(msg *Msg) bool {
+	x.mu.Lock()
+	if x.closed || x.draining {
+		x.mu.Unlock()
+		return false
+	}
+	x.queue = append(x.queue, msg)
+	x.mu.Unlock()
+
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Unsubscribe stops the delivery of messages.
+func (x *memorySub) Unsubscribe() error {
+	x.mu.Lock()
+	closed := x.closed
+	x.mu.Unlock()
+	if closed {
+		return fmt.Errorf("%w: subscription to %s", ErrClosed, x.subject)
+	}
+	x.close(false)
+	return nil
+}
+
+// close stops the subscription. If drain is set, the queued
+// messages are handled first.
+func (x *memorySub) close(drain bool) {
+	x.mu.Lock()
+	if drain {
+		x.draining = true
+	} else {
+		x.closed = true
+		x.queue = nil
+	}
+	x.mu.Unlock()
+
+	x.broker.remove(x)
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (x *memorySub) run() {
+	defer close(x.done)
+
+	for range x.wake {
+		for {
+			x.mu.Lock()
+			if x.closed || len(x.queue) == 0 {
+				stop := x.closed || x.draining
+				x.mu.Unlock()
+				if stop {
+					return
+				}
+				break
+			}
+			msg := x.queue[0]
+			x.queue[0] = nil
+			x.queue = x.queue[1:]
+			x.mu.Unlock()
+
+			x.handler(msg)
+		}
+	}
+}
+
+// copyMsg returns a copy of msg that handlers can change freely.
+func copyMsg(msg *Msg) *Msg {
+	header := make(Header, len(msg.Header))
+	for key, values := range msg.Header {
+		header[key] = append([]string(nil), values...)
+	}
+	return &Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  header,
+		Data:    append([]byte(nil), msg.Data...),
+	}
+}
+
+// validSubject reports whether subject is made of non-empty tokens.
+// Wildcards are only valid in subscriptions, and ">" only last.
+func validSubject(subject string, wildcards bool) bool {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return false
+		case token == "*" || token == ">":
+			if !wildcards || (token == ">" && i != len(tokens)-1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchSubject reports whether subject matches pattern.
+func matchSubject(pattern, subject string) bool {
+	patterns := strings.Split(pattern, ".")
+	tokens := strings.Split(subject, ".")
+	for i, p := range patterns {
+		switch {
+		case p == ">":
+			return len(tokens) > i
+		case i >= len(tokens):
+			return false
+		case p != "*" && p != tokens[i]:
+			return false
+		}
+	}
+	return len(patterns) == len(tokens)
+}
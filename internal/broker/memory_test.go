@@ -0,0 +1,17 @@
+package broker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/broker/brokertest"
+)
+
+func Test_Memory(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) broker.Broker {
+		b := broker.NewMemory()
+		t.Cleanup(func() { _ = b.Drain(context.Background()) })
+		return b
+	})
+}
@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+var _ Broker = (*NATS)(nil)
+
+// NATS is a Broker on a NATS connection.
+type NATS struct {
+	nc     *nats.Conn
+	closed chan struct{}
+}
+
+// NewNATS returns a Broker on nc. It replaces the closed handler
+// of nc, which Drain waits for.
+func NewNATS(nc *nats.Conn) *NATS {
+	x := &NATS{nc: nc, closed: make(chan struct{})}
+	nc.SetClosedHandler(func(*nats.Conn) { close(x.closed) })
+	return x
+}
+
+// Conn returns the NATS connection of the broker.
+func (x *NATS) Conn() *nats.Conn {
+	return x.nc
+}
+
+// Publish publishes msg on msg.Subject.
+func (x *NATS) Publish(_ context.Context, msg *Msg) error {
+	return natsErr(x.nc.PublishMsg(ToNATS(msg)))
+}
+
+// Subscribe calls h with the messages published on subjects
+// matching subject.
+func (x *NATS) Subscribe(subject string, h Handler) (Subscription, error) {
+	sub, err := x.nc.Subscribe(subject, func(msg *nats.Msg) {
+		h(FromNATS(msg))
+	})
+	if err != nil {
+		return nil, natsErr(err)
+	}
+	return sub, nil
+}
+
+// Request publishes msg and waits for the first reply.
+func (x *NATS) Request(ctx context.Context, msg *Msg) (*Msg, error) {
+	reply, err := x.nc.RequestMsgWithContext(ctx, ToNATS(msg))
+	if err != nil {
+		return nil, natsErr(err)
+	}
+	return FromNATS(reply), nil
+}
+
+// Drain drains the subscriptions of the connection, then closes it.
+func (x *NATS) Drain(ctx context.Context) error {
+	if err := x.nc.Drain(); err != nil {
+		x.nc.Close()
+		return fmt.Errorf("broker: draining nats, %w", err)
+	}
+	select {
+	case <-x.closed:
+		return nil
+	case <-ctx.Done():
+		x.nc.Close()
+		return fmt.Errorf("broker: draining nats, %w", ctx.Err())
+	}
+}
+
+// ToNATS returns msg as a NATS message.
+func ToNATS(msg *Msg) *nats.Msg {
+	return &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  nats.Header(msg.Header),
+		Data:    msg.Data,
+	}
+}
+
+// FromNATS returns a NATS message as a Msg. Messages delivered by a
+// JetStream consumer carry their stream sequence and are acknowledged
+// through Msg.Ack.
+func FromNATS(msg *nats.Msg) *Msg {
+	m := &Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  Header(msg.Header),
+		Data:    msg.Data,
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		m.Seq = meta.Sequence.Stream
+		m.ack = func() error { return msg.Ack() }
+	}
+	return m
+}
+
+// natsErr maps NATS errors to the errors of this package.
+func natsErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, nats.ErrNoResponders):
+		return fmt.Errorf("%w, %w", ErrNoResponders, err)
+	case errors.Is(err, nats.ErrConnectionClosed), errors.Is(err, nats.ErrConnectionDraining):
+		return fmt.Errorf("%w, %w", ErrClosed, err)
+	case errors.Is(err, nats.ErrBadSubject):
+		return fmt.Errorf("%w, %w", ErrSubjectInvalid, err)
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package broker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/broker/brokertest"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NATS(t *testing.T) {
+	srv, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second))
+	t.Cleanup(srv.Shutdown)
+
+	brokertest.Run(t, func(t *testing.T) broker.Broker {
+		nc, err := nats.Connect(srv.ClientURL())
+		require.NoError(t, err)
+		b := broker.NewNATS(nc)
+		t.Cleanup(func() { _ = b.Drain(context.Background()) })
+		return b
+	})
+}
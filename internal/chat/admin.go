@@ -0,0 +1,374 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/cluster"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// Control-plane ops answered by every node.
+const (
+	OpDisconnect   = "disconnect"
+	OpAnnounce     = "announce"
+	OpLocate       = "locate"
+	OpRoomSessions = "roomSessions"
+)
+
+// announcementPrefix prefixes announcement notices.
+const announcementPrefix = "[announcement] "
+
+var (
+	ErrAnnouncementInvalid = errors.New("announcement invalid")
+)
+
+// RoomInfo describes a room on this node.
+type RoomInfo struct {
+	ID       string `json:"id"`
+	Topic    string `json:"topic"`
+	Sessions int    `json:"sessions"`
+}
+
+// RoleInfo describes what a user may do in a room.
+type RoleInfo struct {
+	RoomID    string `json:"roomID"`
+	UserID    string `json:"userID"`
+	Moderator bool   `json:"moderator"`
+	Muted     bool   `json:"muted"`
+}
+
+// SessionInfo describes a session.
+type SessionInfo struct {
+	// NodeID is the node the session is connected to.
+	NodeID      string    `json:"nodeID"`
+	UserID      string    `json:"userID"`
+	RoomID      string    `json:"roomID"`
+	DisplayName string    `json:"displayName"`
+	Protocol    string    `json:"protocol"`
+	ReadOnly    bool      `json:"readOnly"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// DisconnectRequest disconnects the sessions of a user.
+type DisconnectRequest struct {
+	UserID string
+	// RoomID limits the disconnect to one room if set.
+	RoomID string
+	Reason string
+}
+
+// AnnounceRequest announces a text to the sessions of a room.
+type AnnounceRequest struct {
+	// RoomID is the room to announce to, empty for every room.
+	RoomID string
+	Text   string
+}
+
+// ClusterResult is the outcome of an action applied to every node.
+type ClusterResult struct {
+	// Sessions is the number of sessions the action applied to.
+	Sessions int `json:"sessions"`
+	// Nodes lists the nodes that replied.
+	Nodes []string `json:"nodes"`
+	// Missing lists the known nodes that did not reply in time.
+	Missing []string `json:"missing,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// UserLocation lists the sessions of a user across the cluster.
+type UserLocation struct {
+	UserID   string        `json:"userID"`
+	Sessions []SessionInfo `json:"sessions"`
+	Missing  []string      `json:"missing,omitempty"`
+	Errors   []string      `json:"errors,omitempty"`
+}
+
+// ClusterRooms counts the sessions per room across the cluster.
+type ClusterRooms struct {
+	// Rooms maps room IDs to their number of sessions.
+	Rooms map[string]int `json:"rooms"`
+	// Nodes maps node IDs to their number of sessions per room.
+	Nodes   map[string]map[string]int `json:"nodes"`
+	Missing []string                  `json:"missing,omitempty"`
+	Errors  []string                  `json:"errors,omitempty"`
+}
+
+// AdminService inspects the rooms of this node and applies operator
+// actions and queries to every node through the cluster control plane.
+type AdminService struct {
+	control *cluster.ControlPlane
+	rooms   *Rooms
+	roles   db.RoomRoleRepository
+}
+
+// NewAdminService returns a new instance of AdminService.
+// Call Register before starting the control plane.
+func NewAdminService(
+	control *cluster.ControlPlane,
+	rooms *Rooms,
+	roles db.RoomRoleRepository,
+) *AdminService {
+	return &AdminService{
+		control: control,
+		rooms:   rooms,
+		roles:   roles,
+	}
+}
+
+// Register registers the admin ops of this node on the control plane.
+func (x *AdminService) Register() error {
+	return errors.Join(
+		x.control.Handle(OpDisconnect, x.handleDisconnect),
+		x.control.Handle(OpAnnounce, x.handleAnnounce),
+		x.control.Handle(OpLocate, x.handleLocate),
+		x.control.Handle(OpRoomSessions, x.handleRoomSessions),
+	)
+}
+
+// Nodes lists the live nodes of the cluster.
+func (x *AdminService) Nodes() []cluster.Node {
+	return x.control.Nodes()
+}
+
+// Rooms lists the rooms of this node.
+func (x *AdminService) Rooms() []RoomInfo {
+	rooms := x.rooms.All()
+	infos := make([]RoomInfo, 0, len(rooms))
+	for _, room := range rooms {
+		infos = append(infos, room.Info())
+	}
+	return infos
+}
+
+// Sessions lists the sessions of a room on this node.
+func (x *AdminService) Sessions(roomID string) ([]SessionInfo, error) {
+	room, ok := x.rooms.Get(roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	sessions := room.sessions()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, x.info(sess))
+	}
+	return infos, nil
+}
+
+// Role returns the role of a user in a room.
+func (x *AdminService) Role(ctx context.Context, roomID, userID string) (RoleInfo, error) {
+	if userID == "" {
+		return RoleInfo{}, ErrUserIDInvalid
+	}
+
+	role, err := x.roles.ReadRoomRole(ctx, roomID, userID)
+	if err != nil {
+		return RoleInfo{}, fmt.Errorf("admin service: reading room role, %w", err)
+	}
+	return RoleInfo{
+		RoomID:    role.RoomID,
+		UserID:    role.UserID,
+		Moderator: role.Moderator,
+		Muted:     role.Muted,
+	}, nil
+}
+
+// SetModerator grants or revokes moderation of a room. It applies to
+// the next command of the user on every node.
+func (x *AdminService) SetModerator(
+	ctx context.Context,
+	roomID, userID string,
+	moderator bool,
+) (RoleInfo, error) {
+	if userID == "" {
+		return RoleInfo{}, ErrUserIDInvalid
+	}
+
+	if err := x.roles.SetRoomModerator(ctx, roomID, userID, moderator); err != nil {
+		return RoleInfo{}, fmt.Errorf("admin service: setting moderator, %w", err)
+	}
+	return x.Role(ctx, roomID, userID)
+}
+
+// Disconnect disconnects the sessions of a user on every node.
+func (x *AdminService) Disconnect(ctx context.Context, req DisconnectRequest) (ClusterResult, error) {
+	if req.UserID == "" {
+		return ClusterResult{}, ErrUserIDInvalid
+	}
+	return x.count(ctx, OpDisconnect, req)
+}
+
+// Announce sends a notice to every session of a room,
+// or of every room, on every node.
+func (x *AdminService) Announce(ctx context.Context, req AnnounceRequest) (ClusterResult, error) {
+	if strings.TrimSpace(req.Text) == "" {
+		return ClusterResult{}, ErrAnnouncementInvalid
+	}
+	return x.count(ctx, OpAnnounce, req)
+}
+
+// Locate returns the sessions of a user on every node.
+func (x *AdminService) Locate(ctx context.Context, userID string) (UserLocation, error) {
+	if userID == "" {
+		return UserLocation{}, ErrUserIDInvalid
+	}
+
+	result, err := x.control.Scatter(ctx, OpLocate, userID)
+	if err != nil {
+		return UserLocation{}, fmt.Errorf("admin service: locating user, %w", err)
+	}
+
+	location := UserLocation{
+		UserID:   userID,
+		Sessions: make([]SessionInfo, 0),
+		Missing:  result.Missing,
+	}
+	for _, reply := range result.Replies {
+		var sessions []SessionInfo
+		if err := reply.Decode(&sessions); err != nil {
+			location.Errors = append(location.Errors, reply.NodeID+": "+err.Error())
+			continue
+		}
+		location.Sessions = append(location.Sessions, sessions...)
+	}
+	sort.Slice(location.Sessions, func(i, j int) bool {
+		return location.Sessions[i].NodeID < location.Sessions[j].NodeID
+	})
+	return location, nil
+}
+
+// RoomSessions counts the sessions per room on every node.
+func (x *AdminService) RoomSessions(ctx context.Context) (ClusterRooms, error) {
+	result, err := x.control.Scatter(ctx, OpRoomSessions, nil)
+	if err != nil {
+		return ClusterRooms{}, fmt.Errorf("admin service: counting sessions, %w", err)
+	}
+
+	rooms := ClusterRooms{
+		Rooms:   make(map[string]int),
+		Nodes:   make(map[string]map[string]int, len(result.Replies)),
+		Missing: result.Missing,
+	}
+	for _, reply := range result.Replies {
+		var counts map[string]int
+		if err := reply.Decode(&counts); err != nil {
+			rooms.Errors = append(rooms.Errors, reply.NodeID+": "+err.Error())
+			continue
+		}
+		rooms.Nodes[reply.NodeID] = counts
+		for roomID, n := range counts {
+			rooms.Rooms[roomID] += n
+		}
+	}
+	return rooms, nil
+}
+
+// count scatters an action and sums the sessions it applied to.
+func (x *AdminService) count(ctx context.Context, op string, req any) (ClusterResult, error) {
+	result, err := x.control.Scatter(ctx, op, req)
+	if err != nil {
+		return ClusterResult{}, fmt.Errorf("admin service: %s, %w", op, err)
+	}
+
+	out := ClusterResult{Nodes: make([]string, 0, len(result.Replies)), Missing: result.Missing}
+	for _, reply := range result.Replies {
+		out.Nodes = append(out.Nodes, reply.NodeID)
+		var n int
+		if err := reply.Decode(&n); err != nil {
+			out.Errors = append(out.Errors, reply.NodeID+": "+err.Error())
+			continue
+		}
+		out.Sessions += n
+	}
+	sort.Strings(out.Nodes)
+	return out, nil
+}
+
+func (x *AdminService) handleDisconnect(_ context.Context, r cluster.Request) (any, error) {
+	var req DisconnectRequest
+	if err := r.Decode(&req); err != nil {
+		return nil, fmt.Errorf("admin service: decoding disconnect, %w", err)
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "disconnected by an operator"
+	}
+
+	var n int
+	for _, room := range x.rooms.All() {
+		if req.RoomID != "" && room.ID != req.RoomID {
+			continue
+		}
+		for _, sess := range room.sessions() {
+			if sess.UserID != req.UserID {
+				continue
+			}
+			if err := room.Disconnect(sess, websocket.ClosePolicyViolation, reason); err != nil {
+				log.Error().Err(err).Msg("admin service: disconnecting session")
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (x *AdminService) handleAnnounce(_ context.Context, r cluster.Request) (any, error) {
+	var req AnnounceRequest
+	if err := r.Decode(&req); err != nil {
+		return nil, fmt.Errorf("admin service: decoding announcement, %w", err)
+	}
+
+	var n int
+	for _, room := range x.rooms.All() {
+		if req.RoomID != "" && room.ID != req.RoomID {
+			continue
+		}
+		for _, sess := range room.sessions() {
+			room.notify(sess, announcementPrefix+req.Text)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (x *AdminService) handleLocate(_ context.Context, r cluster.Request) (any, error) {
+	var userID string
+	if err := r.Decode(&userID); err != nil {
+		return nil, fmt.Errorf("admin service: decoding locate, %w", err)
+	}
+
+	sessions := make([]SessionInfo, 0)
+	for _, room := range x.rooms.All() {
+		for _, sess := range room.sessions() {
+			if sess.UserID == userID {
+				sessions = append(sessions, x.info(sess))
+			}
+		}
+	}
+	return sessions, nil
+}
+
+func (x *AdminService) handleRoomSessions(_ context.Context, _ cluster.Request) (any, error) {
+	counts := make(map[string]int)
+	for _, room := range x.rooms.All() {
+		if n := len(room.sessions()); n > 0 {
+			counts[room.ID] = n
+		}
+	}
+	return counts, nil
+}
+
+func (x *AdminService) info(sess *UserSess) SessionInfo {
+	info := sess.Info()
+	info.NodeID = x.control.NodeID()
+	return info
+}
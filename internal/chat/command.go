@@ -0,0 +1,216 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/rs/zerolog/log"
+)
+
+// CommandPrefix marks a text message as a command.
+// A message starting with two prefixes is sent as a regular
+// message with the first prefix removed.
+const CommandPrefix = "/"
+
+var (
+	ErrCommandNameInvalid  = errors.New("command name invalid")
+	ErrCommandExists       = errors.New("command already registered")
+	ErrCommandNotFound     = errors.New("command not found")
+	ErrCommandForbidden    = errors.New("command forbidden")
+	ErrCommandUsage        = errors.New("command usage invalid")
+	ErrCommandHandlerIsNil = errors.New("command handler is nil")
+)
+
+// CommandHandler handles a single command invocation.
+// A returned error is sent back to the caller only.
+type CommandHandler func(cmd *CommandContext) error
+
+// Command defines a slash command.
+type Command struct {
+	// Name is the command name without the prefix, e.g. "nick".
+	Name string
+	// Usage is a short usage string, e.g. "/nick <name>".
+	Usage string
+	// Description is shown by /help.
+	Description string
+	// Handler validates permissions and acts on the command.
+	Handler CommandHandler
+}
+
+// CommandContext holds everything a CommandHandler needs
+// to act on and respond to a command.
+type CommandContext struct {
+	// Name is the invoked command name.
+	Name string
+	// Args are the whitespace separated command arguments.
+	Args []string
+	// Text is the raw text following the command name.
+	Text string
+	// Session is the calling session.
+	Session *UserSess
+	// Room is the room the command was sent in.
+	Room *Room
+	// Registry is the registry the command was dispatched from.
+	Registry *CommandRegistry
+}
+
+// Reply sends a message to the caller only.
+func (x *CommandContext) Reply(format string, args ...any) error {
+	return x.Session.Notify(fmt.Sprintf(format, args...))
+}
+
+// Moderate publishes a Moderation event for the action of the caller.
+func (x *CommandContext) Moderate(action ModerationAction, targetID, text string) error {
+	return event.Publish(context.Background(), x.Room.eventRegistry, Moderated, x.Room.ID, Moderation{
+		RoomID:      x.Room.ID,
+		ModeratorID: x.Session.UserID,
+		Action:      action,
+		TargetID:    targetID,
+		Text:        text,
+	})
+}
+
+// ReplyRoom sends a message, authored by the caller, to the whole room.
+// The message goes through the MessageCreatedInRoomEvent like any other
+// message, so it is persisted and broadcast across nodes.
+func (x *CommandContext) ReplyRoom(format string, args ...any) error {
+	now := time.Now().UTC()
+	return event.Publish(context.Background(), x.Room.eventRegistry, MessageCreatedInRoom, x.Room.ID, Message{
+		ID:        newMessageID(now),
+		Type:      textMessage,
+		RoomID:    x.Room.ID,
+		SessionID: x.Session.UserID,
+		Body:      []byte(fmt.Sprintf(format, args...)),
+		Author:    x.Session.DisplayName(),
+		Timestamp: now.Format(time.RFC3339),
+	})
+}
+
+// RequireModerator returns ErrCommandForbidden if the caller is neither
+// a moderator of the room nor a service account with the moderate scope
+// in it.
+func (x *CommandContext) RequireModerator() error {
+	if x.Session.Principal != nil && x.Session.Principal.Allows(auth.ScopeModerate, x.Room.ID) {
+		return nil
+	}
+	ok, err := x.Room.IsModerator(context.Background(), x.Session.UserID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: /%s requires a room moderator", ErrCommandForbidden, x.Name)
+	}
+	return nil
+}
+
+// CommandRegistry is a concurrent-safe registry of slash commands.
+// Packages register their own commands on it at startup.
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// NewCommandRegistry returns a new, empty CommandRegistry.
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		commands: make(map[string]Command),
+	}
+}
+
+// Register adds a command to the registry.
+// Command names are case-insensitive and must be unique.
+func (x *CommandRegistry) Register(cmd Command) error {
+	name := strings.ToLower(strings.TrimPrefix(cmd.Name, CommandPrefix))
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return ErrCommandNameInvalid
+	}
+	if cmd.Handler == nil {
+		return ErrCommandHandlerIsNil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, exists := x.commands[name]; exists {
+		return fmt.Errorf("%w: %s", ErrCommandExists, name)
+	}
+	cmd.Name = name
+	x.commands[name] = cmd
+
+	return nil
+}
+
+// Commands returns all registered commands sorted by name.
+func (x *CommandRegistry) Commands() []Command {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	commands := make([]Command, 0, len(x.commands))
+	for _, cmd := range x.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+
+	return commands
+}
+
+// Intercept reports whether body is a command and, if so, executes it.
+// Errors are replied to the caller. A body escaped with a double prefix
+// is returned with the first prefix stripped and should be sent as a
+// regular message.
+func (x *CommandRegistry) Intercept(
+	sess *UserSess,
+	room *Room,
+	body []byte,
+) (handled bool, rest []byte) {
+	text := string(body)
+	if !strings.HasPrefix(text, CommandPrefix) {
+		return false, body
+	}
+	if strings.HasPrefix(text, CommandPrefix+CommandPrefix) {
+		return false, body[len(CommandPrefix):]
+	}
+
+	text = strings.TrimSpace(strings.TrimPrefix(text, CommandPrefix))
+	name, rawArgs, _ := strings.Cut(text, " ")
+	name = strings.ToLower(name)
+
+	cmdCtx := &CommandContext{
+		Name:     name,
+		Args:     strings.Fields(rawArgs),
+		Text:     strings.TrimSpace(rawArgs),
+		Session:  sess,
+		Room:     room,
+		Registry: x,
+	}
+
+	x.mu.RLock()
+	cmd, ok := x.commands[name]
+	x.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("%w: /%s, try /help", ErrCommandNotFound, name)
+	} else {
+		err = cmd.Handler(cmdCtx)
+	}
+	if err != nil {
+		if errors.Is(err, ErrCommandUsage) {
+			err = fmt.Errorf("usage: %s", cmd.Usage)
+		}
+		if replyErr := cmdCtx.Reply("%s", err.Error()); replyErr != nil {
+			log.Error().Err(replyErr).Msg("chat: replying to command")
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+)
+
+const (
+	// maxNickLength is the maximum length of a display name in runes.
+	maxNickLength = 32
+	// maxTopicLength is the maximum length of a room topic in runes.
+	maxTopicLength = 256
+)
+
+// RegisterBuiltinCommands registers /me, /nick, /topic, /invite,
+// /kick, /mute and /help on the given registry.
+func RegisterBuiltinCommands(registry *CommandRegistry, userRepo db.UserRepository) error {
+	for _, cmd := range []Command{
+		{
+			Name:        "me",
+			Usage:       "/me <action>",
+			Description: "Send an action message to the room.",
+			Handler:     handleMe,
+		},
+		{
+			Name:        "nick",
+			Usage:       "/nick <name>",
+			Description: "Change your display name.",
+			Handler:     handleNick,
+		},
+		{
+			Name:        "topic",
+			Usage:       "/topic [topic]",
+			Description: "Show the room topic, or set it as a moderator.",
+			Handler:     handleTopic,
+		},
+		{
+			Name:        "invite",
+			Usage:       "/invite <userID>",
+			Description: "Invite a user to the room. Moderators only.",
+			Handler:     inviteHandler(userRepo),
+		},
+		{
+			Name:        "kick",
+			Usage:       "/kick <name|userID>",
+			Description: "Disconnect a user from the room. Moderators only.",
+			Handler:     handleKick,
+		},
+		{
+			Name:        "mute",
+			Usage:       "/mute <name|userID>",
+			Description: "Toggle whether a user may post in the room. Moderators only.",
+			Handler:     handleMute,
+		},
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "List available commands.",
+			Handler:     handleHelp,
+		},
+	} {
+		if err := registry.Register(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func handleMe(cmd *CommandContext) error {
+	if cmd.Text == "" {
+		return ErrCommandUsage
+	}
+	return cmd.ReplyRoom("* %s %s", cmd.Session.DisplayName(), cmd.Text)
+}
+
+func handleNick(cmd *CommandContext) error {
+	if cmd.Text == "" || utf8.RuneCountInString(cmd.Text) > maxNickLength {
+		return ErrCommandUsage
+	}
+
+	old := cmd.Session.DisplayName()
+	cmd.Session.SetDisplayName(cmd.Text)
+
+	return cmd.ReplyRoom("* %s is now known as %s", old, cmd.Text)
+}
+
+func handleTopic(cmd *CommandContext) error {
+	if cmd.Text == "" {
+		topic := cmd.Room.Topic()
+		if topic == "" {
+			return cmd.Reply("no topic is set")
+		}
+		return cmd.Reply("topic: %s", topic)
+	}
+
+	if err := cmd.RequireModerator(); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(cmd.Text) > maxTopicLength {
+		return ErrCommandUsage
+	}
+
+	cmd.Room.SetTopic(cmd.Text)
+	if err := cmd.Moderate(ModerationTopic, "", cmd.Text); err != nil {
+		return err
+	}
+
+	return cmd.ReplyRoom("* %s set the topic to: %s", cmd.Session.DisplayName(), cmd.Text)
+}
+
+func inviteHandler(userRepo db.UserRepository) CommandHandler {
+	return func(cmd *CommandContext) error {
+		if len(cmd.Args) != 1 {
+			return ErrCommandUsage
+		}
+		if err := cmd.RequireModerator(); err != nil {
+			return err
+		}
+		userID, err := gocql.ParseUUID(cmd.Args[0])
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUserIDInvalid, cmd.Args[0])
+		}
+		roomID, err := gocql.ParseUUID(cmd.Room.ID)
+		if err != nil {
+			return ErrRoomIDInvalid
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := userRepo.CreateUserInRoom(ctx, db.UserInRoom{
+			UserID: userID,
+			RoomID: roomID,
+		}); err != nil {
+			return fmt.Errorf("chat: inviting user, %w", err)
+		}
+
+		return cmd.Reply("invited %s", userID)
+	}
+}
+
+func handleKick(cmd *CommandContext) error {
+	if len(cmd.Args) != 1 {
+		return ErrCommandUsage
+	}
+	if err := cmd.RequireModerator(); err != nil {
+		return err
+	}
+
+	target := cmd.Room.FindSession(cmd.Args[0])
+	if target == nil {
+		return fmt.Errorf("no such user: %s", cmd.Args[0])
+	}
+	if err := cmd.Moderate(ModerationKick, target.UserID, ""); err != nil {
+		return err
+	}
+	if err := cmd.ReplyRoom(
+		"* %s was kicked by %s",
+		target.DisplayName(),
+		cmd.Session.DisplayName(),
+	); err != nil {
+		return err
+	}
+
+	return cmd.Room.Kick(target, "kicked by "+cmd.Session.DisplayName())
+}
+
+func handleMute(cmd *CommandContext) error {
+	if len(cmd.Args) != 1 {
+		return ErrCommandUsage
+	}
+	if err := cmd.RequireModerator(); err != nil {
+		return err
+	}
+
+	target := cmd.Room.FindSession(cmd.Args[0])
+	if target == nil {
+		return fmt.Errorf("no such user: %s", cmd.Args[0])
+	}
+
+	ctx := context.Background()
+	muted, err := cmd.Room.IsMuted(ctx, target.UserID)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Room.SetMuted(ctx, target.UserID, !muted); err != nil {
+		return err
+	}
+	if !muted {
+		if err := cmd.Moderate(ModerationMute, target.UserID, ""); err != nil {
+			return err
+		}
+		return cmd.ReplyRoom("* %s was muted by %s", target.DisplayName(), cmd.Session.DisplayName())
+	}
+	if err := cmd.Moderate(ModerationUnmute, target.UserID, ""); err != nil {
+		return err
+	}
+	return cmd.ReplyRoom("* %s was unmuted by %s", target.DisplayName(), cmd.Session.DisplayName())
+}
+
+func handleHelp(cmd *CommandContext) error {
+	var b strings.Builder
+	b.WriteString("available commands:")
+	for _, c := range cmd.Registry.Commands() {
+		fmt.Fprintf(&b, "\n%s - %s", c.Usage, c.Description)
+	}
+	return cmd.Reply("%s", b.String())
+}
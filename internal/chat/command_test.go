@@ -0,0 +1,436 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/auth"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// testSession returns a JSON protocol session of userID and
+// the client side of its websocket.
+func testSession(t *testing.T, roomID, userID string) (*UserSess, *websocket.Conn) {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+
+	return &UserSess{
+		UserID:      userID,
+		RoomID:      roomID,
+		displayName: userID,
+		Protocol:    protocol.JSON,
+		Conn:        conn,
+		ConnectedAt: time.Now().UTC(),
+	}, client
+}
+
+// readFrame reads the next frame of a client.
+func readFrame(t *testing.T, conn *websocket.Conn) protocol.Frame {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var frame protocol.Frame
+	require.NoError(t, json.Unmarshal(data, &frame))
+	return frame
+}
+
+// userRepo is a UserRepository keeping memberships in memory.
+type userRepo struct {
+	mu    sync.Mutex
+	rooms []db.UserInRoom
+}
+
+func (x *userRepo) CreateUserInRoom(_ context.Context, params db.UserInRoom) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.rooms = append(x.rooms, params)
+	return nil
+}
+
+func (x *userRepo) ReadRoomsByUser(_ context.Context, userID gocql.UUID) ([]db.UserInRoom, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var rooms []db.UserInRoom
+	for _, room := range x.rooms {
+		if room.UserID == userID {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms, nil
+}
+
+func (x *userRepo) DeleteUserInRoom(_ context.Context, params db.UserInRoom) error {
+	return nil
+}
+
+// roleRepo is a RoomRoleRepository keeping roles in memory.
+type roleRepo struct {
+	mu    sync.Mutex
+	roles map[[2]string]db.RoomRole
+}
+
+func newRoleRepo() *roleRepo {
+	return &roleRepo{roles: make(map[[2]string]db.RoomRole)}
+}
+
+func (x *roleRepo) ReadRoomRole(_ context.Context, roomID, userID string) (db.RoomRole, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if role, ok := x.roles[[2]string{userID, roomID}]; ok {
+		return role, nil
+	}
+	return db.RoomRole{UserID: userID, RoomID: roomID}, nil
+}
+
+func (x *roleRepo) ReadRoomRolesByUser(_ context.Context, userID string) ([]db.RoomRole, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var roles []db.RoomRole
+	for key, role := range x.roles {
+		if key[0] == userID {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (x *roleRepo) SetRoomModerator(ctx context.Context, roomID, userID string, moderator bool) error {
+	role, _ := x.ReadRoomRole(ctx, roomID, userID)
+	role.Moderator = moderator
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.roles[[2]string{userID, roomID}] = role
+	return nil
+}
+
+func (x *roleRepo) SetRoomMuted(ctx context.Context, roomID, userID string, muted bool) error {
+	role, _ := x.ReadRoomRole(ctx, roomID, userID)
+	role.Muted = muted
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.roles[[2]string{userID, roomID}] = role
+	return nil
+}
+
+func (x *roleRepo) DeleteRoomRolesByUser(_ context.Context, userID string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for key := range x.roles {
+		if key[0] == userID {
+			delete(x.roles, key)
+		}
+	}
+	return nil
+}
+
+// commandRoom is a room with a moderator, ann, and a member, bob,
+// that records the messages and moderation events it publishes.
+type commandRoom struct {
+	room      *Room
+	commands  *CommandRegistry
+	users     *userRepo
+	roles     *roleRepo
+	messages  chan Message
+	moderated chan Moderation
+
+	ann, bob             *UserSess
+	annClient, bobClient *websocket.Conn
+}
+
+func newCommandRoom(t *testing.T) *commandRoom {
+	t.Helper()
+
+	registry := event.NewRegistry(event.Config{})
+	t.Cleanup(func() { _ = registry.Close(context.Background()) })
+	x := &commandRoom{
+		commands:  NewCommandRegistry(),
+		users:     &userRepo{},
+		roles:     newRoleRepo(),
+		messages:  make(chan Message, 16),
+		moderated: make(chan Moderation, 16),
+	}
+	_, err := event.Subscribe(registry, MessageCreatedInRoom, func(_ event.Event, m Message) error {
+		x.messages <- m
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = event.Subscribe(registry, Moderated, func(_ event.Event, m Moderation) error {
+		x.moderated <- m
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, RegisterBuiltinCommands(x.commands, x.users))
+
+	roomID := gocql.TimeUUID().String()
+	x.room, err = NewRoom(&roomID, registry, x.commands, x.roles)
+	require.NoError(t, err)
+	x.ann, x.annClient = testSession(t, roomID, "ann")
+	x.bob, x.bobClient = testSession(t, roomID, "bob")
+	x.room.Sessions[x.ann] = empty{}
+	x.room.Sessions[x.bob] = empty{}
+	require.NoError(t, x.roles.SetRoomModerator(context.Background(), roomID, "ann", true))
+	return x
+}
+
+// muted reports whether userID is muted in the room.
+func (x *commandRoom) muted(t *testing.T, userID string) bool {
+	t.Helper()
+
+	muted, err := x.room.IsMuted(context.Background(), userID)
+	require.NoError(t, err)
+	return muted
+}
+
+// run sends text as sess and reports whether it was handled.
+func (x *commandRoom) run(t *testing.T, sess *UserSess, text string) bool {
+	t.Helper()
+
+	handled, _ := x.commands.Intercept(sess, x.room, []byte(text))
+	return handled
+}
+
+func (x *commandRoom) message(t *testing.T) Message {
+	t.Helper()
+
+	select {
+	case m := <-x.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published")
+		return Message{}
+	}
+}
+
+func Test_CommandRegistry_Register(t *testing.T) {
+	registry := NewCommandRegistry()
+	handler := func(*CommandContext) error { return nil }
+
+	require.ErrorIs(t, registry.Register(Command{Name: "", Handler: handler}), ErrCommandNameInvalid)
+	require.ErrorIs(t, registry.Register(Command{Name: "two words", Handler: handler}), ErrCommandNameInvalid)
+	require.ErrorIs(t, registry.Register(Command{Name: "ping"}), ErrCommandHandlerIsNil)
+	require.NoError(t, registry.Register(Command{Name: "/Ping", Handler: handler}))
+	require.ErrorIs(t, registry.Register(Command{Name: "ping", Handler: handler}), ErrCommandExists)
+
+	commands := registry.Commands()
+	require.Len(t, commands, 1)
+	require.Equal(t, "ping", commands[0].Name)
+}
+
+func Test_CommandRegistry_Intercept(t *testing.T) {
+	x := newCommandRoom(t)
+	var got *CommandContext
+	require.NoError(t, x.commands.Register(Command{
+		Name:  "echo",
+		Usage: "/echo <text>",
+		Handler: func(cmd *CommandContext) error {
+			if cmd.Text == "" {
+				return ErrCommandUsage
+			}
+			got = cmd
+			return nil
+		},
+	}))
+
+	t.Run("Message", func(t *testing.T) {
+		handled, rest := x.commands.Intercept(x.ann, x.room, []byte("hello"))
+		require.False(t, handled)
+		require.Equal(t, "hello", string(rest))
+	})
+
+	t.Run("Escaped", func(t *testing.T) {
+		handled, rest := x.commands.Intercept(x.ann, x.room, []byte("//echo"))
+		require.False(t, handled)
+		require.Equal(t, "/echo", string(rest))
+	})
+
+	t.Run("Command", func(t *testing.T) {
+		require.True(t, x.run(t, x.ann, "/ECHO  a  b "))
+		require.NotNil(t, got)
+		require.Equal(t, "echo", got.Name)
+		require.Equal(t, []string{"a", "b"}, got.Args)
+		require.Equal(t, "a  b", got.Text)
+		require.Same(t, x.ann, got.Session)
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		require.True(t, x.run(t, x.ann, "/echo"))
+		frame := readFrame(t, x.annClient)
+		require.Equal(t, protocol.FrameNotice, frame.Type)
+		require.Equal(t, "usage: /echo <text>", frame.Body)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		require.True(t, x.run(t, x.ann, "/nope"))
+		require.Contains(t, readFrame(t, x.annClient).Body, ErrCommandNotFound.Error())
+	})
+}
+
+func Test_BuiltinCommands(t *testing.T) {
+	t.Run("Me", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.bob, "/me waves"))
+		m := x.message(t)
+		require.Equal(t, "* bob waves", string(m.Body))
+		require.NoError(t, m.Valid())
+	})
+
+	t.Run("Nick", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.bob, "/nick robert"))
+		require.Equal(t, "robert", x.bob.DisplayName())
+		require.Equal(t, "* bob is now known as robert", string(x.message(t).Body))
+	})
+
+	t.Run("Nick while sending", func(t *testing.T) {
+		x := newCommandRoom(t)
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for i := 0; i < 5; i++ {
+				x.room.receive(x.bob, websocket.TextMessage, []byte(`{"type":"message","body":"hi"}`))
+				x.room.FindSession("robert")
+			}
+		}()
+		for i := 0; i < 5; i++ {
+			require.True(t, x.run(t, x.bob, "/nick robert"))
+		}
+		<-sent
+		for i := 0; i < 10; i++ {
+			x.message(t)
+		}
+		require.Equal(t, "robert", x.bob.DisplayName())
+	})
+
+	t.Run("Topic", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.bob, "/topic news"))
+		require.Contains(t, readFrame(t, x.bobClient).Body, ErrCommandForbidden.Error())
+		require.Empty(t, x.room.Topic())
+
+		require.True(t, x.run(t, x.ann, "/topic news"))
+		require.Equal(t, "news", x.room.Topic())
+		require.Equal(t, ModerationTopic, (<-x.moderated).Action)
+	})
+
+	t.Run("Moderate scope", func(t *testing.T) {
+		x := newCommandRoom(t)
+		x.bob.Principal = &auth.Principal{Scopes: []auth.Scope{auth.ScopeModerate}, Rooms: []string{"other"}}
+		require.True(t, x.run(t, x.bob, "/topic news"))
+		require.Contains(t, readFrame(t, x.bobClient).Body, ErrCommandForbidden.Error())
+		x.bob.Principal = &auth.Principal{Scopes: []auth.Scope{auth.ScopeRoomsPost}}
+		require.True(t, x.run(t, x.bob, "/mute ann"))
+		require.Contains(t, readFrame(t, x.bobClient).Body, ErrCommandForbidden.Error())
+
+		x.bob.Principal = &auth.Principal{Scopes: []auth.Scope{auth.ScopeModerate}, Rooms: []string{x.room.ID}}
+		require.True(t, x.run(t, x.bob, "/topic news"))
+		require.Equal(t, "news", x.room.Topic())
+		require.Equal(t, ModerationTopic, (<-x.moderated).Action)
+		require.True(t, x.run(t, x.bob, "/mute ann"))
+		require.True(t, x.muted(t, "ann"))
+		require.Equal(t, ModerationMute, (<-x.moderated).Action)
+	})
+
+	t.Run("Invite", func(t *testing.T) {
+		x := newCommandRoom(t)
+		userID := gocql.TimeUUID()
+
+		require.True(t, x.run(t, x.bob, "/invite "+userID.String()))
+		require.Contains(t, readFrame(t, x.bobClient).Body, ErrCommandForbidden.Error())
+		require.Empty(t, x.users.rooms)
+
+		require.True(t, x.run(t, x.ann, "/invite "+userID.String()))
+		require.Equal(t, "invited "+userID.String(), readFrame(t, x.annClient).Body)
+		require.Len(t, x.users.rooms, 1)
+		require.Equal(t, userID, x.users.rooms[0].UserID)
+	})
+
+	t.Run("Mute", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.bob, "/mute ann"))
+		require.Contains(t, readFrame(t, x.bobClient).Body, ErrCommandForbidden.Error())
+		require.False(t, x.muted(t, "ann"))
+
+		require.True(t, x.run(t, x.ann, "/mute bob"))
+		require.True(t, x.muted(t, "bob"))
+		require.Equal(t, ModerationMute, (<-x.moderated).Action)
+		require.Equal(t, "* bob was muted by ann", string(x.message(t).Body))
+
+		x.room.receive(x.bob, websocket.TextMessage, []byte(`{"type":"message","body":"hi"}`))
+		require.Equal(t, "you are muted in this room", readFrame(t, x.bobClient).Body)
+
+		require.True(t, x.run(t, x.ann, "/mute bob"))
+		require.False(t, x.muted(t, "bob"))
+		require.Equal(t, ModerationUnmute, (<-x.moderated).Action)
+	})
+
+	t.Run("Roles shared", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.ann, "/mute bob"))
+		require.True(t, x.muted(t, "bob"))
+
+		// The same room served by another node, or after a restart,
+		// where bob happens to connect first.
+		other, err := NewRoom(&x.room.ID, x.room.eventRegistry, x.commands, x.roles)
+		require.NoError(t, err)
+		bob, bobClient := testSession(t, x.room.ID, "bob")
+		other.Sessions[bob] = empty{}
+
+		other.receive(bob, websocket.TextMessage, []byte(`{"type":"message","body":"hi"}`))
+		require.Equal(t, "you are muted in this room", readFrame(t, bobClient).Body)
+		handled, _ := x.commands.Intercept(bob, other, []byte("/topic mine"))
+		require.True(t, handled)
+		require.Contains(t, readFrame(t, bobClient).Body, ErrCommandForbidden.Error())
+		require.Empty(t, other.Topic())
+	})
+
+	t.Run("Kick", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.bob, "/kick ann"))
+		require.Contains(t, readFrame(t, x.bobClient).Body, ErrCommandForbidden.Error())
+
+		require.True(t, x.run(t, x.ann, "/kick bob"))
+		require.Equal(t, ModerationKick, (<-x.moderated).Action)
+		require.NoError(t, x.bobClient.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, _, err := x.bobClient.ReadMessage()
+		require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		x := newCommandRoom(t)
+		require.True(t, x.run(t, x.bob, "/help"))
+		body := readFrame(t, x.bobClient).Body
+		for _, cmd := range x.commands.Commands() {
+			require.Contains(t, body, cmd.Usage)
+		}
+	})
+}
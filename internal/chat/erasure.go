@@ -0,0 +1,613 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/cluster"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/gocql/gocql"
+	"github.com/rs/zerolog/log"
+)
+
+// ErasureMode defines what happens to the messages of an erased user.
+type ErasureMode string
+
+const (
+	// ErasureDelete deletes the messages of the user.
+	ErasureDelete ErasureMode = "delete"
+	// ErasureAnonymise keeps the messages but removes the sender identity.
+	ErasureAnonymise ErasureMode = "anonymise"
+)
+
+// AnonymousSender replaces the sender of anonymised messages.
+const AnonymousSender = "deleted user"
+
+// OpEraseDeadLetters is the control-plane op that drops the dead
+// letters about a user on every node.
+const OpEraseDeadLetters = "eraseDeadLetters"
+
+// erasureLimitations lists what erasure cannot find. It is part of
+// every report, so the signature covers it.
+var erasureLimitations = []string{
+	"messages stored before message_by_room.user_id existed are not indexed by sender and are not erased",
+	"idempotency keys claimed before message_idempotency_by_user existed are not erased and expire with the idempotency window",
+}
+
+var (
+	ErrErasureModeInvalid      = errors.New("erasure mode invalid")
+	ErrErasureUserIDInvalid    = errors.New("erasure user ID invalid")
+	ErrErasureReportKeyInvalid = errors.New("erasure report key invalid")
+	ErrErasureReportInvalid    = errors.New("erasure report signature invalid")
+)
+
+// ErasureReport describes the outcome of erasing a user's data.
+// Reactions are not persisted and webhook attachments are stored inline
+// in message bodies, so both are covered by the message counts.
+type ErasureReport struct {
+	UserID      string      `json:"userID"`
+	Mode        ErasureMode `json:"mode"`
+	StartedAt   time.Time   `json:"startedAt"`
+	CompletedAt time.Time   `json:"completedAt"`
+	// Messages is the number of deleted or anonymised messages.
+	Messages int `json:"messages"`
+	// StreamMessages is the number of deleted copies in the room stream.
+	StreamMessages int `json:"streamMessages"`
+	// Memberships is the number of deleted room memberships.
+	Memberships int `json:"memberships"`
+	// Roles is the number of deleted room roles, which
+	// record moderators and mutes.
+	Roles int `json:"roles"`
+	// IdempotencyKeys is the number of deleted idempotency keys
+	// of the user's messages, in every room.
+	IdempotencyKeys int `json:"idempotencyKeys"`
+	// Events is the number of deleted events about the user
+	// in the event log.
+	Events int `json:"events"`
+	// DeadLetters is the number of dropped dead letters about the
+	// user, which keep full event payloads in memory on every node.
+	DeadLetters int `json:"deadLetters"`
+	// MissingNodes lists the nodes that did not confirm
+	// dropping their dead letters.
+	MissingNodes []string `json:"missingNodes,omitempty"`
+	// HeldMessages is the number of messages kept because
+	// their room is under legal hold.
+	HeldMessages int      `json:"heldMessages"`
+	HeldRooms    []string `json:"heldRooms"`
+	// Remaining is what still references the user outside held rooms
+	// after erasure. Verified is true if none of it is left.
+	Remaining ErasureRemaining `json:"remaining"`
+	Verified  bool             `json:"verified"`
+	// Limitations lists data erasure cannot find, which may
+	// still reference the user even when verified.
+	Limitations []string `json:"limitations"`
+	// Signature is the hex HMAC-SHA256 of the report without the
+	// signature, keyed with the report key of the server.
+	Signature string `json:"signature"`
+}
+
+// ErasureRemaining counts what erasure verification found in each store.
+// Erased message rows, outbox entries and events are read again rather
+// than looked up through the index erasure started from.
+type ErasureRemaining struct {
+	// Messages are message rows that still exist, or still name the
+	// user when anonymised, and references in the sender index.
+	Messages int `json:"messages"`
+	// Outbox are outbox entries of erased messages.
+	Outbox int `json:"outbox"`
+	// Stream are messages of the user in the room stream.
+	Stream int `json:"stream"`
+	// Events are events about the user in the event log.
+	Events int `json:"events"`
+	// Roles are room roles of the user.
+	Roles int `json:"roles"`
+	// IdempotencyKeys are idempotency keys of the user.
+	IdempotencyKeys int `json:"idempotencyKeys"`
+	// DeadLetterNodes are nodes that did not confirm dropping
+	// their dead letters, listed in the report as MissingNodes.
+	DeadLetterNodes int `json:"deadLetterNodes"`
+}
+
+func (x ErasureRemaining) empty() bool {
+	return x == ErasureRemaining{}
+}
+
+// mac returns the HMAC-SHA256 of the report without the signature.
+func (x ErasureReport) mac(key []byte) ([]byte, error) {
+	x.Signature = ""
+	b, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return mac.Sum(nil), nil
+}
+
+// ErasureService erases the data of a user on request.
+type ErasureService struct {
+	messageRepo db.MessageRepository
+	userRepo    db.UserRepository
+	eventRepo   db.EventRepository
+	outboxRepo  db.OutboxRepository
+	roleRepo    db.RoomRoleRepository
+	// stream is nil when room streams are disabled.
+	stream      *RoomStream
+	retention   *RetentionService
+	deadLetters *event.DeadLetters
+	// control is nil when only this node's dead letters are erased.
+	control   *cluster.ControlPlane
+	reportKey []byte
+}
+
+// NewErasureService returns a new instance of ErasureService.
+// Reports are signed with reportKey, which must not be empty.
+// Call Register before starting the control plane.
+func NewErasureService(
+	messageRepo db.MessageRepository,
+	userRepo db.UserRepository,
+	eventRepo db.EventRepository,
+	outboxRepo db.OutboxRepository,
+	roleRepo db.RoomRoleRepository,
+	stream *RoomStream,
+	retention *RetentionService,
+	deadLetters *event.DeadLetters,
+	control *cluster.ControlPlane,
+	reportKey []byte,
+) (*ErasureService, error) {
+	if len(reportKey) == 0 {
+		return nil, ErrErasureReportKeyInvalid
+	}
+	return &ErasureService{
+		messageRepo: messageRepo,
+		userRepo:    userRepo,
+		eventRepo:   eventRepo,
+		outboxRepo:  outboxRepo,
+		roleRepo:    roleRepo,
+		stream:      stream,
+		retention:   retention,
+		deadLetters: deadLetters,
+		control:     control,
+		reportKey:   reportKey,
+	}, nil
+}
+
+// Register registers the erasure ops of this node on the control plane.
+func (x *ErasureService) Register() error {
+	return x.control.Handle(OpEraseDeadLetters, x.handleEraseDeadLetters)
+}
+
+// Erase deletes or anonymises every message of a user, with its outbox
+// entry and its copies in the room stream, and deletes the user's room
+// memberships, roles and idempotency keys, the events about the user
+// in the event log and the dead letters about the user on every node.
+// Messages in rooms under legal hold are kept and listed in the report.
+// Erase is safe to retry.
+func (x *ErasureService) Erase(
+	ctx context.Context,
+	userID string,
+	mode ErasureMode,
+) (ErasureReport, error) {
+	if userID == "" {
+		return ErasureReport{}, ErrErasureUserIDInvalid
+	}
+	if mode != ErasureDelete && mode != ErasureAnonymise {
+		return ErasureReport{}, fmt.Errorf("%w: %s", ErrErasureModeInvalid, mode)
+	}
+
+	report := ErasureReport{
+		UserID:      userID,
+		Mode:        mode,
+		StartedAt:   time.Now().UTC(),
+		HeldRooms:   make([]string, 0),
+		Limitations: erasureLimitations,
+	}
+
+	refs, err := x.messageRepo.ReadMessageRefsBySender(ctx, userID)
+	if err != nil {
+		return ErasureReport{}, fmt.Errorf("erasure service: reading messages, %w", err)
+	}
+
+	held := make(map[string]bool)
+	var erased []db.MessageRef
+	for _, ref := range refs {
+		isHeld, err := x.held(ctx, held, ref.RoomID)
+		if err != nil {
+			return ErasureReport{}, err
+		}
+		if isHeld {
+			report.HeldMessages++
+			continue
+		}
+		if err := x.eraseMessage(ctx, userID, ref, mode); err != nil {
+			return ErasureReport{}, err
+		}
+		erased = append(erased, ref)
+	}
+	report.Messages = len(erased)
+
+	if report.StreamMessages, err = x.eraseStream(ctx, userID, held, true); err != nil {
+		return ErasureReport{}, err
+	}
+	if report.Memberships, err = x.eraseMemberships(ctx, userID); err != nil {
+		return ErasureReport{}, err
+	}
+	if report.Roles, err = x.eraseRoles(ctx, userID); err != nil {
+		return ErasureReport{}, err
+	}
+	if report.IdempotencyKeys, err = x.eraseIdempotencyKeys(ctx, userID); err != nil {
+		return ErasureReport{}, err
+	}
+	events, err := x.eraseEvents(ctx, userID)
+	if err != nil {
+		return ErasureReport{}, err
+	}
+	report.Events = len(events)
+	if report.DeadLetters, report.MissingNodes, err = x.eraseDeadLetters(ctx, userID); err != nil {
+		return ErasureReport{}, err
+	}
+
+	for roomID, ok := range held {
+		if ok {
+			report.HeldRooms = append(report.HeldRooms, roomID)
+		}
+	}
+	sort.Strings(report.HeldRooms)
+
+	if report.Remaining, err = x.verify(ctx, userID, mode, erased, events, held); err != nil {
+		return ErasureReport{}, err
+	}
+	report.Remaining.DeadLetterNodes = len(report.MissingNodes)
+	report.Verified = report.Remaining.empty()
+	report.CompletedAt = time.Now().UTC()
+
+	sum, err := report.mac(x.reportKey)
+	if err != nil {
+		return ErasureReport{}, fmt.Errorf("erasure service: signing report, %w", err)
+	}
+	report.Signature = hex.EncodeToString(sum)
+
+	log.Info().
+		Str("user", userID).
+		Str("mode", string(mode)).
+		Int("messages", report.Messages).
+		Int("held", report.HeldMessages).
+		Bool("verified", report.Verified).
+		Msg("erasure service: user erased")
+
+	return report, nil
+}
+
+// VerifyReport returns ErrErasureReportInvalid unless the report
+// was signed by a server holding the same report key.
+func (x *ErasureService) VerifyReport(report ErasureReport) error {
+	got, err := hex.DecodeString(report.Signature)
+	if err != nil {
+		return ErrErasureReportInvalid
+	}
+	want, err := report.mac(x.reportKey)
+	if err != nil {
+		return fmt.Errorf("erasure service: signing report, %w", err)
+	}
+	if !hmac.Equal(got, want) {
+		return ErrErasureReportInvalid
+	}
+	return nil
+}
+
+// held returns whether a room is under legal hold,
+// caching the answer in rooms.
+func (x *ErasureService) held(ctx context.Context, rooms map[string]bool, roomID string) (bool, error) {
+	if isHeld, ok := rooms[roomID]; ok {
+		return isHeld, nil
+	}
+	err := x.retention.DeletionAllowed(ctx, roomID)
+	switch {
+	case err == nil:
+		rooms[roomID] = false
+	case errors.Is(err, ErrLegalHold):
+		rooms[roomID] = true
+	default:
+		return false, fmt.Errorf("erasure service: reading retention of room %s, %w", roomID, err)
+	}
+	return rooms[roomID], nil
+}
+
+func (x *ErasureService) eraseMessage(
+	ctx context.Context,
+	userID string,
+	ref db.MessageRef,
+	mode ErasureMode,
+) error {
+	switch mode {
+	case ErasureDelete:
+		if err := x.messageRepo.DeleteMessageByRoom(ctx, ref.RoomID, ref.ID); err != nil {
+			return fmt.Errorf("erasure service: deleting message, %w", err)
+		}
+	case ErasureAnonymise:
+		if err := x.messageRepo.AnonymiseMessageByRoom(ctx, ref, AnonymousSender); err != nil {
+			return fmt.Errorf("erasure service: anonymising message, %w", err)
+		}
+	}
+
+	// A pending entry would publish the message as it was written.
+	if err := x.outboxRepo.DeleteOutboxEntry(ctx, db.OutboxShard(ref.RoomID), ref.ID); err != nil {
+		return fmt.Errorf("erasure service: deleting outbox entry, %w", err)
+	}
+	if err := x.messageRepo.DeleteMessageBySender(ctx, userID, ref); err != nil {
+		return fmt.Errorf("erasure service: deleting message ref, %w", err)
+	}
+	return nil
+}
+
+// eraseStream deletes the messages of a user outside held rooms from
+// the room stream and returns how many it found. Messages in the stream
+// cannot be changed, so they are deleted in both modes. With erase
+// false they are only counted.
+func (x *ErasureService) eraseStream(
+	ctx context.Context,
+	userID string,
+	held map[string]bool,
+	erase bool,
+) (int, error) {
+	if x.stream == nil {
+		return 0, nil
+	}
+
+	var seqs []uint64
+	err := x.stream.ScanMessages(ctx, func(seq uint64, m Message) error {
+		if m.SessionID != userID {
+			return nil
+		}
+		isHeld, err := x.held(ctx, held, m.RoomID)
+		if err != nil {
+			return err
+		}
+		if !isHeld {
+			seqs = append(seqs, seq)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("erasure service: scanning room stream, %w", err)
+	}
+
+	if erase {
+		for _, seq := range seqs {
+			if err := x.stream.EraseMessage(ctx, seq); err != nil {
+				return 0, fmt.Errorf("erasure service: %w", err)
+			}
+		}
+	}
+	return len(seqs), nil
+}
+
+// eraseMemberships deletes the room memberships of a user.
+// Memberships are keyed by UUID, other user IDs have none.
+func (x *ErasureService) eraseMemberships(ctx context.Context, userID string) (int, error) {
+	id, err := gocql.ParseUUID(userID)
+	if err != nil {
+		return 0, nil
+	}
+
+	rooms, err := x.userRepo.ReadRoomsByUser(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("erasure service: reading memberships, %w", err)
+	}
+	for _, room := range rooms {
+		if err := x.userRepo.DeleteUserInRoom(ctx, room); err != nil {
+			return 0, fmt.Errorf("erasure service: deleting membership, %w", err)
+		}
+	}
+	return len(rooms), nil
+}
+
+// eraseRoles deletes the roles of a user in every room.
+func (x *ErasureService) eraseRoles(ctx context.Context, userID string) (int, error) {
+	roles, err := x.roleRepo.ReadRoomRolesByUser(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("erasure service: reading room roles, %w", err)
+	}
+	if err := x.roleRepo.DeleteRoomRolesByUser(ctx, userID); err != nil {
+		return 0, fmt.Errorf("erasure service: deleting room roles, %w", err)
+	}
+	return len(roles), nil
+}
+
+// eraseIdempotencyKeys deletes the idempotency keys of a user, which
+// name the user in rooms under legal hold too. Keys claimed before
+// they were indexed by user are not found and expire with their TTL.
+func (x *ErasureService) eraseIdempotencyKeys(ctx context.Context, userID string) (int, error) {
+	keys, err := x.messageRepo.ReadIdempotencyKeysByUser(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("erasure service: reading idempotency keys, %w", err)
+	}
+	for _, key := range keys {
+		if err := x.messageRepo.DeleteIdempotencyKey(ctx, key); err != nil {
+			return 0, fmt.Errorf("erasure service: deleting idempotency key, %w", err)
+		}
+	}
+	return len(keys), nil
+}
+
+// eraseEvents deletes the events about a user from the event log and
+// returns them. Message events are kept without their sender and need
+// no erasure.
+func (x *ErasureService) eraseEvents(ctx context.Context, userID string) ([]db.EventRef, error) {
+	refs, err := x.eventRepo.ReadEventRefsByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("erasure service: reading events, %w", err)
+	}
+	for _, ref := range refs {
+		if err := x.eventRepo.DeleteEvent(ctx, ref); err != nil {
+			return nil, fmt.Errorf("erasure service: deleting event, %w", err)
+		}
+		if err := x.eventRepo.DeleteEventByUser(ctx, userID, ref); err != nil {
+			return nil, fmt.Errorf("erasure service: deleting event ref, %w", err)
+		}
+	}
+	return refs, nil
+}
+
+// eraseDeadLetters drops the dead letters about a user on every node
+// and returns how many it dropped and the nodes that did not confirm.
+// Without a control plane only this node's are dropped.
+func (x *ErasureService) eraseDeadLetters(ctx context.Context, userID string) (int, []string, error) {
+	if x.control == nil {
+		return x.purgeDeadLetters(userID), nil, nil
+	}
+
+	result, err := x.control.Scatter(ctx, OpEraseDeadLetters, userID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("erasure service: erasing dead letters, %w", err)
+	}
+	var n int
+	missing := result.Missing
+	for _, reply := range result.Replies {
+		var purged int
+		if err := reply.Decode(&purged); err != nil {
+			log.Error().Err(err).Str("node", reply.NodeID).Msg("erasure service: erasing dead letters")
+			missing = append(missing, reply.NodeID)
+			continue
+		}
+		n += purged
+	}
+	sort.Strings(missing)
+	return n, missing, nil
+}
+
+func (x *ErasureService) handleEraseDeadLetters(_ context.Context, r cluster.Request) (any, error) {
+	var userID string
+	if err := r.Decode(&userID); err != nil {
+		return nil, fmt.Errorf("erasure service: decoding user ID, %w", err)
+	}
+	return x.purgeDeadLetters(userID), nil
+}
+
+// purgeDeadLetters drops the dead letters of this node about a user.
+func (x *ErasureService) purgeDeadLetters(userID string) int {
+	if x.deadLetters == nil || userID == "" {
+		return 0
+	}
+	return x.deadLetters.Purge(func(evt event.Event) bool {
+		for _, user := range eventUsers(evt.Payload) {
+			if user == userID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// eventUsers returns the users an event payload is about.
+func eventUsers(payload any) []string {
+	switch p := payload.(type) {
+	case Message:
+		return []string{p.SessionID}
+	case Membership:
+		return []string{p.UserID}
+	case Moderation:
+		_, users := moderationUsers(p)
+		return users
+	case Interaction:
+		return []string{p.UserID, p.OwnerID}
+	case SessionConnectedPayload:
+		return []string{p.UserID}
+	}
+	return nil
+}
+
+// verify reads every erased message row, outbox entry and event again,
+// scans the room stream and checks the user indexes, room roles and
+// idempotency keys for anything left or written since erasure started.
+func (x *ErasureService) verify(
+	ctx context.Context,
+	userID string,
+	mode ErasureMode,
+	messages []db.MessageRef,
+	events []db.EventRef,
+	held map[string]bool,
+) (ErasureRemaining, error) {
+	var remaining ErasureRemaining
+
+	left := make(map[db.MessageRef]bool)
+	for _, ref := range messages {
+		m, err := x.messageRepo.ReadMessageByRoom(ctx, ref)
+		switch {
+		case errors.Is(err, db.ErrMessageNotFound):
+		case err != nil:
+			return ErasureRemaining{}, fmt.Errorf("erasure service: verifying message, %w", err)
+		case mode == ErasureDelete, m.UserID != "", m.Sender != AnonymousSender:
+			left[ref] = true
+		}
+
+		_, err = x.outboxRepo.ReadOutboxEntry(ctx, db.OutboxShard(ref.RoomID), ref.ID)
+		switch {
+		case errors.Is(err, db.ErrOutboxEntryNotFound):
+		case err != nil:
+			return ErasureRemaining{}, fmt.Errorf("erasure service: verifying outbox entry, %w", err)
+		default:
+			remaining.Outbox++
+		}
+	}
+	refs, err := x.messageRepo.ReadMessageRefsBySender(ctx, userID)
+	if err != nil {
+		return ErasureRemaining{}, fmt.Errorf("erasure service: verifying message refs, %w", err)
+	}
+	for _, ref := range refs {
+		isHeld, err := x.held(ctx, held, ref.RoomID)
+		if err != nil {
+			return ErasureRemaining{}, err
+		}
+		if !isHeld {
+			left[ref] = true
+		}
+	}
+	remaining.Messages = len(left)
+
+	if remaining.Stream, err = x.eraseStream(ctx, userID, held, false); err != nil {
+		return ErasureRemaining{}, err
+	}
+
+	leftEvents := make(map[db.EventRef]bool)
+	for _, ref := range events {
+		_, err := x.eventRepo.ReadEvent(ctx, ref)
+		switch {
+		case errors.Is(err, db.ErrEventNotFound):
+		case err != nil:
+			return ErasureRemaining{}, fmt.Errorf("erasure service: verifying event, %w", err)
+		default:
+			leftEvents[ref] = true
+		}
+	}
+	eventRefs, err := x.eventRepo.ReadEventRefsByUser(ctx, userID)
+	if err != nil {
+		return ErasureRemaining{}, fmt.Errorf("erasure service: verifying event refs, %w", err)
+	}
+	for _, ref := range eventRefs {
+		leftEvents[ref] = true
+	}
+	remaining.Events = len(leftEvents)
+
+	roles, err := x.roleRepo.ReadRoomRolesByUser(ctx, userID)
+	if err != nil {
+		return ErasureRemaining{}, fmt.Errorf("erasure service: verifying room roles, %w", err)
+	}
+	remaining.Roles = len(roles)
+
+	keys, err := x.messageRepo.ReadIdempotencyKeysByUser(ctx, userID)
+	if err != nil {
+		return ErasureRemaining{}, fmt.Errorf("erasure service: verifying idempotency keys, %w", err)
+	}
+	remaining.IdempotencyKeys = len(keys)
+
+	return remaining, nil
+}
@@ -0,0 +1,265 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+)
+
+// outboxRepo is an OutboxRepository keeping entries in memory.
+type outboxRepo struct {
+	mu      sync.Mutex
+	entries map[gocql.UUID]db.OutboxEntry
+}
+
+func newOutboxRepo() *outboxRepo {
+	return &outboxRepo{entries: make(map[gocql.UUID]db.OutboxEntry)}
+}
+
+func (x *outboxRepo) add(entry db.OutboxEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.entries[entry.ID] = entry
+}
+
+func (x *outboxRepo) ReadOutbox(_ context.Context, params db.ReadOutboxParams) ([]db.OutboxEntry, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var entries []db.OutboxEntry
+	for _, entry := range x.entries {
+		if entry.Shard == params.Shard && entry.ID.Time().Before(params.Before) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (x *outboxRepo) ReadOutboxEntry(_ context.Context, shard int, id gocql.UUID) (db.OutboxEntry, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	entry, ok := x.entries[id]
+	if !ok || entry.Shard != shard {
+		return db.OutboxEntry{}, db.ErrOutboxEntryNotFound
+	}
+	return entry, nil
+}
+
+func (x *outboxRepo) DeleteOutboxEntry(_ context.Context, shard int, id gocql.UUID) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if entry, ok := x.entries[id]; ok && entry.Shard == shard {
+		delete(x.entries, id)
+	}
+	return nil
+}
+
+// erasureFixture stores messages of ann and bob in a room and a room
+// under legal hold, in the message tables, the outbox and a room stream.
+type erasureFixture struct {
+	messages *messageRepo
+	outbox   *outboxRepo
+	events   *eventRepo
+	roles    *roleRepo
+	stream   *RoomStream
+	// deadLetters holds a dead letter of ann and one of bob.
+	deadLetters *event.DeadLetters
+	erasure     *ErasureService
+
+	room, heldRoom string
+}
+
+func newErasureFixture(t *testing.T) *erasureFixture {
+	t.Helper()
+
+	ctx := context.Background()
+	stream, err := NewRoomStream(runJetStream(t), StreamConfig{Consumer: "node-a"})
+	require.NoError(t, err)
+
+	x := &erasureFixture{
+		messages:    newMessageRepo(),
+		outbox:      newOutboxRepo(),
+		events:      &eventRepo{},
+		roles:       newRoleRepo(),
+		stream:      stream,
+		deadLetters: event.NewDeadLetters(0),
+		room:        gocql.TimeUUID().String(),
+		heldRoom:    gocql.TimeUUID().String(),
+	}
+	x.messages.outbox = x.outbox
+
+	policies := &policyRepo{policies: make(map[string]db.RoomPolicy)}
+	retention := NewRetentionService(policies, x.messages, 0)
+	require.NoError(t, retention.SetPolicy(ctx, RetentionPolicy{RoomID: x.heldRoom, LegalHold: true}))
+
+	x.erasure, err = NewErasureService(
+		x.messages,
+		&userRepo{},
+		x.events,
+		x.outbox,
+		x.roles,
+		stream,
+		retention,
+		x.deadLetters,
+		nil,
+		[]byte("report-key"),
+	)
+	require.NoError(t, err)
+
+	x.store(t, x.room, "ann")
+	x.store(t, x.room, "ann")
+	x.store(t, x.heldRoom, "ann")
+	x.store(t, x.room, "bob")
+	require.NoError(t, x.roles.SetRoomMuted(ctx, x.room, "ann", true))
+	failDeadLetter(x.deadLetters, event.New(MemberJoinedEvent, Membership{RoomID: x.room, UserID: "ann"}))
+	failDeadLetter(x.deadLetters, event.New(MessageCreatedInRoomEvent, Message{RoomID: x.room, SessionID: "bob"}))
+	require.NoError(t, x.events.AppendEvent(ctx, db.StoredEvent{
+		ID:    gocql.TimeUUID(),
+		Name:  MemberJoinedEvent,
+		Users: []string{"ann"},
+	}))
+	return x
+}
+
+// failDeadLetter makes evt a dead letter.
+func failDeadLetter(deadLetters *event.DeadLetters, evt event.Event) {
+	h := event.Chain(func(event.Event) error {
+		return errors.New("failed")
+	}, deadLetters.Middleware("test"))
+	_ = h(evt)
+}
+
+// store writes a message as MessageService does, leaving
+// the outbox entry as if publishing had failed.
+func (x *erasureFixture) store(t *testing.T, roomID, userID string) {
+	t.Helper()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	m := Message{
+		ID:        newMessageID(now),
+		Type:      1,
+		RoomID:    roomID,
+		SessionID: userID,
+		Body:      []byte("hello"),
+		Author:    userID,
+		Timestamp: now.Format(time.RFC3339),
+	}
+	data, err := Wire{Format: wire.JSON}.encodeMessage(ctx, event.New(MessageCreatedInRoomEvent, m), m)
+	require.NoError(t, err)
+	subject := RoomSubject(roomID, SubjectMessage)
+	_, err = x.messages.ClaimIdempotencyKey(ctx, db.ClaimIdempotencyKeyParams{
+		RoomID: roomID,
+		UserID: userID,
+		Key:    m.ID.String(),
+		ID:     gocql.UUID(m.ID),
+	})
+	require.NoError(t, err)
+	require.NoError(t, x.messages.CreateMessageByRoom(ctx, db.CreateMessageByRoomParams{
+		ID:        gocql.UUID(m.ID),
+		Data:      m.Body,
+		Sender:    m.Author,
+		UserID:    userID,
+		RoomID:    roomID,
+		Timestamp: now,
+		Outbox:    &db.OutboxMessage{Subject: subject, Data: data},
+	}))
+	require.NoError(t, publish(ctx, x.stream, subject, data))
+}
+
+// streamed returns the number of messages of a user in the room stream.
+func (x *erasureFixture) streamed(t *testing.T, userID string) int {
+	t.Helper()
+
+	var n int
+	require.NoError(t, x.stream.ScanMessages(context.Background(), func(_ uint64, m Message) error {
+		if m.SessionID == userID {
+			n++
+		}
+		return nil
+	}))
+	return n
+}
+
+func Test_ErasureService_Erase(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Delete", func(t *testing.T) {
+		x := newErasureFixture(t)
+		report, err := x.erasure.Erase(ctx, "ann", ErasureDelete)
+		require.NoError(t, err)
+		require.Equal(t, 2, report.Messages)
+		require.Equal(t, 2, report.StreamMessages)
+		require.Equal(t, 1, report.Events)
+		require.Equal(t, 1, report.Roles)
+		// Keys are erased in the held room too.
+		require.Equal(t, 3, report.IdempotencyKeys)
+		require.Equal(t, 1, report.DeadLetters)
+		require.Empty(t, report.MissingNodes)
+		require.NotEmpty(t, report.Limitations)
+		require.Equal(t, 1, report.HeldMessages)
+		require.Equal(t, []string{x.heldRoom}, report.HeldRooms)
+		require.Zero(t, report.Remaining)
+		require.True(t, report.Verified)
+
+		// What is left is bob's and ann's in the held room.
+		require.Len(t, x.messages.messages, 2)
+		require.Len(t, x.outbox.entries, 2)
+		require.Equal(t, 1, x.streamed(t, "ann"))
+		require.Equal(t, 1, x.streamed(t, "bob"))
+		require.Empty(t, x.events.events)
+		require.Empty(t, x.roles.roles)
+		require.Len(t, x.messages.claims, 1)
+		letters := x.deadLetters.List()
+		require.Len(t, letters, 1)
+		require.Equal(t, MessageCreatedInRoomEvent, letters[0].Event)
+	})
+
+	t.Run("Anonymise", func(t *testing.T) {
+		x := newErasureFixture(t)
+		report, err := x.erasure.Erase(ctx, "ann", ErasureAnonymise)
+		require.NoError(t, err)
+		require.Equal(t, 2, report.Messages)
+		require.True(t, report.Verified)
+		require.Len(t, x.messages.messages, 4)
+		require.Equal(t, 1, x.streamed(t, "ann"))
+	})
+
+	t.Run("Not verified", func(t *testing.T) {
+		x := newErasureFixture(t)
+		x.messages.keepDeleted = true
+		report, err := x.erasure.Erase(ctx, "ann", ErasureDelete)
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Equal(t, ErasureRemaining{Messages: 2}, report.Remaining)
+	})
+}
+
+func Test_ErasureService_VerifyReport(t *testing.T) {
+	ctx := context.Background()
+	x := newErasureFixture(t)
+	report, err := x.erasure.Erase(ctx, "ann", ErasureDelete)
+	require.NoError(t, err)
+	require.NoError(t, x.erasure.VerifyReport(report))
+
+	tampered := report
+	tampered.HeldMessages = 0
+	require.ErrorIs(t, x.erasure.VerifyReport(tampered), ErrErasureReportInvalid)
+	tampered = report
+	tampered.Signature = "not hex"
+	require.ErrorIs(t, x.erasure.VerifyReport(tampered), ErrErasureReportInvalid)
+
+	other, err := NewErasureService(x.messages, &userRepo{}, x.events, x.outbox, newRoleRepo(), nil, nil, nil, nil, []byte("other-key"))
+	require.NoError(t, err)
+	require.ErrorIs(t, other.VerifyReport(report), ErrErasureReportInvalid)
+
+	_, err = NewErasureService(x.messages, &userRepo{}, x.events, x.outbox, newRoleRepo(), nil, nil, nil, nil, nil)
+	require.ErrorIs(t, err, ErrErasureReportKeyInvalid)
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/gocql/gocql"
+)
+
+var _ event.Store = (*EventStore)(nil)
+
+// uuidEpoch is the number of 100ns intervals between
+// the UUID epoch, 15 Oct 1582, and the Unix epoch.
+const uuidEpoch = 0x01B21DD213814000
+
+// EventStore keeps events in the Scylla event log.
+type EventStore struct {
+	repo db.EventRepository
+}
+
+// NewEventStore returns an event store backed by the given repository.
+func NewEventStore(repo db.EventRepository) *EventStore {
+	return &EventStore{repo: repo}
+}
+
+// Append adds a record to the event log. The log is ordered by
+// the time the event occured. Transient errors are retryable, and
+// appending a record again overwrites its row instead of adding one.
+func (x *EventStore) Append(ctx context.Context, record event.Record) error {
+	if err := x.repo.AppendEvent(ctx, db.StoredEvent{
+		ID:      logID(record),
+		EventID: record.ID,
+		Name:    record.Name,
+		Key:     record.Key,
+		Payload: record.Payload,
+		Users:   record.Users,
+	}); err != nil {
+		return retryable(fmt.Errorf("chat: appending event, %w", err))
+	}
+	return nil
+}
+
+// logID derives the log ID of a record from the time it occured and its
+// event ID. The same record always gets the same ID.
+func logID(record event.Record) gocql.UUID {
+	sum := sha1.Sum([]byte(record.ID))
+	clock := uint32(sum[0])<<8 | uint32(sum[1])
+	return gocql.TimeUUIDWith(record.OccuredAt.UnixNano()/100+uuidEpoch, clock, sum[2:8])
+}
+
+// Read calls fn for every record of the named event
+// between from and to, oldest first.
+func (x *EventStore) Read(
+	ctx context.Context,
+	name string,
+	from, to time.Time,
+	fn func(event.Record) error,
+) error {
+	return x.repo.ReadEvents(ctx, db.ReadEventsParams{
+		Name: name,
+		From: from,
+		To:   to,
+	}, func(stored db.StoredEvent) error {
+		return fn(event.Record{
+			ID:        stored.EventID,
+			Name:      stored.Name,
+			Key:       stored.Key,
+			Payload:   stored.Payload,
+			OccuredAt: stored.ID.Time(),
+		})
+	})
+}
+
+// KeepEvents subscribes store to the domain events worth keeping:
+// messages, membership changes and moderation.
+//
+// Message events are kept without their body and sender, which
+// retention and erasure only remove from the message tables. The
+// other events are kept with the users they are about, so that
+// erasure finds and deletes them.
+func KeepEvents(registry *event.Registry, store event.Store, mw func(name string) []event.Middleware) error {
+	var errs []error
+	keep := func(_ event.Subscription, err error) {
+		errs = append(errs, err)
+	}
+	keep(event.KeepRedacted(registry, store, MessageCreatedInRoom, redactMessage, mw("keep."+MessageCreatedInRoomEvent)...))
+	keep(event.KeepRedacted(registry, store, MemberJoined, membershipUsers, mw("keep."+MemberJoinedEvent)...))
+	keep(event.KeepRedacted(registry, store, MemberLeft, membershipUsers, mw("keep."+MemberLeftEvent)...))
+	keep(event.KeepRedacted(registry, store, Moderated, moderationUsers, mw("keep."+ModerationEvent)...))
+	return errors.Join(errs...)
+}
+
+// redactMessage keeps what a message event says about its room:
+// which message was created when.
+func redactMessage(m Message) (Message, []string) {
+	return Message{
+		ID:        m.ID,
+		Type:      m.Type,
+		RoomID:    m.RoomID,
+		Timestamp: m.Timestamp,
+	}, nil
+}
+
+func membershipUsers(m Membership) (Membership, []string) {
+	return m, []string{m.UserID}
+}
+
+func moderationUsers(m Moderation) (Moderation, []string) {
+	var users []string
+	for _, id := range []string{m.ModeratorID, m.TargetID} {
+		if id != "" {
+			users = append(users, id)
+		}
+	}
+	return m, users
+}
+
+// ReplayEvents replays the kept events of the given name between from
+// and to into handler, through a registry of its own so that the live
+// handlers do not act again. It returns the number of events replayed.
+func ReplayEvents(
+	ctx context.Context,
+	store event.Store,
+	name string,
+	from, to time.Time,
+	handler event.Handler,
+) (int, error) {
+	registry := event.NewRegistry(event.Config{Workers: 1, QueueSize: 1})
+	defer registry.Close(ctx)
+
+	var err error
+	switch name {
+	case MessageCreatedInRoomEvent:
+		err = forward(registry, MessageCreatedInRoom, handler)
+	case MemberJoinedEvent:
+		err = forward(registry, MemberJoined, handler)
+	case MemberLeftEvent:
+		err = forward(registry, MemberLeft, handler)
+	case ModerationEvent:
+		err = forward(registry, Moderated, handler)
+	default:
+		err = fmt.Errorf("%w: %s is not kept", event.ErrInvalidEventType, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return registry.Replay(ctx, store, name, from, to)
+}
+
+// forward subscribes an untyped handler to events of type t,
+// binding the payload type that Replay decodes into.
+func forward[T any](registry *event.Registry, t event.Type[T], handler event.Handler) error {
+	_, err := event.Subscribe(registry, t, func(evt event.Event, _ T) error {
+		return handler(evt)
+	})
+	return err
+}
@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// eventRepo is an EventRepository keeping events in memory.
+// Appending an event with the ID of a kept one overwrites it.
+type eventRepo struct {
+	mu     sync.Mutex
+	events []db.StoredEvent
+}
+
+func (x *eventRepo) AppendEvent(_ context.Context, params db.StoredEvent) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, stored := range x.events {
+		if stored.Name == params.Name && stored.ID == params.ID {
+			x.events[i] = params
+			return nil
+		}
+	}
+	x.events = append(x.events, params)
+	return nil
+}
+
+func (x *eventRepo) ReadEvents(_ context.Context, params db.ReadEventsParams, fn func(db.StoredEvent) error) error {
+	x.mu.Lock()
+	events := append([]db.StoredEvent(nil), x.events...)
+	x.mu.Unlock()
+
+	for _, stored := range events {
+		at := stored.ID.Time()
+		if stored.Name != params.Name || at.Before(params.From) || (!params.To.IsZero() && at.After(params.To)) {
+			continue
+		}
+		if err := fn(stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *eventRepo) ReadEvent(_ context.Context, ref db.EventRef) (db.StoredEvent, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, stored := range x.events {
+		if stored.Name == ref.Name && stored.ID == ref.ID {
+			return stored, nil
+		}
+	}
+	return db.StoredEvent{}, db.ErrEventNotFound
+}
+
+func (x *eventRepo) ReadEventRefsByUser(_ context.Context, userID string) ([]db.EventRef, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var refs []db.EventRef
+	for _, stored := range x.events {
+		for _, user := range stored.Users {
+			if user == userID {
+				refs = append(refs, db.EventRef{Name: stored.Name, ID: stored.ID})
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (x *eventRepo) DeleteEvent(_ context.Context, ref db.EventRef) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, stored := range x.events {
+		if stored.Name == ref.Name && stored.ID == ref.ID {
+			x.events = append(x.events[:i], x.events[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (x *eventRepo) DeleteEventByUser(context.Context, string, db.EventRef) error {
+	return nil
+}
+
+func Test_KeepEvents(t *testing.T) {
+	ctx := context.Background()
+	events := &eventRepo{}
+	registry := event.NewRegistry(event.Config{Workers: 1})
+	require.NoError(t, KeepEvents(registry, NewEventStore(events), func(string) []event.Middleware { return nil }))
+
+	roomID := gocql.TimeUUID().String()
+	now := time.Now().UTC()
+	from := now.Add(-time.Second)
+	message := Message{
+		ID:             newMessageID(now),
+		Type:           1,
+		RoomID:         roomID,
+		SessionID:      "ann",
+		Body:           []byte("secret"),
+		Author:         "Ann",
+		Timestamp:      now.Format(time.RFC3339),
+		IdempotencyKey: "key",
+	}
+	require.NoError(t, event.Publish(ctx, registry, MessageCreatedInRoom, roomID, message))
+	require.NoError(t, event.Publish(ctx, registry, MemberJoined, roomID, Membership{
+		RoomID:      roomID,
+		UserID:      "ann",
+		DisplayName: "Ann",
+	}))
+	require.NoError(t, event.Publish(ctx, registry, Moderated, roomID, Moderation{
+		RoomID:      roomID,
+		ModeratorID: "bob",
+		Action:      ModerationKick,
+		TargetID:    "ann",
+	}))
+	require.NoError(t, registry.Close(ctx))
+	require.Len(t, events.events, 3)
+
+	t.Run("Message redacted", func(t *testing.T) {
+		var got []Message
+		n, err := ReplayEvents(ctx, NewEventStore(events), MessageCreatedInRoomEvent, from, time.Time{},
+			func(evt event.Event) error {
+				got = append(got, evt.Payload.(Message))
+				return nil
+			})
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		require.Equal(t, Message{
+			ID:        message.ID,
+			Type:      message.Type,
+			RoomID:    roomID,
+			Timestamp: message.Timestamp,
+		}, got[0])
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		refs, err := events.ReadEventRefsByUser(ctx, "ann")
+		require.NoError(t, err)
+		require.Len(t, refs, 2)
+		refs, err = events.ReadEventRefsByUser(ctx, "bob")
+		require.NoError(t, err)
+		require.Len(t, refs, 1)
+	})
+
+	t.Run("Erase", func(t *testing.T) {
+		policies := &policyRepo{policies: make(map[string]db.RoomPolicy)}
+		messages := newMessageRepo()
+		erasure, err := NewErasureService(
+			messages,
+			&userRepo{},
+			events,
+			newOutboxRepo(),
+			newRoleRepo(),
+			nil,
+			NewRetentionService(policies, messages, 0),
+			nil,
+			nil,
+			[]byte("key"),
+		)
+		require.NoError(t, err)
+
+		report, err := erasure.Erase(ctx, "ann", ErasureDelete)
+		require.NoError(t, err)
+		require.Equal(t, 2, report.Events)
+		require.Len(t, events.events, 1)
+		require.Equal(t, MessageCreatedInRoomEvent, events.events[0].Name)
+
+		report, err = erasure.Erase(ctx, uuid.NewString(), ErasureDelete)
+		require.NoError(t, err)
+		require.Zero(t, report.Events)
+	})
+}
+
+func Test_EventStore_Append(t *testing.T) {
+	ctx := context.Background()
+	events := &eventRepo{}
+	store := NewEventStore(events)
+
+	now := time.Now().UTC()
+	record := event.Record{ID: uuid.NewString(), Name: "test", OccuredAt: now}
+	// A retried append that succeeded the first time.
+	require.NoError(t, store.Append(ctx, record))
+	require.NoError(t, store.Append(ctx, record))
+	require.Len(t, events.events, 1)
+	require.True(t, now.Truncate(100*time.Nanosecond).Equal(events.events[0].ID.Time()))
+
+	// Another event at the same time gets its own row.
+	require.NoError(t, store.Append(ctx, event.Record{ID: uuid.NewString(), Name: "test", OccuredAt: now}))
+	require.Len(t, events.events, 2)
+}
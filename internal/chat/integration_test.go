@@ -0,0 +1,245 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/cluster"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+// testNode is a chat node of an integration test cluster. Messages are
+// published as MessageService does, without persisting them.
+type testNode struct {
+	srv      *server.Server
+	broker   broker.Broker
+	control  *cluster.ControlPlane
+	registry *event.Registry
+	rooms    *Rooms
+}
+
+// startCluster starts n chat nodes, each with an embedded NATS server
+// routed to the first one, and waits until every node knows the others.
+func startCluster(t *testing.T, n int) []*testNode {
+	t.Helper()
+
+	nodes := make([]*testNode, n)
+	var seed string
+	for i := range nodes {
+		cfg := broker.EmbeddedConfig{
+			Name: fmt.Sprintf("node-%d", i),
+			Host: "127.0.0.1",
+			Port: broker.RandomPort,
+			Cluster: broker.EmbeddedCluster{
+				Name: "chat-test",
+				Host: "127.0.0.1",
+				Port: broker.RandomPort,
+			},
+		}
+		if seed != "" {
+			cfg.Cluster.Routes = []string{seed}
+		}
+		srv, err := broker.StartEmbedded(cfg)
+		require.NoError(t, err)
+		t.Cleanup(srv.Shutdown)
+		if seed == "" {
+			seed = fmt.Sprintf("nats-route://%s", srv.ClusterAddr())
+		}
+		nodes[i] = startNode(t, cfg.Name, srv)
+	}
+
+	require.Eventually(t, func() bool {
+		for _, node := range nodes {
+			if len(node.control.Nodes()) != n {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond, "nodes did not find each other")
+	return nodes
+}
+
+func startNode(t *testing.T, name string, srv *server.Server) *testNode {
+	t.Helper()
+
+	nc, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	b := broker.NewNATS(nc)
+
+	control, err := cluster.New(b, cluster.Config{NodeID: name, HeartbeatInterval: 100 * time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, control.Start())
+
+	registry := event.NewRegistry(event.Config{})
+	codec := Wire{Format: wire.JSON, NodeID: name}
+	_, err = event.Subscribe(registry, MessageCreatedInRoom, func(evt event.Event, m Message) error {
+		data, err := codec.encodeMessage(evt.Context(), evt, m)
+		if err != nil {
+			return err
+		}
+		return publish(evt.Context(), b, RoomSubject(m.RoomID, SubjectMessage), data)
+	})
+	require.NoError(t, err)
+
+	ch := make(chan *broker.Msg, 64)
+	rooms := NewRooms()
+	rooms.SetSubscriber(ChanSubscriber(b, ch, SubjectMessage, SubjectInteraction))
+	go rooms.Run(ch)
+
+	t.Cleanup(func() {
+		rooms.Shutdown("test done")
+		_ = control.Close()
+		_ = b.Drain(context.Background())
+	})
+	return &testNode{srv: srv, broker: b, control: control, registry: registry, rooms: rooms}
+}
+
+// join connects a JSON protocol session of userID to a room of the
+// node and returns the client side of its websocket.
+func (x *testNode) join(t *testing.T, roomID, userID string) *websocket.Conn {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	joined := make(chan error, 1)
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			joined <- err
+			return
+		}
+		room, err := x.rooms.GetOrCreate(roomID, func() (*Room, error) {
+			return NewRoom(&roomID, x.registry, NewCommandRegistry(), newRoleRepo())
+		})
+		if err != nil {
+			joined <- err
+			return
+		}
+		room.Join <- &UserSess{
+			UserID:      userID,
+			RoomID:      roomID,
+			displayName: userID,
+			Protocol:    protocol.JSON,
+			Conn:        conn,
+			ConnectedAt: time.Now().UTC(),
+		}
+		joined <- nil
+	}))
+	t.Cleanup(httpSrv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpSrv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, <-joined)
+	return conn
+}
+
+// interest waits until the NATS server of the node routes subject.
+func (x *testNode) interest(t *testing.T, subject string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return x.srv.GlobalAccount().SubscriptionInterest(subject)
+	}, 5*time.Second, 10*time.Millisecond, "no interest in %s", subject)
+}
+
+// readMessage reads frames until a message frame arrives.
+func readMessage(t *testing.T, conn *websocket.Conn) protocol.Frame {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var frame protocol.Frame
+		require.NoError(t, json.Unmarshal(data, &frame))
+		if frame.Type == protocol.FrameMessage {
+			return frame
+		}
+	}
+}
+
+func Test_Cluster(t *testing.T) {
+	nodes := startCluster(t, 3)
+	roomID := "integration-room"
+	subject := RoomSubject(roomID, SubjectMessage)
+
+	bob := nodes[2].join(t, roomID, "bob")
+	nodes[0].interest(t, subject)
+	ann := nodes[0].join(t, roomID, "ann")
+
+	require.NoError(t, ann.WriteJSON(protocol.Frame{Type: protocol.FrameMessage, Body: "hello"}))
+	for _, conn := range []*websocket.Conn{bob, ann} {
+		frame := readMessage(t, conn)
+		require.Equal(t, "hello", frame.Body)
+		require.Equal(t, "ann", frame.Author)
+		id, err := uuid.Parse(frame.ID)
+		require.NoError(t, err)
+		require.Equal(t, uuid.Version(1), id.Version())
+	}
+
+	// Node 1 serves no session of the room, so it neither
+	// subscribed nor created it.
+	_, ok := nodes[1].rooms.Get(roomID)
+	require.False(t, ok)
+
+	t.Run("Scatter", func(t *testing.T) {
+		result, err := nodes[1].control.Scatter(context.Background(), "nodes", nil)
+		require.NoError(t, err)
+		require.Empty(t, result.Missing)
+		require.Len(t, result.Replies, 3)
+	})
+
+	t.Run("Retention changed", func(t *testing.T) {
+		ctx := context.Background()
+		policies := &policyRepo{policies: make(map[string]db.RoomPolicy)}
+		services := make([]*RetentionService, len(nodes))
+		for i, node := range nodes {
+			services[i] = NewRetentionService(policies, newMessageRepo(), 30)
+			require.NoError(t, services[i].Register(node.control))
+		}
+		ttl, err := services[2].TTL(ctx, roomID)
+		require.NoError(t, err)
+		require.Equal(t, 30*day, ttl)
+
+		require.NoError(t, services[0].SetPolicy(ctx, RetentionPolicy{RoomID: roomID, LegalHold: true}))
+		ttl, err = services[2].TTL(ctx, roomID)
+		require.NoError(t, err)
+		require.Zero(t, ttl)
+	})
+
+	t.Run("Erase dead letters", func(t *testing.T) {
+		services := make([]*ErasureService, len(nodes))
+		for i, node := range nodes {
+			deadLetters := event.NewDeadLetters(0)
+			failDeadLetter(deadLetters, event.New(MemberJoinedEvent, Membership{RoomID: roomID, UserID: "ann"}))
+			failDeadLetter(deadLetters, event.New(MemberJoinedEvent, Membership{RoomID: roomID, UserID: "bob"}))
+			var err error
+			services[i], err = NewErasureService(nil, nil, nil, nil, nil, nil, nil, deadLetters, node.control, []byte("key"))
+			require.NoError(t, err)
+			require.NoError(t, services[i].Register())
+		}
+
+		n, missing, err := services[1].eraseDeadLetters(context.Background(), "ann")
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		require.Empty(t, missing)
+		for _, service := range services {
+			require.Len(t, service.deadLetters.List(), 1)
+		}
+	})
+}
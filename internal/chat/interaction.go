@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const InteractionCreatedEvent = "InteractionCreated"
+
+// InteractionCreated binds InteractionCreatedEvent to its payload.
+var InteractionCreated = event.NewType[Interaction](InteractionCreatedEvent)
+
+var (
+	ErrInteractionMessageIDInvalid   = errors.New("interaction message ID invalid")
+	ErrInteractionComponentIDInvalid = errors.New("interaction component ID invalid")
+	ErrInteractionOwnerIDInvalid     = errors.New("interaction owner ID invalid")
+)
+
+// Interaction is a user clicking a component of a message.
+// It is routed to the sessions of the message owner only.
+type Interaction struct {
+	RoomID      string
+	MessageID   uuid.UUID
+	ComponentID string
+	Values      []string
+	// UserID and Author identify the user who clicked.
+	UserID string
+	Author string
+	// OwnerID is the user ID of the message author.
+	OwnerID string
+}
+
+// Valid returns nil if all the fields of Interaction are valid.
+func (x Interaction) Valid() error {
+	var roomIDErr, messageIDErr, componentIDErr, userIDErr, ownerIDErr error
+
+	if x.RoomID == "" {
+		roomIDErr = ErrRoomIDInvalid
+	}
+	if x.MessageID == uuid.Nil {
+		messageIDErr = ErrInteractionMessageIDInvalid
+	}
+	if x.ComponentID == "" {
+		componentIDErr = ErrInteractionComponentIDInvalid
+	}
+	if x.UserID == "" {
+		userIDErr = ErrUserIDInvalid
+	}
+	if x.OwnerID == "" {
+		ownerIDErr = ErrInteractionOwnerIDInvalid
+	}
+
+	return errors.Join(roomIDErr, messageIDErr, componentIDErr, userIDErr, ownerIDErr)
+}
+
+// InteractionService forwards interactions to the nodes hosting
+// the room through the broker, so they reach the message owner
+// wherever it is connected.
+type InteractionService struct {
+	broker broker.Broker
+	wire   Wire
+}
+
+// NewInteractionService returns a new instance of InteractionService.
+func NewInteractionService(b broker.Broker, codec Wire) *InteractionService {
+	return &InteractionService{broker: b, wire: codec}
+}
+
+// HandleInteractionCreatedEvent handles a new interaction created event.
+func (x *InteractionService) HandleInteractionCreatedEvent(evt event.Event, payload Interaction) error {
+	log.Info().Ctx(evt.Context()).Msg("HandleInteractionCreatedEvent ->")
+	defer log.Info().Ctx(evt.Context()).Msg("<- HandleInteractionCreatedEvent")
+
+	if err := payload.Valid(); err != nil {
+		return fmt.Errorf("chat: %w: %w", event.ErrInvalidEventPayloadError, err)
+	}
+
+	data, err := x.wire.encodeInteraction(evt.Context(), evt, payload)
+	if err != nil {
+		return fmt.Errorf("interaction service: encoding event, %w", err)
+	}
+
+	subject := RoomSubject(payload.RoomID, SubjectInteraction)
+	if err := publish(evt.Context(), x.broker, subject, data); err != nil {
+		return fmt.Errorf("interaction service: publishing event, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package chat
+
+import "github.com/Salam4nder/chat/internal/event"
+
+const (
+	MemberJoinedEvent = "MemberJoined"
+	MemberLeftEvent   = "MemberLeft"
+)
+
+var (
+	// MemberJoined binds MemberJoinedEvent to its payload.
+	MemberJoined = event.NewType[Membership](MemberJoinedEvent)
+	// MemberLeft binds MemberLeftEvent to its payload.
+	MemberLeft = event.NewType[Membership](MemberLeftEvent)
+)
+
+// Membership is a session joining or leaving a room on this node.
+type Membership struct {
+	RoomID      string
+	UserID      string
+	DisplayName string
+}
+
+func newMembership(roomID string, sess *UserSess) Membership {
+	return Membership{
+		RoomID:      roomID,
+		UserID:      sess.UserID,
+		DisplayName: sess.DisplayName(),
+	}
+}
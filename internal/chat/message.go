@@ -2,7 +2,10 @@ package chat
 
 import (
 	"errors"
+	"time"
 
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/gocql/gocql"
 	"github.com/google/uuid"
 )
 
@@ -14,10 +17,13 @@ var (
 	ErrMessageBodyInvalid      = errors.New("message body invalid")
 	ErrMessageAuthorInvalid    = errors.New("message author invalid")
 	ErrMessageTimestampInvalid = errors.New("message timestamp invalid")
+	ErrMessageKeyInvalid       = errors.New("message idempotency key invalid")
 )
 
 // Message defines the message structure.
 type Message struct {
+	// ID is time based, see newMessageID. Messages are stored under
+	// it, so writing the same message again does not duplicate it.
 	ID        uuid.UUID
 	Type      int
 	RoomID    string
@@ -25,6 +31,14 @@ type Message struct {
 	Body      []byte
 	Author    string
 	Timestamp string
+	// Components are interactive elements attached to the message.
+	Components []protocol.Component
+	// IdempotencyKey is chosen by the client. Messages of a user that
+	// reuse a key within the idempotency window are stored only once.
+	IdempotencyKey string
+	// Seq is the stream sequence of the message, set on delivery
+	// when room streams are enabled. Sessions resume after it.
+	Seq uint64
 }
 
 // Valid returns nil if all the fields of Message are valid.
@@ -37,9 +51,10 @@ func (x *Message) Valid() error {
 		messageBodyErr      error
 		messageAuthorErr    error
 		messageTimestampErr error
+		messageKeyErr       error
 	)
 
-	if x.ID == uuid.Nil {
+	if x.ID.Version() != 1 {
 		messageIDErr = ErrMessageIDInvalid
 	}
 	if x.Type == 0 || x.Type > 10 {
@@ -57,9 +72,13 @@ func (x *Message) Valid() error {
 	if x.Author == "" {
 		messageAuthorErr = ErrMessageAuthorInvalid
 	}
-	if x.Timestamp == "" {
+	if _, err := x.Time(); err != nil {
 		messageTimestampErr = ErrMessageTimestampInvalid
 	}
+	if len(x.IdempotencyKey) > protocol.MaxIdempotencyKeyLength {
+		messageKeyErr = ErrMessageKeyInvalid
+	}
+	componentsErr := protocol.ValidComponents(x.Components)
 
 	return errors.Join(
 		messageIDErr,
@@ -69,9 +88,16 @@ func (x *Message) Valid() error {
 		messageBodyErr,
 		messageAuthorErr,
 		messageTimestampErr,
+		messageKeyErr,
+		componentsErr,
 	)
 }
 
+// Time parses the timestamp of the message.
+func (x *Message) Time() (time.Time, error) {
+	return time.Parse(time.RFC3339, x.Timestamp)
+}
+
 // TypeString returns a friendly string representation of the message type.
 func (x *Message) TypeString() string {
 	switch x.Type {
@@ -89,3 +115,9 @@ func (x *Message) TypeString() string {
 		return "Unknown"
 	}
 }
+
+// newMessageID returns a time based message ID for t. Message IDs
+// order messages in a room, so they must be time based.
+func newMessageID(t time.Time) uuid.UUID {
+	return uuid.UUID(gocql.UUIDFromTime(t))
+}
@@ -1,72 +1,253 @@
 package chat
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/Salam4nder/chat/internal/broker"
 	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
 	"github.com/Salam4nder/chat/internal/event"
-	"github.com/nats-io/nats.go"
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/Salam4nder/chat/internal/tracing"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	MessageCreatedInRoomEvent = "MessageCreatedInRoom"
+
+	// DefaultIdempotencyWindow is used when no idempotency window
+	// is configured.
+	DefaultIdempotencyWindow = 24 * time.Hour
 )
 
+// MessageCreatedInRoom binds MessageCreatedInRoomEvent to its payload.
+var MessageCreatedInRoom = event.NewType[Message](MessageCreatedInRoomEvent)
+
 // MessageService defines the main message service.
-// It can persist messages and communicates with NATS.
+// It can persist messages and communicates with the broker.
 type MessageService struct {
 	messageRepo db.MessageRepository
-	natsClient  *nats.Conn
+	outboxRepo  db.OutboxRepository
+	publisher   Publisher
+	retention   *RetentionService
+	wire        Wire
+	// rooms reaches the sessions of users that resend a message.
+	// It is nil when they are not told.
+	rooms *Rooms
+	// keyWindow is how long idempotency keys are remembered.
+	keyWindow time.Duration
 }
 
 // NewMessageService returns a new instance of MessageService.
-// It can persist messages and communicate with NATS.
-func NewMessageService(repo db.MessageRepository, client *nats.Conn) *MessageService {
+// It can persist messages and communicate with the broker.
+// Idempotency keys are remembered for keyWindow, or
+// DefaultIdempotencyWindow if it is zero. Sessions in rooms that
+// resend a message get the ID of the message stored under the key.
+func NewMessageService(
+	repo db.MessageRepository,
+	outboxRepo db.OutboxRepository,
+	publisher Publisher,
+	retention *RetentionService,
+	codec Wire,
+	rooms *Rooms,
+	keyWindow time.Duration,
+) *MessageService {
+	if keyWindow <= 0 {
+		keyWindow = DefaultIdempotencyWindow
+	}
 	return &MessageService{
 		messageRepo: repo,
-		natsClient:  client,
+		outboxRepo:  outboxRepo,
+		publisher:   publisher,
+		retention:   retention,
+		wire:        codec,
+		rooms:       rooms,
+		keyWindow:   keyWindow,
 	}
 }
 
 func (x *MessageService) HandleMessageCreatedInRoomEvent(
 	evt event.Event,
+	payload Message,
 ) error {
-	log.Info().Msg("HandleMessageCreatedInRoomEvent ->")
-	defer log.Info().Msg("<- HandleMessageCreatedInRoomEvent")
-
-	payload, ok := evt.Payload.(Message)
-	if !ok {
-		return event.ErrInvalidEventType
-	}
+	log.Info().Ctx(evt.Context()).Msg("HandleMessageCreatedInRoomEvent ->")
+	defer log.Info().Ctx(evt.Context()).Msg("<- HandleMessageCreatedInRoomEvent")
 
 	if err := payload.Valid(); err != nil {
 		return fmt.Errorf("chat: %w: %w", event.ErrInvalidEventPayloadError, err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(evt.Context(), 5*time.Second)
 	defer cancel()
-	if err := x.messageRepo.CreateMessageByRoom(ctx, db.CreateMessageByRoomParams{
-		Data:   payload.Body,
-		Type:   payload.TypeString(),
-		Sender: payload.Author,
-		RoomID: payload.RoomID,
-	}); err != nil {
-		return fmt.Errorf("message service: persisting message in room, %w", err)
+	// Transient errors are retried. The message is written under its
+	// own ID, so a retry of a timed out write stores it only once.
+	if payload.IdempotencyKey != "" {
+		stored, err := x.claim(ctx, &payload)
+		if err != nil {
+			return err
+		}
+		if stored {
+			return nil
+		}
 	}
+	id := gocql.UUID(payload.ID)
 
-	buf := bytes.Buffer{}
-	if err := gob.NewEncoder(&buf).Encode(payload); err != nil {
+	data, err := x.wire.encodeMessage(evt.Context(), evt, payload)
+	if err != nil {
 		return fmt.Errorf("message service: encoding event, %w", err)
 	}
+	ttl, err := x.retention.TTL(ctx, payload.RoomID)
+	if err != nil {
+		return retryable(fmt.Errorf("message service: reading retention, %w", err))
+	}
+	subject := RoomSubject(payload.RoomID, SubjectMessage)
+	outbox := &db.OutboxMessage{Subject: subject, Data: data}
+	if err := x.persist(ctx, payload, ttl, outbox); err != nil {
+		return retryable(fmt.Errorf("message service: persisting message in room, %w", err))
+	}
 
-	if err := x.natsClient.Publish(evt.Name, buf.Bytes()); err != nil {
-		return fmt.Errorf("message service: publishing event, %w", err)
+	// The message is stored with its outbox entry, so from here on the
+	// relay delivers it if publishing fails or the process dies.
+	if err := publish(ctx, x.publisher, subject, data); err != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(err).
+			Str("messageID", payload.ID.String()).
+			Msg("message service: publishing failed, leaving message to the outbox relay")
+		return nil
+	}
+	if err := x.outboxRepo.DeleteOutboxEntry(ctx, db.OutboxShard(payload.RoomID), id); err != nil {
+		log.Warn().
+			Ctx(ctx).
+			Err(err).
+			Str("messageID", payload.ID.String()).
+			Msg("message service: deleting outbox entry, the relay will publish the message again")
 	}
 
 	return nil
 }
+
+// claim claims the idempotency key of m and reports whether the
+// message holding it is already stored, in which case the sessions of
+// the user are told its ID. The claim and the message are written
+// separately, so a message whose save failed or never happened can
+// hold the key; m then takes its ID and is stored in its place.
+func (x *MessageService) claim(ctx context.Context, m *Message) (bool, error) {
+	holder, err := x.messageRepo.ClaimIdempotencyKey(ctx, db.ClaimIdempotencyKeyParams{
+		RoomID: m.RoomID,
+		UserID: m.SessionID,
+		Key:    m.IdempotencyKey,
+		ID:     gocql.UUID(m.ID),
+		TTL:    x.keyWindow,
+	})
+	if err != nil {
+		return false, retryable(fmt.Errorf("message service: claiming idempotency key, %w", err))
+	}
+	if holder == gocql.UUID(m.ID) {
+		return false, nil
+	}
+
+	_, err = x.messageRepo.ReadMessageByRoom(ctx, db.MessageRef{RoomID: m.RoomID, ID: holder})
+	switch {
+	case errors.Is(err, db.ErrMessageNotFound):
+		log.Info().
+			Ctx(ctx).
+			Str("messageID", m.ID.String()).
+			Str("originalID", holder.String()).
+			Msg("message service: storing resent message in place of a message never stored")
+		m.ID = uuid.UUID(holder)
+		return false, nil
+	case err != nil:
+		return false, retryable(fmt.Errorf("message service: reading message holding idempotency key, %w", err))
+	}
+
+	log.Info().
+		Ctx(ctx).
+		Str("messageID", m.ID.String()).
+		Str("originalID", holder.String()).
+		Msg("message service: dropping resent message")
+	x.notifyDuplicate(*m, uuid.UUID(holder))
+	return true, nil
+}
+
+// notifyDuplicate tells the sessions of the sender in the room of m
+// that m was dropped for the message with the given ID.
+func (x *MessageService) notifyDuplicate(m Message, id uuid.UUID) {
+	if x.rooms == nil {
+		return
+	}
+	room, ok := x.rooms.Get(m.RoomID)
+	if !ok {
+		return
+	}
+	for _, sess := range room.sessions() {
+		if sess.UserID != m.SessionID {
+			continue
+		}
+		if err := sess.DeliverDuplicate(m.RoomID, m.IdempotencyKey, id); err != nil {
+			log.Error().Err(err).Msg("message service: writing duplicate")
+		}
+	}
+}
+
+func (x *MessageService) persist(
+	ctx context.Context,
+	m Message,
+	ttl time.Duration,
+	outbox *db.OutboxMessage,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "scylla.insert",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "cassandra"),
+			attribute.String("db.cassandra.table", "message_by_room"),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	// The timestamp was validated with the message.
+	timestamp, _ := m.Time()
+	return x.messageRepo.CreateMessageByRoom(ctx, db.CreateMessageByRoomParams{
+		ID:        gocql.UUID(m.ID),
+		Data:      m.Body,
+		Type:      m.TypeString(),
+		Sender:    m.Author,
+		UserID:    m.SessionID,
+		RoomID:    m.RoomID,
+		Timestamp: timestamp,
+		TTL:       ttl,
+		Outbox:    outbox,
+	})
+}
+
+// retryable marks transient ScyllaDB errors as retryable.
+func retryable(err error) error {
+	if db.IsTransient(err) {
+		return event.Retryable(err)
+	}
+	return err
+}
+
+// publish publishes data on a broker subject with the
+// trace context of ctx in the message headers.
+func publish(ctx context.Context, publisher Publisher, subject string, data []byte) error {
+	ctx, span := tracing.Start(ctx, "nats.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", subject)))
+
+	msg := broker.NewMsg(subject)
+	msg.Data = data
+	tracing.Inject(ctx, msg.Header)
+
+	err := publisher.Publish(ctx, msg)
+	if err != nil {
+		metrics.NATSPublishError(subjectLabel(subject))
+	}
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MessageService_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	t.Cleanup(func() { _ = b.Drain(ctx) })
+
+	messages := newMessageRepo()
+	outbox := newOutboxRepo()
+	messages.outbox = outbox
+	registry := event.NewRegistry(event.Config{})
+	t.Cleanup(func() { _ = registry.Close(ctx) })
+
+	roomID := gocql.TimeUUID().String()
+	room, err := NewRoom(&roomID, registry, NewCommandRegistry(), newRoleRepo())
+	require.NoError(t, err)
+	ann, annClient := testSession(t, roomID, "ann")
+	room.Sessions[ann] = empty{}
+	rooms := NewRooms()
+	rooms.rooms[roomID] = room
+
+	service := NewMessageService(
+		messages,
+		outbox,
+		b,
+		NewRetentionService(&policyRepo{policies: make(map[string]db.RoomPolicy)}, messages, 0),
+		Wire{Format: wire.JSON, NodeID: "node-a"},
+		rooms,
+		0,
+	)
+	send := func(body string) (Message, error) {
+		now := time.Now().UTC()
+		m := Message{
+			ID:             newMessageID(now),
+			Type:           websocket.TextMessage,
+			RoomID:         roomID,
+			SessionID:      "ann",
+			Body:           []byte(body),
+			Author:         "ann",
+			Timestamp:      now.Format(time.RFC3339),
+			IdempotencyKey: "key",
+		}
+		return m, service.HandleMessageCreatedInRoomEvent(event.New(MessageCreatedInRoomEvent, m), m)
+	}
+
+	// The key is claimed, then saving the message fails.
+	messages.createErr = errors.New("write timeout")
+	first, err := send("hello")
+	require.Error(t, err)
+	require.Empty(t, messages.messages)
+
+	t.Run("Resend stored", func(t *testing.T) {
+		messages.createErr = nil
+		_, err := send("hello")
+		require.NoError(t, err)
+
+		stored, err := messages.ReadMessageByRoom(ctx, db.MessageRef{RoomID: roomID, ID: gocql.UUID(first.ID)})
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(stored.Data))
+		require.Len(t, messages.messages, 1)
+		require.Empty(t, outbox.entries)
+	})
+
+	t.Run("Resend dropped", func(t *testing.T) {
+		_, err := send("hello")
+		require.NoError(t, err)
+		require.Len(t, messages.messages, 1)
+
+		frame := readFrame(t, annClient)
+		require.Equal(t, protocol.Frame{
+			Type:           protocol.FrameDuplicate,
+			ID:             first.ID.String(),
+			RoomID:         roomID,
+			IdempotencyKey: "key",
+		}, frame)
+	})
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Message_Valid(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	valid := func() Message {
+		return Message{
+			ID:        newMessageID(now),
+			Type:      textMessage,
+			RoomID:    "room-1",
+			SessionID: "user-1",
+			Body:      []byte("hello"),
+			Author:    "Ann",
+			Timestamp: now.Format(time.RFC3339),
+		}
+	}
+
+	m := valid()
+	require.NoError(t, m.Valid())
+	require.Equal(t, now, gocql.UUID(m.ID).Time())
+	got, err := m.Time()
+	require.NoError(t, err)
+	require.Equal(t, now, got)
+
+	m = valid()
+	m.ID = uuid.New()
+	require.ErrorIs(t, m.Valid(), ErrMessageIDInvalid)
+
+	m = valid()
+	m.Timestamp = "yesterday"
+	require.ErrorIs(t, m.Valid(), ErrMessageTimestampInvalid)
+
+	m = valid()
+	m.IdempotencyKey = strings.Repeat("k", protocol.MaxIdempotencyKeyLength+1)
+	require.ErrorIs(t, m.Valid(), ErrMessageKeyInvalid)
+}
@@ -0,0 +1,29 @@
+package chat
+
+import "github.com/Salam4nder/chat/internal/event"
+
+const ModerationEvent = "Moderation"
+
+// Moderated binds ModerationEvent to its payload.
+var Moderated = event.NewType[Moderation](ModerationEvent)
+
+// ModerationAction names what a moderator did.
+type ModerationAction string
+
+const (
+	ModerationTopic  ModerationAction = "topic"
+	ModerationKick   ModerationAction = "kick"
+	ModerationMute   ModerationAction = "mute"
+	ModerationUnmute ModerationAction = "unmute"
+)
+
+// Moderation is a moderator acting on a room or one of its members.
+type Moderation struct {
+	RoomID      string
+	ModeratorID string
+	Action      ModerationAction
+	// TargetID is the user acted on, empty for room-wide actions.
+	TargetID string
+	// Text is the new topic for ModerationTopic.
+	Text string
+}
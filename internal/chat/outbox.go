@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultOutboxRelayDelay is used when no relay delay is configured.
+	DefaultOutboxRelayDelay = 10 * time.Second
+	// DefaultOutboxBatchSize is used when no batch size is configured.
+	DefaultOutboxBatchSize = 100
+)
+
+// OutboxRelay publishes the outbox entries of messages that were
+// persisted but not published, giving at-least-once delivery.
+//
+// Every node runs a relay over every shard, so an entry can be
+// published more than once. Rooms drop messages they have already
+// broadcast and clients deduplicate by message ID.
+type OutboxRelay struct {
+	outboxRepo db.OutboxRepository
+	publisher  Publisher
+	delay      time.Duration
+	batchSize  int
+}
+
+// NewOutboxRelay returns a new OutboxRelay. Entries younger than delay
+// are left to the handler that wrote them. Zero values are replaced
+// by defaults.
+func NewOutboxRelay(
+	repo db.OutboxRepository,
+	publisher Publisher,
+	delay time.Duration,
+	batchSize int,
+) *OutboxRelay {
+	if delay <= 0 {
+		delay = DefaultOutboxRelayDelay
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultOutboxBatchSize
+	}
+	return &OutboxRelay{
+		outboxRepo: repo,
+		publisher:  publisher,
+		delay:      delay,
+		batchSize:  batchSize,
+	}
+}
+
+// Run relays the outbox every interval until ctx is done.
+func (x *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Warn().Msg("outbox relay: interval not set, relay disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := x.Relay(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("outbox relay: relaying outbox")
+			}
+			if n > 0 {
+				log.Info().Int("entries", n).Msg("outbox relay: relayed outbox entries")
+			}
+		}
+	}
+}
+
+// Relay publishes and deletes up to one batch of entries per shard.
+// It stops at the first failed publish, as the broker is likely down,
+// and returns the number of entries relayed.
+func (x *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	before := time.Now().Add(-x.delay)
+
+	var n int
+	for shard := 0; shard < db.OutboxShards; shard++ {
+		entries, err := x.outboxRepo.ReadOutbox(ctx, db.ReadOutboxParams{
+			Shard:  shard,
+			Before: before,
+			Limit:  x.batchSize,
+		})
+		if err != nil {
+			return n, fmt.Errorf("outbox relay: reading shard %d, %w", shard, err)
+		}
+
+		for _, entry := range entries {
+			if err := publish(ctx, x.publisher, entry.Subject, entry.Data); err != nil {
+				return n, fmt.Errorf("outbox relay: publishing entry %s, %w", entry.ID, err)
+			}
+			metrics.OutboxRelayed.Inc()
+			n++
+
+			if err := x.outboxRepo.DeleteOutboxEntry(ctx, entry.Shard, entry.ID); err != nil {
+				return n, fmt.Errorf("outbox relay: deleting entry %s, %w", entry.ID, err)
+			}
+		}
+	}
+
+	return n, nil
+}
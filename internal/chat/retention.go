@@ -0,0 +1,406 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/cluster"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// day is the retention granularity.
+	day = 24 * time.Hour
+	// retentionCacheTTL is how long a node caches a room's retention.
+	// Policy changes are broadcast to every node, nodes that miss the
+	// broadcast apply them to new messages after it.
+	retentionCacheTTL = time.Minute
+	// retentionJobTimeout bounds re-applying the policy of a single room.
+	retentionJobTimeout = 10 * time.Minute
+	// retentionLease is how long a node owns re-applying the policy of
+	// a room. It outlives the job, so a job never loses its lease.
+	retentionLease = retentionJobTimeout + time.Minute
+
+	// OpRetentionChanged is the control-plane op that drops
+	// the cached retention of a room on every node.
+	OpRetentionChanged = "retentionChanged"
+
+	// MaxRetentionDays is the longest retention, ScyllaDB rejects
+	// TTLs over 20 years. Longer retention means keeping forever.
+	MaxRetentionDays = 20 * 365
+)
+
+var (
+	ErrRetentionDaysInvalid = errors.New("retention days invalid")
+	ErrLegalHold            = errors.New("room is under legal hold")
+)
+
+// RetentionPolicy defines how long messages in a room are kept.
+type RetentionPolicy struct {
+	RoomID string `json:"roomID"`
+	// RetentionDays is nil when the room uses the default retention.
+	// Zero keeps messages forever.
+	RetentionDays *int `json:"retentionDays"`
+	// LegalHold stops expiry and deletion of messages in the room.
+	LegalHold bool `json:"legalHold"`
+	// ReapplyPending is true until existing messages follow the policy.
+	ReapplyPending bool      `json:"reapplyPending"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type cachedRetention struct {
+	ttl       time.Duration
+	expiresAt time.Time
+}
+
+// RetentionService manages per-room retention policies.
+// Policies are applied through TTLs at write time and re-applied
+// to existing messages by a background job when they change. The
+// job of every node runs, but one node at a time owns each room.
+type RetentionService struct {
+	policyRepo       db.RoomPolicyRepository
+	messageRepo      db.MessageRepository
+	defaultRetention time.Duration
+	// owner identifies this service in room leases.
+	owner string
+	// control is nil until Register is called.
+	control *cluster.ControlPlane
+
+	mu    sync.Mutex
+	cache map[string]cachedRetention
+	// forgotten counts forget calls, so that a policy read before
+	// a change is not cached after it.
+	forgotten uint64
+}
+
+// NewRetentionService returns a new instance of RetentionService.
+// defaultDays applies to rooms without a policy, zero keeps messages forever.
+// It is capped at MaxRetentionDays.
+func NewRetentionService(
+	policyRepo db.RoomPolicyRepository,
+	messageRepo db.MessageRepository,
+	defaultDays int,
+) *RetentionService {
+	if defaultDays > MaxRetentionDays {
+		log.Warn().
+			Int("defaultDays", defaultDays).
+			Msgf("retention service: default retention capped at %d days", MaxRetentionDays)
+		defaultDays = MaxRetentionDays
+	}
+	return &RetentionService{
+		policyRepo:       policyRepo,
+		messageRepo:      messageRepo,
+		defaultRetention: time.Duration(defaultDays) * day,
+		owner:            uuid.NewString(),
+		cache:            make(map[string]cachedRetention),
+	}
+}
+
+// Register registers the retention ops of this node on the control
+// plane, through which policy changes are broadcast from then on.
+// Call it before starting the control plane.
+func (x *RetentionService) Register(control *cluster.ControlPlane) error {
+	x.control = control
+	return control.Handle(OpRetentionChanged, x.handleRetentionChanged)
+}
+
+// Policy returns the policy of a room.
+// Rooms without a stored policy get the default policy.
+func (x *RetentionService) Policy(ctx context.Context, roomID string) (RetentionPolicy, error) {
+	row, err := x.policyRepo.ReadRoomPolicy(ctx, roomID)
+	if err != nil {
+		if errors.Is(err, db.ErrRoomPolicyNotFound) {
+			return RetentionPolicy{RoomID: roomID}, nil
+		}
+		return RetentionPolicy{}, fmt.Errorf("retention service: reading policy, %w", err)
+	}
+
+	return RetentionPolicy{
+		RoomID:         row.RoomID,
+		RetentionDays:  row.RetentionDays,
+		LegalHold:      row.LegalHold,
+		ReapplyPending: row.ReapplyPending,
+		UpdatedAt:      row.UpdatedAt,
+	}, nil
+}
+
+// SetPolicy stores the policy of a room and drops its cached retention
+// on every node. Existing messages are re-applied by the retention job
+// if the effective TTL changed.
+func (x *RetentionService) SetPolicy(ctx context.Context, policy RetentionPolicy) error {
+	if policy.RoomID == "" {
+		return ErrRoomIDInvalid
+	}
+	if policy.RetentionDays != nil &&
+		(*policy.RetentionDays < 0 || *policy.RetentionDays > MaxRetentionDays) {
+		return ErrRetentionDaysInvalid
+	}
+
+	old, err := x.Policy(ctx, policy.RoomID)
+	if err != nil {
+		return err
+	}
+
+	if err := x.policyRepo.UpsertRoomPolicy(ctx, db.RoomPolicy{
+		RoomID:         policy.RoomID,
+		RetentionDays:  policy.RetentionDays,
+		LegalHold:      policy.LegalHold,
+		ReapplyPending: old.ReapplyPending || x.ttl(old) != x.ttl(policy),
+		UpdatedAt:      time.Now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("retention service: storing policy, %w", err)
+	}
+
+	x.forget(policy.RoomID)
+	x.broadcast(ctx, policy.RoomID)
+
+	return nil
+}
+
+// broadcast drops the cached retention of a room on every node.
+// Nodes that miss it use the old retention until their cache expires.
+func (x *RetentionService) broadcast(ctx context.Context, roomID string) {
+	if x.control == nil {
+		return
+	}
+
+	result, err := x.control.Scatter(ctx, OpRetentionChanged, roomID)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("room", roomID).
+			Msg("retention service: broadcasting policy change")
+		return
+	}
+	if len(result.Missing) > 0 {
+		log.Warn().
+			Str("room", roomID).
+			Strs("nodes", result.Missing).
+			Msgf("retention service: nodes missed the policy change, they apply it within %s", retentionCacheTTL)
+	}
+}
+
+func (x *RetentionService) handleRetentionChanged(_ context.Context, r cluster.Request) (any, error) {
+	var roomID string
+	if err := r.Decode(&roomID); err != nil {
+		return nil, fmt.Errorf("retention service: decoding room ID, %w", err)
+	}
+	x.forget(roomID)
+	return true, nil
+}
+
+// forget drops the cached retention of a room.
+func (x *RetentionService) forget(roomID string) {
+	x.mu.Lock()
+	delete(x.cache, roomID)
+	x.forgotten++
+	x.mu.Unlock()
+}
+
+// TTL returns the TTL of new messages in a room.
+// Zero means the message never expires.
+func (x *RetentionService) TTL(ctx context.Context, roomID string) (time.Duration, error) {
+	x.mu.Lock()
+	cached, ok := x.cache[roomID]
+	forgotten := x.forgotten
+	x.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.ttl, nil
+	}
+
+	policy, err := x.Policy(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	ttl := x.ttl(policy)
+
+	x.mu.Lock()
+	if x.forgotten == forgotten {
+		x.cache[roomID] = cachedRetention{ttl: ttl, expiresAt: time.Now().Add(retentionCacheTTL)}
+	}
+	x.mu.Unlock()
+
+	return ttl, nil
+}
+
+// DeletionAllowed returns ErrLegalHold if messages of the room
+// must not be deleted.
+func (x *RetentionService) DeletionAllowed(ctx context.Context, roomID string) error {
+	policy, err := x.Policy(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if policy.LegalHold {
+		return fmt.Errorf("%w: %s", ErrLegalHold, roomID)
+	}
+	return nil
+}
+
+// Run re-applies changed policies every interval until ctx is done.
+func (x *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Warn().Msg("retention service: job interval not set, job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := x.ReapplyPending(ctx); err != nil {
+				log.Error().Err(err).Msg("retention service: re-applying policies")
+			}
+		}
+	}
+}
+
+// ReapplyPending re-applies every policy that changed since it was
+// last applied to the existing messages of its room. Rooms whose lease
+// another node holds are left to it.
+func (x *RetentionService) ReapplyPending(ctx context.Context) error {
+	rows, err := x.policyRepo.ReadPendingRoomPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("retention service: reading pending policies, %w", err)
+	}
+
+	var errs []error
+	for _, row := range rows {
+		if err := x.reapplyLeased(ctx, row); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// reapplyLeased re-applies the policy of a room if this node
+// claims its lease, and releases the lease when done.
+func (x *RetentionService) reapplyLeased(ctx context.Context, row db.RoomPolicy) error {
+	ok, err := x.policyRepo.ClaimRoomPolicyLease(ctx, row.RoomID, x.owner, retentionLease)
+	if err != nil {
+		return fmt.Errorf("retention service: claiming room %s, %w", row.RoomID, err)
+	}
+	if !ok {
+		log.Debug().
+			Str("room", row.RoomID).
+			Msg("retention service: policy re-applied by another node")
+		return nil
+	}
+	defer func() {
+		if err := x.policyRepo.ReleaseRoomPolicyLease(context.Background(), row.RoomID, x.owner); err != nil {
+			log.Error().
+				Err(err).
+				Str("room", row.RoomID).
+				Msg("retention service: releasing room, it is released when the lease expires")
+		}
+	}()
+
+	return x.reapply(ctx, row)
+}
+
+// reapply rewrites every message of a room with the TTL it has left
+// under the policy, one page at a time. Messages older than the retention
+// are deleted. Under legal hold expiry is removed and nothing is deleted.
+// The policy is read again with every page, and the job stops once it
+// changed, so that a legal hold set meanwhile stops deletion at once.
+func (x *RetentionService) reapply(ctx context.Context, row db.RoomPolicy) error {
+	ctx, cancel := context.WithTimeout(ctx, retentionJobTimeout)
+	defer cancel()
+
+	ttl := x.ttl(RetentionPolicy{RetentionDays: row.RetentionDays, LegalHold: row.LegalHold})
+
+	var rewritten, deleted int
+	params := db.ReadMessagesPageParams{RoomID: row.RoomID}
+	for {
+		page, err := x.messageRepo.ReadMessagesByRoomIDPage(ctx, params)
+		if err != nil {
+			return fmt.Errorf("retention service: reading messages of room %s, %w", row.RoomID, err)
+		}
+		current, err := x.policyRepo.ReadRoomPolicy(ctx, row.RoomID)
+		if err != nil {
+			return fmt.Errorf("retention service: reading policy of room %s, %w", row.RoomID, err)
+		}
+		if !current.UpdatedAt.Equal(row.UpdatedAt) {
+			log.Info().
+				Str("room", row.RoomID).
+				Int("rewritten", rewritten).
+				Int("deleted", deleted).
+				Msg("retention service: policy changed, re-applying the new one on the next run")
+			return nil
+		}
+
+		now := time.Now()
+		for _, message := range page.Messages {
+			ref := db.MessageRef{RoomID: message.RoomID, ID: message.ID}
+			remaining := time.Duration(0)
+			if ttl > 0 {
+				remaining = ttl - now.Sub(messageTime(message))
+				if remaining <= 0 {
+					if err := x.messageRepo.DeleteMessageByRoom(ctx, row.RoomID, message.ID); err != nil {
+						return fmt.Errorf("retention service: deleting expired message, %w", err)
+					}
+					if message.UserID != "" {
+						if err := x.messageRepo.DeleteMessageBySender(ctx, message.UserID, ref); err != nil {
+							return fmt.Errorf("retention service: deleting expired message ref, %w", err)
+						}
+					}
+					deleted++
+					continue
+				}
+			}
+			// The page may be stale by now, the repository
+			// re-reads the message before rewriting it.
+			if err := x.messageRepo.RewriteMessageByRoom(ctx, ref, remaining); err != nil {
+				return fmt.Errorf("retention service: rewriting message, %w", err)
+			}
+			rewritten++
+		}
+
+		if page.NextPageState == nil {
+			break
+		}
+		params.PageState = page.NextPageState
+	}
+
+	if err := x.policyRepo.MarkRoomPolicyApplied(ctx, row.RoomID, row.UpdatedAt); err != nil {
+		return fmt.Errorf("retention service: marking policy applied, %w", err)
+	}
+
+	log.Info().
+		Str("room", row.RoomID).
+		Int("rewritten", rewritten).
+		Int("deleted", deleted).
+		Msg("retention service: policy re-applied")
+
+	return nil
+}
+
+// ttl returns the effective message TTL of a policy.
+func (x *RetentionService) ttl(policy RetentionPolicy) time.Duration {
+	switch {
+	case policy.LegalHold:
+		return 0
+	case policy.RetentionDays != nil:
+		return time.Duration(*policy.RetentionDays) * day
+	default:
+		return x.defaultRetention
+	}
+}
+
+// messageTime returns when a message was written. Message IDs are
+// time based, which holds even for rows written without a timestamp.
+func messageTime(message db.Message) time.Time {
+	if message.ID.Version() == 1 {
+		return message.ID.Time()
+	}
+	return message.Time
+}
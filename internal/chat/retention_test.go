@@ -0,0 +1,434 @@
+package chat
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+)
+
+// messageRepo is a MessageRepository keeping messages in memory.
+// Page states are the ID of the last message of a page, so that
+// deleting messages does not shift later pages.
+type messageRepo struct {
+	mu       sync.Mutex
+	messages map[db.MessageRef]db.Message
+	ttls     map[db.MessageRef]time.Duration
+	claims   map[db.IdempotencyKey]gocql.UUID
+	// outbox, if set, receives the outbox entries of created messages.
+	outbox *outboxRepo
+	// createErr, if set, fails CreateMessageByRoom.
+	createErr error
+	// keepDeleted makes DeleteMessageByRoom a no-op.
+	keepDeleted bool
+	// onPage is called after a page was read.
+	onPage func()
+}
+
+func newMessageRepo() *messageRepo {
+	return &messageRepo{
+		messages: make(map[db.MessageRef]db.Message),
+		ttls:     make(map[db.MessageRef]time.Duration),
+		claims:   make(map[db.IdempotencyKey]gocql.UUID),
+	}
+}
+
+func (x *messageRepo) Session() *gocql.Session { return nil }
+
+func (x *messageRepo) CreateMessageByRoom(_ context.Context, params db.CreateMessageByRoomParams) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.createErr != nil {
+		return x.createErr
+	}
+	ref := db.MessageRef{RoomID: params.RoomID, ID: params.ID}
+	x.messages[ref] = db.Message{
+		ID:     params.ID,
+		Data:   params.Data,
+		Type:   params.Type,
+		Sender: params.Sender,
+		UserID: params.UserID,
+		RoomID: params.RoomID,
+		Time:   params.Timestamp,
+	}
+	x.ttls[ref] = params.TTL
+	if x.outbox != nil && params.Outbox != nil {
+		x.outbox.add(db.OutboxEntry{
+			Shard:         db.OutboxShard(params.RoomID),
+			ID:            params.ID,
+			OutboxMessage: *params.Outbox,
+		})
+	}
+	return nil
+}
+
+func (x *messageRepo) ClaimIdempotencyKey(_ context.Context, params db.ClaimIdempotencyKeyParams) (gocql.UUID, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := db.IdempotencyKey{RoomID: params.RoomID, UserID: params.UserID, Key: params.Key}
+	if id, ok := x.claims[key]; ok {
+		return id, nil
+	}
+	x.claims[key] = params.ID
+	return params.ID, nil
+}
+
+func (x *messageRepo) ReadIdempotencyKeysByUser(_ context.Context, userID string) ([]db.IdempotencyKey, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var keys []db.IdempotencyKey
+	for key := range x.claims {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (x *messageRepo) DeleteIdempotencyKey(_ context.Context, key db.IdempotencyKey) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.claims, key)
+	return nil
+}
+
+func (x *messageRepo) ReadMessagesByRoomID(_ context.Context, roomID string) ([]db.Message, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.room(roomID), nil
+}
+
+func (x *messageRepo) ReadMessagesByRoomIDPage(_ context.Context, params db.ReadMessagesPageParams) (db.MessagePage, error) {
+	x.mu.Lock()
+	messages := x.room(params.RoomID)
+	x.mu.Unlock()
+
+	if params.PageState != nil {
+		last, err := gocql.ParseUUID(string(params.PageState))
+		if err != nil {
+			return db.MessagePage{}, err
+		}
+		i := sort.Search(len(messages), func(i int) bool {
+			return messageBefore(last, messages[i].ID)
+		})
+		messages = messages[i:]
+	}
+	pageSize := params.PageSize
+	if pageSize <= 0 {
+		pageSize = db.DefaultPageSize
+	}
+
+	var page db.MessagePage
+	if len(messages) > pageSize {
+		messages = messages[:pageSize]
+		page.NextPageState = []byte(messages[pageSize-1].ID.String())
+	}
+	page.Messages = messages
+	if x.onPage != nil {
+		x.onPage()
+	}
+	return page, nil
+}
+
+func (x *messageRepo) ReadMessageByRoom(_ context.Context, ref db.MessageRef) (db.Message, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	m, ok := x.messages[ref]
+	if !ok {
+		return db.Message{}, db.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func (x *messageRepo) RewriteMessageByRoom(_ context.Context, ref db.MessageRef, ttl time.Duration) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.messages[ref]; ok {
+		x.ttls[ref] = ttl
+	}
+	return nil
+}
+
+func (x *messageRepo) DeleteMessageByRoom(_ context.Context, roomID string, id gocql.UUID) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.keepDeleted {
+		return nil
+	}
+	ref := db.MessageRef{RoomID: roomID, ID: id}
+	delete(x.messages, ref)
+	delete(x.ttls, ref)
+	return nil
+}
+
+func (x *messageRepo) ReadMessageRefsBySender(_ context.Context, userID string) ([]db.MessageRef, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var refs []db.MessageRef
+	for ref, m := range x.messages {
+		if m.UserID == userID {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+func (x *messageRepo) AnonymiseMessageByRoom(_ context.Context, ref db.MessageRef, sender string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if m, ok := x.messages[ref]; ok {
+		m.Sender = sender
+		m.UserID = ""
+		x.messages[ref] = m
+	}
+	return nil
+}
+
+func (x *messageRepo) DeleteMessageBySender(context.Context, string, db.MessageRef) error {
+	return nil
+}
+
+// room returns the messages of a room, oldest first.
+func (x *messageRepo) room(roomID string) []db.Message {
+	var messages []db.Message
+	for ref, m := range x.messages {
+		if ref.RoomID == roomID {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messageBefore(messages[i].ID, messages[j].ID)
+	})
+	return messages
+}
+
+// messageBefore orders message IDs by time, then by their bytes.
+func messageBefore(a, b gocql.UUID) bool {
+	if !a.Time().Equal(b.Time()) {
+		return a.Time().Before(b.Time())
+	}
+	return a.String() < b.String()
+}
+
+// policyRepo is a RoomPolicyRepository keeping policies in memory.
+type policyRepo struct {
+	mu       sync.Mutex
+	policies map[string]db.RoomPolicy
+	// leases maps rooms to the owner of their lease.
+	leases map[string]string
+}
+
+func (x *policyRepo) UpsertRoomPolicy(_ context.Context, params db.RoomPolicy) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.policies[params.RoomID] = params
+	return nil
+}
+
+func (x *policyRepo) ReadRoomPolicy(_ context.Context, roomID string) (db.RoomPolicy, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	policy, ok := x.policies[roomID]
+	if !ok {
+		return db.RoomPolicy{}, db.ErrRoomPolicyNotFound
+	}
+	return policy, nil
+}
+
+func (x *policyRepo) ReadPendingRoomPolicies(context.Context) ([]db.RoomPolicy, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var policies []db.RoomPolicy
+	for _, policy := range x.policies {
+		if policy.ReapplyPending {
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+func (x *policyRepo) MarkRoomPolicyApplied(_ context.Context, roomID string, updatedAt time.Time) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if policy, ok := x.policies[roomID]; ok && policy.UpdatedAt.Equal(updatedAt) {
+		policy.ReapplyPending = false
+		x.policies[roomID] = policy
+	}
+	return nil
+}
+
+func (x *policyRepo) ClaimRoomPolicyLease(_ context.Context, roomID, owner string, _ time.Duration) (bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.leases == nil {
+		x.leases = make(map[string]string)
+	}
+	if holder, ok := x.leases[roomID]; ok {
+		return holder == owner, nil
+	}
+	x.leases[roomID] = owner
+	return true, nil
+}
+
+func (x *policyRepo) ReleaseRoomPolicyLease(_ context.Context, roomID, owner string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.leases[roomID] == owner {
+		delete(x.leases, roomID)
+	}
+	return nil
+}
+
+func Test_RetentionService_SetPolicy(t *testing.T) {
+	ctx := context.Background()
+	service := NewRetentionService(&policyRepo{policies: make(map[string]db.RoomPolicy)}, newMessageRepo(), 30)
+
+	for _, days := range []int{-1, MaxRetentionDays + 1} {
+		err := service.SetPolicy(ctx, RetentionPolicy{RoomID: "room", RetentionDays: &days})
+		require.ErrorIs(t, err, ErrRetentionDaysInvalid)
+	}
+	require.ErrorIs(t, service.SetPolicy(ctx, RetentionPolicy{}), ErrRoomIDInvalid)
+
+	days := MaxRetentionDays
+	require.NoError(t, service.SetPolicy(ctx, RetentionPolicy{RoomID: "room", RetentionDays: &days}))
+	ttl, err := service.TTL(ctx, "room")
+	require.NoError(t, err)
+	require.Equal(t, MaxRetentionDays*day, ttl)
+
+	capped := NewRetentionService(&policyRepo{policies: make(map[string]db.RoomPolicy)}, newMessageRepo(), 100*365)
+	ttl, err = capped.TTL(ctx, "room")
+	require.NoError(t, err)
+	require.Equal(t, MaxRetentionDays*day, ttl)
+}
+
+func Test_RetentionService_ReapplyPending(t *testing.T) {
+	ctx := context.Background()
+	policies := &policyRepo{policies: make(map[string]db.RoomPolicy)}
+	messages := newMessageRepo()
+	service := NewRetentionService(policies, messages, 0)
+
+	roomID := gocql.TimeUUID().String()
+	now := time.Now()
+	old := db.DefaultPageSize + 10
+	for i := 0; i < old+5; i++ {
+		// The oldest messages are 40 days old, the rest a day old.
+		at := now.Add(-day)
+		if i < old {
+			at = now.Add(-40*day + time.Duration(i)*time.Second)
+		}
+		require.NoError(t, messages.CreateMessageByRoom(ctx, db.CreateMessageByRoomParams{
+			ID:        gocql.UUIDFromTime(at),
+			Data:      []byte("hello"),
+			UserID:    "ann",
+			RoomID:    roomID,
+			Timestamp: at,
+		}))
+	}
+
+	// A message anonymised while the job runs stays anonymised.
+	latest, err := messages.ReadMessagesByRoomID(ctx, roomID)
+	require.NoError(t, err)
+	ref := db.MessageRef{RoomID: roomID, ID: latest[len(latest)-1].ID}
+	var pages int
+	messages.onPage = func() {
+		pages++
+		if pages == 1 {
+			require.NoError(t, messages.AnonymiseMessageByRoom(ctx, ref, "deleted user"))
+		}
+	}
+
+	days := 30
+	require.NoError(t, service.SetPolicy(ctx, RetentionPolicy{RoomID: roomID, RetentionDays: &days}))
+	require.NoError(t, service.ReapplyPending(ctx))
+	require.Equal(t, 2, pages)
+
+	remaining, err := messages.ReadMessagesByRoomID(ctx, roomID)
+	require.NoError(t, err)
+	require.Len(t, remaining, 5)
+	for _, m := range remaining {
+		ttl := messages.ttls[db.MessageRef{RoomID: roomID, ID: m.ID}]
+		require.InDelta(t, float64(29*day), float64(ttl), float64(time.Minute))
+	}
+	require.Equal(t, "deleted user", messages.messages[ref].Sender)
+
+	policy, err := service.Policy(ctx, roomID)
+	require.NoError(t, err)
+	require.False(t, policy.ReapplyPending)
+}
+
+func Test_RetentionService_ReapplyLeased(t *testing.T) {
+	ctx := context.Background()
+	policies := &policyRepo{policies: make(map[string]db.RoomPolicy)}
+	messages := newMessageRepo()
+	service := NewRetentionService(policies, messages, 0)
+
+	roomID := gocql.TimeUUID().String()
+	at := time.Now().Add(-40 * day)
+	require.NoError(t, messages.CreateMessageByRoom(ctx, db.CreateMessageByRoomParams{
+		ID:        gocql.UUIDFromTime(at),
+		RoomID:    roomID,
+		Timestamp: at,
+	}))
+	days := 30
+	require.NoError(t, service.SetPolicy(ctx, RetentionPolicy{RoomID: roomID, RetentionDays: &days}))
+
+	// Another node re-applies the policy of the room.
+	ok, err := policies.ClaimRoomPolicyLease(ctx, roomID, "other", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, service.ReapplyPending(ctx))
+	require.Len(t, messages.messages, 1)
+	policy, err := service.Policy(ctx, roomID)
+	require.NoError(t, err)
+	require.True(t, policy.ReapplyPending)
+
+	require.NoError(t, policies.ReleaseRoomPolicyLease(ctx, roomID, "other"))
+	require.NoError(t, service.ReapplyPending(ctx))
+	require.Empty(t, messages.messages)
+	require.Empty(t, policies.leases)
+}
+
+func Test_RetentionService_LegalHoldDuringReapply(t *testing.T) {
+	ctx := context.Background()
+	policies := &policyRepo{policies: make(map[string]db.RoomPolicy)}
+	messages := newMessageRepo()
+	service := NewRetentionService(policies, messages, 0)
+
+	roomID := gocql.TimeUUID().String()
+	now := time.Now()
+	n := db.DefaultPageSize + 10
+	for i := 0; i < n; i++ {
+		at := now.Add(-40*day + time.Duration(i)*time.Second)
+		require.NoError(t, messages.CreateMessageByRoom(ctx, db.CreateMessageByRoomParams{
+			ID:        gocql.UUIDFromTime(at),
+			RoomID:    roomID,
+			Timestamp: at,
+		}))
+	}
+	days := 30
+	require.NoError(t, service.SetPolicy(ctx, RetentionPolicy{RoomID: roomID, RetentionDays: &days}))
+
+	// The hold is set on another node while the first page is read.
+	other := NewRetentionService(policies, messages, 0)
+	messages.onPage = func() {
+		messages.onPage = nil
+		require.NoError(t, other.SetPolicy(ctx, RetentionPolicy{RoomID: roomID, RetentionDays: &days, LegalHold: true}))
+	}
+	require.NoError(t, service.ReapplyPending(ctx))
+	require.Len(t, messages.messages, n)
+
+	// The next run applies the hold.
+	require.NoError(t, service.ReapplyPending(ctx))
+	require.Len(t, messages.messages, n)
+	for _, ttl := range messages.ttls {
+		require.Zero(t, ttl)
+	}
+	policy, err := service.Policy(ctx, roomID)
+	require.NoError(t, err)
+	require.False(t, policy.ReapplyPending)
+}
@@ -1,51 +1,221 @@
 package chat
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/Salam4nder/chat/internal/broker"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
 	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/Salam4nder/chat/internal/tracing"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/Salam4nder/chat/pkg/protocol"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// interactiveMessagesLimit bounds how many messages with components
+// a room remembers for routing interactions.
+const interactiveMessagesLimit = 1024
+
+// roleTimeout is the maximum duration to read or write a room role.
+const roleTimeout = 5 * time.Second
+
+// recentMessagesLimit bounds how many message IDs a room remembers
+// to drop duplicates, which at-least-once delivery can produce.
+const recentMessagesLimit = 1024
+
 type empty struct{}
 
-type Rooms map[string]*Room
+// interactiveMessage is a message with components.
+type interactiveMessage struct {
+	ownerID    string
+	components map[string]empty
+}
+
+// Rooms is a concurrent-safe registry of the rooms on this node.
+type Rooms struct {
+	mu         sync.RWMutex
+	rooms      map[string]*Room
+	closed     bool
+	subscriber Subscriber
+}
+
+// NewRooms returns an empty room registry.
+func NewRooms() *Rooms {
+	return &Rooms{rooms: make(map[string]*Room)}
+}
 
 // ChatRomoms is the main chat room registry.
-var ChatRomoms Rooms
+var ChatRomoms = NewRooms()
 
-func (x *Rooms) Run(m chan *nats.Msg, interrupt chan os.Signal) {
-	for {
-		select {
-		case msg := <-m:
-			if msg == nil {
-				return
-			}
-			var message Message
-			if err := gob.NewDecoder(bytes.NewReader(msg.Data)).
-				Decode(&message); err != nil {
-				log.Error().
-					Err(err).
-					Msg("failed to decode message")
-			}
-			if room, ok := (*x)[message.RoomID]; ok {
-				room.broadcast(message)
-			}
+// SetSubscriber sets the Subscriber of the rooms created from now on.
+// Rooms created without one only reach the sessions of this node.
+func (x *Rooms) SetSubscriber(subscriber Subscriber) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
 
-		case i := <-interrupt:
-			for _, room := range *x {
-				room.interrupt <- i
-			}
+	x.subscriber = subscriber
+}
+
+// Get returns the room with the given ID.
+func (x *Rooms) Get(roomID string) (*Room, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	room, ok := x.rooms[roomID]
+	return room, ok
+}
+
+// GetOrCreate returns the room with the given ID, creating
+// and running it with create if it does not exist.
+func (x *Rooms) GetOrCreate(roomID string, create func() (*Room, error)) (*Room, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.closed {
+		return nil, ErrRoomClosed
+	}
+	if room, ok := x.rooms[roomID]; ok {
+		return room, nil
+	}
+	room, err := create()
+	if err != nil {
+		return nil, err
+	}
+	room.subscriber = x.subscriber
+	x.rooms[roomID] = room
+	metrics.Rooms.Inc()
+	go room.Run()
+	return room, nil
+}
+
+// All returns every room, ordered by ID.
+func (x *Rooms) All() []*Room {
+	x.mu.RLock()
+	rooms := make([]*Room, 0, len(x.rooms))
+	for _, room := range x.rooms {
+		rooms = append(rooms, room)
+	}
+	x.mu.RUnlock()
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms
+}
+
+// Run delivers messages and interactions from the broker to the rooms
+// on this node until m is closed. Stream messages are acknowledged
+// once delivered.
+func (x *Rooms) Run(m chan *broker.Msg) {
+	for msg := range m {
+		x.deliver(msg)
+		if err := msg.Ack(); err != nil {
+			log.Error().Err(err).Msg("chat: acknowledging stream message")
+		}
+	}
+}
+
+// Shutdown stops accepting new rooms, sends a going-away close frame
+// with the given reason to every session and stops every room.
+// It returns the number of sessions it closed.
+func (x *Rooms) Shutdown(reason string) int {
+	x.mu.Lock()
+	x.closed = true
+	x.mu.Unlock()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		n  int
+	)
+	for _, room := range x.All() {
+		wg.Add(1)
+		go func(room *Room) {
+			defer wg.Done()
+			closed := room.Shutdown(reason)
+			mu.Lock()
+			n += closed
+			mu.Unlock()
+		}(room)
+	}
+	wg.Wait()
+	return n
+}
+
+// deliver decodes a message or interaction from the broker
+// and hands it to its room on this node.
+func (x *Rooms) deliver(msg *broker.Msg) {
+	roomID, kind, ok := parseRoomSubject(msg.Subject)
+	if !ok {
+		log.Error().Str("subject", msg.Subject).Msg("chat: unknown subject")
+		return
+	}
+	room, ok := x.Get(roomID)
+	if !ok {
+		return
+	}
+
+	// Relayed outbox entries have no headers, only the trace
+	// context of their envelope.
+	env, decodeErr := wire.Unmarshal(msg.Data)
+	parent := tracing.Extract(context.Background(), msg.Header)
+	if !trace.SpanContextFromContext(parent).IsValid() {
+		parent = tracing.ExtractMap(parent, env.Trace)
+	}
+	ctx, span := tracing.Start(
+		parent,
+		"nats.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", msg.Subject)),
+	)
+	defer span.End()
+
+	if decodeErr != nil {
+		span.RecordError(decodeErr)
+		log.Error().
+			Ctx(ctx).
+			Err(decodeErr).
+			Str("subject", msg.Subject).
+			Msg("failed to decode envelope")
+		return
+	}
+
+	switch kind {
+	case SubjectInteraction:
+		interaction, err := decodeInteraction(env)
+		if err != nil {
+			span.RecordError(err)
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("origin", env.OriginNode).
+				Msg("failed to decode interaction")
 			return
 		}
+		room.deliverInteraction(ctx, interaction)
+
+	case SubjectMessage:
+		message, err := decodeMessage(env)
+		if err != nil {
+			span.RecordError(err)
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("origin", env.OriginNode).
+				Msg("failed to decode message")
+			return
+		}
+		message.Seq = msg.Seq
+		room.broadcast(ctx, message)
 	}
 }
 
@@ -58,13 +228,45 @@ type Room struct {
 	Leave    chan *UserSess
 	Sessions map[*UserSess]empty
 
-	interrupt     chan os.Signal
+	// topic lives in memory on the node serving the room's sessions.
+	// It is not shared with other nodes and is lost when the room stops.
+	topic string
+	// roles keeps who moderates the room and who is muted in it,
+	// shared by every node.
+	roles db.RoomRoleRepository
+
+	interactive      map[uuid.UUID]interactiveMessage
+	interactiveOrder []uuid.UUID
+
+	recent      map[uuid.UUID]empty
+	recentOrder []uuid.UUID
+
+	// subscriber and unsubscribeFn are only used by Run.
+	subscriber    Subscriber
+	unsubscribeFn func() error
+
+	// memberships queues the membership events of Run for
+	// forwardMemberships. Run never publishes itself, as the event
+	// worker that hands it sessions waits on Join, so a Run waiting
+	// on that worker's full queue would stop both.
+	membershipMu    sync.Mutex
+	memberships     []membershipEvent
+	membershipReady chan struct{}
+
+	done          chan struct{}
+	doneOnce      sync.Once
 	eventRegistry *event.Registry
+	commands      *CommandRegistry
 }
 
 // NewRoom returns a new room with the given ID.
 // Pass in nil to generate a new ID.
-func NewRoom(roomID *string, registry *event.Registry) (*Room, error) {
+func NewRoom(
+	roomID *string,
+	registry *event.Registry,
+	commands *CommandRegistry,
+	roles db.RoomRoleRepository,
+) (*Room, error) {
 	if roomID == nil {
 		str := uuid.NewString()
 		roomID = &str
@@ -72,42 +274,154 @@ func NewRoom(roomID *string, registry *event.Registry) (*Room, error) {
 	if registry == nil {
 		return nil, errors.New("chat: event registry is nil")
 	}
+	if commands == nil {
+		return nil, errors.New("chat: command registry is nil")
+	}
+	if roles == nil {
+		return nil, errors.New("chat: room role repository is nil")
+	}
 	return &Room{
-		ID:            *roomID,
-		Join:          make(chan *UserSess),
-		Leave:         make(chan *UserSess),
-		Sessions:      make(map[*UserSess]empty),
-		eventRegistry: registry,
+		ID:              *roomID,
+		Join:            make(chan *UserSess),
+		Leave:           make(chan *UserSess),
+		Sessions:        make(map[*UserSess]empty),
+		interactive:     make(map[uuid.UUID]interactiveMessage),
+		recent:          make(map[uuid.UUID]empty),
+		membershipReady: make(chan struct{}, 1),
+		done:            make(chan struct{}),
+		eventRegistry:   registry,
+		commands:        commands,
+		roles:           roles,
 	}, nil
 }
 
 // Run runs the main chat room engine.
 // It will handle joins, leaves and writes.
 func (x *Room) Run() {
+	go x.forwardMemberships()
+
 	for {
 		select {
 		case session := <-x.Join:
 			x.mu.Lock()
 			x.Sessions[session] = empty{}
 			x.mu.Unlock()
+			metrics.WebsocketConnections.Inc()
+			x.subscribe()
 			go x.serveConn(session)
+			x.queueMembership(MemberJoined, session)
 			log.Info().Msgf("chat: user joined room %s", x.ID)
 
 		case session := <-x.Leave:
 			session.Conn.Close()
 			x.mu.Lock()
 			delete(x.Sessions, session)
+			last := len(x.Sessions) == 0
 			x.mu.Unlock()
+			metrics.WebsocketConnections.Dec()
+			if last {
+				x.unsubscribe()
+			}
+			x.queueMembership(MemberLeft, session)
 			log.Info().Msgf("chat: user left room %s", x.ID)
 
-		case <-x.interrupt:
-			log.Info().Msgf("chat: room %s interrupted", x.ID)
+		case <-x.done:
+			x.unsubscribe()
+			log.Info().Msgf("chat: room %s stopped", x.ID)
 			return
 		}
 	}
 }
 
+// subscribe subscribes the node to the room unless it already is.
+// A failed subscription is tried again on the next join.
+func (x *Room) subscribe() {
+	if x.subscriber == nil || x.unsubscribeFn != nil {
+		return
+	}
+	unsubscribe, err := x.subscriber(x.ID)
+	if err != nil {
+		log.Error().Err(err).Msgf("chat: room %s only reaches this node", x.ID)
+		return
+	}
+	x.unsubscribeFn = unsubscribe
+}
+
+// unsubscribe unsubscribes the node from the room, once it is empty.
+func (x *Room) unsubscribe() {
+	if x.unsubscribeFn == nil {
+		return
+	}
+	if err := x.unsubscribeFn(); err != nil {
+		log.Error().Err(err).Msgf("chat: unsubscribing from room %s", x.ID)
+	}
+	x.unsubscribeFn = nil
+}
+
+// membershipEvent is a MemberJoined or MemberLeft event
+// waiting to be published.
+type membershipEvent struct {
+	t          event.Type[Membership]
+	membership Membership
+}
+
+// queueMembership queues a MemberJoined or MemberLeft event
+// for forwardMemberships without waiting for it to be published.
+func (x *Room) queueMembership(t event.Type[Membership], sess *UserSess) {
+	x.membershipMu.Lock()
+	x.memberships = append(x.memberships, membershipEvent{t: t, membership: newMembership(x.ID, sess)})
+	x.membershipMu.Unlock()
+
+	select {
+	case x.membershipReady <- struct{}{}:
+	default:
+	}
+}
+
+// forwardMemberships publishes queued membership events in order until
+// the room stops, then publishes what is left.
+func (x *Room) forwardMemberships() {
+	for {
+		select {
+		case <-x.membershipReady:
+			x.publishMemberships()
+		case <-x.done:
+			x.publishMemberships()
+			return
+		}
+	}
+}
+
+// publishMemberships publishes the queued membership events,
+// keyed by room so that they are handled in order.
+func (x *Room) publishMemberships() {
+	x.membershipMu.Lock()
+	pending := x.memberships
+	x.memberships = nil
+	x.membershipMu.Unlock()
+
+	for _, m := range pending {
+		if err := event.Publish(
+			context.Background(),
+			x.eventRegistry,
+			m.t,
+			x.ID,
+			m.membership,
+		); err != nil {
+			log.Error().Err(err).Str("event", m.t.Name()).Msg("chat: publishing membership")
+		}
+	}
+}
+
 func (x *Room) serveConn(sess *UserSess) {
+	defer func() {
+		select {
+		case x.Leave <- sess:
+		case <-x.done:
+			metrics.WebsocketConnections.Dec()
+		}
+	}()
+
 	for {
 		mType, m, err := sess.Conn.ReadMessage()
 		if err != nil {
@@ -119,31 +433,341 @@ func (x *Room) serveConn(sess *UserSess) {
 					Msg("chat: close message received")
 				break
 			}
+			log.Error().Err(err).Msg("chat: reading message")
+			break
 		}
+		metrics.MessagesReceived.Inc()
+		x.receive(sess, mType, m)
+	}
+}
+
+// receive handles a frame read from a session.
+func (x *Room) receive(sess *UserSess, mType int, m []byte) {
+	ctx, span := tracing.Start(context.Background(), "chat.receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("chat.room.id", x.ID),
+			attribute.String("chat.user.id", sess.UserID),
+		))
+	defer span.End()
 
-		message := Message{
-			ID:        uuid.New(),
-			Type:      mType,
-			RoomID:    sess.RoomID,
-			SessionID: sess.UserID,
-			Body:      m,
-			Author:    sess.DisplayName,
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
+	now := time.Now().UTC()
+	message := Message{
+		ID:        newMessageID(now),
+		Type:      mType,
+		RoomID:    sess.RoomID,
+		SessionID: sess.UserID,
+		Body:      m,
+		Author:    sess.DisplayName(),
+		Timestamp: now.Format(time.RFC3339),
+	}
+
+	if mType == websocket.TextMessage && sess.Protocol == protocol.JSON {
+		var frame protocol.Frame
+		if err := json.Unmarshal(m, &frame); err != nil {
+			x.notify(sess, "invalid frame: "+err.Error())
+			return
 		}
+		switch frame.Type {
+		case protocol.FrameMessage:
+			if len(frame.IdempotencyKey) > protocol.MaxIdempotencyKeyLength {
+				x.notify(sess, "idempotency key too long")
+				return
+			}
+			message.Body = []byte(frame.Body)
+			message.Components = frame.Components
+			message.IdempotencyKey = frame.IdempotencyKey
+		case protocol.FrameInteraction:
+			x.interact(ctx, sess, frame.Interaction)
+			return
+		default:
+			x.notify(sess, "unknown frame type: "+frame.Type)
+			return
+		}
+	}
 
-		if err := x.eventRegistry.Publish(
-			event.New(MessageCreatedInRoomEvent, message),
-		); err != nil {
-			log.Error().Err(err).Msg("chat: publishing message")
+	if sess.ReadOnly {
+		x.notify(sess, "you may not post in this room")
+		return
+	}
+
+	if mType == websocket.TextMessage {
+		var handled bool
+		if handled, message.Body = x.commands.Intercept(sess, x, message.Body); handled {
+			return
 		}
 	}
+
+	muted, err := x.IsMuted(ctx, sess.UserID)
+	if err != nil {
+		span.RecordError(err)
+		log.Error().Ctx(ctx).Err(err).Msg("chat: checking mute")
+		x.notify(sess, "your message could not be sent, try again")
+		return
+	}
+	if muted {
+		x.notify(sess, "you are muted in this room")
+		return
+	}
+
+	if err := protocol.ValidComponents(message.Components); err != nil {
+		x.notify(sess, "invalid components: "+err.Error())
+		return
+	}
+
+	if err := event.Publish(ctx, x.eventRegistry, MessageCreatedInRoom, x.ID, message); err != nil {
+		span.RecordError(err)
+		log.Error().Ctx(ctx).Err(err).Msg("chat: publishing message")
+	}
+}
+
+// interact routes an interaction with a component to the owner
+// of the message the component is attached to.
+func (x *Room) interact(ctx context.Context, sess *UserSess, i *protocol.Interaction) {
+	if i == nil {
+		x.notify(sess, "interaction missing")
+		return
+	}
+	messageID, err := uuid.Parse(i.MessageID)
+	if err != nil {
+		x.notify(sess, "interaction message ID invalid")
+		return
+	}
+
+	x.mu.Lock()
+	msg, ok := x.interactive[messageID]
+	x.mu.Unlock()
+	if !ok {
+		x.notify(sess, "interaction expired")
+		return
+	}
+	if _, ok := msg.components[i.ComponentID]; !ok {
+		x.notify(sess, "interaction component ID invalid")
+		return
+	}
+
+	if err := event.Publish(ctx, x.eventRegistry, InteractionCreated, x.ID, Interaction{
+		RoomID:      x.ID,
+		MessageID:   messageID,
+		ComponentID: i.ComponentID,
+		Values:      i.Values,
+		UserID:      sess.UserID,
+		Author:      sess.DisplayName(),
+		OwnerID:     msg.ownerID,
+	}); err != nil {
+		log.Error().Ctx(ctx).Err(err).Msg("chat: publishing interaction")
+	}
+}
+
+// deliverInteraction writes an interaction to the local sessions
+// of the message owner.
+func (x *Room) deliverInteraction(ctx context.Context, i Interaction) {
+	for _, sess := range x.sessions() {
+		if sess.UserID != i.OwnerID {
+			continue
+		}
+		_, span := x.startWrite(ctx, sess)
+		err := sess.DeliverInteraction(i)
+		tracing.End(span, err)
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg("chat: writing interaction")
+		}
+	}
+}
+
+// remember keeps track of messages with components so that
+// interactions can be routed to their owner.
+func (x *Room) remember(m Message) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.interactive[m.ID]; ok {
+		return
+	}
+	if len(x.interactiveOrder) >= interactiveMessagesLimit {
+		delete(x.interactive, x.interactiveOrder[0])
+		x.interactiveOrder = x.interactiveOrder[1:]
+	}
+
+	components := make(map[string]empty, len(m.Components))
+	for _, c := range m.Components {
+		components[c.ID] = empty{}
+	}
+	x.interactive[m.ID] = interactiveMessage{
+		ownerID:    m.SessionID,
+		components: components,
+	}
+	x.interactiveOrder = append(x.interactiveOrder, m.ID)
+}
+
+// firstSeen records a message ID and reports whether
+// it is the first time the room sees it.
+func (x *Room) firstSeen(id uuid.UUID) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.recent[id]; ok {
+		return false
+	}
+	if len(x.recentOrder) >= recentMessagesLimit {
+		delete(x.recent, x.recentOrder[0])
+		x.recentOrder = x.recentOrder[1:]
+	}
+	x.recent[id] = empty{}
+	x.recentOrder = append(x.recentOrder, id)
+	return true
+}
+
+func (x *Room) notify(sess *UserSess, text string) {
+	if err := sess.Notify(text); err != nil {
+		log.Error().Err(err).Msg("chat: writing notice")
+	}
 }
 
-func (x *Room) broadcast(m Message) {
+func (x *Room) sessions() []*UserSess {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	sessions := make([]*UserSess, 0, len(x.Sessions))
 	for sess := range x.Sessions {
-		err := sess.Conn.WriteMessage(m.Type, m.Body)
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+func (x *Room) broadcast(ctx context.Context, m Message) {
+	if !x.firstSeen(m.ID) {
+		metrics.MessagesDeduplicated.Inc()
+		return
+	}
+	if len(m.Components) > 0 {
+		x.remember(m)
+	}
+
+	metrics.MessagesBroadcast.Inc()
+	for _, sess := range x.sessions() {
+		_, span := x.startWrite(ctx, sess)
+		err := sess.Deliver(m)
+		tracing.End(span, err)
 		if err != nil {
-			log.Error().Err(err).Msg("chat: writing message")
+			metrics.SessionWriteErrors.Inc()
+			log.Error().Ctx(ctx).Err(err).Msg("chat: writing message")
 		}
 	}
 }
+
+// startWrite starts the span of a write to a session.
+func (x *Room) startWrite(ctx context.Context, sess *UserSess) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "session.write",
+		trace.WithAttributes(
+			attribute.String("chat.room.id", x.ID),
+			attribute.String("chat.user.id", sess.UserID),
+		))
+}
+
+// Topic returns the room topic.
+func (x *Room) Topic() string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.topic
+}
+
+// SetTopic sets the room topic.
+func (x *Room) SetTopic(topic string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.topic = topic
+}
+
+// IsModerator reports whether the given user moderates the room.
+func (x *Room) IsModerator(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, roleTimeout)
+	defer cancel()
+
+	role, err := x.roles.ReadRoomRole(ctx, x.ID, userID)
+	if err != nil {
+		return false, fmt.Errorf("chat: reading room role, %w", err)
+	}
+	return role.Moderator, nil
+}
+
+// IsMuted reports whether the given user is muted in the room.
+func (x *Room) IsMuted(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, roleTimeout)
+	defer cancel()
+
+	role, err := x.roles.ReadRoomRole(ctx, x.ID, userID)
+	if err != nil {
+		return false, fmt.Errorf("chat: reading room role, %w", err)
+	}
+	return role.Muted, nil
+}
+
+// SetMuted mutes or unmutes the given user in the room on every node.
+func (x *Room) SetMuted(ctx context.Context, userID string, muted bool) error {
+	ctx, cancel := context.WithTimeout(ctx, roleTimeout)
+	defer cancel()
+
+	if err := x.roles.SetRoomMuted(ctx, x.ID, userID, muted); err != nil {
+		return fmt.Errorf("chat: setting mute, %w", err)
+	}
+	return nil
+}
+
+// FindSession returns the first session in the room whose user ID
+// or display name matches, or nil.
+func (x *Room) FindSession(nameOrID string) *UserSess {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for sess := range x.Sessions {
+		if sess.UserID == nameOrID || sess.DisplayName() == nameOrID {
+			return sess
+		}
+	}
+	return nil
+}
+
+// Kick sends a close frame with the given reason to the session
+// and closes its connection. The session leaves the room once its
+// reader notices the closed connection.
+func (x *Room) Kick(sess *UserSess, reason string) error {
+	return x.Disconnect(sess, websocket.ClosePolicyViolation, reason)
+}
+
+// Disconnect sends a close frame with the given code and reason to the
+// session and closes its connection.
+func (x *Room) Disconnect(sess *UserSess, code int, reason string) error {
+	err := sess.WriteClose(code, reason)
+	if closeErr := sess.Conn.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// Shutdown sends a going-away close frame with the given reason to every
+// session and stops the room. It returns the number of sessions it closed.
+func (x *Room) Shutdown(reason string) int {
+	sessions := x.sessions()
+	for _, sess := range sessions {
+		if err := x.Disconnect(sess, websocket.CloseGoingAway, reason); err != nil {
+			log.Error().Err(err).Msg("chat: closing session on shutdown")
+		}
+	}
+	x.doneOnce.Do(func() { close(x.done) })
+	return len(sessions)
+}
+
+// Info describes the room.
+func (x *Room) Info() RoomInfo {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return RoomInfo{
+		ID:       x.ID,
+		Topic:    x.topic,
+		Sessions: len(x.Sessions),
+	}
+}
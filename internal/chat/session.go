@@ -1,12 +1,183 @@
 package chat
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// writeWait is the maximum duration to wait for a write to a session.
+// It is a variable so that tests can shorten it.
+var writeWait = 10 * time.Second
+
 type UserSess struct {
-	UserID      string
-	RoomID      string
-	DisplayName string
+	// mu serializes writes, the websocket connection
+	// supports only one concurrent writer.
+	mu sync.Mutex
+
+	// nameMu guards displayName, which /nick changes while
+	// the session's messages are being received.
+	nameMu      sync.RWMutex
+	displayName string
+
+	UserID string
+	RoomID string
+	// Protocol is the wire protocol negotiated on connect.
+	// An empty protocol means raw message bodies.
+	Protocol string
+	// ReadOnly sessions may read and interact but not post.
+	ReadOnly bool
+	// Principal is the service account the session authenticated
+	// as with an API key, nil for users.
+	Principal   *auth.Principal
 	Conn        *websocket.Conn
+	ConnectedAt time.Time
+}
+
+// DisplayName returns the name shown for the session.
+func (x *UserSess) DisplayName() string {
+	x.nameMu.RLock()
+	defer x.nameMu.RUnlock()
+
+	return x.displayName
+}
+
+// SetDisplayName changes the name shown for the session.
+func (x *UserSess) SetDisplayName(name string) {
+	x.nameMu.Lock()
+	defer x.nameMu.Unlock()
+
+	x.displayName = name
+}
+
+// Write writes a message to the session's connection.
+// A session that does not take the message within writeWait, or whose
+// write fails, is dropped: its connection is closed and the session
+// leaves its room once its reader notices.
+// It is safe to call concurrently.
+func (x *UserSess) Write(messageType int, data []byte) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	err := x.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err == nil {
+		err = x.Conn.WriteMessage(messageType, data)
+	}
+	if err != nil {
+		if closeErr := x.Conn.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+		return fmt.Errorf("chat: writing to session, %w", err)
+	}
+	return nil
+}
+
+// WriteClose sends a close frame with the given code and text.
+// It is safe to call concurrently.
+func (x *UserSess) WriteClose(code int, text string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(writeWait),
+	)
+}
+
+// Deliver writes a room message to the session in its protocol.
+func (x *UserSess) Deliver(m Message) error {
+	if x.Protocol != protocol.JSON || m.Type != websocket.TextMessage {
+		return x.Write(m.Type, m.Body)
+	}
+
+	return x.writeFrame(protocol.Frame{
+		Type:       protocol.FrameMessage,
+		ID:         m.ID.String(),
+		RoomID:     m.RoomID,
+		UserID:     m.SessionID,
+		Author:     m.Author,
+		Body:       string(m.Body),
+		Timestamp:  m.Timestamp,
+		Components: m.Components,
+		Seq:        m.Seq,
+	})
+}
+
+// Notify writes a notice meant for this session only.
+func (x *UserSess) Notify(text string) error {
+	if x.Protocol != protocol.JSON {
+		return x.Write(websocket.TextMessage, []byte(text))
+	}
+
+	return x.writeFrame(protocol.Frame{
+		Type: protocol.FrameNotice,
+		Body: text,
+	})
+}
+
+// DeliverDuplicate tells the session that a message it posted with an
+// idempotency key was dropped, as the key is held by message id.
+// Only sessions with the JSON protocol can send keys.
+func (x *UserSess) DeliverDuplicate(roomID, key string, id uuid.UUID) error {
+	if x.Protocol != protocol.JSON {
+		return nil
+	}
+
+	return x.writeFrame(protocol.Frame{
+		Type:           protocol.FrameDuplicate,
+		ID:             id.String(),
+		RoomID:         roomID,
+		IdempotencyKey: key,
+	})
+}
+
+// DeliverInteraction writes an interaction to the session.
+// Sessions without the JSON protocol cannot own components
+// and are skipped.
+func (x *UserSess) DeliverInteraction(i Interaction) error {
+	if x.Protocol != protocol.JSON {
+		return nil
+	}
+
+	return x.writeFrame(protocol.Frame{
+		Type:   protocol.FrameInteraction,
+		RoomID: i.RoomID,
+		Interaction: &protocol.Interaction{
+			MessageID:   i.MessageID.String(),
+			ComponentID: i.ComponentID,
+			Values:      i.Values,
+			UserID:      i.UserID,
+			Author:      i.Author,
+		},
+	})
+}
+
+// Info describes the session.
+func (x *UserSess) Info() SessionInfo {
+	return SessionInfo{
+		UserID:      x.UserID,
+		RoomID:      x.RoomID,
+		DisplayName: x.DisplayName(),
+		Protocol:    x.Protocol,
+		ReadOnly:    x.ReadOnly,
+		RemoteAddr:  x.Conn.RemoteAddr().String(),
+		ConnectedAt: x.ConnectedAt,
+	}
+}
+
+func (x *UserSess) writeFrame(frame protocol.Frame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("chat: encoding frame, %w", err)
+	}
+
+	return x.Write(websocket.TextMessage, data)
 }
@@ -1,39 +1,60 @@
 package chat
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/internal/broker"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
 	"github.com/Salam4nder/chat/internal/event"
 	"github.com/gorilla/websocket"
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 )
 
 const SessionConnectedEvent = "session_connected"
 
+// resumeTimeout bounds sending a resuming session what it missed.
+const resumeTimeout = time.Minute
+
+// SessionConnected binds SessionConnectedEvent to its payload.
+var SessionConnected = event.NewType[SessionConnectedPayload](SessionConnectedEvent)
+
 var (
 	ErrUserIDInvalid   = errors.New("user id invalid")
 	ErrRoomIDInvalid   = errors.New("room id invalid")
+	ErrRoomNotFound    = errors.New("room not found")
+	ErrRoomClosed      = errors.New("room closed")
 	ErrUsernameInvalid = errors.New("username invalid")
 	ErrConnInvalid     = errors.New("conn invalid")
 )
 
-// SessionService handles session events and communicates with NATS.
+// SessionService handles session events and communicates with the broker.
 type SessionService struct {
-	natsClient *nats.Conn
-	registry   *event.Registry
+	broker   broker.Broker
+	registry *event.Registry
+	commands *CommandRegistry
+	roles    db.RoomRoleRepository
+	stream   *RoomStream
 }
 
 // NewSessionService creates a new SessionService.
-// It handles session events and communicates with NATS.
+// It handles session events and communicates with the broker.
 func NewSessionService(
-	natsClient *nats.Conn,
+	b broker.Broker,
 	registry *event.Registry,
+	commands *CommandRegistry,
+	roles db.RoomRoleRepository,
+	stream *RoomStream,
 ) *SessionService {
 	return &SessionService{
-		natsClient: natsClient,
-		registry:   registry,
+		broker:   b,
+		registry: registry,
+		commands: commands,
+		roles:    roles,
+		stream:   stream,
 	}
 }
 
@@ -43,7 +64,14 @@ type SessionConnectedPayload struct {
 	UserID   string
 	RoomID   string
 	Username string
-	Conn     *websocket.Conn
+	Protocol string
+	ReadOnly bool
+	// Principal is the service account of an API key, nil for users.
+	Principal *auth.Principal
+	Conn      *websocket.Conn
+	// Cursor is the Seq of the last message the client read before
+	// reconnecting, zero for a fresh session.
+	Cursor uint64
 }
 
 // Valid returns nil if the payload is valid.
@@ -67,15 +95,10 @@ func (x SessionConnectedPayload) Valid() error {
 }
 
 // HandleSessionConnectedEvent handles a new session connected event.
-func (x *SessionService) HandleSessionConnectedEvent(evt event.Event) error {
+func (x *SessionService) HandleSessionConnectedEvent(_ event.Event, payload SessionConnectedPayload) error {
 	log.Info().Msg("HandleNewSessionConnectedEvent ->")
 	defer log.Info().Msg("HandleNewSessionConnectedEvent <-")
 
-	payload, ok := evt.Payload.(SessionConnectedPayload)
-	if !ok {
-		return event.ErrInvalidEventType
-	}
-
 	if err := payload.Valid(); err != nil {
 		return fmt.Errorf(
 			"chat: %w, %w",
@@ -84,25 +107,56 @@ func (x *SessionService) HandleSessionConnectedEvent(evt event.Event) error {
 		)
 	}
 
-	room, exists := ChatRomoms[payload.RoomID]
-	if !exists {
-		var err error
-		room, err = NewRoom(&payload.RoomID, x.registry)
-		if err != nil {
-			return err
-		}
-		ChatRomoms[payload.RoomID] = room
-		go room.Run()
+	room, err := ChatRomoms.GetOrCreate(payload.RoomID, func() (*Room, error) {
+		return NewRoom(&payload.RoomID, x.registry, x.commands, x.roles)
+	})
+	if err != nil {
+		return err
 	}
 
 	session := &UserSess{
 		UserID:      payload.UserID,
 		RoomID:      payload.RoomID,
-		DisplayName: payload.Username,
+		displayName: payload.Username,
+		Protocol:    payload.Protocol,
+		ReadOnly:    payload.ReadOnly,
+		Principal:   payload.Principal,
 		Conn:        payload.Conn,
+		ConnectedAt: time.Now().UTC(),
+	}
+
+	select {
+	case room.Join <- session:
+	case <-room.done:
+		return ErrRoomClosed
 	}
 
-	room.Join <- session
+	if payload.Cursor > 0 && x.stream != nil {
+		go x.resume(session, payload.Cursor)
+	}
 
 	return nil
 }
+
+// resume sends a session the messages of its room stored after cursor.
+// The session has joined already, so they may interleave with live
+// messages; clients order them by Seq and drop duplicates by ID.
+func (x *SessionService) resume(sess *UserSess, cursor uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+	defer cancel()
+
+	n, err := x.stream.Resume(ctx, sess.RoomID, cursor, sess.Deliver)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("roomID", sess.RoomID).
+			Uint64("cursor", cursor).
+			Msg("chat: resuming session")
+		return
+	}
+	log.Info().
+		Int("messages", n).
+		Str("roomID", sess.RoomID).
+		Uint64("cursor", cursor).
+		Msg("chat: resumed session")
+}
@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SessionService_FullQueue(t *testing.T) {
+	// One worker with room for one event, so that membership events
+	// of the room and its session events fill the same queue.
+	registry := event.NewRegistry(event.Config{Workers: 1, QueueSize: 1})
+	t.Cleanup(func() { _ = registry.Close(context.Background()) })
+	service := NewSessionService(nil, registry, NewCommandRegistry(), newRoleRepo(), nil)
+	_, err := event.Subscribe(registry, SessionConnected, service.HandleSessionConnectedEvent)
+	require.NoError(t, err)
+	joined := make(chan Membership, 16)
+	_, err = event.Subscribe(registry, MemberJoined, func(_ event.Event, m Membership) error {
+		joined <- m
+		return nil
+	})
+	require.NoError(t, err)
+
+	roomID := gocql.TimeUUID().String()
+	payloads := make([]SessionConnectedPayload, cap(joined))
+	for i := range payloads {
+		sess, _ := testSession(t, roomID, gocql.TimeUUID().String())
+		payloads[i] = SessionConnectedPayload{
+			UserID:   sess.UserID,
+			RoomID:   roomID,
+			Username: sess.DisplayName(),
+			Conn:     sess.Conn,
+		}
+	}
+	t.Cleanup(func() {
+		if room, ok := ChatRomoms.Get(roomID); ok {
+			room.Shutdown("test done")
+		}
+	})
+
+	go func() {
+		for _, payload := range payloads {
+			if err := event.Publish(context.Background(), registry, SessionConnected, roomID, payload); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for range payloads {
+		select {
+		case m := <-joined:
+			require.Equal(t, roomID, m.RoomID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("room and event worker deadlocked")
+		}
+	}
+}
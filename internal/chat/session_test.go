@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UserSess_Write(t *testing.T) {
+	wait := writeWait
+	writeWait = 50 * time.Millisecond
+	t.Cleanup(func() { writeWait = wait })
+
+	t.Run("Slow reader", func(t *testing.T) {
+		// The client never reads, so writes block once
+		// the socket buffers are full.
+		sess, _ := testSession(t, "room", "ann")
+		data := bytes.Repeat([]byte("a"), 64*1024)
+
+		start := time.Now()
+		var err error
+		for err == nil {
+			err = sess.Write(websocket.BinaryMessage, data)
+			require.Less(t, time.Since(start), 5*time.Second, "write did not time out")
+		}
+		var netErr net.Error
+		require.ErrorAs(t, err, &netErr)
+		require.True(t, netErr.Timeout())
+
+		_, _, err = sess.Conn.ReadMessage()
+		require.ErrorIs(t, err, net.ErrClosed, "session not dropped")
+	})
+
+	t.Run("Closed connection", func(t *testing.T) {
+		sess, _ := testSession(t, "room", "ann")
+		require.NoError(t, sess.Conn.UnderlyingConn().Close())
+
+		require.Error(t, sess.Write(websocket.TextMessage, []byte("hi")))
+		_, _, err := sess.Conn.ReadMessage()
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+}
@@ -0,0 +1,308 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultStreamName is used when no stream name is configured.
+	DefaultStreamName = "CHAT_ROOMS"
+	// DefaultStreamMaxAge is used when no retention is configured.
+	DefaultStreamMaxAge = 24 * time.Hour
+	// DefaultStreamAckWait is used when no ack wait is configured.
+	DefaultStreamAckWait = 30 * time.Second
+	// DefaultStreamInactiveThreshold is used when no inactive
+	// threshold is configured.
+	DefaultStreamInactiveThreshold = time.Hour
+	// DefaultResumeLimit is used when no resume limit is configured.
+	DefaultResumeLimit = 1000
+	// resumeWait bounds the wait for the next message of a resume.
+	resumeWait = 5 * time.Second
+)
+
+var _ Publisher = (*RoomStream)(nil)
+
+// Publisher publishes messages. A broker.Broker publishes them
+// fire-and-forget; RoomStream stores them in a stream.
+type Publisher interface {
+	Publish(ctx context.Context, msg *broker.Msg) error
+}
+
+// StreamConfig configures a RoomStream.
+type StreamConfig struct {
+	// Name is the stream name.
+	Name string
+	// MaxAge and MaxBytes bound the retention of the stream.
+	// A zero MaxBytes is unbounded.
+	MaxAge   time.Duration
+	MaxBytes int64
+	Replicas int
+	// Consumer is the durable consumer of this node. It keeps the
+	// position of the node while it is disconnected.
+	Consumer string
+	// AckWait is how long a delivered message may go unacknowledged
+	// before it is delivered again.
+	AckWait time.Duration
+	// InactiveThreshold removes the consumers of nodes that are gone.
+	InactiveThreshold time.Duration
+	// ResumeLimit bounds the messages replayed to a resuming session.
+	ResumeLimit int
+}
+
+// RoomStream stores room messages in a JetStream stream. Nodes consume
+// it through a durable consumer each, and sessions resume from a stream
+// sequence after reconnecting.
+type RoomStream struct {
+	js  nats.JetStreamContext
+	cfg StreamConfig
+}
+
+// NewRoomStream creates or updates the stream and returns a RoomStream.
+// Zero values in cfg are replaced by defaults.
+func NewRoomStream(nc *nats.Conn, cfg StreamConfig) (*RoomStream, error) {
+	if cfg.Name == "" {
+		cfg.Name = DefaultStreamName
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultStreamMaxAge
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = -1
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 1
+	}
+	if cfg.AckWait <= 0 {
+		cfg.AckWait = DefaultStreamAckWait
+	}
+	if cfg.InactiveThreshold <= 0 {
+		cfg.InactiveThreshold = DefaultStreamInactiveThreshold
+	}
+	if cfg.ResumeLimit <= 0 {
+		cfg.ResumeLimit = DefaultResumeLimit
+	}
+	if cfg.Consumer == "" {
+		return nil, errors.New("chat: stream consumer name is empty")
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("chat: creating jetstream context, %w", err)
+	}
+
+	streamCfg := &nats.StreamConfig{
+		Name:      cfg.Name,
+		Subjects:  []string{RoomSubject("*", SubjectMessage)},
+		Retention: nats.LimitsPolicy,
+		MaxAge:    cfg.MaxAge,
+		MaxBytes:  cfg.MaxBytes,
+		Replicas:  cfg.Replicas,
+		Discard:   nats.DiscardOld,
+	}
+	if _, err := js.StreamInfo(cfg.Name); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(streamCfg)
+		if err != nil {
+			return nil, fmt.Errorf("chat: adding stream, %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("chat: reading stream, %w", err)
+	} else if _, err := js.UpdateStream(streamCfg); err != nil {
+		return nil, fmt.Errorf("chat: updating stream, %w", err)
+	}
+
+	return &RoomStream{js: js, cfg: cfg}, nil
+}
+
+// Publish stores msg in the stream. It returns once the
+// stream acknowledged it.
+func (x *RoomStream) Publish(ctx context.Context, msg *broker.Msg) error {
+	_, err := x.js.PublishMsg(broker.ToNATS(msg), nats.Context(ctx))
+	return err
+}
+
+// Subscribe calls h with the messages of the stream through the durable
+// consumer of this node. A new consumer starts with new messages; an
+// existing one resumes where it stopped. Messages carry their stream
+// sequence and must be acknowledged once handled.
+//
+// Unsubscribing keeps the consumer, so that a restarted node with the
+// same consumer name picks up what it missed.
+func (x *RoomStream) Subscribe(h broker.Handler) (broker.Subscription, error) {
+	_, err := x.js.ConsumerInfo(x.cfg.Name, x.cfg.Consumer)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		// Created here rather than by the subscription, which
+		// would delete it on unsubscribe.
+		_, err = x.js.AddConsumer(x.cfg.Name, &nats.ConsumerConfig{
+			Durable:           x.cfg.Consumer,
+			DeliverSubject:    nats.NewInbox(),
+			FilterSubject:     RoomSubject("*", SubjectMessage),
+			DeliverPolicy:     nats.DeliverNewPolicy,
+			AckPolicy:         nats.AckExplicitPolicy,
+			AckWait:           x.cfg.AckWait,
+			InactiveThreshold: x.cfg.InactiveThreshold,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chat: preparing consumer %s, %w", x.cfg.Consumer, err)
+	}
+
+	sub, err := x.js.Subscribe(
+		RoomSubject("*", SubjectMessage),
+		func(msg *nats.Msg) { h(broker.FromNATS(msg)) },
+		nats.Bind(x.cfg.Name, x.cfg.Consumer),
+		nats.ManualAck(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("chat: subscribing to stream, %w", err)
+	}
+	return sub, nil
+}
+
+// Resume calls fn for the messages of a room stored after the cursor,
+// a stream sequence, up to the last message at the time of the call.
+// It stops after ResumeLimit messages and returns how many it passed on.
+func (x *RoomStream) Resume(
+	ctx context.Context,
+	roomID string,
+	cursor uint64,
+	fn func(Message) error,
+) (int, error) {
+	subject := RoomSubject(roomID, SubjectMessage)
+	lastMsg, err := x.js.GetLastMsg(x.cfg.Name, subject, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("chat: reading last message, %w", err)
+	}
+	last := lastMsg.Sequence
+	if cursor >= last {
+		return 0, nil
+	}
+
+	sub, err := x.js.SubscribeSync(
+		subject,
+		nats.BindStream(x.cfg.Name),
+		nats.OrderedConsumer(),
+		nats.StartSequence(cursor+1),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("chat: subscribing to stream, %w", err)
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Warn().Err(err).Msg("chat: unsubscribing resume consumer")
+		}
+	}()
+
+	var n int
+	for n < x.cfg.ResumeLimit {
+		waitCtx, cancel := context.WithTimeout(ctx, resumeWait)
+		msg, err := sub.NextMsgWithContext(waitCtx)
+		cancel()
+		if err != nil {
+			return n, fmt.Errorf("chat: reading stream, %w", err)
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return n, fmt.Errorf("chat: reading message metadata, %w", err)
+		}
+
+		env, err := wire.Unmarshal(msg.Data)
+		if err != nil {
+			return n, fmt.Errorf("chat: decoding message %d, %w", meta.Sequence.Stream, err)
+		}
+		message, err := decodeMessage(env)
+		if err != nil {
+			return n, fmt.Errorf("chat: decoding message %d, %w", meta.Sequence.Stream, err)
+		}
+		message.Seq = meta.Sequence.Stream
+		if err := fn(message); err != nil {
+			return n, err
+		}
+		n++
+		if meta.Sequence.Stream >= last {
+			break
+		}
+	}
+	return n, nil
+}
+
+// ScanMessages calls fn with every message in the stream and its stream
+// sequence, oldest first, up to the last message at the time of the call.
+func (x *RoomStream) ScanMessages(ctx context.Context, fn func(seq uint64, m Message) error) error {
+	info, err := x.js.StreamInfo(x.cfg.Name, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("chat: reading stream, %w", err)
+	}
+	if info.State.Msgs == 0 {
+		return nil
+	}
+	last := info.State.LastSeq
+
+	sub, err := x.js.SubscribeSync(
+		RoomSubject("*", SubjectMessage),
+		nats.BindStream(x.cfg.Name),
+		nats.OrderedConsumer(),
+		nats.DeliverAll(),
+	)
+	if err != nil {
+		return fmt.Errorf("chat: subscribing to stream, %w", err)
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Warn().Err(err).Msg("chat: unsubscribing scan consumer")
+		}
+	}()
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, resumeWait)
+		msg, err := sub.NextMsgWithContext(waitCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("chat: reading stream, %w", err)
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return fmt.Errorf("chat: reading message metadata, %w", err)
+		}
+
+		env, err := wire.Unmarshal(msg.Data)
+		if err != nil {
+			return fmt.Errorf("chat: decoding message %d, %w", meta.Sequence.Stream, err)
+		}
+		message, err := decodeMessage(env)
+		if err != nil {
+			return fmt.Errorf("chat: decoding message %d, %w", meta.Sequence.Stream, err)
+		}
+		// Messages published during the scan are not scanned.
+		if meta.Sequence.Stream > last {
+			return nil
+		}
+		if err := fn(meta.Sequence.Stream, message); err != nil {
+			return err
+		}
+		// The last message may have been deleted, in which case
+		// the pending count tells when the scan is done.
+		if meta.Sequence.Stream >= last || meta.NumPending == 0 {
+			return nil
+		}
+	}
+}
+
+// EraseMessage deletes a message from the stream and
+// overwrites its data in storage.
+func (x *RoomStream) EraseMessage(ctx context.Context, seq uint64) error {
+	if err := x.js.SecureDeleteMsg(x.cfg.Name, seq, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("chat: erasing stream message %d, %w", seq, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func runJetStream(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	srv, err := broker.StartEmbedded(broker.EmbeddedConfig{
+		Host:     "127.0.0.1",
+		Port:     broker.RandomPort,
+		StoreDir: t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func publishMessage(t *testing.T, p Publisher, roomID, body string) {
+	t.Helper()
+
+	m := Message{
+		ID:     uuid.New(),
+		RoomID: roomID,
+		Body:   []byte(body),
+	}
+	codec := Wire{Format: wire.Protobuf, NodeID: "node-a"}
+	data, err := codec.encodeMessage(context.Background(), event.New(MessageCreatedInRoomEvent, m), m)
+	require.NoError(t, err)
+	require.NoError(t, publish(context.Background(), p, RoomSubject(roomID, SubjectMessage), data))
+}
+
+func receive(t *testing.T, ch chan *broker.Msg) uint64 {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		require.NoError(t, msg.Ack())
+		return msg.Seq
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+		return 0
+	}
+}
+
+func Test_RoomStream(t *testing.T) {
+	nc := runJetStream(t)
+	stream, err := NewRoomStream(nc, StreamConfig{Consumer: "node-a"})
+	require.NoError(t, err)
+
+	ch := make(chan *broker.Msg, 16)
+	handler := func(msg *broker.Msg) { ch <- msg }
+	sub, err := stream.Subscribe(handler)
+	require.NoError(t, err)
+
+	roomID := uuid.NewString()
+	publishMessage(t, stream, roomID, "one")
+	publishMessage(t, stream, uuid.NewString(), "other room")
+	publishMessage(t, stream, roomID, "two")
+	first := receive(t, ch)
+	for i := 0; i < 2; i++ {
+		receive(t, ch)
+	}
+
+	t.Run("Durable consumer", func(t *testing.T) {
+		require.NoError(t, sub.Unsubscribe())
+		publishMessage(t, stream, roomID, "missed")
+
+		sub, err := stream.Subscribe(handler)
+		require.NoError(t, err)
+		require.Equal(t, first+3, receive(t, ch))
+		require.NoError(t, sub.Unsubscribe())
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		var bodies []string
+		var seqs []uint64
+		n, err := stream.Resume(context.Background(), roomID, first, func(m Message) error {
+			bodies = append(bodies, string(m.Body))
+			seqs = append(seqs, m.Seq)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		require.Equal(t, []string{"two", "missed"}, bodies)
+		require.Equal(t, []uint64{first + 2, first + 3}, seqs)
+	})
+
+	t.Run("Resume up to date", func(t *testing.T) {
+		n, err := stream.Resume(context.Background(), roomID, first+3, func(Message) error {
+			return nil
+		})
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		ctx := context.Background()
+		require.NoError(t, stream.EraseMessage(ctx, first+3))
+
+		var bodies []string
+		start := time.Now()
+		require.NoError(t, stream.ScanMessages(ctx, func(_ uint64, m Message) error {
+			if len(bodies) == 0 {
+				publishMessage(t, stream, roomID, "during scan")
+			}
+			bodies = append(bodies, string(m.Body))
+			return nil
+		}))
+		require.Equal(t, []string{"one", "other room", "two"}, bodies)
+		require.Less(t, time.Since(start), resumeWait)
+	})
+}
+
+func Test_RoomStream_Restart(t *testing.T) {
+	storeDir := t.TempDir()
+	// start starts the server of the cluster and returns its URL
+	// and a function that shuts it down.
+	start := func() (string, func()) {
+		srv, err := broker.StartEmbedded(broker.EmbeddedConfig{
+			Host:     "127.0.0.1",
+			Port:     broker.RandomPort,
+			StoreDir: storeDir,
+		})
+		require.NoError(t, err)
+		return srv.ClientURL(), func() {
+			srv.Shutdown()
+			srv.WaitForShutdown()
+		}
+	}
+	connect := func(url, consumer string) (*nats.Conn, *RoomStream) {
+		nc, err := nats.Connect(url)
+		require.NoError(t, err)
+		stream, err := NewRoomStream(nc, StreamConfig{Consumer: consumer})
+		require.NoError(t, err)
+		return nc, stream
+	}
+
+	url, stop := start()
+	nodeA, streamA := connect(url, "node-a")
+	ch := make(chan *broker.Msg, 16)
+	_, err := streamA.Subscribe(func(msg *broker.Msg) { ch <- msg })
+	require.NoError(t, err)
+
+	roomID := uuid.NewString()
+	publishMessage(t, streamA, roomID, "seen")
+	seen := receive(t, ch)
+
+	// node-a goes down without unsubscribing, as when its process is
+	// killed, and node-b publishes in the meantime. Then the server
+	// restarts as well.
+	nodeA.Close()
+	nodeB, streamB := connect(url, "node-b")
+	publishMessage(t, streamB, roomID, "missed one")
+	publishMessage(t, streamB, roomID, "missed two")
+	nodeB.Close()
+	stop()
+
+	url, stop = start()
+	t.Cleanup(stop)
+	nodeA, streamA = connect(url, "node-a")
+	t.Cleanup(nodeA.Close)
+	ch = make(chan *broker.Msg, 16)
+	_, err = streamA.Subscribe(func(msg *broker.Msg) { ch <- msg })
+	require.NoError(t, err)
+	require.Equal(t, seen+1, receive(t, ch))
+	require.Equal(t, seen+2, receive(t, ch))
+}
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Salam4nder/chat/internal/broker"
+)
+
+// Kinds of room subjects.
+const (
+	// SubjectMessage carries the messages of a room.
+	SubjectMessage = "msg"
+	// SubjectInteraction carries the interactions of a room.
+	SubjectInteraction = "interaction"
+)
+
+const roomSubjectPrefix = "chat.room."
+
+// RoomSubject returns the broker subject of the given kind for a room,
+// chat.room.<roomID>.<kind>. Pass "*" as the room ID to match every room.
+func RoomSubject(roomID, kind string) string {
+	return roomSubjectPrefix + roomID + "." + kind
+}
+
+// parseRoomSubject returns the room ID and kind of a room subject.
+func parseRoomSubject(subject string) (roomID, kind string, ok bool) {
+	rest, ok := strings.CutPrefix(subject, roomSubjectPrefix)
+	if !ok {
+		return "", "", false
+	}
+	roomID, kind, ok = strings.Cut(rest, ".")
+	return roomID, kind, ok && roomID != "" && kind != ""
+}
+
+// subjectLabel returns a label of bounded cardinality for a subject,
+// leaving out the room ID.
+func subjectLabel(subject string) string {
+	if _, kind, ok := parseRoomSubject(subject); ok {
+		return RoomSubject("*", kind)
+	}
+	return subject
+}
+
+// Subscriber subscribes the node to the subjects of a room and returns
+// a function that unsubscribes it. Rooms subscribe when their first
+// session joins and unsubscribe once their last session left.
+type Subscriber func(roomID string) (unsubscribe func() error, err error)
+
+// ChanSubscriber returns a Subscriber that delivers the subjects of
+// the given kinds to ch through b, for Rooms.Run to read.
+func ChanSubscriber(b broker.Broker, ch chan *broker.Msg, kinds ...string) Subscriber {
+	return func(roomID string) (func() error, error) {
+		subs := make([]broker.Subscription, 0, len(kinds))
+		unsubscribe := func() error {
+			var errs []error
+			for _, sub := range subs {
+				errs = append(errs, sub.Unsubscribe())
+			}
+			return errors.Join(errs...)
+		}
+
+		for _, kind := range kinds {
+			sub, err := b.Subscribe(RoomSubject(roomID, kind), func(msg *broker.Msg) {
+				ch <- msg
+			})
+			if err != nil {
+				_ = unsubscribe()
+				return nil, fmt.Errorf("chat: subscribing to room %s, %w", roomID, err)
+			}
+			subs = append(subs, sub)
+		}
+		return unsubscribe, nil
+	}
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRoomSubject(t *testing.T) {
+	roomID, kind, ok := parseRoomSubject(RoomSubject("room-1", SubjectMessage))
+	require.True(t, ok)
+	require.Equal(t, "room-1", roomID)
+	require.Equal(t, SubjectMessage, kind)
+
+	for _, subject := range []string{"MessageCreatedInRoom", "chat.room.", "chat.room.room-1", "chat.room..msg"} {
+		_, _, ok := parseRoomSubject(subject)
+		require.False(t, ok, subject)
+	}
+
+	require.Equal(t, "chat.room.*.msg", subjectLabel(RoomSubject("room-1", SubjectMessage)))
+}
+
+func Test_ChanSubscriber(t *testing.T) {
+	b := broker.NewMemory()
+	ch := make(chan *broker.Msg, 16)
+	subscribe := ChanSubscriber(b, ch, SubjectMessage, SubjectInteraction)
+	publish := func(roomID, kind string) {
+		require.NoError(t, b.Publish(context.Background(), broker.NewMsg(RoomSubject(roomID, kind))))
+	}
+
+	unsubscribe, err := subscribe("room-1")
+	require.NoError(t, err)
+
+	publish("room-2", SubjectMessage)
+	publish("room-1", SubjectMessage)
+	publish("room-1", SubjectInteraction)
+
+	// Each kind has its own subscription, so they may arrive in any order.
+	var subjects []string
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-ch:
+			subjects = append(subjects, msg.Subject)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message delivered")
+		}
+	}
+	require.ElementsMatch(t, []string{
+		RoomSubject("room-1", SubjectMessage),
+		RoomSubject("room-1", SubjectInteraction),
+	}, subjects)
+
+	require.NoError(t, unsubscribe())
+	publish("room-1", SubjectMessage)
+	select {
+	case msg := <-ch:
+		t.Fatalf("delivered %s after unsubscribing", msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,353 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// webhookTokenSize is the size of a webhook secret token in bytes.
+	webhookTokenSize = 32
+	// webhookMaxTextLength is the maximum length of a webhook text in runes.
+	webhookMaxTextLength = 4000
+	// webhookMaxUsernameLength is the maximum length of a username override in runes.
+	webhookMaxUsernameLength = 80
+	// webhookMaxAttachments is the maximum number of attachments per payload.
+	webhookMaxAttachments = 10
+	// textMessage mirrors websocket.TextMessage.
+	textMessage = 1
+	// webhookLimiterSweep is how often idle limiters and expired
+	// cached webhooks are dropped.
+	webhookLimiterSweep = time.Minute
+	// webhookCacheTTL is how long a webhook read from the database,
+	// or its absence, is trusted. A webhook deleted on another node
+	// keeps working here for at most this long.
+	webhookCacheTTL = time.Minute
+)
+
+var (
+	ErrWebhookNameInvalid        = errors.New("webhook name invalid")
+	ErrWebhookUnauthorized       = errors.New("webhook token invalid")
+	ErrWebhookRateLimited        = errors.New("webhook rate limited")
+	ErrWebhookPayloadEmpty       = errors.New("webhook payload has neither text nor attachments")
+	ErrWebhookTextInvalid        = errors.New("webhook text invalid")
+	ErrWebhookUsernameInvalid    = errors.New("webhook username invalid")
+	ErrWebhookAttachmentsInvalid = errors.New("webhook attachments invalid")
+)
+
+// WebhookPayload is the JSON payload accepted by an incoming webhook.
+type WebhookPayload struct {
+	Text        string              `json:"text"`
+	Username    string              `json:"username,omitempty"`
+	Attachments []WebhookAttachment `json:"attachments,omitempty"`
+}
+
+// WebhookAttachment is a link attached to a webhook payload.
+type WebhookAttachment struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url"`
+	Text  string `json:"text,omitempty"`
+}
+
+// Valid returns nil if the payload is valid.
+func (x WebhookPayload) Valid() error {
+	if strings.TrimSpace(x.Text) == "" && len(x.Attachments) == 0 {
+		return ErrWebhookPayloadEmpty
+	}
+
+	var textErr, usernameErr, attachmentsErr error
+
+	if utf8.RuneCountInString(x.Text) > webhookMaxTextLength {
+		textErr = ErrWebhookTextInvalid
+	}
+	if utf8.RuneCountInString(x.Username) > webhookMaxUsernameLength {
+		usernameErr = ErrWebhookUsernameInvalid
+	}
+	if len(x.Attachments) > webhookMaxAttachments {
+		attachmentsErr = ErrWebhookAttachmentsInvalid
+	}
+	for _, attachment := range x.Attachments {
+		u, err := url.Parse(attachment.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			attachmentsErr = ErrWebhookAttachmentsInvalid
+			break
+		}
+	}
+
+	return errors.Join(textErr, usernameErr, attachmentsErr)
+}
+
+// body renders the payload as a plain text message body.
+func (x WebhookPayload) body() []byte {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(x.Text))
+	for _, attachment := range x.Attachments {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		if attachment.Title != "" {
+			b.WriteString(attachment.Title)
+			b.WriteString(": ")
+		}
+		b.WriteString(attachment.URL)
+		if attachment.Text != "" {
+			b.WriteString("\n")
+			b.WriteString(attachment.Text)
+		}
+	}
+	return []byte(b.String())
+}
+
+// Webhook is an incoming webhook bound to a room.
+type Webhook struct {
+	ID     uuid.UUID
+	RoomID string
+	Name   string
+}
+
+// WebhookService manages incoming webhooks and turns their
+// payloads into room messages.
+type WebhookService struct {
+	repo     db.WebhookRepository
+	registry *event.Registry
+
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[uuid.UUID]*rate.Limiter
+	webhooks map[uuid.UUID]cachedWebhook
+	swept    time.Time
+}
+
+// cachedWebhook is a webhook read from the database, or the fact
+// that it does not exist, kept until expiresAt.
+type cachedWebhook struct {
+	webhook   db.Webhook
+	found     bool
+	expiresAt time.Time
+}
+
+// NewWebhookService returns a new instance of WebhookService.
+// Every webhook may post ratePerSecond messages with the given burst.
+func NewWebhookService(
+	repo db.WebhookRepository,
+	registry *event.Registry,
+	ratePerSecond float64,
+	burst int,
+) *WebhookService {
+	return &WebhookService{
+		repo:     repo,
+		registry: registry,
+		limit:    rate.Limit(ratePerSecond),
+		burst:    burst,
+		limiters: make(map[uuid.UUID]*rate.Limiter),
+		webhooks: make(map[uuid.UUID]cachedWebhook),
+	}
+}
+
+// CreateWebhook creates a new webhook for the given room.
+// The returned secret token is only available at creation time.
+func (x *WebhookService) CreateWebhook(
+	ctx context.Context,
+	roomID string,
+	name string,
+) (Webhook, string, error) {
+	if _, err := uuid.Parse(roomID); err != nil {
+		return Webhook{}, "", ErrRoomIDInvalid
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > webhookMaxUsernameLength {
+		return Webhook{}, "", ErrWebhookNameInvalid
+	}
+
+	raw := make([]byte, webhookTokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		return Webhook{}, "", fmt.Errorf("webhook service: generating token, %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	hash := sha256.Sum256([]byte(token))
+
+	id := gocql.TimeUUID()
+	if err := x.repo.CreateWebhook(ctx, db.Webhook{
+		ID:        id,
+		RoomID:    roomID,
+		Name:      name,
+		TokenHash: hash[:],
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return Webhook{}, "", fmt.Errorf("webhook service: creating webhook, %w", err)
+	}
+
+	return Webhook{ID: uuid.UUID(id), RoomID: roomID, Name: name}, token, nil
+}
+
+// DeleteWebhook deletes the webhook with the given ID
+// if the token matches.
+func (x *WebhookService) DeleteWebhook(
+	ctx context.Context,
+	id uuid.UUID,
+	token string,
+) error {
+	if _, err := x.authorize(ctx, id, token); err != nil {
+		return err
+	}
+
+	if err := x.repo.DeleteWebhook(ctx, gocql.UUID(id)); err != nil {
+		return fmt.Errorf("webhook service: deleting webhook, %w", err)
+	}
+
+	x.mu.Lock()
+	delete(x.limiters, id)
+	delete(x.webhooks, id)
+	x.mu.Unlock()
+
+	return nil
+}
+
+// Execute validates the token and payload of an incoming webhook call
+// and publishes the payload as a MessageCreatedInRoomEvent.
+func (x *WebhookService) Execute(
+	ctx context.Context,
+	id uuid.UUID,
+	token string,
+	payload WebhookPayload,
+) error {
+	// The token is checked first, so that calls without it cannot use
+	// up the limit of the webhook. The token hash is cached, so that
+	// a flood of calls does not cost a database read each.
+	webhook, err := x.authorize(ctx, id, token)
+	if err != nil {
+		return err
+	}
+
+	if !x.limiter(id).Allow() {
+		return ErrWebhookRateLimited
+	}
+
+	if err := payload.Valid(); err != nil {
+		return err
+	}
+
+	author := webhook.Name
+	if username := strings.TrimSpace(payload.Username); username != "" {
+		author = username
+	}
+
+	now := time.Now().UTC()
+	if err := event.PublishSync(ctx, x.registry, MessageCreatedInRoom, Message{
+		ID:        newMessageID(now),
+		Type:      textMessage,
+		RoomID:    webhook.RoomID,
+		SessionID: "webhook:" + id.String(),
+		Body:      payload.body(),
+		Author:    author,
+		Timestamp: now.Format(time.RFC3339),
+	}); err != nil {
+		return fmt.Errorf("webhook service: publishing message, %w", err)
+	}
+
+	return nil
+}
+
+// authorize reads the webhook and checks the token against its hash.
+func (x *WebhookService) authorize(
+	ctx context.Context,
+	id uuid.UUID,
+	token string,
+) (db.Webhook, error) {
+	webhook, found, err := x.webhook(ctx, id)
+	if err != nil {
+		return db.Webhook{}, err
+	}
+	if !found {
+		return db.Webhook{}, ErrWebhookUnauthorized
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], webhook.TokenHash) != 1 {
+		return db.Webhook{}, ErrWebhookUnauthorized
+	}
+
+	return webhook, nil
+}
+
+// webhook returns the webhook with the given ID and whether it exists.
+// The database is only read when the cached entry is missing or expired.
+func (x *WebhookService) webhook(ctx context.Context, id uuid.UUID) (db.Webhook, bool, error) {
+	now := time.Now()
+
+	x.mu.Lock()
+	cached, ok := x.webhooks[id]
+	x.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.webhook, cached.found, nil
+	}
+
+	webhook, err := x.repo.ReadWebhook(ctx, gocql.UUID(id))
+	found := true
+	if err != nil {
+		if !errors.Is(err, db.ErrWebhookNotFound) {
+			return db.Webhook{}, false, fmt.Errorf("webhook service: reading webhook, %w", err)
+		}
+		found = false
+	}
+
+	x.mu.Lock()
+	x.sweep(now)
+	x.webhooks[id] = cachedWebhook{webhook: webhook, found: found, expiresAt: now.Add(webhookCacheTTL)}
+	x.mu.Unlock()
+
+	return webhook, found, nil
+}
+
+// limiter returns the limiter of a webhook.
+func (x *WebhookService) limiter(id uuid.UUID) *rate.Limiter {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.sweep(time.Now())
+
+	limiter, ok := x.limiters[id]
+	if !ok {
+		limiter = rate.NewLimiter(x.limit, x.burst)
+		x.limiters[id] = limiter
+	}
+
+	return limiter
+}
+
+// sweep drops expired cached webhooks and limiters whose bucket refilled,
+// since those behave like new ones, once in a while to keep the maps small.
+// The caller must hold x.mu.
+func (x *WebhookService) sweep(now time.Time) {
+	if now.Sub(x.swept) < webhookLimiterSweep {
+		return
+	}
+	for key, limiter := range x.limiters {
+		if limiter.TokensAt(now) >= float64(x.burst) {
+			delete(x.limiters, key)
+		}
+	}
+	for key, cached := range x.webhooks {
+		if !now.Before(cached.expiresAt) {
+			delete(x.webhooks, key)
+		}
+	}
+	x.swept = now
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRepo is a WebhookRepository holding webhooks in memory
+// and counting reads.
+type webhookRepo struct {
+	webhooks map[gocql.UUID]db.Webhook
+	reads    atomic.Int32
+}
+
+func (x *webhookRepo) CreateWebhook(_ context.Context, params db.Webhook) error {
+	x.webhooks[params.ID] = params
+	return nil
+}
+
+func (x *webhookRepo) ReadWebhook(_ context.Context, id gocql.UUID) (db.Webhook, error) {
+	x.reads.Add(1)
+	webhook, ok := x.webhooks[id]
+	if !ok {
+		return db.Webhook{}, db.ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+func (x *webhookRepo) DeleteWebhook(_ context.Context, id gocql.UUID) error {
+	delete(x.webhooks, id)
+	return nil
+}
+
+// newWebhookService returns a WebhookService backed by an in-memory repo
+// holding one webhook with the token "token", and a channel receiving
+// the messages it publishes.
+func newWebhookService(
+	t *testing.T,
+	ratePerSecond float64,
+	burst int,
+) (*WebhookService, *webhookRepo, db.Webhook, <-chan Message) {
+	t.Helper()
+	ctx := context.Background()
+	repo := &webhookRepo{webhooks: make(map[gocql.UUID]db.Webhook)}
+	registry := event.NewRegistry(event.Config{})
+	t.Cleanup(func() { registry.Close(ctx) })
+
+	messages := make(chan Message, 16)
+	_, err := event.Subscribe(registry, MessageCreatedInRoom, func(_ event.Event, m Message) error {
+		messages <- m
+		return nil
+	})
+	require.NoError(t, err)
+
+	id := gocql.TimeUUID()
+	hash := sha256.Sum256([]byte("token"))
+	webhook := db.Webhook{ID: id, RoomID: uuid.NewString(), Name: "ci", TokenHash: hash[:]}
+	repo.webhooks[id] = webhook
+
+	return NewWebhookService(repo, registry, ratePerSecond, burst), repo, webhook, messages
+}
+
+func Test_WebhookService_Execute(t *testing.T) {
+	ctx := context.Background()
+	service, repo, webhook, messages := newWebhookService(t, 1000, 10)
+	id := uuid.UUID(webhook.ID)
+
+	require.NoError(t, service.Execute(ctx, id, "token", WebhookPayload{
+		Text:        " deployed ",
+		Attachments: []WebhookAttachment{{Title: "logs", URL: "https://ci.example.com/1"}},
+	}))
+	msg := <-messages
+	require.Equal(t, webhook.RoomID, msg.RoomID)
+	require.Equal(t, "ci", msg.Author)
+	require.Equal(t, "deployed\nlogs: https://ci.example.com/1", string(msg.Body))
+	require.Equal(t, "webhook:"+id.String(), msg.SessionID)
+
+	t.Run("Username override", func(t *testing.T) {
+		require.NoError(t, service.Execute(ctx, id, "token", WebhookPayload{Text: "hi", Username: " jenkins "}))
+		msg := <-messages
+		require.Equal(t, "jenkins", msg.Author)
+		require.Equal(t, webhook.RoomID, msg.RoomID)
+		require.Equal(t, int32(1), repo.reads.Load(), "known webhook read twice")
+	})
+
+	t.Run("Wrong token", func(t *testing.T) {
+		require.ErrorIs(t, service.Execute(ctx, id, "wrong", WebhookPayload{Text: "hi"}), ErrWebhookUnauthorized)
+		require.ErrorIs(t, service.Execute(ctx, id, "", WebhookPayload{Text: "hi"}), ErrWebhookUnauthorized)
+		require.Empty(t, messages)
+	})
+
+	t.Run("Invalid payload", func(t *testing.T) {
+		require.ErrorIs(t, service.Execute(ctx, id, "token", WebhookPayload{}), ErrWebhookPayloadEmpty)
+		require.ErrorIs(t, service.Execute(ctx, id, "token", WebhookPayload{
+			Text:        "hi",
+			Attachments: []WebhookAttachment{{URL: "javascript:alert(1)"}},
+		}), ErrWebhookAttachmentsInvalid)
+		require.Empty(t, messages)
+	})
+
+	t.Run("Unknown webhook", func(t *testing.T) {
+		unknown := uuid.New()
+		reads := repo.reads.Load()
+		require.ErrorIs(t, service.Execute(ctx, unknown, "token", WebhookPayload{Text: "hi"}), ErrWebhookUnauthorized)
+		require.ErrorIs(t, service.Execute(ctx, unknown, "token", WebhookPayload{Text: "hi"}), ErrWebhookUnauthorized)
+		require.Equal(t, reads+1, repo.reads.Load(), "unknown webhook read twice")
+		service.mu.Lock()
+		_, ok := service.limiters[unknown]
+		service.mu.Unlock()
+		require.False(t, ok)
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		require.ErrorIs(t, service.DeleteWebhook(ctx, id, "wrong"), ErrWebhookUnauthorized)
+		require.NoError(t, service.DeleteWebhook(ctx, id, "token"))
+		require.ErrorIs(t, service.Execute(ctx, id, "token", WebhookPayload{Text: "hi"}), ErrWebhookUnauthorized)
+	})
+}
+
+func Test_WebhookService_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	service, repo, webhook, _ := newWebhookService(t, 0.001, 1)
+	id := uuid.UUID(webhook.ID)
+	payload := WebhookPayload{Text: "deployed"}
+
+	require.ErrorIs(t, service.Execute(ctx, id, "wrong", payload), ErrWebhookUnauthorized)
+	require.ErrorIs(t, service.Execute(ctx, id, "", payload), ErrWebhookUnauthorized)
+	require.NoError(t, service.Execute(ctx, id, "token", payload), "calls without the token used up the limit")
+	require.ErrorIs(t, service.Execute(ctx, id, "token", payload), ErrWebhookRateLimited)
+	require.ErrorIs(t, service.Execute(ctx, id, "wrong", payload), ErrWebhookUnauthorized)
+	require.Equal(t, int32(1), repo.reads.Load(), "calls read the webhook")
+
+	t.Run("Sweep", func(t *testing.T) {
+		service := NewWebhookService(repo, event.NewRegistry(event.Config{}), 1000, 1)
+		service.limiter(uuid.New()).Allow()
+		service.webhooks[uuid.New()] = cachedWebhook{expiresAt: time.Now()}
+		time.Sleep(5 * time.Millisecond)
+		service.swept = time.Time{}
+		service.limiter(uuid.New())
+		require.Len(t, service.limiters, 1)
+		require.Empty(t, service.webhooks)
+	})
+}
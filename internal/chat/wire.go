@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/tracing"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/google/uuid"
+)
+
+// Wire encodes room traffic for other nodes, see package wire.
+type Wire struct {
+	// Format is the encoding this node writes. Nodes read both.
+	Format wire.Format
+	// NodeID is recorded as the origin of the envelopes.
+	NodeID string
+}
+
+// encodeMessage encodes m with the metadata of the event it comes from.
+func (x Wire) encodeMessage(ctx context.Context, evt event.Event, m Message) ([]byte, error) {
+	env := x.envelope(ctx, evt)
+	env.Message = toWireMessage(m)
+	return wire.Marshal(x.Format, env)
+}
+
+// encodeInteraction encodes i with the metadata of the event it comes from.
+func (x Wire) encodeInteraction(ctx context.Context, evt event.Event, i Interaction) ([]byte, error) {
+	env := x.envelope(ctx, evt)
+	env.Interaction = toWireInteraction(i)
+	return wire.Marshal(x.Format, env)
+}
+
+func (x Wire) envelope(ctx context.Context, evt event.Event) wire.Envelope {
+	return wire.Envelope{
+		EventID:    evt.ID,
+		EventName:  evt.Name,
+		OriginNode: x.NodeID,
+		OccurredAt: evt.OccuredAt.UTC(),
+		Trace:      tracing.InjectMap(ctx),
+	}
+}
+
+// decodeMessage decodes the message of an envelope.
+func decodeMessage(env wire.Envelope) (Message, error) {
+	if env.Message == nil {
+		return Message{}, fmt.Errorf("%w: message", wire.ErrPayloadMissing)
+	}
+	return fromWireMessage(env.Message)
+}
+
+// decodeInteraction decodes the interaction of an envelope.
+func decodeInteraction(env wire.Envelope) (Interaction, error) {
+	if env.Interaction == nil {
+		return Interaction{}, fmt.Errorf("%w: interaction", wire.ErrPayloadMissing)
+	}
+	return fromWireInteraction(env.Interaction)
+}
+
+func toWireMessage(m Message) *wire.Message {
+	w := &wire.Message{
+		ID:        m.ID.String(),
+		Type:      m.Type,
+		RoomID:    m.RoomID,
+		SessionID: m.SessionID,
+		Body:      m.Body,
+		Author:    m.Author,
+		Timestamp: m.Timestamp,
+	}
+	for _, c := range m.Components {
+		wc := wire.Component{
+			Type:        c.Type,
+			ID:          c.ID,
+			Label:       c.Label,
+			Style:       c.Style,
+			Placeholder: c.Placeholder,
+		}
+		for _, o := range c.Options {
+			wc.Options = append(wc.Options, wire.Option{Label: o.Label, Value: o.Value})
+		}
+		w.Components = append(w.Components, wc)
+	}
+	return w
+}
+
+func fromWireMessage(w *wire.Message) (Message, error) {
+	id, err := uuid.Parse(w.ID)
+	if err != nil {
+		return Message{}, fmt.Errorf("chat: %w, %w", ErrMessageIDInvalid, err)
+	}
+	m := Message{
+		ID:        id,
+		Type:      w.Type,
+		RoomID:    w.RoomID,
+		SessionID: w.SessionID,
+		Body:      w.Body,
+		Author:    w.Author,
+		Timestamp: w.Timestamp,
+	}
+	for _, wc := range w.Components {
+		c := protocol.Component{
+			Type:        wc.Type,
+			ID:          wc.ID,
+			Label:       wc.Label,
+			Style:       wc.Style,
+			Placeholder: wc.Placeholder,
+		}
+		for _, o := range wc.Options {
+			c.Options = append(c.Options, protocol.Option{Label: o.Label, Value: o.Value})
+		}
+		m.Components = append(m.Components, c)
+	}
+	return m, nil
+}
+
+func toWireInteraction(i Interaction) *wire.Interaction {
+	return &wire.Interaction{
+		RoomID:      i.RoomID,
+		MessageID:   i.MessageID.String(),
+		ComponentID: i.ComponentID,
+		Values:      i.Values,
+		UserID:      i.UserID,
+		Author:      i.Author,
+		OwnerID:     i.OwnerID,
+	}
+}
+
+func fromWireInteraction(w *wire.Interaction) (Interaction, error) {
+	messageID, err := uuid.Parse(w.MessageID)
+	if err != nil {
+		return Interaction{}, fmt.Errorf("chat: %w, %w", ErrInteractionMessageIDInvalid, err)
+	}
+	return Interaction{
+		RoomID:      w.RoomID,
+		MessageID:   messageID,
+		ComponentID: w.ComponentID,
+		Values:      w.Values,
+		UserID:      w.UserID,
+		Author:      w.Author,
+		OwnerID:     w.OwnerID,
+	}, nil
+}
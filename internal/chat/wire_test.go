@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Wire(t *testing.T) {
+	m := Message{
+		ID:         uuid.New(),
+		Type:       1,
+		RoomID:     "room-1",
+		SessionID:  "user-1",
+		Body:       []byte("hello"),
+		Author:     "Ann",
+		Timestamp:  "2024-05-01T12:30:00Z",
+		Components: []protocol.Component{protocol.Button("ok", "OK")},
+	}
+	i := Interaction{
+		RoomID:      "room-1",
+		MessageID:   m.ID,
+		ComponentID: "ok",
+		UserID:      "user-2",
+		Author:      "Bob",
+		OwnerID:     "user-1",
+	}
+
+	for _, f := range []wire.Format{wire.JSON, wire.Protobuf} {
+		codec := Wire{Format: f, NodeID: "node-a"}
+		evt := event.New(MessageCreatedInRoomEvent, m)
+		data, err := codec.encodeMessage(context.Background(), evt, m)
+		require.NoError(t, err)
+
+		env, err := wire.Unmarshal(data)
+		require.NoError(t, err)
+		require.Equal(t, evt.ID, env.EventID)
+		require.Equal(t, MessageCreatedInRoomEvent, env.EventName)
+		require.Equal(t, "node-a", env.OriginNode)
+		require.True(t, evt.OccuredAt.Equal(env.OccurredAt))
+		got, err := decodeMessage(env)
+		require.NoError(t, err)
+		require.Equal(t, m, got)
+		_, err = decodeInteraction(env)
+		require.ErrorIs(t, err, wire.ErrPayloadMissing)
+
+		data, err = codec.encodeInteraction(context.Background(), event.New(InteractionCreatedEvent, i), i)
+		require.NoError(t, err)
+		env, err = wire.Unmarshal(data)
+		require.NoError(t, err)
+		gotInteraction, err := decodeInteraction(env)
+		require.NoError(t, err)
+		require.Equal(t, i, gotInteraction)
+	}
+}
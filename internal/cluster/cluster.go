@@ -0,0 +1,562 @@
+// Package cluster is the control plane of a chat cluster.
+//
+// Every node has an ID and answers control-plane requests through
+// the broker.
+// A request either targets one node or is scattered to every node,
+// in which case the replies are gathered until every known node
+// answered or the timeout expires. Nodes learn about each other
+// through heartbeats. Requests, replies and heartbeats are wire
+// envelopes; request and reply values are JSON encoded within them.
+//
+// Subjects:
+//
+//	chat.cluster.heartbeat            node heartbeats
+//	chat.cluster.req.<op>             requests to every node
+//	chat.cluster.node.<nodeID>.<op>   requests to a single node
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	subjectPrefix    = "chat.cluster."
+	heartbeatSubject = subjectPrefix + "heartbeat"
+	// missedHeartbeats is the number of heartbeats a node may miss
+	// before it is considered gone.
+	missedHeartbeats = 3
+	// DefaultHeartbeatInterval is used when no interval is configured.
+	DefaultHeartbeatInterval = 5 * time.Second
+	// DefaultTimeout is used when no request timeout is configured.
+	DefaultTimeout = 2 * time.Second
+)
+
+var (
+	ErrClosed         = errors.New("cluster: control plane closed")
+	ErrNotStarted     = errors.New("cluster: control plane not started")
+	ErrOpInvalid      = errors.New("cluster: op invalid")
+	ErrOpExists       = errors.New("cluster: op already handled")
+	ErrOpUnknown      = errors.New("cluster: op unknown")
+	ErrNodeIDInvalid  = errors.New("cluster: node ID invalid")
+	ErrNodeNotReached = errors.New("cluster: node not reached")
+)
+
+// Handler answers a control-plane request on this node.
+// The returned value is JSON encoded into the reply.
+type Handler func(ctx context.Context, req Request) (any, error)
+
+// Request is a control-plane request.
+type Request struct {
+	Op   string
+	From string
+	data []byte
+}
+
+// Decode decodes the request payload into v.
+func (x Request) Decode(v any) error {
+	return json.Unmarshal(x.data, v)
+}
+
+// Reply is the reply of a node.
+type Reply struct {
+	NodeID string
+	Err    string
+	Data   []byte
+}
+
+// Decode decodes the reply payload into v.
+func (x Reply) Decode(v any) error {
+	if x.Err != "" {
+		return errors.New(x.Err)
+	}
+	return json.Unmarshal(x.Data, v)
+}
+
+// Result is the outcome of a scattered request.
+type Result struct {
+	Replies []Reply
+	// Missing lists the known nodes that did not reply in time.
+	Missing []string
+}
+
+// Node describes a node of the cluster.
+type Node struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"startedAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Config configures a control plane.
+type Config struct {
+	// NodeID identifies this node. A random ID is used if empty.
+	NodeID string
+	// HeartbeatInterval defaults to DefaultHeartbeatInterval.
+	HeartbeatInterval time.Duration
+	// Timeout bounds requests without a context deadline
+	// and defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Format is the wire format of sent envelopes, JSON if empty.
+	// Envelopes are decoded from either format.
+	Format wire.Format
+}
+
+// ControlPlane sends and answers control-plane requests.
+type ControlPlane struct {
+	broker    broker.Broker
+	cfg       Config
+	startedAt time.Time
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	nodes    map[string]Node
+	subs     []broker.Subscription
+	stop     chan struct{}
+	closed   bool
+}
+
+// New returns a control plane. Register handlers, then call Start.
+// It fails with ErrNodeIDInvalid if the configured node ID is not
+// a valid subject token.
+func New(b broker.Broker, cfg Config) (*ControlPlane, error) {
+	if cfg.NodeID == "" {
+		cfg.NodeID = defaultNodeID()
+	}
+	if err := ValidateNodeID(cfg.NodeID); err != nil {
+		return nil, err
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Format == "" {
+		cfg.Format = wire.JSON
+	}
+	return &ControlPlane{
+		broker:    b,
+		cfg:       cfg,
+		startedAt: time.Now().UTC(),
+		handlers:  make(map[string]Handler),
+		nodes:     make(map[string]Node),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// ValidateNodeID returns ErrNodeIDInvalid if the node ID is empty or
+// contains a dot, a wildcard or whitespace, since it is used as a token
+// of the subjects nodes are reached on.
+func ValidateNodeID(id string) error {
+	if id == "" || strings.ContainsAny(id, ".*>") || strings.IndexFunc(id, unicode.IsSpace) >= 0 {
+		return ErrNodeIDInvalid
+	}
+	return nil
+}
+
+// NodeID returns the ID of this node.
+func (x *ControlPlane) NodeID() string {
+	return x.cfg.NodeID
+}
+
+// Handle registers the handler of an op. Ops are dot free names.
+func (x *ControlPlane) Handle(op string, h Handler) error {
+	if op == "" || strings.ContainsAny(op, ".*> ") {
+		return fmt.Errorf("%w: %q", ErrOpInvalid, op)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.handlers[op]; ok {
+		return fmt.Errorf("%w: %s", ErrOpExists, op)
+	}
+	x.handlers[op] = h
+	return nil
+}
+
+// Start subscribes to control-plane subjects and starts heartbeats.
+func (x *ControlPlane) Start() error {
+	if err := x.subscribe(); err != nil {
+		return err
+	}
+
+	x.beat(false)
+	go x.heartbeats()
+
+	log.Info().Str("node", x.cfg.NodeID).Msg("cluster: control plane started")
+	return nil
+}
+
+func (x *ControlPlane) subscribe() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.closed {
+		return ErrClosed
+	}
+	x.nodes[x.cfg.NodeID] = Node{ID: x.cfg.NodeID, StartedAt: x.startedAt, LastSeen: time.Now().UTC()}
+
+	for subject, h := range map[string]broker.Handler{
+		heartbeatSubject:                              x.onHeartbeat,
+		subjectPrefix + "req.*":                       x.onRequest,
+		subjectPrefix + "node." + x.cfg.NodeID + ".*": x.onRequest,
+	} {
+		sub, err := x.broker.Subscribe(subject, h)
+		if err != nil {
+			return fmt.Errorf("cluster: subscribing to %s, %w", subject, err)
+		}
+		x.subs = append(x.subs, sub)
+	}
+	return nil
+}
+
+// Close announces that this node leaves and stops answering requests.
+func (x *ControlPlane) Close() error {
+	x.mu.Lock()
+	if x.closed {
+		x.mu.Unlock()
+		return nil
+	}
+	x.closed = true
+	close(x.stop)
+	subs := x.subs
+	x.subs = nil
+	x.mu.Unlock()
+
+	x.beat(true)
+
+	var errs []error
+	for _, sub := range subs {
+		errs = append(errs, sub.Unsubscribe())
+	}
+	return errors.Join(errs...)
+}
+
+// Check reports whether the control plane is started.
+// The broker connection is checked on its own.
+func (x *ControlPlane) Check(_ context.Context) error {
+	x.mu.Lock()
+	closed, started := x.closed, len(x.subs) > 0
+	x.mu.Unlock()
+
+	switch {
+	case closed:
+		return ErrClosed
+	case !started:
+		return ErrNotStarted
+	}
+	return nil
+}
+
+// Nodes returns the live nodes, ordered by ID.
+func (x *ControlPlane) Nodes() []Node {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.expire()
+	nodes := make([]Node, 0, len(x.nodes))
+	for _, node := range x.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Request sends a request to a single node and waits for its reply.
+func (x *ControlPlane) Request(ctx context.Context, nodeID, op string, req any) (Reply, error) {
+	if err := ValidateNodeID(nodeID); err != nil {
+		return Reply{}, err
+	}
+	data, err := x.encode(op, req)
+	if err != nil {
+		return Reply{}, err
+	}
+
+	ctx, cancel := x.withTimeout(ctx)
+	defer cancel()
+
+	out := broker.NewMsg(subjectPrefix + "node." + nodeID + "." + op)
+	out.Data = data
+	msg, err := x.broker.Request(ctx, out)
+	if err != nil {
+		if errors.Is(err, broker.ErrNoResponders) || errors.Is(err, context.DeadlineExceeded) {
+			return Reply{}, fmt.Errorf("%w: %s, %w", ErrNodeNotReached, nodeID, err)
+		}
+		return Reply{}, fmt.Errorf("cluster: requesting %s from %s, %w", op, nodeID, err)
+	}
+	reply, err := decodeReply(msg.Data)
+	if err != nil {
+		return Reply{}, fmt.Errorf("cluster: decoding reply, %w", err)
+	}
+	return reply, nil
+}
+
+// Scatter sends a request to every node and gathers the replies until
+// every known node replied or the context, or the default timeout,
+// expires. Late nodes are listed in the result as missing.
+func (x *ControlPlane) Scatter(ctx context.Context, op string, req any) (Result, error) {
+	data, err := x.encode(op, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := x.withTimeout(ctx)
+	defer cancel()
+
+	// Replies are dropped once nobody waits for them.
+	inbox := broker.NewInbox()
+	replies := make(chan *broker.Msg, 64)
+	sub, err := x.broker.Subscribe(inbox, func(msg *broker.Msg) {
+		select {
+		case replies <- msg:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("cluster: subscribing to replies, %w", err)
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Error().Err(err).Msg("cluster: unsubscribing from replies")
+		}
+	}()
+
+	pending := make(map[string]bool)
+	for _, node := range x.Nodes() {
+		pending[node.ID] = true
+	}
+
+	msg := broker.NewMsg(subjectPrefix + "req." + op)
+	msg.Reply = inbox
+	msg.Data = data
+	if err := x.broker.Publish(ctx, msg); err != nil {
+		return Result{}, fmt.Errorf("cluster: publishing %s, %w", op, err)
+	}
+
+	var result Result
+	for len(pending) > 0 {
+		select {
+		case msg := <-replies:
+			reply, err := decodeReply(msg.Data)
+			if err != nil {
+				log.Error().Err(err).Msg("cluster: decoding reply")
+				continue
+			}
+			delete(pending, reply.NodeID)
+			result.Replies = append(result.Replies, reply)
+		case <-ctx.Done():
+			for nodeID := range pending {
+				result.Missing = append(result.Missing, nodeID)
+			}
+			sort.Strings(result.Missing)
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+func (x *ControlPlane) onRequest(msg *broker.Msg) {
+	op := msg.Subject[strings.LastIndexByte(msg.Subject, '.')+1:]
+
+	x.mu.Lock()
+	h, ok := x.handlers[op]
+	x.mu.Unlock()
+
+	reply := &wire.ControlReply{NodeID: x.cfg.NodeID}
+	env, err := wire.Unmarshal(msg.Data)
+	if err == nil && env.ControlRequest == nil {
+		err = wire.ErrPayloadMissing
+	}
+	switch {
+	case err != nil:
+		reply.Error = fmt.Sprintf("cluster: decoding request, %s", err)
+	case !ok:
+		reply.Error = fmt.Sprintf("%s: %s", ErrOpUnknown, op)
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), x.cfg.Timeout)
+		v, err := h(ctx, Request{Op: op, From: env.OriginNode, data: env.ControlRequest.Data})
+		cancel()
+		if err != nil {
+			reply.Error = err.Error()
+		} else if v != nil {
+			if reply.Data, err = json.Marshal(v); err != nil {
+				reply.Error = fmt.Sprintf("cluster: encoding reply, %s", err)
+			}
+		}
+	}
+
+	if msg.Reply == "" {
+		return
+	}
+	data, err := x.marshal(wire.Envelope{ControlReply: reply})
+	if err != nil {
+		log.Error().Err(err).Msg("cluster: encoding reply")
+		return
+	}
+	if err := broker.Respond(context.Background(), x.broker, msg, data); err != nil {
+		log.Error().Err(err).Str("op", op).Msg("cluster: responding")
+	}
+}
+
+func (x *ControlPlane) onHeartbeat(msg *broker.Msg) {
+	env, err := wire.Unmarshal(msg.Data)
+	if err == nil && env.Heartbeat == nil {
+		err = wire.ErrPayloadMissing
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("cluster: decoding heartbeat")
+		return
+	}
+	hb := env.Heartbeat
+	if hb.NodeID == x.cfg.NodeID {
+		return
+	}
+
+	x.mu.Lock()
+	if hb.Leaving {
+		delete(x.nodes, hb.NodeID)
+		x.mu.Unlock()
+		log.Info().Str("node", hb.NodeID).Msg("cluster: node left")
+		return
+	}
+	_, known := x.nodes[hb.NodeID]
+	x.nodes[hb.NodeID] = Node{ID: hb.NodeID, StartedAt: hb.StartedAt, LastSeen: time.Now().UTC()}
+	x.mu.Unlock()
+
+	// Answer new nodes right away so they do not wait
+	// a full interval to learn about this one.
+	if !known {
+		log.Info().Str("node", hb.NodeID).Msg("cluster: node joined")
+		x.beat(false)
+	}
+}
+
+func (x *ControlPlane) heartbeats() {
+	ticker := time.NewTicker(x.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-x.stop:
+			return
+		case <-ticker.C:
+			x.beat(false)
+			x.mu.Lock()
+			x.expire()
+			x.mu.Unlock()
+		}
+	}
+}
+
+func (x *ControlPlane) beat(leaving bool) {
+	data, err := x.marshal(wire.Envelope{Heartbeat: &wire.Heartbeat{
+		NodeID:    x.cfg.NodeID,
+		StartedAt: x.startedAt,
+		Leaving:   leaving,
+	}})
+	if err != nil {
+		log.Error().Err(err).Msg("cluster: encoding heartbeat")
+		return
+	}
+	msg := broker.NewMsg(heartbeatSubject)
+	msg.Data = data
+	if err := x.broker.Publish(context.Background(), msg); err != nil {
+		log.Error().Err(err).Msg("cluster: publishing heartbeat")
+	}
+
+	if !leaving {
+		x.mu.Lock()
+		if node, ok := x.nodes[x.cfg.NodeID]; ok {
+			node.LastSeen = time.Now().UTC()
+			x.nodes[x.cfg.NodeID] = node
+		}
+		x.mu.Unlock()
+	}
+}
+
+// expire drops nodes that missed too many heartbeats.
+// x.mu must be held.
+func (x *ControlPlane) expire() {
+	deadline := time.Now().Add(-missedHeartbeats * x.cfg.HeartbeatInterval)
+	for id, node := range x.nodes {
+		if id != x.cfg.NodeID && node.LastSeen.Before(deadline) {
+			delete(x.nodes, id)
+			log.Info().Str("node", id).Msg("cluster: node expired")
+		}
+	}
+}
+
+func (x *ControlPlane) encode(op string, req any) ([]byte, error) {
+	control := &wire.ControlRequest{Op: op}
+	if req != nil {
+		var err error
+		if control.Data, err = json.Marshal(req); err != nil {
+			return nil, fmt.Errorf("cluster: encoding request, %w", err)
+		}
+	}
+
+	data, err := x.marshal(wire.Envelope{ControlRequest: control})
+	if err != nil {
+		return nil, fmt.Errorf("cluster: encoding request, %w", err)
+	}
+	return data, nil
+}
+
+// marshal encodes an envelope sent by this node.
+func (x *ControlPlane) marshal(env wire.Envelope) ([]byte, error) {
+	env.OriginNode = x.cfg.NodeID
+	env.OccurredAt = time.Now().UTC()
+	return wire.Marshal(x.cfg.Format, env)
+}
+
+func decodeReply(data []byte) (Reply, error) {
+	env, err := wire.Unmarshal(data)
+	if err != nil {
+		return Reply{}, err
+	}
+	if env.ControlReply == nil {
+		return Reply{}, wire.ErrPayloadMissing
+	}
+	return Reply{
+		NodeID: env.ControlReply.NodeID,
+		Err:    env.ControlReply.Error,
+		Data:   env.ControlReply.Data,
+	}, nil
+}
+
+func (x *ControlPlane) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, x.cfg.Timeout)
+}
+
+// defaultNodeID returns the host name with a random suffix,
+// so that restarted nodes get a fresh ID.
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "node"
+	}
+	host = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".*>", r) || unicode.IsSpace(r) {
+			return '-'
+		}
+		return r
+	}, host)
+	return host + "-" + uuid.NewString()[:8]
+}
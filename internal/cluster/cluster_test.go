@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// wait bounds the wait for nodes to learn about each other.
+const wait = 5 * time.Second
+
+type echo struct {
+	Text string
+	N    int
+}
+
+func newBroker(t *testing.T) *broker.Memory {
+	t.Helper()
+
+	b := broker.NewMemory()
+	t.Cleanup(func() { _ = b.Drain(context.Background()) })
+	return b
+}
+
+// start starts a control plane that answers the echo op
+// and closes it at the end of the test.
+func start(t *testing.T, b broker.Broker, cfg Config) *ControlPlane {
+	t.Helper()
+
+	x, err := New(b, cfg)
+	require.NoError(t, err)
+	require.NoError(t, x.Handle("echo", func(_ context.Context, req Request) (any, error) {
+		var v echo
+		if err := req.Decode(&v); err != nil {
+			return nil, err
+		}
+		v.Text = req.From + ": " + v.Text
+		return v, nil
+	}))
+	require.NoError(t, x.Start())
+	t.Cleanup(func() { _ = x.Close() })
+	return x
+}
+
+// waitNodes waits until x knows n nodes.
+func waitNodes(t *testing.T, x *ControlPlane, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool { return len(x.Nodes()) == n }, wait, 10*time.Millisecond)
+}
+
+func Test_New_NodeID(t *testing.T) {
+	b := newBroker(t)
+	for _, id := range []string{"node.a", "node*", "node>", "node a", "node\ta", "node\n"} {
+		_, err := New(b, Config{NodeID: id})
+		require.ErrorIs(t, err, ErrNodeIDInvalid, id)
+	}
+
+	x, err := New(b, Config{})
+	require.NoError(t, err)
+	require.NoError(t, ValidateNodeID(x.NodeID()))
+}
+
+func Test_ControlPlane_Request(t *testing.T) {
+	ctx := context.Background()
+	b := newBroker(t)
+	a := start(t, b, Config{NodeID: "node-a"})
+	start(t, b, Config{NodeID: "node-b", Format: wire.Protobuf})
+
+	reply, err := a.Request(ctx, "node-b", "echo", echo{Text: "hi", N: 2})
+	require.NoError(t, err)
+	require.Equal(t, "node-b", reply.NodeID)
+	var got echo
+	require.NoError(t, reply.Decode(&got))
+	require.Equal(t, echo{Text: "node-a: hi", N: 2}, got)
+
+	reply, err = a.Request(ctx, "node-b", "unknown", nil)
+	require.NoError(t, err)
+	require.ErrorContains(t, reply.Decode(&got), ErrOpUnknown.Error())
+
+	_, err = a.Request(ctx, "node-c", "echo", nil)
+	require.ErrorIs(t, err, ErrNodeNotReached)
+	_, err = a.Request(ctx, "node.*", "echo", nil)
+	require.ErrorIs(t, err, ErrNodeIDInvalid)
+}
+
+func Test_ControlPlane_Scatter(t *testing.T) {
+	ctx := context.Background()
+	b := newBroker(t)
+	a := start(t, b, Config{NodeID: "node-a", Timeout: 200 * time.Millisecond})
+	start(t, b, Config{NodeID: "node-b", Format: wire.Protobuf})
+
+	// node-c answers slower than the requests wait.
+	c, err := New(b, Config{NodeID: "node-c"})
+	require.NoError(t, err)
+	require.NoError(t, c.Handle("echo", func(ctx context.Context, _ Request) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	require.NoError(t, c.Start())
+	t.Cleanup(func() { _ = c.Close() })
+	waitNodes(t, a, 3)
+
+	result, err := a.Scatter(ctx, "echo", echo{Text: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"node-c"}, result.Missing)
+	require.Len(t, result.Replies, 2)
+	for _, reply := range result.Replies {
+		var got echo
+		require.NoError(t, reply.Decode(&got))
+		require.Equal(t, "node-a: hi", got.Text)
+	}
+
+	// Without a slow node every known node replies.
+	require.NoError(t, c.Close())
+	waitNodes(t, a, 2)
+	result, err = a.Scatter(ctx, "echo", echo{Text: "hi"})
+	require.NoError(t, err)
+	require.Empty(t, result.Missing)
+	require.Len(t, result.Replies, 2)
+}
+
+func Test_ControlPlane_Heartbeats(t *testing.T) {
+	b := newBroker(t)
+	interval := 20 * time.Millisecond
+	a := start(t, b, Config{NodeID: "node-a", HeartbeatInterval: interval})
+	c := start(t, b, Config{NodeID: "node-c", Format: wire.Protobuf, HeartbeatInterval: interval})
+	waitNodes(t, a, 2)
+	waitNodes(t, c, 2)
+
+	t.Run("Expired", func(t *testing.T) {
+		// A node that stops sending heartbeats without leaving.
+		data, err := wire.Marshal(wire.JSON, wire.Envelope{
+			OriginNode: "node-b",
+			Heartbeat:  &wire.Heartbeat{NodeID: "node-b", StartedAt: time.Now().UTC()},
+		})
+		require.NoError(t, err)
+		msg := broker.NewMsg(heartbeatSubject)
+		msg.Data = data
+		require.NoError(t, b.Publish(context.Background(), msg))
+
+		waitNodes(t, a, 3)
+		require.Equal(t, "node-b", a.Nodes()[1].ID)
+		waitNodes(t, a, 2)
+		require.Equal(t, "node-c", a.Nodes()[1].ID)
+	})
+
+	t.Run("Left", func(t *testing.T) {
+		require.NoError(t, c.Close())
+		waitNodes(t, a, 1)
+		require.Equal(t, "node-a", a.Nodes()[0].ID)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		msg := broker.NewMsg(heartbeatSubject)
+		msg.Data = []byte("not an envelope")
+		require.NoError(t, b.Publish(context.Background(), msg))
+		time.Sleep(interval)
+		require.Len(t, a.Nodes(), 1)
+	})
+}
@@ -10,11 +10,28 @@ import (
 
 // App holds the application-wide configuration.
 type App struct {
-	ServiceName string     `mapstructure:"serviceName"`
-	Environment string     `mapstructure:"environment"`
-	HTTPServer  HTTPServer `mapstructure:"httpServer"`
-	ScyllaDB    ScyllaDB   `mapstructure:"scyllaDB"`
-	NATS        NATS       `mapstructure:"nats"`
+	ServiceName string      `mapstructure:"serviceName"`
+	Environment string      `mapstructure:"environment"`
+	HTTPServer  HTTPServer  `mapstructure:"httpServer"`
+	AdminServer HTTPServer  `mapstructure:"adminServer"`
+	ScyllaDB    ScyllaDB    `mapstructure:"scyllaDB"`
+	NATS        NATS        `mapstructure:"nats"`
+	Cluster     Cluster     `mapstructure:"cluster"`
+	Events      Events      `mapstructure:"events"`
+	Webhooks    Webhooks    `mapstructure:"webhooks"`
+	Retention   Retention   `mapstructure:"retention"`
+	Erasure     Erasure     `mapstructure:"erasure"`
+	Outbox      Outbox      `mapstructure:"outbox"`
+	Idempotency Idempotency `mapstructure:"idempotency"`
+	Tracing     Tracing     `mapstructure:"tracing"`
+
+	// Broker is nats, or memory for a single node without NATS.
+	Broker string `mapstructure:"broker"`
+	// WireFormat is json or protobuf, the encoding of room
+	// traffic this node sends. Nodes read both.
+	WireFormat string `mapstructure:"wireFormat"`
+	// ShutdownTimeout bounds draining sessions and in-flight work.
+	ShutdownTimeout time.Duration `mapstructure:"shutdownTimeout"`
 }
 
 // HTTPServer holds the configuration for the HTTP server.
@@ -38,6 +55,128 @@ type ScyllaDB struct {
 type NATS struct {
 	Host string `mapstructure:"host"`
 	Port string `mapstructure:"port"`
+	// Embedded runs the NATS server in the process,
+	// listening on Host and Port.
+	Embedded bool `mapstructure:"embedded"`
+	// StoreDir enables JetStream on the embedded server.
+	StoreDir  string      `mapstructure:"storeDir"`
+	Cluster   NATSCluster `mapstructure:"cluster"`
+	JetStream JetStream   `mapstructure:"jetStream"`
+}
+
+// NATSCluster holds the configuration for clustering embedded
+// NATS servers. Clustering is off without a port.
+type NATSCluster struct {
+	Name string `mapstructure:"name"`
+	Host string `mapstructure:"host"`
+	Port string `mapstructure:"port"`
+	// Routes are the cluster URLs of the other servers,
+	// nats-route://host:port.
+	Routes []string `mapstructure:"routes"`
+}
+
+// JetStream holds the configuration for durable room streams.
+type JetStream struct {
+	// Enabled stores room messages in a stream instead of
+	// publishing them on core NATS.
+	Enabled bool   `mapstructure:"enabled"`
+	Stream  string `mapstructure:"stream"`
+	// MaxAge and MaxBytes bound the retention of the stream.
+	MaxAge   time.Duration `mapstructure:"maxAge"`
+	MaxBytes int64         `mapstructure:"maxBytes"`
+	Replicas int           `mapstructure:"replicas"`
+	// AckWait is how long a node may take to acknowledge a message.
+	AckWait time.Duration `mapstructure:"ackWait"`
+	// InactiveThreshold removes the consumers of nodes that are gone.
+	InactiveThreshold time.Duration `mapstructure:"inactiveThreshold"`
+	// ResumeLimit bounds the messages sent to a resuming session.
+	ResumeLimit int `mapstructure:"resumeLimit"`
+}
+
+// Cluster holds the configuration for the cluster control plane.
+type Cluster struct {
+	// NodeID identifies the node. A random ID is used if empty,
+	// unless JetStream is enabled, which needs a stable one.
+	NodeID string `mapstructure:"nodeID"`
+	// HeartbeatInterval is how often the node announces itself.
+	HeartbeatInterval time.Duration `mapstructure:"heartbeatInterval"`
+	// RequestTimeout bounds control-plane requests.
+	RequestTimeout time.Duration `mapstructure:"requestTimeout"`
+}
+
+// Events holds the configuration for the in-memory event registry.
+type Events struct {
+	// Workers is the number of goroutines handling events.
+	Workers int `mapstructure:"workers"`
+	// QueueSize is the number of events each worker buffers.
+	QueueSize int `mapstructure:"queueSize"`
+	// HandlerTimeout bounds a single call of a handler.
+	HandlerTimeout time.Duration `mapstructure:"handlerTimeout"`
+	// RetryAttempts is the number of attempts for retryable errors.
+	RetryAttempts int `mapstructure:"retryAttempts"`
+	// RetryBackoff is the wait before the first retry. It doubles
+	// up to RetryMaxBackoff.
+	RetryBackoff    time.Duration `mapstructure:"retryBackoff"`
+	RetryMaxBackoff time.Duration `mapstructure:"retryMaxBackoff"`
+	// DeadLetters is the number of dead letters kept.
+	DeadLetters int `mapstructure:"deadLetters"`
+	// Store keeps domain events in the Scylla event log for replay.
+	Store bool `mapstructure:"store"`
+}
+
+// Webhooks holds the configuration for incoming webhooks.
+type Webhooks struct {
+	// RateLimit is the number of messages per second a single webhook may post.
+	RateLimit float64 `mapstructure:"rateLimit"`
+	// Burst is the number of messages a single webhook may post at once.
+	Burst int `mapstructure:"burst"`
+}
+
+// Retention holds the configuration for message retention.
+type Retention struct {
+	// DefaultDays is the retention of rooms without a policy.
+	// Zero keeps messages forever.
+	DefaultDays int `mapstructure:"defaultDays"`
+	// JobInterval is how often changed policies are re-applied
+	// to existing messages.
+	JobInterval time.Duration `mapstructure:"jobInterval"`
+}
+
+// Erasure holds the configuration for user data erasure.
+type Erasure struct {
+	// ReportKey signs erasure reports, so that a stored report can be
+	// checked for tampering. Keep it secret and the same on every node.
+	ReportKey string `mapstructure:"reportKey"`
+}
+
+// Outbox holds the configuration for the message outbox relay.
+type Outbox struct {
+	// RelayInterval is how often the relay publishes pending entries.
+	RelayInterval time.Duration `mapstructure:"relayInterval"`
+	// RelayDelay is how old an entry must be before the relay publishes
+	// it, so that it does not race the handler that wrote it.
+	RelayDelay time.Duration `mapstructure:"relayDelay"`
+	// BatchSize is the number of entries relayed per shard and interval.
+	BatchSize int `mapstructure:"batchSize"`
+}
+
+// Idempotency holds the configuration for message idempotency keys.
+type Idempotency struct {
+	// Window is how long a key is remembered. Messages resent with
+	// the same key within it are stored once.
+	Window time.Duration `mapstructure:"window"`
+}
+
+// Tracing holds the configuration for OpenTelemetry tracing.
+type Tracing struct {
+	// Exporter is otlp, stdout or file. Tracing is off if empty.
+	Exporter string `mapstructure:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector.
+	Endpoint string `mapstructure:"endpoint"`
+	// Insecure disables TLS to the OTLP collector.
+	Insecure bool `mapstructure:"insecure"`
+	// File is the path spans are appended to by the file exporter.
+	File string `mapstructure:"file"`
 }
 
 // New returns the application-wide configuration.
@@ -0,0 +1,25 @@
+package cql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gocql/gocql"
+)
+
+// HealthCheck returns a check that runs a cheap query against
+// the local node of the session.
+func HealthCheck(session *gocql.Session) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if session == nil || session.Closed() {
+			return errors.New("cql: session closed")
+		}
+		if err := session.Query(`SELECT now() FROM system.local`).
+			WithContext(ctx).
+			Exec(); err != nil {
+			return fmt.Errorf("cql: querying system.local, %w", err)
+		}
+		return nil
+	}
+}
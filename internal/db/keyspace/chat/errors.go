@@ -0,0 +1,29 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gocql/gocql"
+)
+
+// IsTransient reports whether err is a timeout or unavailability
+// that may succeed if the query is tried again.
+func IsTransient(err error) bool {
+	var (
+		writeTimeout *gocql.RequestErrWriteTimeout
+		readTimeout  *gocql.RequestErrReadTimeout
+		unavailable  *gocql.RequestErrUnavailable
+	)
+	switch {
+	case errors.As(err, &writeTimeout),
+		errors.As(err, &readTimeout),
+		errors.As(err, &unavailable),
+		errors.Is(err, gocql.ErrTimeoutNoResponse),
+		errors.Is(err, gocql.ErrNoConnections),
+		errors.Is(err, gocql.ErrConnectionClosed),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
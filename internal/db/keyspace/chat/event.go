@@ -0,0 +1,288 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+var _ EventRepository = (*ScyllaEventRepository)(nil)
+
+var (
+	// ErrEventRangeInvalid is returned when an event time range
+	// has no start or ends before it starts.
+	ErrEventRangeInvalid = errors.New("event range invalid")
+	// ErrEventNotFound is returned when an event does not exist.
+	ErrEventNotFound = errors.New("event not found")
+)
+
+const day = 24 * time.Hour
+
+// StoredEvent defines the event_log database model.
+type StoredEvent struct {
+	// ID is time based and orders the events of a day.
+	ID      gocql.UUID
+	EventID string
+	Name    string
+	Key     string
+	Payload []byte
+	// Users are the IDs of the users the event is about.
+	// Their events are indexed in the EventByUser table.
+	Users []string
+}
+
+// EventRef references an event in the event log.
+type EventRef struct {
+	Name string
+	ID   gocql.UUID
+}
+
+// ReadEventsParams defines the parameters to read events
+// of one name in a time range, oldest first.
+type ReadEventsParams struct {
+	Name string
+	From time.Time
+	// To defaults to now.
+	To time.Time
+}
+
+// EventRepository defines database methods to interact with the event log.
+type EventRepository interface {
+	// AppendEvent appends an event to the event log.
+	AppendEvent(ctx context.Context, params StoredEvent) error
+	// ReadEvents calls fn for every event in the range, oldest first.
+	// It stops at the first error of fn and returns it.
+	ReadEvents(ctx context.Context, params ReadEventsParams, fn func(StoredEvent) error) error
+	// ReadEvent reads a single event.
+	ReadEvent(ctx context.Context, ref EventRef) (StoredEvent, error)
+	// ReadEventRefsByUser reads references to all events about a user.
+	ReadEventRefsByUser(ctx context.Context, userID string) ([]EventRef, error)
+	// DeleteEvent deletes an event from the event log.
+	DeleteEvent(ctx context.Context, ref EventRef) error
+	// DeleteEventByUser deletes an event reference of a user.
+	DeleteEventByUser(ctx context.Context, userID string, ref EventRef) error
+}
+
+// ScyllaEventRepository implements the EventRepository interface.
+// Events are partitioned by name and UTC day, so that a busy
+// event name does not grow a single partition forever.
+type ScyllaEventRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaEventRepository creates a new ScyllaEventRepository.
+func NewScyllaEventRepository(session *gocql.Session) *ScyllaEventRepository {
+	return &ScyllaEventRepository{session: session}
+}
+
+// AppendEvent appends an event to the event log and references it
+// for each of its users in the EventByUser table, in a logged batch.
+func (x *ScyllaEventRepository) AppendEvent(
+	ctx context.Context,
+	params StoredEvent,
+) error {
+	batch := x.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Observer(metrics.Batch("AppendEvent"))
+	batch.Query(
+		`INSERT INTO chat.event_log
+         (name, day, id, event_id, key, payload)
+         VALUES (?, ?, ?, ?, ?, ?)`,
+		params.Name,
+		eventDay(params.ID),
+		params.ID,
+		params.EventID,
+		params.Key,
+		params.Payload,
+	)
+	for _, userID := range params.Users {
+		batch.Query(
+			`INSERT INTO chat.event_by_user
+             (user_id, name, id)
+             VALUES (?, ?, ?)`,
+			userID,
+			params.Name,
+			params.ID,
+		)
+	}
+
+	if err := x.session.ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("event repo: appending event, %w", err)
+	}
+
+	return nil
+}
+
+// ReadEvents calls fn for every event in the range, oldest first.
+// It reads one day partition at a time and pages through each.
+func (x *ScyllaEventRepository) ReadEvents(
+	ctx context.Context,
+	params ReadEventsParams,
+	fn func(StoredEvent) error,
+) error {
+	to := params.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if params.From.IsZero() || to.Before(params.From) {
+		return ErrEventRangeInvalid
+	}
+
+	query := `SELECT id, event_id, key, payload
+              FROM chat.event_log
+              WHERE name = ? AND day = ? AND id >= ? AND id <= ?`
+
+	from, until := gocql.MinTimeUUID(params.From), gocql.MaxTimeUUID(to)
+	for d := params.From.UTC().Truncate(day); !d.After(to); d = d.Add(day) {
+		scanner := x.session.Query(
+			query,
+			params.Name,
+			d,
+			from,
+			until,
+		).WithContext(ctx).
+			Observer(metrics.Query("ReadEvents")).
+			PageSize(DefaultPageSize).
+			Iter().
+			Scanner()
+
+		for scanner.Next() {
+			event := StoredEvent{Name: params.Name}
+			if err := scanner.Scan(
+				&event.ID,
+				&event.EventID,
+				&event.Key,
+				&event.Payload,
+			); err != nil {
+				_ = scanner.Err()
+				return fmt.Errorf("event repo: scanning event, %w", err)
+			}
+			if err := fn(event); err != nil {
+				// Err closes the iterator.
+				_ = scanner.Err()
+				return err
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("event repo: scanner had errors, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadEvent reads a single event.
+// It returns ErrEventNotFound if the event does not exist.
+func (x *ScyllaEventRepository) ReadEvent(
+	ctx context.Context,
+	ref EventRef,
+) (StoredEvent, error) {
+	query := `SELECT event_id, key, payload
+              FROM chat.event_log
+              WHERE name = ? AND day = ? AND id = ?`
+
+	event := StoredEvent{Name: ref.Name, ID: ref.ID}
+	if err := x.session.Query(
+		query,
+		ref.Name,
+		eventDay(ref.ID),
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadEvent")).
+		Scan(&event.EventID, &event.Key, &event.Payload); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return StoredEvent{}, ErrEventNotFound
+		}
+		return StoredEvent{}, fmt.Errorf("event repo: reading event, %w", err)
+	}
+
+	return event, nil
+}
+
+// ReadEventRefsByUser reads references to all events about a user.
+func (x *ScyllaEventRepository) ReadEventRefsByUser(
+	ctx context.Context,
+	userID string,
+) ([]EventRef, error) {
+	query := `SELECT name, id
+              FROM chat.event_by_user
+              WHERE user_id = ?`
+
+	refs := make([]EventRef, 0)
+
+	scanner := x.session.Query(
+		query,
+		userID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadEventRefsByUser")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		var ref EventRef
+		if err := scanner.Scan(&ref.Name, &ref.ID); err != nil {
+			return nil, fmt.Errorf("event repo: scanning event ref, %w", err)
+		}
+		refs = append(refs, ref)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("event repo: scanner had errors, %w", err)
+	}
+
+	return refs, nil
+}
+
+// DeleteEvent deletes an event from the event log.
+func (x *ScyllaEventRepository) DeleteEvent(
+	ctx context.Context,
+	ref EventRef,
+) error {
+	query := `DELETE FROM chat.event_log
+              WHERE name = ? AND day = ? AND id = ?`
+
+	if err := x.session.Query(
+		query,
+		ref.Name,
+		eventDay(ref.ID),
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteEvent")).
+		Exec(); err != nil {
+		return fmt.Errorf("event repo: deleting event, %w", err)
+	}
+
+	return nil
+}
+
+// DeleteEventByUser deletes an event reference of a user.
+func (x *ScyllaEventRepository) DeleteEventByUser(
+	ctx context.Context,
+	userID string,
+	ref EventRef,
+) error {
+	query := `DELETE FROM chat.event_by_user
+              WHERE user_id = ? AND name = ? AND id = ?`
+
+	if err := x.session.Query(
+		query,
+		userID,
+		ref.Name,
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteEventByUser")).
+		Exec(); err != nil {
+		return fmt.Errorf("event repo: deleting event by user, %w", err)
+	}
+
+	return nil
+}
+
+// eventDay returns the day partition of an event ID.
+func eventDay(id gocql.UUID) time.Time {
+	return id.Time().UTC().Truncate(day)
+}
@@ -0,0 +1,86 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Events(t *testing.T) {
+	ctx := context.Background()
+	name := "test." + uuid.NewString()
+	now := time.Now().UTC()
+
+	// Three events, the first one the day before.
+	times := []time.Time{now.Add(-day), now.Add(-time.Minute), now}
+	for i, at := range times {
+		err := testEventRepo.AppendEvent(ctx, StoredEvent{
+			ID:      gocql.UUIDFromTime(at),
+			EventID: uuid.NewString(),
+			Name:    name,
+			Key:     "room",
+			Payload: []byte{byte(i)},
+		})
+		require.NoError(t, err)
+	}
+
+	read := func(from, to time.Time) []StoredEvent {
+		var events []StoredEvent
+		err := testEventRepo.ReadEvents(ctx, ReadEventsParams{Name: name, From: from, To: to},
+			func(event StoredEvent) error {
+				events = append(events, event)
+				return nil
+			})
+		require.NoError(t, err)
+		return events
+	}
+
+	events := read(now.Add(-2*day), time.Time{})
+	require.Len(t, events, 3)
+	for i, event := range events {
+		assert.Equal(t, []byte{byte(i)}, event.Payload)
+		assert.Equal(t, "room", event.Key)
+	}
+
+	events = read(now.Add(-time.Hour), now.Add(-time.Second))
+	require.Len(t, events, 1)
+	assert.Equal(t, []byte{1}, events[0].Payload)
+
+	t.Run("Invalid range", func(t *testing.T) {
+		err := testEventRepo.ReadEvents(ctx, ReadEventsParams{Name: name, From: now, To: now.Add(-time.Hour)},
+			func(StoredEvent) error { return nil })
+		require.ErrorIs(t, err, ErrEventRangeInvalid)
+	})
+
+	t.Run("By user", func(t *testing.T) {
+		userID := uuid.NewString()
+		id := gocql.UUIDFromTime(now)
+		require.NoError(t, testEventRepo.AppendEvent(ctx, StoredEvent{
+			ID:      id,
+			EventID: uuid.NewString(),
+			Name:    name,
+			Key:     "room",
+			Payload: []byte{3},
+			Users:   []string{userID},
+		}))
+		require.Len(t, read(now.Add(-time.Second), time.Time{}), 2)
+
+		refs, err := testEventRepo.ReadEventRefsByUser(ctx, userID)
+		require.NoError(t, err)
+		require.Equal(t, []EventRef{{Name: name, ID: id}}, refs)
+
+		require.NoError(t, testEventRepo.DeleteEvent(ctx, refs[0]))
+		require.NoError(t, testEventRepo.DeleteEventByUser(ctx, userID, refs[0]))
+		refs, err = testEventRepo.ReadEventRefsByUser(ctx, userID)
+		require.NoError(t, err)
+		assert.Empty(t, refs)
+		require.Len(t, read(now.Add(-time.Second), time.Time{}), 1)
+	})
+}
@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+// ClaimIdempotencyKeyParams defines the parameters to claim
+// the idempotency key of a message.
+type ClaimIdempotencyKeyParams struct {
+	RoomID string
+	UserID string
+	Key    string
+	// ID is the ID of the message claiming the key.
+	ID gocql.UUID
+	// TTL releases the key after the given duration.
+	TTL time.Duration
+}
+
+// IdempotencyKey identifies the idempotency key of a user in a room.
+type IdempotencyKey struct {
+	RoomID string
+	UserID string
+	Key    string
+}
+
+// ClaimIdempotencyKey records the message ID under a key of a user in
+// a room, unless the key is already taken. It returns the ID of the
+// message holding the key, which is params.ID if the claim succeeded
+// or the same message claimed it before. The key is indexed by user
+// first, so that erasure finds every key a claim may have written.
+func (x *ScyllaMessageRepository) ClaimIdempotencyKey(
+	ctx context.Context,
+	params ClaimIdempotencyKeyParams,
+) (gocql.UUID, error) {
+	indexQuery := `INSERT INTO chat.message_idempotency_by_user 
+                   (user_id, room_id, key) 
+                   VALUES (?, ?, ?) 
+                   USING TTL ?`
+
+	if err := x.session.Query(
+		indexQuery,
+		params.UserID,
+		params.RoomID,
+		params.Key,
+		ttlSeconds(params.TTL),
+	).WithContext(ctx).
+		Observer(metrics.Query("IndexIdempotencyKey")).
+		Exec(); err != nil {
+		return gocql.UUID{}, fmt.Errorf("message repo: indexing idempotency key, %w", err)
+	}
+
+	query := `INSERT INTO chat.message_idempotency 
+              (room_id, user_id, key, id) 
+              VALUES (?, ?, ?, ?) 
+              IF NOT EXISTS 
+              USING TTL ?`
+
+	existing := make(map[string]any)
+	applied, err := x.session.Query(
+		query,
+		params.RoomID,
+		params.UserID,
+		params.Key,
+		params.ID,
+		ttlSeconds(params.TTL),
+	).WithContext(ctx).
+		Observer(metrics.Query("ClaimIdempotencyKey")).
+		MapScanCAS(existing)
+	if err != nil {
+		return gocql.UUID{}, fmt.Errorf("message repo: claiming idempotency key, %w", err)
+	}
+	if applied {
+		return params.ID, nil
+	}
+
+	id, ok := existing["id"].(gocql.UUID)
+	if !ok {
+		return gocql.UUID{}, fmt.Errorf("message repo: idempotency key has no message ID")
+	}
+	return id, nil
+}
+
+// ReadIdempotencyKeysByUser reads the idempotency keys of a user
+// in every room.
+func (x *ScyllaMessageRepository) ReadIdempotencyKeysByUser(
+	ctx context.Context,
+	userID string,
+) ([]IdempotencyKey, error) {
+	query := `SELECT room_id, key 
+              FROM chat.message_idempotency_by_user 
+              WHERE user_id = ?`
+
+	keys := make([]IdempotencyKey, 0)
+
+	scanner := x.session.Query(
+		query,
+		userID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadIdempotencyKeysByUser")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		key := IdempotencyKey{UserID: userID}
+		if err := scanner.Scan(&key.RoomID, &key.Key); err != nil {
+			return nil, fmt.Errorf("message repo: scanning idempotency key, %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("message repo: scanner had errors, %w", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteIdempotencyKey deletes an idempotency key and its index entry.
+func (x *ScyllaMessageRepository) DeleteIdempotencyKey(ctx context.Context, key IdempotencyKey) error {
+	query := `DELETE FROM chat.message_idempotency 
+              WHERE room_id = ? AND user_id = ? AND key = ?`
+
+	if err := x.session.Query(
+		query,
+		key.RoomID,
+		key.UserID,
+		key.Key,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteIdempotencyKey")).
+		Exec(); err != nil {
+		return fmt.Errorf("message repo: deleting idempotency key, %w", err)
+	}
+
+	indexQuery := `DELETE FROM chat.message_idempotency_by_user 
+                   WHERE user_id = ? AND room_id = ? AND key = ?`
+
+	if err := x.session.Query(
+		indexQuery,
+		key.UserID,
+		key.RoomID,
+		key.Key,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteIdempotencyKeyByUser")).
+		Exec(); err != nil {
+		return fmt.Errorf("message repo: deleting idempotency key by user, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ClaimIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	params := ClaimIdempotencyKeyParams{
+		RoomID: uuid.NewString(),
+		UserID: uuid.NewString(),
+		Key:    "key-1",
+		ID:     gocql.TimeUUID(),
+		TTL:    time.Minute,
+	}
+
+	id, err := testMessageRepo.ClaimIdempotencyKey(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, params.ID, id)
+
+	t.Run("Same message", func(t *testing.T) {
+		id, err := testMessageRepo.ClaimIdempotencyKey(ctx, params)
+		require.NoError(t, err)
+		assert.Equal(t, params.ID, id)
+	})
+
+	t.Run("Resent message", func(t *testing.T) {
+		resent := params
+		resent.ID = gocql.TimeUUID()
+		id, err := testMessageRepo.ClaimIdempotencyKey(ctx, resent)
+		require.NoError(t, err)
+		assert.Equal(t, params.ID, id)
+	})
+
+	t.Run("Other user", func(t *testing.T) {
+		other := params
+		other.UserID = uuid.NewString()
+		other.ID = gocql.TimeUUID()
+		id, err := testMessageRepo.ClaimIdempotencyKey(ctx, other)
+		require.NoError(t, err)
+		assert.Equal(t, other.ID, id)
+	})
+
+	t.Run("Delete by user", func(t *testing.T) {
+		keys, err := testMessageRepo.ReadIdempotencyKeysByUser(ctx, params.UserID)
+		require.NoError(t, err)
+		require.Equal(t, []IdempotencyKey{{RoomID: params.RoomID, UserID: params.UserID, Key: params.Key}}, keys)
+
+		require.NoError(t, testMessageRepo.DeleteIdempotencyKey(ctx, keys[0]))
+		keys, err = testMessageRepo.ReadIdempotencyKeysByUser(ctx, params.UserID)
+		require.NoError(t, err)
+		require.Empty(t, keys)
+
+		resent := params
+		resent.ID = gocql.TimeUUID()
+		id, err := testMessageRepo.ClaimIdempotencyKey(ctx, resent)
+		require.NoError(t, err)
+		assert.Equal(t, resent.ID, id)
+	})
+}
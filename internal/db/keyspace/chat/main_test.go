@@ -26,6 +26,13 @@ const (
 var (
 	testMessageRepo *ScyllaMessageRepository
 	testUserRepo    *ScyllaUserRepository
+	testWebhookRepo *ScyllaWebhookRepository
+
+	testServiceAccountRepo *ScyllaServiceAccountRepository
+	testRoomPolicyRepo     *ScyllaRoomPolicyRepository
+	testEventRepo          *ScyllaEventRepository
+	testOutboxRepo         *ScyllaOutboxRepository
+	testRoomRoleRepo       *ScyllaRoomRoleRepository
 )
 
 func TestMain(m *testing.M) {
@@ -57,6 +64,12 @@ func TestMain(m *testing.M) {
 
 	testMessageRepo = NewScyllaMessageRepository(session)
 	testUserRepo = NewScyllaUserRepository(session)
+	testWebhookRepo = NewScyllaWebhookRepository(session)
+	testServiceAccountRepo = NewScyllaServiceAccountRepository(session)
+	testRoomPolicyRepo = NewScyllaRoomPolicyRepository(session)
+	testEventRepo = NewScyllaEventRepository(session)
+	testOutboxRepo = NewScyllaOutboxRepository(session)
+	testRoomRoleRepo = NewScyllaRoomRoleRepository(session)
 
 	os.Exit(m.Run())
 }
@@ -2,32 +2,71 @@ package chat
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/Salam4nder/chat/internal/metrics"
 	"github.com/gocql/gocql"
 )
 
 var _ MessageRepository = (*ScyllaMessageRepository)(nil)
 
+var (
+	// ErrMessageNotFound is returned when a message does not exist.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessageChanged is returned when a message kept changing
+	// while it was being rewritten.
+	ErrMessageChanged = errors.New("message changed")
+)
+
 // Message defines the message database model.
 type Message struct {
 	ID     gocql.UUID
 	Data   []byte
 	Type   string
 	Sender string
+	// UserID is the stable ID of the sender.
+	// Sender is only a display name.
+	UserID string
 	RoomID string
 	Time   time.Time
 }
 
+// MessageRef references a message in a room.
+type MessageRef struct {
+	RoomID string
+	ID     gocql.UUID
+}
+
 // MessageRepository defines database methods to interact with messages.
 type MessageRepository interface {
 	// Session returns the underlying database session.
 	Session() *gocql.Session
 	// CreateMessageByRoom creates a new entry in the MessagesInRoom table.
 	CreateMessageByRoom(ctx context.Context, params CreateMessageByRoomParams) error
+	// ClaimIdempotencyKey records the message holding an idempotency key.
+	ClaimIdempotencyKey(ctx context.Context, params ClaimIdempotencyKeyParams) (gocql.UUID, error)
+	// ReadIdempotencyKeysByUser reads the idempotency keys of a user.
+	ReadIdempotencyKeysByUser(ctx context.Context, userID string) ([]IdempotencyKey, error)
+	// DeleteIdempotencyKey deletes an idempotency key.
+	DeleteIdempotencyKey(ctx context.Context, key IdempotencyKey) error
 	// ReadMessagesByRoom reads all messages from a room based on a roomID.
 	ReadMessagesByRoomID(ctx context.Context, roomID string) ([]Message, error)
+	// ReadMessagesByRoomIDPage reads one page of messages from a room.
+	ReadMessagesByRoomIDPage(ctx context.Context, params ReadMessagesPageParams) (MessagePage, error)
+	// ReadMessageByRoom reads a single message of a room.
+	ReadMessageByRoom(ctx context.Context, ref MessageRef) (Message, error)
+	// RewriteMessageByRoom rewrites an existing message with a new TTL.
+	RewriteMessageByRoom(ctx context.Context, ref MessageRef, ttl time.Duration) error
+	// DeleteMessageByRoom deletes a message from a room.
+	DeleteMessageByRoom(ctx context.Context, roomID string, id gocql.UUID) error
+	// ReadMessageRefsBySender reads references to all messages of a user.
+	ReadMessageRefsBySender(ctx context.Context, userID string) ([]MessageRef, error)
+	// AnonymiseMessageByRoom removes the sender identity from a message.
+	AnonymiseMessageByRoom(ctx context.Context, ref MessageRef, sender string) error
+	// DeleteMessageBySender deletes a message reference of a user.
+	DeleteMessageBySender(ctx context.Context, userID string, ref MessageRef) error
 }
 
 // ScyllaMessageRepository implements the MessagesRepository interface.
@@ -48,44 +87,114 @@ func (x *ScyllaMessageRepository) Session() *gocql.Session {
 // CreateMessageByRoomParams defines the parameters to create
 // a new message in a room.
 type CreateMessageByRoomParams struct {
+	// ID is the time based ID of the message. A new ID is generated
+	// if it is zero, set it to write a message idempotently.
+	ID        gocql.UUID
 	Data      []byte
 	Type      string
 	Sender    string
+	UserID    string
 	RoomID    string
 	Timestamp time.Time
+	// TTL expires the message after the given duration.
+	// Zero keeps the message forever.
+	TTL time.Duration
+	// Outbox, if set, is written with the message in the same logged
+	// batch, so that it is relayed even if publishing it fails.
+	Outbox *OutboxMessage
 }
 
 // CreateMessageByRoom creates a new entry in the MessageByRoom table.
+// Messages with a UserID are also referenced in the MessageBySender table.
 func (x *ScyllaMessageRepository) CreateMessageByRoom(
 	ctx context.Context,
 	params CreateMessageByRoomParams,
 ) error {
-	query := `INSERT INTO chat.message_by_room 
-              (id, data, type, sender, room_id, time) 
-              VALUES (?, ?, ?, ?, ?, ?)`
+	id := params.ID
+	if id == (gocql.UUID{}) {
+		id = gocql.UUIDFromTime(time.Now())
+	}
 
-	if err := x.session.Query(
-		query,
-		gocql.UUIDFromTime(time.Now()),
-		params.Data,
-		params.Type,
-		params.Sender,
-		params.RoomID,
-		params.Timestamp,
-	).WithContext(ctx).
-		Exec(); err != nil {
+	if err := x.writeMessage(ctx, "CreateMessageByRoom", Message{
+		ID:     id,
+		Data:   params.Data,
+		Type:   params.Type,
+		Sender: params.Sender,
+		UserID: params.UserID,
+		RoomID: params.RoomID,
+		Time:   params.Timestamp,
+	}, params.TTL, params.Outbox); err != nil {
 		return fmt.Errorf("message repo: creating message, %w", err)
 	}
 
 	return nil
 }
 
+// writeMessage inserts a message into the MessageByRoom table and,
+// if it has a UserID, the MessageBySender table in a logged batch.
+// A non-nil outbox message is added to the MessageOutbox table.
+// The method names the caller in the query metrics.
+func (x *ScyllaMessageRepository) writeMessage(
+	ctx context.Context,
+	method string,
+	message Message,
+	ttl time.Duration,
+	outbox *OutboxMessage,
+) error {
+	var userID *string
+	if message.UserID != "" {
+		userID = &message.UserID
+	}
+
+	batch := x.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Observer(metrics.Batch(method))
+	batch.Query(
+		`INSERT INTO chat.message_by_room 
+         (id, data, type, sender, user_id, room_id, time) 
+         VALUES (?, ?, ?, ?, ?, ?, ?) 
+         USING TTL ?`,
+		message.ID,
+		message.Data,
+		message.Type,
+		message.Sender,
+		userID,
+		message.RoomID,
+		message.Time,
+		ttlSeconds(ttl),
+	)
+	if userID != nil {
+		batch.Query(
+			`INSERT INTO chat.message_by_sender 
+             (user_id, room_id, id) 
+             VALUES (?, ?, ?) 
+             USING TTL ?`,
+			message.UserID,
+			message.RoomID,
+			message.ID,
+			ttlSeconds(ttl),
+		)
+	}
+	if outbox != nil {
+		batch.Query(
+			`INSERT INTO chat.message_outbox 
+             (shard, id, subject, data) 
+             VALUES (?, ?, ?, ?)`,
+			OutboxShard(message.RoomID),
+			message.ID,
+			outbox.Subject,
+			outbox.Data,
+		)
+	}
+
+	return x.session.ExecuteBatch(batch)
+}
+
 // ReadMessagesByRoomID reads all message entries from a room based on a roomID.
 func (x *ScyllaMessageRepository) ReadMessagesByRoomID(
 	ctx context.Context,
 	roomID string,
 ) ([]Message, error) {
-	query := `SELECT id, data, type, sender, room_id, time 
+	query := `SELECT id, data, type, sender, user_id, room_id, time 
               FROM chat.message_by_room 
               WHERE room_id = ?`
 
@@ -95,6 +204,7 @@ func (x *ScyllaMessageRepository) ReadMessagesByRoomID(
 		query,
 		roomID,
 	).WithContext(ctx).
+		Observer(metrics.Query("ReadMessagesByRoomID")).
 		Iter().
 		Scanner()
 
@@ -105,6 +215,7 @@ func (x *ScyllaMessageRepository) ReadMessagesByRoomID(
 			&message.Data,
 			&message.Type,
 			&message.Sender,
+			&message.UserID,
 			&message.RoomID,
 			&message.Time,
 		); err != nil {
@@ -119,3 +230,354 @@ func (x *ScyllaMessageRepository) ReadMessagesByRoomID(
 
 	return messages, nil
 }
+
+// DefaultPageSize is the page size used when none is given.
+const DefaultPageSize = 500
+
+// ReadMessagesPageParams defines the parameters to read
+// a page of messages from a room, oldest first.
+type ReadMessagesPageParams struct {
+	RoomID string
+	// From and To bound the message time, zero values are unbounded.
+	From time.Time
+	To   time.Time
+	// PageSize defaults to DefaultPageSize.
+	PageSize int
+	// PageState is the NextPageState of the previous page,
+	// nil for the first page.
+	PageState []byte
+}
+
+// MessagePage is a page of messages.
+type MessagePage struct {
+	Messages []Message
+	// NextPageState is nil on the last page.
+	NextPageState []byte
+}
+
+// ReadMessagesByRoomIDPage reads one page of messages from a room.
+// Message IDs are time based, so the time range is applied to the ID.
+func (x *ScyllaMessageRepository) ReadMessagesByRoomIDPage(
+	ctx context.Context,
+	params ReadMessagesPageParams,
+) (MessagePage, error) {
+	query := `SELECT id, data, type, sender, user_id, room_id, time 
+              FROM chat.message_by_room 
+              WHERE room_id = ?`
+	values := []any{params.RoomID}
+	if !params.From.IsZero() {
+		query += ` AND id >= ?`
+		values = append(values, gocql.MinTimeUUID(params.From))
+	}
+	if !params.To.IsZero() {
+		query += ` AND id <= ?`
+		values = append(values, gocql.MaxTimeUUID(params.To))
+	}
+
+	pageSize := params.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	iter := x.session.Query(query, values...).
+		WithContext(ctx).
+		Observer(metrics.Query("ReadMessagesByRoomIDPage")).
+		PageSize(pageSize).
+		PageState(params.PageState).
+		Iter()
+
+	page := MessagePage{Messages: make([]Message, 0, pageSize)}
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		var message Message
+		if err := scanner.Scan(
+			&message.ID,
+			&message.Data,
+			&message.Type,
+			&message.Sender,
+			&message.UserID,
+			&message.RoomID,
+			&message.Time,
+		); err != nil {
+			return MessagePage{}, fmt.Errorf("message repo: scanning message, %w", err)
+		}
+		page.Messages = append(page.Messages, message)
+	}
+	page.NextPageState = iter.PageState()
+
+	if err := scanner.Err(); err != nil {
+		return MessagePage{}, fmt.Errorf("message repo: scanner had errors, %w", err)
+	}
+	if len(page.NextPageState) == 0 {
+		page.NextPageState = nil
+	}
+
+	return page, nil
+}
+
+// ReadMessageByRoom reads a single message of a room.
+// It returns ErrMessageNotFound if the message does not exist.
+func (x *ScyllaMessageRepository) ReadMessageByRoom(
+	ctx context.Context,
+	ref MessageRef,
+) (Message, error) {
+	query := `SELECT id, data, type, sender, user_id, room_id, time 
+              FROM chat.message_by_room 
+              WHERE room_id = ? AND id = ?`
+
+	var message Message
+	if err := x.session.Query(
+		query,
+		ref.RoomID,
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadMessageByRoom")).
+		Scan(
+			&message.ID,
+			&message.Data,
+			&message.Type,
+			&message.Sender,
+			&message.UserID,
+			&message.RoomID,
+			&message.Time,
+		); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return Message{}, ErrMessageNotFound
+		}
+		return Message{}, fmt.Errorf("message repo: reading message, %w", err)
+	}
+
+	return message, nil
+}
+
+// rewriteAttempts bounds how often RewriteMessageByRoom re-reads a
+// message that changed between its read and its write.
+const rewriteAttempts = 3
+
+// RewriteMessageByRoom rewrites an existing message with a new TTL.
+// A zero TTL removes the expiry. The write only applies if the message
+// still exists with the sender it was read with, so that messages
+// deleted or anonymised in the meantime stay so. Messages that no
+// longer exist are skipped.
+func (x *ScyllaMessageRepository) RewriteMessageByRoom(
+	ctx context.Context,
+	ref MessageRef,
+	ttl time.Duration,
+) error {
+	for attempt := 0; attempt < rewriteAttempts; attempt++ {
+		message, err := x.ReadMessageByRoom(ctx, ref)
+		if err != nil {
+			if errors.Is(err, ErrMessageNotFound) {
+				return nil
+			}
+			return fmt.Errorf("message repo: rewriting message, %w", err)
+		}
+
+		var userID *string
+		if message.UserID != "" {
+			userID = &message.UserID
+		}
+		existing := make(map[string]any)
+		applied, err := x.session.Query(
+			`UPDATE chat.message_by_room 
+             USING TTL ? 
+             SET data = ?, type = ?, sender = ?, user_id = ?, time = ? 
+             WHERE room_id = ? AND id = ? 
+             IF sender = ? AND user_id = ?`,
+			ttlSeconds(ttl),
+			message.Data,
+			message.Type,
+			message.Sender,
+			userID,
+			message.Time,
+			ref.RoomID,
+			ref.ID,
+			message.Sender,
+			userID,
+		).WithContext(ctx).
+			Observer(metrics.Query("RewriteMessageByRoom")).
+			MapScanCAS(existing)
+		if err != nil {
+			return fmt.Errorf("message repo: rewriting message, %w", err)
+		}
+		if applied {
+			if userID == nil {
+				return nil
+			}
+			return x.rewriteMessageBySender(ctx, message, ttl)
+		}
+		// Deleted since it was read, or changed and read again.
+		if _, ok := existing["sender"]; !ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("message repo: rewriting message, %w", ErrMessageChanged)
+}
+
+// rewriteMessageBySender rewrites the sender index entry of a rewritten
+// message with its new TTL, and deletes it again if the message was
+// deleted or anonymised in the meantime.
+func (x *ScyllaMessageRepository) rewriteMessageBySender(
+	ctx context.Context,
+	message Message,
+	ttl time.Duration,
+) error {
+	if err := x.session.Query(
+		`INSERT INTO chat.message_by_sender 
+         (user_id, room_id, id) 
+         VALUES (?, ?, ?) 
+         USING TTL ?`,
+		message.UserID,
+		message.RoomID,
+		message.ID,
+		ttlSeconds(ttl),
+	).WithContext(ctx).
+		Observer(metrics.Query("RewriteMessageBySender")).
+		Exec(); err != nil {
+		return fmt.Errorf("message repo: rewriting message by sender, %w", err)
+	}
+
+	ref := MessageRef{RoomID: message.RoomID, ID: message.ID}
+	current, err := x.ReadMessageByRoom(ctx, ref)
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+	case err != nil:
+		return fmt.Errorf("message repo: rewriting message by sender, %w", err)
+	case current.UserID == message.UserID:
+		return nil
+	}
+	return x.DeleteMessageBySender(ctx, message.UserID, ref)
+}
+
+// DeleteMessageByRoom deletes a message from a room.
+func (x *ScyllaMessageRepository) DeleteMessageByRoom(
+	ctx context.Context,
+	roomID string,
+	id gocql.UUID,
+) error {
+	query := `DELETE FROM chat.message_by_room 
+              WHERE room_id = ? AND id = ?`
+
+	if err := x.session.Query(
+		query,
+		roomID,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteMessageByRoom")).
+		Exec(); err != nil {
+		return fmt.Errorf("message repo: deleting message, %w", err)
+	}
+
+	return nil
+}
+
+// ReadMessageRefsBySender reads references to all messages of a user.
+func (x *ScyllaMessageRepository) ReadMessageRefsBySender(
+	ctx context.Context,
+	userID string,
+) ([]MessageRef, error) {
+	query := `SELECT room_id, id 
+              FROM chat.message_by_sender 
+              WHERE user_id = ?`
+
+	refs := make([]MessageRef, 0)
+
+	scanner := x.session.Query(
+		query,
+		userID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadMessageRefsBySender")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		var ref MessageRef
+		if err := scanner.Scan(&ref.RoomID, &ref.ID); err != nil {
+			return nil, fmt.Errorf("message repo: scanning message ref, %w", err)
+		}
+		refs = append(refs, ref)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("message repo: scanner had errors, %w", err)
+	}
+
+	return refs, nil
+}
+
+// AnonymiseMessageByRoom replaces the sender of a message and clears its
+// user ID. The remaining TTL of the message is kept.
+func (x *ScyllaMessageRepository) AnonymiseMessageByRoom(
+	ctx context.Context,
+	ref MessageRef,
+	sender string,
+) error {
+	var ttl int
+	if err := x.session.Query(
+		`SELECT TTL(data) 
+         FROM chat.message_by_room 
+         WHERE room_id = ? AND id = ?`,
+		ref.RoomID,
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("AnonymiseMessageByRoom")).
+		Scan(&ttl); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("message repo: reading message ttl, %w", err)
+	}
+
+	if err := x.session.Query(
+		`UPDATE chat.message_by_room 
+         USING TTL ? 
+         SET sender = ?, user_id = null 
+         WHERE room_id = ? AND id = ?`,
+		ttl,
+		sender,
+		ref.RoomID,
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("AnonymiseMessageByRoom")).
+		Exec(); err != nil {
+		return fmt.Errorf("message repo: anonymising message, %w", err)
+	}
+
+	return nil
+}
+
+// DeleteMessageBySender deletes a message reference of a user.
+func (x *ScyllaMessageRepository) DeleteMessageBySender(
+	ctx context.Context,
+	userID string,
+	ref MessageRef,
+) error {
+	query := `DELETE FROM chat.message_by_sender 
+              WHERE user_id = ? AND room_id = ? AND id = ?`
+
+	if err := x.session.Query(
+		query,
+		userID,
+		ref.RoomID,
+		ref.ID,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteMessageBySender")).
+		Exec(); err != nil {
+		return fmt.Errorf("message repo: deleting message by sender, %w", err)
+	}
+
+	return nil
+}
+
+// ttlSeconds converts a TTL to whole seconds, rounding up
+// so that a positive TTL never becomes "no expiry".
+func ttlSeconds(ttl time.Duration) int {
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := int(ttl / time.Second)
+	if ttl%time.Second != 0 {
+		seconds++
+	}
+	return seconds
+}
@@ -147,3 +147,185 @@ func Test_ReadMessagesByRoomID(t *testing.T) {
 		require.Empty(t, messages)
 	})
 }
+
+func Test_MessageBySender(t *testing.T) {
+	ctx := context.Background()
+
+	t.Cleanup(func() {
+		for _, table := range []string{"chat.message_by_room", "chat.message_by_sender"} {
+			err := testMessageRepo.Session().Query("TRUNCATE " + table).Exec()
+			assert.NoError(t, err)
+		}
+	})
+
+	params := CreateMessageByRoomParams{
+		Data:      []byte("test"),
+		Type:      "text",
+		Sender:    "test_sender",
+		UserID:    uuid.NewString(),
+		RoomID:    uuid.NewString(),
+		Timestamp: time.Now().UTC(),
+	}
+	require.NoError(t, testMessageRepo.CreateMessageByRoom(ctx, params))
+	require.NoError(t, testMessageRepo.CreateMessageByRoom(ctx, params))
+
+	refs, err := testMessageRepo.ReadMessageRefsBySender(ctx, params.UserID)
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+
+	t.Run("Anonymise", func(t *testing.T) {
+		require.NoError(t, testMessageRepo.AnonymiseMessageByRoom(ctx, refs[0], "deleted user"))
+
+		messages, err := testMessageRepo.ReadMessagesByRoomID(ctx, params.RoomID)
+		require.NoError(t, err)
+		for _, m := range messages {
+			if m.ID == refs[0].ID {
+				assert.Equal(t, "deleted user", m.Sender)
+				assert.Empty(t, m.UserID)
+				assert.Equal(t, params.Data, m.Data)
+			} else {
+				assert.Equal(t, params.UserID, m.UserID)
+			}
+		}
+	})
+
+	t.Run("Rewrite", func(t *testing.T) {
+		// Erasure removes the reference of an anonymised message,
+		// rewriting it must neither restore the sender nor the reference.
+		require.NoError(t, testMessageRepo.DeleteMessageBySender(ctx, params.UserID, refs[0]))
+		for _, ref := range refs {
+			require.NoError(t, testMessageRepo.RewriteMessageByRoom(ctx, ref, time.Hour))
+		}
+		require.NoError(t, testMessageRepo.RewriteMessageByRoom(ctx, MessageRef{
+			RoomID: params.RoomID,
+			ID:     gocql.TimeUUID(),
+		}, time.Hour))
+
+		got, err := testMessageRepo.ReadMessageRefsBySender(ctx, params.UserID)
+		require.NoError(t, err)
+		require.Equal(t, refs[1:], got)
+		messages, err := testMessageRepo.ReadMessagesByRoomID(ctx, params.RoomID)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		for _, m := range messages {
+			if m.ID == refs[0].ID {
+				assert.Equal(t, "deleted user", m.Sender)
+				assert.Empty(t, m.UserID)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		for _, ref := range refs {
+			require.NoError(t, testMessageRepo.DeleteMessageByRoom(ctx, ref.RoomID, ref.ID))
+			require.NoError(t, testMessageRepo.DeleteMessageBySender(ctx, params.UserID, ref))
+		}
+
+		refs, err := testMessageRepo.ReadMessageRefsBySender(ctx, params.UserID)
+		require.NoError(t, err)
+		assert.Empty(t, refs)
+		messages, err := testMessageRepo.ReadMessagesByRoomID(ctx, params.RoomID)
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("Rewrite deleted", func(t *testing.T) {
+		for _, ref := range refs {
+			require.NoError(t, testMessageRepo.RewriteMessageByRoom(ctx, ref, time.Hour))
+		}
+
+		got, err := testMessageRepo.ReadMessageRefsBySender(ctx, params.UserID)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+		messages, err := testMessageRepo.ReadMessagesByRoomID(ctx, params.RoomID)
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+}
+
+func Test_ReadMessagesByRoomIDPage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Cleanup(func() {
+		for _, table := range []string{"chat.message_by_room", "chat.message_by_sender"} {
+			err := testMessageRepo.Session().Query("TRUNCATE " + table).Exec()
+			assert.NoError(t, err)
+		}
+	})
+
+	params := CreateMessageByRoomParams{
+		Data:   []byte("test"),
+		Type:   "text",
+		Sender: "test_sender",
+		RoomID: uuid.NewString(),
+	}
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		require.NoError(t, testMessageRepo.CreateMessageByRoom(ctx, params))
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		var (
+			messages []Message
+			state    []byte
+			pages    int
+		)
+		for {
+			page, err := testMessageRepo.ReadMessagesByRoomIDPage(ctx, ReadMessagesPageParams{
+				RoomID:    params.RoomID,
+				PageSize:  3,
+				PageState: state,
+			})
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Messages), 3)
+			messages = append(messages, page.Messages...)
+			pages++
+			if page.NextPageState == nil {
+				break
+			}
+			state = page.NextPageState
+		}
+		assert.Len(t, messages, 7)
+		assert.GreaterOrEqual(t, pages, 3)
+		for i := 1; i < len(messages); i++ {
+			assert.False(t, messages[i].ID.Time().Before(messages[i-1].ID.Time()))
+		}
+	})
+
+	t.Run("Time range", func(t *testing.T) {
+		page, err := testMessageRepo.ReadMessagesByRoomIDPage(ctx, ReadMessagesPageParams{
+			RoomID: params.RoomID,
+			To:     start.Add(-time.Minute),
+		})
+		require.NoError(t, err)
+		assert.Empty(t, page.Messages)
+		assert.Nil(t, page.NextPageState)
+	})
+}
+
+func Test_CreateMessageByRoom_HistoricalID(t *testing.T) {
+	ctx := context.Background()
+
+	t.Cleanup(func() {
+		err := testMessageRepo.Session().Query("TRUNCATE chat.message_by_room").Exec()
+		assert.NoError(t, err)
+	})
+
+	at := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	params := CreateMessageByRoomParams{
+		ID:        gocql.UUIDFromTime(at),
+		Data:      []byte("test"),
+		Type:      "text",
+		Sender:    "test_sender",
+		RoomID:    uuid.NewString(),
+		Timestamp: at,
+	}
+	require.NoError(t, testMessageRepo.CreateMessageByRoom(ctx, params))
+	require.NoError(t, testMessageRepo.CreateMessageByRoom(ctx, params))
+
+	messages, err := testMessageRepo.ReadMessagesByRoomID(ctx, params.RoomID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, params.ID, messages[0].ID)
+	assert.True(t, at.Equal(messages[0].Time))
+}
@@ -0,0 +1,167 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+var _ OutboxRepository = (*ScyllaOutboxRepository)(nil)
+
+// ErrOutboxEntryNotFound is returned when an outbox entry does not exist.
+var ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+
+// OutboxShards is the number of partitions of the MessageOutbox table.
+// Relays read every shard.
+const OutboxShards = 16
+
+// OutboxMessage is a broker message written with the row it announces.
+type OutboxMessage struct {
+	Subject string
+	Data    []byte
+}
+
+// OutboxEntry defines the message_outbox database model.
+type OutboxEntry struct {
+	Shard int
+	// ID is the ID of the message the entry was written with.
+	ID gocql.UUID
+	OutboxMessage
+}
+
+// ReadOutboxParams defines the parameters to read
+// the oldest entries of an outbox shard.
+type ReadOutboxParams struct {
+	Shard int
+	// Before excludes entries written at or after the given time.
+	Before time.Time
+	Limit  int
+}
+
+// OutboxRepository defines database methods to interact with the outbox.
+type OutboxRepository interface {
+	// ReadOutbox reads the oldest entries of a shard.
+	ReadOutbox(ctx context.Context, params ReadOutboxParams) ([]OutboxEntry, error)
+	// ReadOutboxEntry reads the entry of a message.
+	ReadOutboxEntry(ctx context.Context, shard int, id gocql.UUID) (OutboxEntry, error)
+	// DeleteOutboxEntry deletes an entry once it is relayed.
+	DeleteOutboxEntry(ctx context.Context, shard int, id gocql.UUID) error
+}
+
+// ScyllaOutboxRepository implements the OutboxRepository interface.
+type ScyllaOutboxRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaOutboxRepository creates a new ScyllaOutboxRepository.
+func NewScyllaOutboxRepository(session *gocql.Session) *ScyllaOutboxRepository {
+	return &ScyllaOutboxRepository{session: session}
+}
+
+// OutboxShard returns the outbox shard of a room.
+func OutboxShard(roomID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(roomID))
+	return int(h.Sum32() % OutboxShards)
+}
+
+// ReadOutbox reads the oldest entries of a shard.
+func (x *ScyllaOutboxRepository) ReadOutbox(
+	ctx context.Context,
+	params ReadOutboxParams,
+) ([]OutboxEntry, error) {
+	query := `SELECT id, subject, data 
+              FROM chat.message_outbox 
+              WHERE shard = ? AND id < ? 
+              LIMIT ?`
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	entries := make([]OutboxEntry, 0)
+
+	scanner := x.session.Query(
+		query,
+		params.Shard,
+		gocql.MinTimeUUID(params.Before),
+		limit,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadOutbox")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		entry := OutboxEntry{Shard: params.Shard}
+		if err := scanner.Scan(
+			&entry.ID,
+			&entry.Subject,
+			&entry.Data,
+		); err != nil {
+			_ = scanner.Err()
+			return nil, fmt.Errorf("outbox repo: scanning entry, %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("outbox repo: scanner had errors, %w", err)
+	}
+
+	return entries, nil
+}
+
+// ReadOutboxEntry reads the entry of a message.
+// It returns ErrOutboxEntryNotFound if the entry does not exist.
+func (x *ScyllaOutboxRepository) ReadOutboxEntry(
+	ctx context.Context,
+	shard int,
+	id gocql.UUID,
+) (OutboxEntry, error) {
+	query := `SELECT subject, data 
+              FROM chat.message_outbox 
+              WHERE shard = ? AND id = ?`
+
+	entry := OutboxEntry{Shard: shard, ID: id}
+	if err := x.session.Query(
+		query,
+		shard,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadOutboxEntry")).
+		Scan(&entry.Subject, &entry.Data); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return OutboxEntry{}, ErrOutboxEntryNotFound
+		}
+		return OutboxEntry{}, fmt.Errorf("outbox repo: reading entry, %w", err)
+	}
+
+	return entry, nil
+}
+
+// DeleteOutboxEntry deletes an entry once it is relayed.
+func (x *ScyllaOutboxRepository) DeleteOutboxEntry(
+	ctx context.Context,
+	shard int,
+	id gocql.UUID,
+) error {
+	query := `DELETE FROM chat.message_outbox WHERE shard = ? AND id = ?`
+
+	if err := x.session.Query(
+		query,
+		shard,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteOutboxEntry")).
+		Exec(); err != nil {
+		return fmt.Errorf("outbox repo: deleting entry, %w", err)
+	}
+
+	return nil
+}
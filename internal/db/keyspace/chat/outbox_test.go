@@ -0,0 +1,55 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Outbox(t *testing.T) {
+	ctx := context.Background()
+	roomID := uuid.NewString()
+	id := gocql.UUIDFromTime(time.Now().Add(-time.Minute))
+
+	err := testMessageRepo.CreateMessageByRoom(ctx, CreateMessageByRoomParams{
+		ID:     id,
+		Data:   []byte("hello"),
+		Type:   "text",
+		Sender: "sender",
+		RoomID: roomID,
+		Outbox: &OutboxMessage{Subject: "subject", Data: []byte("data")},
+	})
+	require.NoError(t, err)
+
+	find := func(before time.Time) *OutboxEntry {
+		entries, err := testOutboxRepo.ReadOutbox(ctx, ReadOutboxParams{
+			Shard:  OutboxShard(roomID),
+			Before: before,
+			Limit:  1000,
+		})
+		require.NoError(t, err)
+		for _, entry := range entries {
+			if entry.ID == id {
+				return &entry
+			}
+		}
+		return nil
+	}
+
+	require.Nil(t, find(time.Now().Add(-time.Hour)))
+	entry := find(time.Now())
+	require.NotNil(t, entry)
+	assert.Equal(t, "subject", entry.Subject)
+	assert.Equal(t, []byte("data"), entry.Data)
+
+	err = testOutboxRepo.DeleteOutboxEntry(ctx, entry.Shard, entry.ID)
+	require.NoError(t, err)
+	require.Nil(t, find(time.Now()))
+}
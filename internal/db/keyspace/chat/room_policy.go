@@ -0,0 +1,230 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+var _ RoomPolicyRepository = (*ScyllaRoomPolicyRepository)(nil)
+
+// ErrRoomPolicyNotFound is returned when a room has no policy.
+var ErrRoomPolicyNotFound = errors.New("room policy not found")
+
+// RoomPolicy defines the room_policy database model.
+type RoomPolicy struct {
+	RoomID string
+	// RetentionDays is nil when the room uses the default retention.
+	RetentionDays  *int
+	LegalHold      bool
+	ReapplyPending bool
+	UpdatedAt      time.Time
+}
+
+// RoomPolicyRepository defines database methods to interact with room policies.
+type RoomPolicyRepository interface {
+	// UpsertRoomPolicy creates or replaces the policy of a room.
+	UpsertRoomPolicy(ctx context.Context, params RoomPolicy) error
+	// ReadRoomPolicy reads the policy of a room.
+	ReadRoomPolicy(ctx context.Context, roomID string) (RoomPolicy, error)
+	// ReadPendingRoomPolicies reads all policies that need to be re-applied.
+	ReadPendingRoomPolicies(ctx context.Context) ([]RoomPolicy, error)
+	// MarkRoomPolicyApplied clears the re-apply flag of a room policy
+	// unless the policy was updated since updatedAt.
+	MarkRoomPolicyApplied(ctx context.Context, roomID string, updatedAt time.Time) error
+	// ClaimRoomPolicyLease claims the lease to re-apply the policy of a room.
+	ClaimRoomPolicyLease(ctx context.Context, roomID, owner string, ttl time.Duration) (bool, error)
+	// ReleaseRoomPolicyLease releases the lease of a room held by owner.
+	ReleaseRoomPolicyLease(ctx context.Context, roomID, owner string) error
+}
+
+// ScyllaRoomPolicyRepository implements the RoomPolicyRepository interface.
+type ScyllaRoomPolicyRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaRoomPolicyRepository creates a new ScyllaRoomPolicyRepository.
+func NewScyllaRoomPolicyRepository(session *gocql.Session) *ScyllaRoomPolicyRepository {
+	return &ScyllaRoomPolicyRepository{session: session}
+}
+
+// UpsertRoomPolicy creates or replaces the policy of a room.
+func (x *ScyllaRoomPolicyRepository) UpsertRoomPolicy(
+	ctx context.Context,
+	params RoomPolicy,
+) error {
+	query := `INSERT INTO chat.room_policy 
+              (room_id, retention_days, legal_hold, reapply_pending, updated_at) 
+              VALUES (?, ?, ?, ?, ?)`
+
+	if err := x.session.Query(
+		query,
+		params.RoomID,
+		params.RetentionDays,
+		params.LegalHold,
+		params.ReapplyPending,
+		params.UpdatedAt,
+	).WithContext(ctx).
+		Observer(metrics.Query("UpsertRoomPolicy")).
+		Exec(); err != nil {
+		return fmt.Errorf("room policy repo: upserting room policy, %w", err)
+	}
+
+	return nil
+}
+
+// ReadRoomPolicy reads the policy of a room.
+// It returns ErrRoomPolicyNotFound if the room has no policy.
+func (x *ScyllaRoomPolicyRepository) ReadRoomPolicy(
+	ctx context.Context,
+	roomID string,
+) (RoomPolicy, error) {
+	query := `SELECT room_id, retention_days, legal_hold, reapply_pending, updated_at 
+              FROM chat.room_policy 
+              WHERE room_id = ?`
+
+	var policy RoomPolicy
+	if err := x.session.Query(
+		query,
+		roomID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadRoomPolicy")).
+		Scan(
+			&policy.RoomID,
+			&policy.RetentionDays,
+			&policy.LegalHold,
+			&policy.ReapplyPending,
+			&policy.UpdatedAt,
+		); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return RoomPolicy{}, ErrRoomPolicyNotFound
+		}
+		return RoomPolicy{}, fmt.Errorf("room policy repo: reading room policy, %w", err)
+	}
+
+	return policy, nil
+}
+
+// ReadPendingRoomPolicies reads all policies that need to be re-applied.
+// Room policies are few, so the table is scanned and filtered here.
+func (x *ScyllaRoomPolicyRepository) ReadPendingRoomPolicies(
+	ctx context.Context,
+) ([]RoomPolicy, error) {
+	query := `SELECT room_id, retention_days, legal_hold, reapply_pending, updated_at 
+              FROM chat.room_policy`
+
+	policies := make([]RoomPolicy, 0)
+
+	scanner := x.session.Query(query).
+		WithContext(ctx).
+		Observer(metrics.Query("ReadPendingRoomPolicies")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		var policy RoomPolicy
+		if err := scanner.Scan(
+			&policy.RoomID,
+			&policy.RetentionDays,
+			&policy.LegalHold,
+			&policy.ReapplyPending,
+			&policy.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("room policy repo: scanning room policy, %w", err)
+		}
+		if policy.ReapplyPending {
+			policies = append(policies, policy)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("room policy repo: scanner had errors, %w", err)
+	}
+
+	return policies, nil
+}
+
+// MarkRoomPolicyApplied clears the re-apply flag of a room policy
+// unless the policy was updated since updatedAt.
+func (x *ScyllaRoomPolicyRepository) MarkRoomPolicyApplied(
+	ctx context.Context,
+	roomID string,
+	updatedAt time.Time,
+) error {
+	query := `UPDATE chat.room_policy 
+              SET reapply_pending = false 
+              WHERE room_id = ? 
+              IF updated_at = ?`
+
+	if err := x.session.Query(
+		query,
+		roomID,
+		updatedAt,
+	).WithContext(ctx).
+		Observer(metrics.Query("MarkRoomPolicyApplied")).
+		Exec(); err != nil {
+		return fmt.Errorf("room policy repo: marking room policy applied, %w", err)
+	}
+
+	return nil
+}
+
+// ClaimRoomPolicyLease claims the lease to re-apply the policy of a room
+// for ttl, so that one node at a time rewrites its messages. It reports
+// whether owner holds the lease, including when it held it already.
+func (x *ScyllaRoomPolicyRepository) ClaimRoomPolicyLease(
+	ctx context.Context,
+	roomID, owner string,
+	ttl time.Duration,
+) (bool, error) {
+	query := `INSERT INTO chat.room_policy_lease 
+              (room_id, owner) 
+              VALUES (?, ?) 
+              IF NOT EXISTS 
+              USING TTL ?`
+
+	existing := make(map[string]any)
+	applied, err := x.session.Query(
+		query,
+		roomID,
+		owner,
+		ttlSeconds(ttl),
+	).WithContext(ctx).
+		Observer(metrics.Query("ClaimRoomPolicyLease")).
+		MapScanCAS(existing)
+	if err != nil {
+		return false, fmt.Errorf("room policy repo: claiming room policy lease, %w", err)
+	}
+	if applied {
+		return true, nil
+	}
+
+	holder, _ := existing["owner"].(string)
+	return holder == owner, nil
+}
+
+// ReleaseRoomPolicyLease releases the lease of a room if owner holds it.
+func (x *ScyllaRoomPolicyRepository) ReleaseRoomPolicyLease(
+	ctx context.Context,
+	roomID, owner string,
+) error {
+	query := `DELETE FROM chat.room_policy_lease 
+              WHERE room_id = ? 
+              IF owner = ?`
+
+	if err := x.session.Query(
+		query,
+		roomID,
+		owner,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReleaseRoomPolicyLease")).
+		Exec(); err != nil {
+		return fmt.Errorf("room policy repo: releasing room policy lease, %w", err)
+	}
+
+	return nil
+}
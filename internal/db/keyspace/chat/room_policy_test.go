@@ -0,0 +1,81 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RoomPolicy(t *testing.T) {
+	ctx := context.Background()
+	days := 7
+
+	policy := RoomPolicy{
+		RoomID:         uuid.NewString(),
+		RetentionDays:  &days,
+		LegalHold:      true,
+		ReapplyPending: true,
+		UpdatedAt:      time.Now().UTC().Truncate(time.Millisecond),
+	}
+	err := testRoomPolicyRepo.UpsertRoomPolicy(ctx, policy)
+	require.NoError(t, err)
+
+	got, err := testRoomPolicyRepo.ReadRoomPolicy(ctx, policy.RoomID)
+	require.NoError(t, err)
+	require.NotNil(t, got.RetentionDays)
+	assert.Equal(t, days, *got.RetentionDays)
+	assert.True(t, got.LegalHold)
+
+	pending, err := testRoomPolicyRepo.ReadPendingRoomPolicies(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, pending)
+
+	err = testRoomPolicyRepo.MarkRoomPolicyApplied(ctx, policy.RoomID, policy.UpdatedAt)
+	require.NoError(t, err)
+	got, err = testRoomPolicyRepo.ReadRoomPolicy(ctx, policy.RoomID)
+	require.NoError(t, err)
+	assert.False(t, got.ReapplyPending)
+
+	t.Run("Default retention is nil", func(t *testing.T) {
+		policy := RoomPolicy{RoomID: uuid.NewString(), UpdatedAt: time.Now().UTC()}
+		require.NoError(t, testRoomPolicyRepo.UpsertRoomPolicy(ctx, policy))
+
+		got, err := testRoomPolicyRepo.ReadRoomPolicy(ctx, policy.RoomID)
+		require.NoError(t, err)
+		assert.Nil(t, got.RetentionDays)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		_, err := testRoomPolicyRepo.ReadRoomPolicy(ctx, uuid.NewString())
+		require.ErrorIs(t, err, ErrRoomPolicyNotFound)
+	})
+
+	t.Run("Lease", func(t *testing.T) {
+		roomID := uuid.NewString()
+		ok, err := testRoomPolicyRepo.ClaimRoomPolicyLease(ctx, roomID, "node-a", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = testRoomPolicyRepo.ClaimRoomPolicyLease(ctx, roomID, "node-a", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = testRoomPolicyRepo.ClaimRoomPolicyLease(ctx, roomID, "node-b", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// Only the holder releases the lease.
+		require.NoError(t, testRoomPolicyRepo.ReleaseRoomPolicyLease(ctx, roomID, "node-b"))
+		ok, err = testRoomPolicyRepo.ClaimRoomPolicyLease(ctx, roomID, "node-b", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		require.NoError(t, testRoomPolicyRepo.ReleaseRoomPolicyLease(ctx, roomID, "node-a"))
+		ok, err = testRoomPolicyRepo.ClaimRoomPolicyLease(ctx, roomID, "node-b", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
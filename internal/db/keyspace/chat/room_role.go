@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+var _ RoomRoleRepository = (*ScyllaRoomRoleRepository)(nil)
+
+// RoomRole defines the room_role database model.
+// It is what a user may do in a room, shared by every node.
+type RoomRole struct {
+	UserID    string
+	RoomID    string
+	Moderator bool
+	Muted     bool
+}
+
+// RoomRoleRepository defines database methods to interact with room roles.
+type RoomRoleRepository interface {
+	// ReadRoomRole reads the role of a user in a room.
+	// A user without a role gets the zero role.
+	ReadRoomRole(ctx context.Context, roomID, userID string) (RoomRole, error)
+	// ReadRoomRolesByUser reads the roles of a user in every room.
+	ReadRoomRolesByUser(ctx context.Context, userID string) ([]RoomRole, error)
+	// SetRoomModerator grants or revokes moderation of a room.
+	SetRoomModerator(ctx context.Context, roomID, userID string, moderator bool) error
+	// SetRoomMuted mutes or unmutes a user in a room.
+	SetRoomMuted(ctx context.Context, roomID, userID string, muted bool) error
+	// DeleteRoomRolesByUser deletes the roles of a user in every room.
+	DeleteRoomRolesByUser(ctx context.Context, userID string) error
+}
+
+// ScyllaRoomRoleRepository implements the RoomRoleRepository interface.
+// Roles are partitioned by user, so that erasing a user deletes
+// a single partition.
+type ScyllaRoomRoleRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaRoomRoleRepository creates a new ScyllaRoomRoleRepository.
+func NewScyllaRoomRoleRepository(session *gocql.Session) *ScyllaRoomRoleRepository {
+	return &ScyllaRoomRoleRepository{session: session}
+}
+
+// ReadRoomRole reads the role of a user in a room.
+// A user without a role gets the zero role.
+func (x *ScyllaRoomRoleRepository) ReadRoomRole(
+	ctx context.Context,
+	roomID, userID string,
+) (RoomRole, error) {
+	query := `SELECT moderator, muted 
+              FROM chat.room_role 
+              WHERE user_id = ? AND room_id = ?`
+
+	role := RoomRole{UserID: userID, RoomID: roomID}
+	if err := x.session.Query(
+		query,
+		userID,
+		roomID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadRoomRole")).
+		Scan(&role.Moderator, &role.Muted); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return role, nil
+		}
+		return RoomRole{}, fmt.Errorf("room role repo: reading room role, %w", err)
+	}
+
+	return role, nil
+}
+
+// ReadRoomRolesByUser reads the roles of a user in every room.
+func (x *ScyllaRoomRoleRepository) ReadRoomRolesByUser(
+	ctx context.Context,
+	userID string,
+) ([]RoomRole, error) {
+	query := `SELECT user_id, room_id, moderator, muted 
+              FROM chat.room_role 
+              WHERE user_id = ?`
+
+	roles := make([]RoomRole, 0)
+
+	scanner := x.session.Query(
+		query,
+		userID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadRoomRolesByUser")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		var role RoomRole
+		if err := scanner.Scan(&role.UserID, &role.RoomID, &role.Moderator, &role.Muted); err != nil {
+			return nil, fmt.Errorf("room role repo: scanning room role, %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("room role repo: scanner had errors, %w", err)
+	}
+
+	return roles, nil
+}
+
+// SetRoomModerator grants or revokes moderation of a room.
+// The mute of the user is left as it is.
+func (x *ScyllaRoomRoleRepository) SetRoomModerator(
+	ctx context.Context,
+	roomID, userID string,
+	moderator bool,
+) error {
+	query := `UPDATE chat.room_role 
+              SET moderator = ? 
+              WHERE user_id = ? AND room_id = ?`
+
+	if err := x.session.Query(
+		query,
+		moderator,
+		userID,
+		roomID,
+	).WithContext(ctx).
+		Observer(metrics.Query("SetRoomModerator")).
+		Exec(); err != nil {
+		return fmt.Errorf("room role repo: setting room moderator, %w", err)
+	}
+
+	return nil
+}
+
+// SetRoomMuted mutes or unmutes a user in a room.
+// The moderation rights of the user are left as they are.
+func (x *ScyllaRoomRoleRepository) SetRoomMuted(
+	ctx context.Context,
+	roomID, userID string,
+	muted bool,
+) error {
+	query := `UPDATE chat.room_role 
+              SET muted = ? 
+              WHERE user_id = ? AND room_id = ?`
+
+	if err := x.session.Query(
+		query,
+		muted,
+		userID,
+		roomID,
+	).WithContext(ctx).
+		Observer(metrics.Query("SetRoomMuted")).
+		Exec(); err != nil {
+		return fmt.Errorf("room role repo: setting room mute, %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRoomRolesByUser deletes the roles of a user in every room.
+func (x *ScyllaRoomRoleRepository) DeleteRoomRolesByUser(
+	ctx context.Context,
+	userID string,
+) error {
+	query := `DELETE FROM chat.room_role 
+              WHERE user_id = ?`
+
+	if err := x.session.Query(
+		query,
+		userID,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteRoomRolesByUser")).
+		Exec(); err != nil {
+		return fmt.Errorf("room role repo: deleting room roles, %w", err)
+	}
+
+	return nil
+}
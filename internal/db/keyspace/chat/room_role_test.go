@@ -0,0 +1,45 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RoomRole(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.NewString()
+	roomID := uuid.NewString()
+
+	role, err := testRoomRoleRepo.ReadRoomRole(ctx, roomID, userID)
+	require.NoError(t, err)
+	require.Equal(t, RoomRole{UserID: userID, RoomID: roomID}, role)
+
+	require.NoError(t, testRoomRoleRepo.SetRoomModerator(ctx, roomID, userID, true))
+	require.NoError(t, testRoomRoleRepo.SetRoomMuted(ctx, roomID, userID, true))
+	require.NoError(t, testRoomRoleRepo.SetRoomMuted(ctx, uuid.NewString(), userID, true))
+
+	role, err = testRoomRoleRepo.ReadRoomRole(ctx, roomID, userID)
+	require.NoError(t, err)
+	require.True(t, role.Moderator)
+	require.True(t, role.Muted)
+
+	require.NoError(t, testRoomRoleRepo.SetRoomMuted(ctx, roomID, userID, false))
+	role, err = testRoomRoleRepo.ReadRoomRole(ctx, roomID, userID)
+	require.NoError(t, err)
+	require.True(t, role.Moderator)
+	require.False(t, role.Muted)
+
+	roles, err := testRoomRoleRepo.ReadRoomRolesByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+
+	require.NoError(t, testRoomRoleRepo.DeleteRoomRolesByUser(ctx, userID))
+	roles, err = testRoomRoleRepo.ReadRoomRolesByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Empty(t, roles)
+}
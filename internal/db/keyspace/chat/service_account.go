@@ -0,0 +1,279 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+var _ ServiceAccountRepository = (*ScyllaServiceAccountRepository)(nil)
+
+var (
+	// ErrServiceAccountNotFound is returned when a service account does not exist.
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	// ErrAPIKeyNotFound is returned when an API key does not exist.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// ServiceAccount defines the service_account database model.
+type ServiceAccount struct {
+	ID        gocql.UUID
+	Name      string
+	CreatedAt time.Time
+}
+
+// APIKey defines the api_key database model.
+// Only the hash of the secret is stored.
+type APIKey struct {
+	ID         gocql.UUID
+	AccountID  gocql.UUID
+	Hash       []byte
+	Scopes     []string
+	Rooms      []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// ServiceAccountRepository defines database methods to interact
+// with service accounts and their API keys.
+type ServiceAccountRepository interface {
+	// CreateServiceAccount creates an entry in the chat.service_account table.
+	CreateServiceAccount(ctx context.Context, params ServiceAccount) error
+	// ReadServiceAccount reads a service account by its ID.
+	ReadServiceAccount(ctx context.Context, id gocql.UUID) (ServiceAccount, error)
+	// CreateAPIKey creates an API key for a service account.
+	CreateAPIKey(ctx context.Context, params APIKey) error
+	// ReadAPIKey reads an API key by its ID.
+	ReadAPIKey(ctx context.Context, id gocql.UUID) (APIKey, error)
+	// ReadAPIKeysByAccount reads all API keys of a service account.
+	ReadAPIKeysByAccount(ctx context.Context, accountID gocql.UUID) ([]APIKey, error)
+	// TouchAPIKey sets the last used time of an API key.
+	TouchAPIKey(ctx context.Context, id gocql.UUID, at time.Time) error
+	// RevokeAPIKey sets the revocation time of an API key.
+	RevokeAPIKey(ctx context.Context, id gocql.UUID, at time.Time) error
+}
+
+// ScyllaServiceAccountRepository implements the ServiceAccountRepository interface.
+type ScyllaServiceAccountRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaServiceAccountRepository creates a new ScyllaServiceAccountRepository.
+func NewScyllaServiceAccountRepository(session *gocql.Session) *ScyllaServiceAccountRepository {
+	return &ScyllaServiceAccountRepository{session: session}
+}
+
+// CreateServiceAccount creates an entry in the chat.service_account table.
+func (x *ScyllaServiceAccountRepository) CreateServiceAccount(
+	ctx context.Context,
+	params ServiceAccount,
+) error {
+	query := `INSERT INTO chat.service_account 
+              (id, name, created_at) 
+              VALUES (?, ?, ?)`
+
+	if err := x.session.Query(
+		query,
+		params.ID,
+		params.Name,
+		params.CreatedAt,
+	).WithContext(ctx).
+		Observer(metrics.Query("CreateServiceAccount")).
+		Exec(); err != nil {
+		return fmt.Errorf("service account repo: creating service account, %w", err)
+	}
+
+	return nil
+}
+
+// ReadServiceAccount reads a service account by its ID.
+// It returns ErrServiceAccountNotFound if the account does not exist.
+func (x *ScyllaServiceAccountRepository) ReadServiceAccount(
+	ctx context.Context,
+	id gocql.UUID,
+) (ServiceAccount, error) {
+	query := `SELECT id, name, created_at 
+              FROM chat.service_account 
+              WHERE id = ?`
+
+	var account ServiceAccount
+	if err := x.session.Query(
+		query,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadServiceAccount")).
+		Scan(&account.ID, &account.Name, &account.CreatedAt); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return ServiceAccount{}, ErrServiceAccountNotFound
+		}
+		return ServiceAccount{}, fmt.Errorf("service account repo: reading service account, %w", err)
+	}
+
+	return account, nil
+}
+
+// CreateAPIKey creates entries in the chat.api_key and
+// chat.api_key_by_account tables.
+func (x *ScyllaServiceAccountRepository) CreateAPIKey(
+	ctx context.Context,
+	params APIKey,
+) error {
+	batch := x.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	batch.Observer(metrics.Batch("CreateAPIKey"))
+	batch.Query(
+		`INSERT INTO chat.api_key 
+         (id, account_id, hash, scopes, rooms, created_at) 
+         VALUES (?, ?, ?, ?, ?, ?)`,
+		params.ID,
+		params.AccountID,
+		params.Hash,
+		params.Scopes,
+		params.Rooms,
+		params.CreatedAt,
+	)
+	batch.Query(
+		`INSERT INTO chat.api_key_by_account 
+         (account_id, id) 
+         VALUES (?, ?)`,
+		params.AccountID,
+		params.ID,
+	)
+
+	if err := x.session.ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("service account repo: creating api key, %w", err)
+	}
+
+	return nil
+}
+
+// ReadAPIKey reads an API key by its ID.
+// It returns ErrAPIKeyNotFound if the key does not exist.
+func (x *ScyllaServiceAccountRepository) ReadAPIKey(
+	ctx context.Context,
+	id gocql.UUID,
+) (APIKey, error) {
+	query := `SELECT id, account_id, hash, scopes, rooms, created_at, last_used_at, revoked_at 
+              FROM chat.api_key 
+              WHERE id = ?`
+
+	var key APIKey
+	if err := x.session.Query(
+		query,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadAPIKey")).
+		Scan(
+			&key.ID,
+			&key.AccountID,
+			&key.Hash,
+			&key.Scopes,
+			&key.Rooms,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.RevokedAt,
+		); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return APIKey{}, ErrAPIKeyNotFound
+		}
+		return APIKey{}, fmt.Errorf("service account repo: reading api key, %w", err)
+	}
+
+	return key, nil
+}
+
+// ReadAPIKeysByAccount reads all API keys of a service account.
+func (x *ScyllaServiceAccountRepository) ReadAPIKeysByAccount(
+	ctx context.Context,
+	accountID gocql.UUID,
+) ([]APIKey, error) {
+	query := `SELECT id 
+              FROM chat.api_key_by_account 
+              WHERE account_id = ?`
+
+	keys := make([]APIKey, 0)
+
+	scanner := x.session.Query(
+		query,
+		accountID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadAPIKeysByAccount")).
+		Iter().
+		Scanner()
+
+	var ids []gocql.UUID
+	for scanner.Next() {
+		var id gocql.UUID
+		if err := scanner.Scan(&id); err != nil {
+			return nil, fmt.Errorf("service account repo: scanning api key id, %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("service account repo: scanner had errors, %w", err)
+	}
+
+	for _, id := range ids {
+		key, err := x.ReadAPIKey(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// TouchAPIKey sets the last used time of an API key.
+func (x *ScyllaServiceAccountRepository) TouchAPIKey(
+	ctx context.Context,
+	id gocql.UUID,
+	at time.Time,
+) error {
+	query := `UPDATE chat.api_key 
+              SET last_used_at = ? 
+              WHERE id = ?`
+
+	if err := x.session.Query(
+		query,
+		at,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("TouchAPIKey")).
+		Exec(); err != nil {
+		return fmt.Errorf("service account repo: touching api key, %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAPIKey sets the revocation time of an API key.
+func (x *ScyllaServiceAccountRepository) RevokeAPIKey(
+	ctx context.Context,
+	id gocql.UUID,
+	at time.Time,
+) error {
+	query := `UPDATE chat.api_key 
+              SET revoked_at = ? 
+              WHERE id = ? IF EXISTS`
+
+	if err := x.session.Query(
+		query,
+		at,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("RevokeAPIKey")).
+		Exec(); err != nil {
+		return fmt.Errorf("service account repo: revoking api key, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ServiceAccount(t *testing.T) {
+	ctx := context.Background()
+
+	account := ServiceAccount{
+		ID:        gocql.TimeUUID(),
+		Name:      "bot",
+		CreatedAt: time.Now().UTC(),
+	}
+	err := testServiceAccountRepo.CreateServiceAccount(ctx, account)
+	require.NoError(t, err)
+
+	t.Run("Read service account", func(t *testing.T) {
+		got, err := testServiceAccountRepo.ReadServiceAccount(ctx, account.ID)
+		require.NoError(t, err)
+		assert.Equal(t, account.Name, got.Name)
+	})
+
+	t.Run("Service account not found", func(t *testing.T) {
+		_, err := testServiceAccountRepo.ReadServiceAccount(ctx, gocql.TimeUUID())
+		require.ErrorIs(t, err, ErrServiceAccountNotFound)
+	})
+
+	t.Run("Create, touch and revoke api key", func(t *testing.T) {
+		key := APIKey{
+			ID:        gocql.TimeUUID(),
+			AccountID: account.ID,
+			Hash:      []byte("hash"),
+			Scopes:    []string{"rooms:read", "rooms:post"},
+			Rooms:     []string{"room"},
+			CreatedAt: time.Now().UTC(),
+		}
+		err := testServiceAccountRepo.CreateAPIKey(ctx, key)
+		require.NoError(t, err)
+
+		keys, err := testServiceAccountRepo.ReadAPIKeysByAccount(ctx, account.ID)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, key.Scopes, keys[0].Scopes)
+		assert.Equal(t, key.Rooms, keys[0].Rooms)
+		assert.True(t, keys[0].RevokedAt.IsZero())
+
+		now := time.Now().UTC()
+		require.NoError(t, testServiceAccountRepo.TouchAPIKey(ctx, key.ID, now))
+		require.NoError(t, testServiceAccountRepo.RevokeAPIKey(ctx, key.ID, now))
+
+		got, err := testServiceAccountRepo.ReadAPIKey(ctx, key.ID)
+		require.NoError(t, err)
+		assert.False(t, got.LastUsedAt.IsZero())
+		assert.False(t, got.RevokedAt.IsZero())
+	})
+}
@@ -2,11 +2,15 @@ package chat
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/Salam4nder/chat/internal/metrics"
 	"github.com/gocql/gocql"
 	"github.com/rs/zerolog/log"
 )
 
+var _ UserRepository = (*ScyllaUserRepository)(nil)
+
 // UserInRoom defines the user_in_room database model.
 type UserInRoom struct {
 	UserID gocql.UUID
@@ -52,6 +56,7 @@ func (x *ScyllaUserRepository) CreateUserInRoom(
 		params.UserID,
 		params.RoomID,
 	).WithContext(ctx).
+		Observer(metrics.Query("CreateUserInRoom")).
 		Exec(); err != nil {
 		log.Error().Err(err).Msg("message: creating user in room")
 		return err
@@ -60,6 +65,40 @@ func (x *ScyllaUserRepository) CreateUserInRoom(
 	return nil
 }
 
+// ReadRoomsByUser reads all the rooms a user is in.
+func (x *ScyllaUserRepository) ReadRoomsByUser(
+	ctx context.Context,
+	userID gocql.UUID,
+) ([]UserInRoom, error) {
+	query := `SELECT user_id, room_id 
+              FROM chat.user_in_room 
+              WHERE user_id = ?`
+
+	rooms := make([]UserInRoom, 0)
+
+	scanner := x.session.Query(
+		query,
+		userID,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadRoomsByUser")).
+		Iter().
+		Scanner()
+
+	for scanner.Next() {
+		var room UserInRoom
+		if err := scanner.Scan(&room.UserID, &room.RoomID); err != nil {
+			return nil, fmt.Errorf("user repo: scanning user in room, %w", err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("user repo: scanner had errors, %w", err)
+	}
+
+	return rooms, nil
+}
+
 // DeleteUserInRoom deletes an entry in the chat.user_in_room table.
 func (x *ScyllaUserRepository) DeleteUserInRoom(
 	ctx context.Context,
@@ -73,6 +112,7 @@ func (x *ScyllaUserRepository) DeleteUserInRoom(
 		params.UserID,
 		params.RoomID,
 	).WithContext(ctx).
+		Observer(metrics.Query("DeleteUserInRoom")).
 		Exec(); err != nil {
 		log.Error().Err(err).Msg("message: deleting user in room")
 		return err
@@ -22,3 +22,23 @@ func Test_CreateUserInRoom(t *testing.T) {
 	err := testUserRepo.CreateUserInRoom(context.Background(), params)
 	require.NoError(t, err)
 }
+
+func Test_ReadRoomsByUser(t *testing.T) {
+	ctx := context.Background()
+	userID := gocql.TimeUUID()
+
+	for i := 0; i < 3; i++ {
+		err := testUserRepo.CreateUserInRoom(ctx, UserInRoom{
+			UserID: userID,
+			RoomID: gocql.TimeUUID(),
+		})
+		require.NoError(t, err)
+	}
+
+	rooms, err := testUserRepo.ReadRoomsByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, rooms, 3)
+	for _, room := range rooms {
+		require.Equal(t, userID, room.UserID)
+	}
+}
@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/gocql/gocql"
+)
+
+var _ WebhookRepository = (*ScyllaWebhookRepository)(nil)
+
+// ErrWebhookNotFound is returned when a webhook does not exist.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook defines the webhook database model.
+type Webhook struct {
+	ID        gocql.UUID
+	RoomID    string
+	Name      string
+	TokenHash []byte
+	CreatedAt time.Time
+}
+
+// WebhookRepository defines database methods to interact with incoming webhooks.
+type WebhookRepository interface {
+	// CreateWebhook creates a new entry in the chat.webhook table.
+	CreateWebhook(ctx context.Context, params Webhook) error
+	// ReadWebhook reads a webhook by its ID.
+	ReadWebhook(ctx context.Context, id gocql.UUID) (Webhook, error)
+	// DeleteWebhook deletes a webhook by its ID.
+	DeleteWebhook(ctx context.Context, id gocql.UUID) error
+}
+
+// ScyllaWebhookRepository implements the WebhookRepository interface.
+type ScyllaWebhookRepository struct {
+	session *gocql.Session
+}
+
+// NewScyllaWebhookRepository creates a new ScyllaWebhookRepository.
+func NewScyllaWebhookRepository(session *gocql.Session) *ScyllaWebhookRepository {
+	return &ScyllaWebhookRepository{session: session}
+}
+
+// CreateWebhook creates a new entry in the chat.webhook table.
+func (x *ScyllaWebhookRepository) CreateWebhook(
+	ctx context.Context,
+	params Webhook,
+) error {
+	query := `INSERT INTO chat.webhook 
+              (id, room_id, name, token_hash, created_at) 
+              VALUES (?, ?, ?, ?, ?)`
+
+	if err := x.session.Query(
+		query,
+		params.ID,
+		params.RoomID,
+		params.Name,
+		params.TokenHash,
+		params.CreatedAt,
+	).WithContext(ctx).
+		Observer(metrics.Query("CreateWebhook")).
+		Exec(); err != nil {
+		return fmt.Errorf("webhook repo: creating webhook, %w", err)
+	}
+
+	return nil
+}
+
+// ReadWebhook reads a webhook by its ID.
+// It returns ErrWebhookNotFound if the webhook does not exist.
+func (x *ScyllaWebhookRepository) ReadWebhook(
+	ctx context.Context,
+	id gocql.UUID,
+) (Webhook, error) {
+	query := `SELECT id, room_id, name, token_hash, created_at 
+              FROM chat.webhook 
+              WHERE id = ?`
+
+	var webhook Webhook
+	if err := x.session.Query(
+		query,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("ReadWebhook")).
+		Scan(
+			&webhook.ID,
+			&webhook.RoomID,
+			&webhook.Name,
+			&webhook.TokenHash,
+			&webhook.CreatedAt,
+		); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return Webhook{}, ErrWebhookNotFound
+		}
+		return Webhook{}, fmt.Errorf("webhook repo: reading webhook, %w", err)
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook by its ID.
+func (x *ScyllaWebhookRepository) DeleteWebhook(
+	ctx context.Context,
+	id gocql.UUID,
+) error {
+	query := `DELETE FROM chat.webhook 
+              WHERE id = ?`
+
+	if err := x.session.Query(
+		query,
+		id,
+	).WithContext(ctx).
+		Observer(metrics.Query("DeleteWebhook")).
+		Exec(); err != nil {
+		return fmt.Errorf("webhook repo: deleting webhook, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build testdb
+
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Webhook(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Create, read and delete", func(t *testing.T) {
+		params := Webhook{
+			ID:        gocql.TimeUUID(),
+			RoomID:    uuid.NewString(),
+			Name:      "ci",
+			TokenHash: []byte("hash"),
+			CreatedAt: time.Now().UTC(),
+		}
+
+		err := testWebhookRepo.CreateWebhook(ctx, params)
+		require.NoError(t, err)
+
+		webhook, err := testWebhookRepo.ReadWebhook(ctx, params.ID)
+		require.NoError(t, err)
+		assert.Equal(t, params.ID, webhook.ID)
+		assert.Equal(t, params.RoomID, webhook.RoomID)
+		assert.Equal(t, params.Name, webhook.Name)
+		assert.Equal(t, params.TokenHash, webhook.TokenHash)
+
+		err = testWebhookRepo.DeleteWebhook(ctx, params.ID)
+		require.NoError(t, err)
+
+		_, err = testWebhookRepo.ReadWebhook(ctx, params.ID)
+		require.ErrorIs(t, err, ErrWebhookNotFound)
+	})
+}
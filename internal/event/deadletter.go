@@ -0,0 +1,206 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/google/uuid"
+)
+
+// DefaultDeadLetterCapacity is used when no capacity is configured.
+const DefaultDeadLetterCapacity = 1000
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrHandlerUnknown     = errors.New("event handler unknown")
+)
+
+// DeadLetter is an event whose handler failed for good.
+type DeadLetter struct {
+	ID      string `json:"id"`
+	Handler string `json:"handler"`
+	Event   string `json:"event"`
+	EventID string `json:"eventID"`
+	Key     string `json:"key,omitempty"`
+	// Payload is the JSON encoding of the event payload, or its
+	// type if it cannot be encoded.
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error"`
+	Replays   int             `json:"replays"`
+	FailedAt  time.Time       `json:"failedAt"`
+	OccuredAt time.Time       `json:"occuredAt"`
+
+	event Event
+}
+
+// DeadLetters is an in-memory store of dead letters, bounded by
+// dropping the oldest. Operators inspect and replay them.
+type DeadLetters struct {
+	capacity int
+
+	mu       sync.Mutex
+	letters  []DeadLetter
+	handlers map[string]Handler
+}
+
+// NewDeadLetters returns an empty store that keeps up to capacity letters.
+func NewDeadLetters(capacity int) *DeadLetters {
+	if capacity <= 0 {
+		capacity = DefaultDeadLetterCapacity
+	}
+	return &DeadLetters{
+		capacity: capacity,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Middleware stores the events the named handler fails on, after
+// the middleware it wraps, typically Retry, gave up. The handler it
+// wraps is the one Replay runs.
+func (x *DeadLetters) Middleware(name string) Middleware {
+	return func(next Handler) Handler {
+		x.mu.Lock()
+		x.handlers[name] = next
+		x.mu.Unlock()
+
+		return func(evt Event) error {
+			err := next(evt)
+			if err != nil {
+				x.add(name, evt, err)
+			}
+			return err
+		}
+	}
+}
+
+// List returns the dead letters, oldest first.
+func (x *DeadLetters) List() []DeadLetter {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	letters := make([]DeadLetter, len(x.letters))
+	copy(letters, x.letters)
+	return letters
+}
+
+// Get returns the dead letter with the given ID.
+func (x *DeadLetters) Get(id string) (DeadLetter, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	i := x.index(id)
+	if i < 0 {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return x.letters[i], nil
+}
+
+// Delete removes the dead letter with the given ID.
+func (x *DeadLetters) Delete(id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	i := x.index(id)
+	if i < 0 {
+		return ErrDeadLetterNotFound
+	}
+	x.letters = append(x.letters[:i], x.letters[i+1:]...)
+	return nil
+}
+
+// Purge removes the dead letters whose event matches and returns how
+// many it removed. Their payloads are dropped with them.
+func (x *DeadLetters) Purge(match func(Event) bool) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	letters := x.letters[:0]
+	for _, letter := range x.letters {
+		if !match(letter.event) {
+			letters = append(letters, letter)
+		}
+	}
+	n := len(x.letters) - len(letters)
+	for i := len(letters); i < len(x.letters); i++ {
+		x.letters[i] = DeadLetter{}
+	}
+	x.letters = letters
+	return n
+}
+
+// Replay runs the handler of a dead letter again with ctx. The letter is
+// removed if the handler succeeds and keeps the new error otherwise.
+func (x *DeadLetters) Replay(ctx context.Context, id string) (DeadLetter, error) {
+	x.mu.Lock()
+	i := x.index(id)
+	if i < 0 {
+		x.mu.Unlock()
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	letter := x.letters[i]
+	handler, ok := x.handlers[letter.Handler]
+	x.mu.Unlock()
+	if !ok {
+		return letter, fmt.Errorf("%w: %s", ErrHandlerUnknown, letter.Handler)
+	}
+
+	err := handler(letter.event.WithContext(ctx))
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if i = x.index(id); i < 0 {
+		return letter, err
+	}
+	if err == nil {
+		x.letters = append(x.letters[:i], x.letters[i+1:]...)
+		return letter, nil
+	}
+	x.letters[i].Replays++
+	x.letters[i].Error = err.Error()
+	x.letters[i].FailedAt = time.Now().UTC()
+	return x.letters[i], err
+}
+
+func (x *DeadLetters) add(handler string, evt Event, err error) {
+	metrics.DeadLetter(evt.Name)
+
+	payload, encErr := json.Marshal(evt.Payload)
+	if encErr != nil {
+		payload, _ = json.Marshal(fmt.Sprintf("%T", evt.Payload))
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(x.letters) >= x.capacity {
+		x.letters = x.letters[1:]
+	}
+	x.letters = append(x.letters, DeadLetter{
+		ID:        uuid.NewString(),
+		Handler:   handler,
+		Event:     evt.Name,
+		EventID:   evt.ID,
+		Key:       evt.Key,
+		Payload:   payload,
+		Error:     err.Error(),
+		FailedAt:  time.Now().UTC(),
+		OccuredAt: evt.OccuredAt,
+		event:     evt,
+	})
+	// Replays run with their own context.
+	x.letters[len(x.letters)-1].event.ctx = nil
+}
+
+func (x *DeadLetters) index(id string) int {
+	for i, letter := range x.letters {
+		if letter.ID == id {
+			return i
+		}
+	}
+	return -1
+}
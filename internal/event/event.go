@@ -1,6 +1,7 @@
 package event
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -10,6 +11,8 @@ import (
 var (
 	ErrInvalidEventType         = errors.New("event type invalid")
 	ErrInvalidEventPayloadError = errors.New("event payload invalid")
+	ErrRegistryClosed           = errors.New("event registry closed")
+	ErrTypeMismatch             = errors.New("event bound to another payload type")
 )
 
 // Handler defines an event handler.
@@ -25,6 +28,11 @@ type Event struct {
 	Name      string
 	Payload   Payload
 	OccuredAt time.Time
+	// Key orders asynchronous handling: events with the same key
+	// are handled in publish order. Empty keys are not ordered.
+	Key string
+
+	ctx context.Context
 }
 
 // New returns a new event.
@@ -36,3 +44,25 @@ func New(name string, payload Payload) Event {
 		OccuredAt: time.Now(),
 	}
 }
+
+// Context returns the context of the event.
+// It is context.Background if none was set.
+func (x Event) Context() context.Context {
+	if x.ctx == nil {
+		return context.Background()
+	}
+	return x.ctx
+}
+
+// WithContext returns a copy of the event carrying ctx,
+// which handlers use for tracing and cancellation.
+func (x Event) WithContext(ctx context.Context) Event {
+	x.ctx = ctx
+	return x
+}
+
+// WithKey returns a copy of the event with the given ordering key.
+func (x Event) WithKey(key string) Event {
+	x.Key = key
+	return x
+}
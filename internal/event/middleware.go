@@ -0,0 +1,184 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultRetryAttempts is used when no number of attempts is configured.
+	DefaultRetryAttempts = 3
+	// DefaultRetryBackoff is used when no initial backoff is configured.
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// DefaultRetryMaxBackoff is used when no maximum backoff is configured.
+	DefaultRetryMaxBackoff = 2 * time.Second
+)
+
+var ErrHandlerPanic = errors.New("event handler panicked")
+
+// Middleware wraps a handler.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the given middleware. The first middleware
+// is the outermost, so it sees the event first.
+func Chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+type retryableError struct {
+	err error
+}
+
+func (x retryableError) Error() string { return x.err.Error() }
+func (x retryableError) Unwrap() error { return x.err }
+
+// Retryable marks err as transient, so that Retry tries the handler again.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err: err}
+}
+
+// IsRetryable reports whether err was marked with Retryable.
+func IsRetryable(err error) bool {
+	var r retryableError
+	return errors.As(err, &r)
+}
+
+// Recover turns a panic in the handler into an error wrapping
+// ErrHandlerPanic, so that it cannot crash the publishing goroutine.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(evt Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					metrics.HandlerPanic(evt.Name)
+					log.Error().
+						Ctx(evt.Context()).
+						Str("event", evt.Name).
+						Str("stack", string(debug.Stack())).
+						Msgf("event: handler panicked: %v", r)
+					err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+				}
+			}()
+			return next(evt)
+		}
+	}
+}
+
+// RetryConfig configures Retry.
+type RetryConfig struct {
+	// Attempts is the total number of attempts, including the first.
+	Attempts int
+	// Backoff is the wait before the second attempt. It doubles
+	// for every further attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Retry tries the handler again while it returns errors marked with
+// Retryable. It stops early when the event context is done.
+// Zero values in cfg are replaced by defaults.
+func Retry(cfg RetryConfig) Middleware {
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = DefaultRetryAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultRetryBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultRetryMaxBackoff
+	}
+
+	return func(next Handler) Handler {
+		return func(evt Event) error {
+			backoff := cfg.Backoff
+			for attempt := 1; ; attempt++ {
+				err := next(evt)
+				if err == nil || !IsRetryable(err) || attempt >= cfg.Attempts {
+					return err
+				}
+
+				metrics.HandlerRetry(evt.Name)
+				log.Warn().
+					Ctx(evt.Context()).
+					Err(err).
+					Str("event", evt.Name).
+					Int("attempt", attempt).
+					Dur("backoff", backoff).
+					Msg("event: retrying handler")
+
+				timer := time.NewTimer(backoff)
+				select {
+				case <-timer.C:
+				case <-evt.Context().Done():
+					timer.Stop()
+					return err
+				}
+				if backoff *= 2; backoff > cfg.MaxBackoff {
+					backoff = cfg.MaxBackoff
+				}
+			}
+		}
+	}
+}
+
+// Timeout bounds every call of the handler by d through the event
+// context. Handlers must honour the context for it to take effect.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		if d <= 0 {
+			return next
+		}
+		return func(evt Event) error {
+			ctx, cancel := context.WithTimeout(evt.Context(), d)
+			defer cancel()
+			return next(evt.WithContext(ctx))
+		}
+	}
+}
+
+// Logging logs the outcome of every call of the named handler.
+func Logging(name string) Middleware {
+	return func(next Handler) Handler {
+		return func(evt Event) error {
+			start := time.Now()
+			err := next(evt)
+
+			l := log.Debug()
+			if err != nil {
+				l = log.Error().Err(err)
+			}
+			l.Ctx(evt.Context()).
+				Str("handler", name).
+				Str("event", evt.Name).
+				Str("eventID", evt.ID).
+				Str("key", evt.Key).
+				Dur("duration", time.Since(start)).
+				Msg("event: handled")
+			return err
+		}
+	}
+}
+
+// Metrics records the duration and errors of the handler.
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return func(evt Event) error {
+			start := time.Now()
+			err := next(evt)
+			metrics.ObserveHandler(evt.Name, start, err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Recover(t *testing.T) {
+	h := Chain(func(Event) error { panic("boom") }, Recover())
+
+	err := h(New("test", nil))
+	require.ErrorIs(t, err, ErrHandlerPanic)
+	require.ErrorContains(t, err, "boom")
+}
+
+func Test_Retry(t *testing.T) {
+	cfg := RetryConfig{Attempts: 3, Backoff: time.Millisecond}
+	errTransient := errors.New("transient")
+
+	t.Run("Retryable", func(t *testing.T) {
+		var calls int
+		h := Chain(func(Event) error {
+			calls++
+			if calls < 3 {
+				return Retryable(errTransient)
+			}
+			return nil
+		}, Retry(cfg))
+
+		require.NoError(t, h(New("test", nil)))
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		var calls int
+		h := Chain(func(Event) error {
+			calls++
+			return Retryable(errTransient)
+		}, Retry(cfg))
+
+		err := h(New("test", nil))
+		require.ErrorIs(t, err, errTransient)
+		require.True(t, IsRetryable(err))
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("Not retryable", func(t *testing.T) {
+		var calls int
+		h := Chain(func(Event) error {
+			calls++
+			return errTransient
+		}, Retry(cfg))
+
+		require.ErrorIs(t, h(New("test", nil)), errTransient)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func Test_Timeout(t *testing.T) {
+	h := Chain(func(evt Event) error {
+		<-evt.Context().Done()
+		return evt.Context().Err()
+	}, Timeout(time.Millisecond))
+
+	require.ErrorIs(t, h(New("test", nil)), context.DeadlineExceeded)
+}
+
+func Test_DeadLetters(t *testing.T) {
+	deadLetters := NewDeadLetters(2)
+	fail := true
+	h := Chain(func(Event) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}, deadLetters.Middleware("test"), Recover())
+
+	for i := 0; i < 3; i++ {
+		require.Error(t, h(New("test", map[string]int{"n": i})))
+	}
+	letters := deadLetters.List()
+	require.Len(t, letters, 2)
+	require.JSONEq(t, `{"n":1}`, string(letters[0].Payload))
+	require.Equal(t, "test", letters[0].Handler)
+
+	_, err := deadLetters.Replay(context.Background(), letters[0].ID)
+	require.Error(t, err)
+	letter, err := deadLetters.Get(letters[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, letter.Replays)
+
+	fail = false
+	_, err = deadLetters.Replay(context.Background(), letters[0].ID)
+	require.NoError(t, err)
+	_, err = deadLetters.Get(letters[0].ID)
+	require.ErrorIs(t, err, ErrDeadLetterNotFound)
+
+	require.NoError(t, deadLetters.Delete(letters[1].ID))
+	require.Empty(t, deadLetters.List())
+
+	t.Run("Purge", func(t *testing.T) {
+		fail = true
+		for i := 0; i < 2; i++ {
+			require.Error(t, h(New("test", map[string]int{"n": i})))
+		}
+		n := deadLetters.Purge(func(evt Event) bool {
+			return evt.Payload.(map[string]int)["n"] == 0
+		})
+		require.Equal(t, 1, n)
+		letters := deadLetters.List()
+		require.Len(t, letters, 1)
+		require.JSONEq(t, `{"n":1}`, string(letters[0].Payload))
+	})
+}
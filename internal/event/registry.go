@@ -1,45 +1,320 @@
 package event
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
 	"sync"
 
+	"github.com/Salam4nder/chat/internal/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	// DefaultWorkers is used when no number of workers is configured.
+	DefaultWorkers = 8
+	// DefaultQueueSize is used when no queue size is configured.
+	DefaultQueueSize = 256
+)
+
+// Config configures a Registry.
+type Config struct {
+	// Workers is the number of goroutines that run the handlers
+	// of asynchronously published events.
+	Workers int
+	// QueueSize is the number of events each worker buffers.
+	// Publish blocks while the queue of its worker is full.
+	QueueSize int
+}
+
+// Subscription identifies a handler subscribed to an event.
+type Subscription struct {
+	name string
+	id   uint64
+}
+
+type subscription struct {
+	id      uint64
+	handler Handler
+}
+
 // Registry is a concurrent-safe registry that holds events and their handlers.
 // It is used for in-memory pub/sub. Use cases include domain side effects and
 // event sourcing.
+//
+// Asynchronous events are dispatched to a pool of workers. Events with the
+// same key always go to the same worker, so they are handled in the order
+// they were published. Every handler of an event runs even if another fails.
 type Registry struct {
-	mu       sync.Mutex
-	handlers map[string][]Handler
+	mu       sync.RWMutex
+	handlers map[string][]subscription
+	types    map[string]reflect.Type
+	nextID   uint64
+
+	queues  []chan Event
+	workers sync.WaitGroup
+
+	// closeMu guards closed and the calls to sending.Add and inflight.Add.
+	// It is never held while blocking on a queue.
+	closeMu  sync.RWMutex
+	closed   bool
+	sending  sync.WaitGroup
+	inflight sync.WaitGroup
+	// done is closed by Close to release publishers blocked on a full queue.
+	done chan struct{}
+	// stop is closed once no publisher is sending, telling the
+	// workers to handle what is left in their queues and return.
+	stop chan struct{}
 }
 
-// NewRegistry returns a new Registry, ready to be used.
-func NewRegistry() *Registry {
-	return &Registry{
-		handlers: make(map[string][]Handler),
+// NewRegistry returns a new Registry with its workers running.
+// Zero values in cfg are replaced by defaults.
+func NewRegistry(cfg Config) *Registry {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+
+	x := &Registry{
+		handlers: make(map[string][]subscription),
+		types:    make(map[string]reflect.Type),
+		queues:   make([]chan Event, cfg.Workers),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
 	}
+	for i := range x.queues {
+		x.queues[i] = make(chan Event, cfg.QueueSize)
+		x.workers.Add(1)
+		go x.work(x.queues[i])
+	}
+	return x
 }
 
-// Subscribe adds a new handler for the given event.
-func (x *Registry) Subscribe(eventName string, handler Handler) {
+// Subscribe adds a new handler for the given event,
+// wrapped with the given middleware.
+func (x *Registry) Subscribe(eventName string, handler Handler, mw ...Middleware) Subscription {
+	handler = Chain(handler, mw...)
+
 	x.mu.Lock()
 	defer x.mu.Unlock()
 
-	x.handlers[eventName] = append(x.handlers[eventName], handler)
+	x.nextID++
+	x.handlers[eventName] = append(x.handlers[eventName], subscription{id: x.nextID, handler: handler})
+	return Subscription{name: eventName, id: x.nextID}
 }
 
-// Publish publishes the given event to all its handlers.
-func (x *Registry) Publish(event Event) error {
+// Unsubscribe removes a handler. Events already queued
+// are no longer delivered to it. It reports whether the
+// handler was subscribed.
+func (x *Registry) Unsubscribe(sub Subscription) bool {
 	x.mu.Lock()
 	defer x.mu.Unlock()
 
-	for _, handler := range x.handlers[event.Name] {
-		if err := handler(event); err != nil {
-			log.Error().Err(err).Msg("event: handling event")
-			return err
+	subs := x.handlers[sub.name]
+	for i, s := range subs {
+		if s.id != sub.id {
+			continue
+		}
+		subs = append(subs[:i:i], subs[i+1:]...)
+		if len(subs) == 0 {
+			delete(x.handlers, sub.name)
+		} else {
+			x.handlers[sub.name] = subs
+		}
+		return true
+	}
+	return false
+}
+
+// Publish queues the given event for its handlers and returns without
+// waiting for them. Handler errors are logged. It blocks while the queue
+// of the event's worker is full, until Close is called, in which case it
+// returns ErrRegistryClosed. A handler that publishes to its own full queue
+// is therefore only released by Close. It returns ErrInvalidEventType if
+// the payload does not match the type bound to the event name.
+func (x *Registry) Publish(event Event) error {
+	if err := x.check(event); err != nil {
+		return err
+	}
+
+	ctx, span := tracing.Start(event.Context(), "event.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("event.name", event.Name)))
+	defer span.End()
+	event = event.WithContext(ctx)
+
+	x.closeMu.RLock()
+	if x.closed {
+		x.closeMu.RUnlock()
+		return ErrRegistryClosed
+	}
+	x.sending.Add(1)
+	x.closeMu.RUnlock()
+	defer x.sending.Done()
+
+	queue := x.queues[x.worker(event)]
+	select {
+	case queue <- event:
+		return nil
+	default:
+	}
+	select {
+	case queue <- event:
+		return nil
+	case <-x.done:
+		return ErrRegistryClosed
+	}
+}
+
+// PublishSync runs the handlers of the given event in the calling goroutine
+// and returns their joined errors. It is not ordered with asynchronously
+// published events of the same key.
+func (x *Registry) PublishSync(event Event) error {
+	if err := x.check(event); err != nil {
+		return err
+	}
+
+	x.closeMu.RLock()
+	if x.closed {
+		x.closeMu.RUnlock()
+		return ErrRegistryClosed
+	}
+	x.inflight.Add(1)
+	x.closeMu.RUnlock()
+	defer x.inflight.Done()
+
+	ctx, span := tracing.Start(event.Context(), "event.publish",
+		trace.WithAttributes(attribute.String("event.name", event.Name)))
+	defer span.End()
+
+	return x.dispatch(event.WithContext(ctx))
+}
+
+// Close rejects new events with ErrRegistryClosed and waits for queued and
+// in-flight events to be handled. It gives up when ctx is done, leaving the
+// workers to finish in the background.
+func (x *Registry) Close(ctx context.Context) error {
+	x.closeMu.Lock()
+	if !x.closed {
+		x.closed = true
+		close(x.done)
+		go func() {
+			x.sending.Wait()
+			close(x.stop)
+		}()
+	}
+	x.closeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		x.workers.Wait()
+		x.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (x *Registry) work(queue chan Event) {
+	defer x.workers.Done()
+
+	for {
+		select {
+		case event := <-queue:
+			x.handle(event)
+		case <-x.stop:
+			for {
+				select {
+				case event := <-queue:
+					x.handle(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle dispatches an asynchronously published event and logs its errors.
+func (x *Registry) handle(event Event) {
+	if err := x.dispatch(event); err != nil {
+		log.Error().Ctx(event.Context()).Err(err).Str("event", event.Name).Msg("event: handling event")
+	}
+}
+
+// dispatch runs every handler of the event and joins their errors.
+func (x *Registry) dispatch(event Event) error {
+	x.mu.RLock()
+	subs := x.handlers[event.Name]
+	x.mu.RUnlock()
+
+	var errs []error
+	for _, sub := range subs {
+		ctx, span := tracing.Start(event.Context(), "event.handle",
+			trace.WithAttributes(attribute.String("event.name", event.Name)))
+		err := sub.handler(event.WithContext(ctx))
+		tracing.End(span, err)
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
+}
 
+// bind binds an event name to a payload type. Binding the same
+// type again is a no-op.
+func (x *Registry) bind(name string, typ reflect.Type) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if bound, ok := x.types[name]; ok {
+		if bound != typ {
+			return fmt.Errorf("%w: %s is %s, not %s", ErrTypeMismatch, name, bound, typ)
+		}
+		return nil
+	}
+	x.types[name] = typ
 	return nil
 }
+
+// check returns ErrInvalidEventType if the payload of the event does
+// not match the type bound to its name. Unbound events are not checked.
+func (x *Registry) check(event Event) error {
+	x.mu.RLock()
+	typ, ok := x.types[event.Name]
+	x.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if event.Payload == nil {
+		if typ.Kind() == reflect.Interface {
+			return nil
+		}
+	} else if reflect.TypeOf(event.Payload).AssignableTo(typ) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s payload is %T, not %s", ErrInvalidEventType, event.Name, event.Payload, typ)
+}
+
+// worker returns the index of the worker that handles the event.
+func (x *Registry) worker(event Event) int {
+	key := event.Key
+	if key == "" {
+		key = event.ID
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(x.queues)))
+}
@@ -0,0 +1,206 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Registry_OrderPerKey(t *testing.T) {
+	registry := NewRegistry(Config{Workers: 4})
+
+	var (
+		mu  sync.Mutex
+		got = make(map[string][]int)
+	)
+	registry.Subscribe("test", func(evt Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[evt.Key] = append(got[evt.Key], evt.Payload.(int))
+		return nil
+	})
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 100; i++ {
+		for _, key := range keys {
+			require.NoError(t, registry.Publish(New("test", i).WithKey(key)))
+		}
+	}
+	require.NoError(t, registry.Close(context.Background()))
+
+	for _, key := range keys {
+		require.Len(t, got[key], 100)
+		for i, n := range got[key] {
+			require.Equal(t, i, n, "key %s", key)
+		}
+	}
+}
+
+func Test_Registry_HandlerIsolation(t *testing.T) {
+	registry := NewRegistry(Config{})
+	errFirst := errors.New("first")
+
+	var ran bool
+	registry.Subscribe("test", func(Event) error { return errFirst })
+	registry.Subscribe("test", func(Event) error {
+		ran = true
+		return nil
+	})
+
+	err := registry.PublishSync(New("test", nil))
+	require.ErrorIs(t, err, errFirst)
+	require.True(t, ran)
+	require.NoError(t, registry.Close(context.Background()))
+}
+
+func Test_Registry_SlowHandlerDoesNotBlockPublishers(t *testing.T) {
+	registry := NewRegistry(Config{Workers: 2})
+	release := make(chan struct{})
+	handled := make(chan string, 1)
+
+	registry.Subscribe("slow", func(Event) error {
+		<-release
+		return nil
+	})
+	registry.Subscribe("fast", func(evt Event) error {
+		handled <- evt.Key
+		return nil
+	})
+
+	require.NoError(t, registry.Publish(New("slow", nil).WithKey("slow")))
+	// Find a key handled by the other worker.
+	var key string
+	for i := 0; ; i++ {
+		key = strconv.Itoa(i)
+		if registry.worker(New("fast", nil).WithKey(key)) !=
+			registry.worker(New("slow", nil).WithKey("slow")) {
+			break
+		}
+	}
+	require.NoError(t, registry.Publish(New("fast", nil).WithKey(key)))
+
+	select {
+	case got := <-handled:
+		require.Equal(t, key, got)
+	case <-time.After(time.Second):
+		t.Fatal("fast event blocked by slow handler")
+	}
+	close(release)
+	require.NoError(t, registry.Close(context.Background()))
+}
+
+func Test_Registry_ReentrantPublish(t *testing.T) {
+	registry := NewRegistry(Config{})
+	done := make(chan struct{})
+
+	registry.Subscribe("outer", func(evt Event) error {
+		if err := registry.PublishSync(New("inner", nil)); err != nil {
+			return err
+		}
+		return registry.Publish(New("inner", nil).WithKey(evt.Key))
+	})
+	var n int
+	registry.Subscribe("inner", func(Event) error {
+		n++
+		if n == 2 {
+			close(done)
+		}
+		return nil
+	})
+
+	require.NoError(t, registry.Publish(New("outer", nil).WithKey("k")))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("re-entrant publish deadlocked")
+	}
+	require.NoError(t, registry.Close(context.Background()))
+}
+
+func Test_Registry_ReentrantPublishFullQueue(t *testing.T) {
+	registry := NewRegistry(Config{Workers: 1, QueueSize: 1})
+	full := make(chan struct{})
+	errs := make(chan error, 1)
+
+	registry.Subscribe("outer", func(Event) error {
+		// The first event fills the queue, the second blocks on it.
+		if err := registry.Publish(New("inner", nil)); err != nil {
+			return err
+		}
+		close(full)
+		errs <- registry.Publish(New("inner", nil))
+		return nil
+	})
+	var n int
+	registry.Subscribe("inner", func(Event) error {
+		n++
+		return nil
+	})
+
+	require.NoError(t, registry.Publish(New("outer", nil)))
+	<-full
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, registry.Close(ctx), "re-entrant publish deadlocked close")
+	require.ErrorIs(t, <-errs, ErrRegistryClosed)
+	require.Equal(t, 1, n)
+}
+
+func Test_Registry_Unsubscribe(t *testing.T) {
+	registry := NewRegistry(Config{})
+
+	var n int
+	sub := registry.Subscribe("test", func(Event) error {
+		n++
+		return nil
+	})
+	require.NoError(t, registry.PublishSync(New("test", nil)))
+	require.True(t, registry.Unsubscribe(sub))
+	require.False(t, registry.Unsubscribe(sub))
+	require.NoError(t, registry.PublishSync(New("test", nil)))
+	require.Equal(t, 1, n)
+	require.NoError(t, registry.Close(context.Background()))
+}
+
+func Test_Registry_Close(t *testing.T) {
+	t.Run("Drains queued events", func(t *testing.T) {
+		registry := NewRegistry(Config{Workers: 1})
+
+		var n int
+		registry.Subscribe("test", func(Event) error {
+			time.Sleep(time.Millisecond)
+			n++
+			return nil
+		})
+		for i := 0; i < 20; i++ {
+			require.NoError(t, registry.Publish(New("test", nil)))
+		}
+		require.NoError(t, registry.Close(context.Background()))
+		require.Equal(t, 20, n)
+
+		require.ErrorIs(t, registry.Publish(New("test", nil)), ErrRegistryClosed)
+		require.ErrorIs(t, registry.PublishSync(New("test", nil)), ErrRegistryClosed)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		registry := NewRegistry(Config{Workers: 1})
+		release := make(chan struct{})
+		defer close(release)
+
+		registry.Subscribe("test", func(Event) error {
+			<-release
+			return nil
+		})
+		require.NoError(t, registry.Publish(New("test", nil)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, registry.Close(ctx), context.DeadlineExceeded)
+	})
+}
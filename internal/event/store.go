@@ -0,0 +1,112 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Record is an event as kept in a Store. The payload is JSON encoded.
+type Record struct {
+	ID        string
+	Name      string
+	Key       string
+	Payload   json.RawMessage
+	OccuredAt time.Time
+	// Users are the IDs of the users the event is about,
+	// so that their events can be found and erased.
+	Users []string
+}
+
+// Store is an append-only log of events.
+type Store interface {
+	// Append adds a record to the log.
+	Append(ctx context.Context, record Record) error
+	// Read calls fn for every record of the named event that occured
+	// between from and to, oldest first. It stops at the first error
+	// of fn and returns it.
+	Read(ctx context.Context, name string, from, to time.Time, fn func(Record) error) error
+}
+
+// Keep subscribes a handler that appends every event of type t to the
+// store, wrapped with the given middleware. Only kept events can be
+// replayed.
+func Keep[T any](r *Registry, store Store, t Type[T], mw ...Middleware) (Subscription, error) {
+	return KeepRedacted(r, store, t, nil, mw...)
+}
+
+// KeepRedacted is Keep with every payload passed through redact before
+// it is stored. redact returns the payload without what must not be
+// kept and the IDs of the users the event is about. A nil redact keeps
+// payloads as they are.
+func KeepRedacted[T any](
+	r *Registry,
+	store Store,
+	t Type[T],
+	redact func(T) (T, []string),
+	mw ...Middleware,
+) (Subscription, error) {
+	return Subscribe(r, t, func(evt Event, payload T) error {
+		var users []string
+		if redact != nil {
+			payload, users = redact(payload)
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("%w: %s, %v", ErrInvalidEventPayloadError, evt.Name, err)
+		}
+		return store.Append(evt.Context(), Record{
+			ID:        evt.ID,
+			Name:      evt.Name,
+			Key:       evt.Key,
+			Payload:   data,
+			OccuredAt: evt.OccuredAt,
+			Users:     users,
+		})
+	}, mw...)
+}
+
+// Replay reads the named events that occured between from and to from
+// the store and runs their handlers with PublishSync, oldest first. It
+// stops at the first failure and returns the number of events replayed.
+//
+// The payloads are decoded into the type bound to the name, so subscribe
+// a typed handler first. Replay into a registry built for the purpose:
+// the handlers of the live registry, Keep included, would act again.
+func (x *Registry) Replay(
+	ctx context.Context,
+	store Store,
+	name string,
+	from, to time.Time,
+) (int, error) {
+	x.mu.RLock()
+	typ, ok := x.types[name]
+	x.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s is not bound to a payload type", ErrInvalidEventType, name)
+	}
+
+	var n int
+	err := store.Read(ctx, name, from, to, func(record Record) error {
+		payload := reflect.New(typ)
+		if err := json.Unmarshal(record.Payload, payload.Interface()); err != nil {
+			return fmt.Errorf("%w: %s %s, %v", ErrInvalidEventPayloadError, name, record.ID, err)
+		}
+
+		if err := x.PublishSync(Event{
+			ID:        record.ID,
+			Name:      record.Name,
+			Payload:   payload.Elem().Interface(),
+			OccuredAt: record.OccuredAt,
+			Key:       record.Key,
+			ctx:       ctx,
+		}); err != nil {
+			return fmt.Errorf("event: replaying %s %s, %w", name, record.ID, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,100 @@
+package event
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (x *memoryStore) Append(_ context.Context, record Record) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.records = append(x.records, record)
+	return nil
+}
+
+func (x *memoryStore) Read(
+	_ context.Context,
+	name string,
+	from, to time.Time,
+	fn func(Record) error,
+) error {
+	x.mu.Lock()
+	records := append([]Record(nil), x.records...)
+	x.mu.Unlock()
+
+	for _, record := range records {
+		if record.Name != name || record.OccuredAt.Before(from) || record.OccuredAt.After(to) {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Test_Keep_Replay(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+	typ := NewType[testPayload]("test")
+
+	live := NewRegistry(Config{})
+	_, err := Keep(live, store, typ)
+	require.NoError(t, err)
+
+	from := time.Now()
+	for _, text := range []string{"a", "b", "c"} {
+		require.NoError(t, Publish(ctx, live, typ, "room", testPayload{Text: text}))
+	}
+	require.NoError(t, live.Close(ctx))
+	require.Len(t, store.records, 3)
+	require.Equal(t, "room", store.records[0].Key)
+
+	replay := NewRegistry(Config{})
+	var got []string
+	_, err = Subscribe(replay, typ, func(evt Event, payload testPayload) error {
+		require.Equal(t, "room", evt.Key)
+		got = append(got, payload.Text)
+		return nil
+	})
+	require.NoError(t, err)
+
+	n, err := replay.Replay(ctx, store, typ.Name(), from, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, []string{"a", "b", "c"}, got)
+
+	t.Run("Unbound", func(t *testing.T) {
+		_, err := replay.Replay(ctx, store, "unbound", from, time.Now())
+		require.ErrorIs(t, err, ErrInvalidEventType)
+	})
+
+	require.NoError(t, replay.Close(ctx))
+}
+
+func Test_KeepRedacted(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+	typ := NewType[testPayload]("test")
+
+	live := NewRegistry(Config{})
+	_, err := KeepRedacted(live, store, typ, func(p testPayload) (testPayload, []string) {
+		return testPayload{}, []string{p.Text}
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, Publish(ctx, live, typ, "room", testPayload{Text: "ann"}))
+	require.NoError(t, live.Close(ctx))
+	require.Len(t, store.records, 1)
+	require.Equal(t, []string{"ann"}, store.records[0].Users)
+	require.NotContains(t, string(store.records[0].Payload), "ann")
+}
@@ -0,0 +1,74 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Type binds an event name to its payload type.
+// Declare one per event and use it with Subscribe and Publish.
+type Type[T any] struct {
+	name string
+}
+
+// NewType returns the type of the events with the given name.
+func NewType[T any](name string) Type[T] {
+	return Type[T]{name: name}
+}
+
+// Name returns the event name.
+func (x Type[T]) Name() string {
+	return x.name
+}
+
+// New returns a new event with the given payload.
+func (x Type[T]) New(payload T) Event {
+	return New(x.name, payload)
+}
+
+// TypedHandler handles an event whose payload is already of type T.
+type TypedHandler[T any] func(evt Event, payload T) error
+
+// Subscribe adds a handler for events of type t, wrapped with the given
+// middleware. It fails if the event name is already bound to another
+// payload type, so that mismatched registrations surface at startup.
+func Subscribe[T any](
+	r *Registry,
+	t Type[T],
+	handler TypedHandler[T],
+	mw ...Middleware,
+) (Subscription, error) {
+	if err := r.bind(t.name, payloadType[T]()); err != nil {
+		return Subscription{}, err
+	}
+	return r.Subscribe(t.name, func(evt Event) error {
+		payload, ok := evt.Payload.(T)
+		if !ok {
+			return fmt.Errorf("%w: %s payload is %T", ErrInvalidEventType, t.name, evt.Payload)
+		}
+		return handler(evt, payload)
+	}, mw...), nil
+}
+
+// Publish queues an event of type t with the given ordering key.
+// See Registry.Publish.
+func Publish[T any](ctx context.Context, r *Registry, t Type[T], key string, payload T) error {
+	if err := r.bind(t.name, payloadType[T]()); err != nil {
+		return err
+	}
+	return r.Publish(t.New(payload).WithKey(key).WithContext(ctx))
+}
+
+// PublishSync runs the handlers of an event of type t.
+// See Registry.PublishSync.
+func PublishSync[T any](ctx context.Context, r *Registry, t Type[T], payload T) error {
+	if err := r.bind(t.name, payloadType[T]()); err != nil {
+		return err
+	}
+	return r.PublishSync(t.New(payload).WithContext(ctx))
+}
+
+func payloadType[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
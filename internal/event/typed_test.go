@@ -0,0 +1,43 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPayload struct {
+	Text string
+}
+
+func Test_Subscribe_Typed(t *testing.T) {
+	registry := NewRegistry(Config{})
+	typ := NewType[testPayload]("test")
+
+	var got testPayload
+	_, err := Subscribe(registry, typ, func(_ Event, payload testPayload) error {
+		got = payload
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, PublishSync(context.Background(), registry, typ, testPayload{Text: "hi"}))
+	require.Equal(t, "hi", got.Text)
+	require.NoError(t, registry.Close(context.Background()))
+}
+
+func Test_Subscribe_TypeMismatch(t *testing.T) {
+	registry := NewRegistry(Config{})
+
+	_, err := Subscribe(registry, NewType[testPayload]("test"), func(Event, testPayload) error { return nil })
+	require.NoError(t, err)
+	_, err = Subscribe(registry, NewType[string]("test"), func(Event, string) error { return nil })
+	require.ErrorIs(t, err, ErrTypeMismatch)
+	require.ErrorIs(t, Publish(context.Background(), registry, NewType[int]("test"), "", 1), ErrTypeMismatch)
+
+	// The string-named API is checked against the bound type.
+	require.ErrorIs(t, registry.PublishSync(New("test", "hi")), ErrInvalidEventType)
+	require.NoError(t, registry.PublishSync(New("test", testPayload{})))
+	require.NoError(t, registry.Close(context.Background()))
+}
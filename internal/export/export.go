@@ -0,0 +1,150 @@
+// Package export writes room history as JSONL, CSV, Markdown
+// or self-contained HTML transcripts.
+//
+// Messages are streamed from the repository page by page,
+// so memory stays bounded regardless of the size of the room.
+package export
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+)
+
+// Format is an output format.
+type Format string
+
+const (
+	JSONL    Format = "jsonl"
+	CSV      Format = "csv"
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+)
+
+var (
+	ErrFormatInvalid = errors.New("export: format invalid")
+	ErrRoomIDInvalid = errors.New("export: room ID invalid")
+)
+
+// Record is an exported message.
+type Record struct {
+	ID     string    `json:"id"`
+	RoomID string    `json:"roomID"`
+	Time   time.Time `json:"time"`
+	Sender string    `json:"sender"`
+	UserID string    `json:"userID,omitempty"`
+	Type   string    `json:"type"`
+	// Body is the message text. Bodies that are not valid UTF-8 text
+	// are base64 encoded and Encoding is set to "base64".
+	Body     string `json:"body"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Writer writes records in an output format.
+// Close must be called to complete the output.
+type Writer interface {
+	Write(Record) error
+	Close() error
+}
+
+// NewWriter returns a Writer for the given format.
+// The title is used by formats with a heading.
+func NewWriter(format Format, w io.Writer, title string) (Writer, error) {
+	switch format {
+	case JSONL:
+		return newJSONLWriter(w), nil
+	case CSV:
+		return newCSVWriter(w)
+	case Markdown:
+		return newMarkdownWriter(w, title)
+	case HTML:
+		return newHTMLWriter(w, title)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrFormatInvalid, format)
+	}
+}
+
+// Options define which messages are exported.
+type Options struct {
+	RoomID string
+	// From and To bound the message time, zero values are unbounded.
+	From time.Time
+	To   time.Time
+	// Sender keeps only messages whose sender name or user ID matches.
+	Sender string
+	// PageSize is the number of messages read at once.
+	PageSize int
+}
+
+// Export streams the messages of a room to w, oldest first,
+// and returns the number of exported messages. It does not close w.
+func Export(
+	ctx context.Context,
+	repo db.MessageRepository,
+	opts Options,
+	w Writer,
+) (int, error) {
+	if opts.RoomID == "" {
+		return 0, ErrRoomIDInvalid
+	}
+
+	var (
+		count int
+		state []byte
+	)
+	for {
+		page, err := repo.ReadMessagesByRoomIDPage(ctx, db.ReadMessagesPageParams{
+			RoomID:    opts.RoomID,
+			From:      opts.From,
+			To:        opts.To,
+			PageSize:  opts.PageSize,
+			PageState: state,
+		})
+		if err != nil {
+			return count, fmt.Errorf("export: reading messages, %w", err)
+		}
+
+		for _, message := range page.Messages {
+			if opts.Sender != "" && message.Sender != opts.Sender && message.UserID != opts.Sender {
+				continue
+			}
+			if err := w.Write(toRecord(message)); err != nil {
+				return count, fmt.Errorf("export: writing message, %w", err)
+			}
+			count++
+		}
+
+		if page.NextPageState == nil {
+			return count, nil
+		}
+		state = page.NextPageState
+	}
+}
+
+func toRecord(message db.Message) Record {
+	r := Record{
+		ID:     message.ID.String(),
+		RoomID: message.RoomID,
+		Time:   message.Time.UTC(),
+		Sender: message.Sender,
+		UserID: message.UserID,
+		Type:   message.Type,
+	}
+	if message.ID.Version() == 1 {
+		r.Time = message.ID.Time().UTC()
+	}
+
+	if utf8.Valid(message.Data) {
+		r.Body = string(message.Data)
+	} else {
+		r.Body = base64.StdEncoding.EncodeToString(message.Data)
+		r.Encoding = "base64"
+	}
+	return r
+}
@@ -0,0 +1,170 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+)
+
+// messageRepo serves messages in pages of PageSize.
+// Page states are the index of the next message.
+type messageRepo struct {
+	db.MessageRepository
+	messages []db.Message
+	pages    int
+}
+
+func (x *messageRepo) ReadMessagesByRoomIDPage(_ context.Context, params db.ReadMessagesPageParams) (db.MessagePage, error) {
+	x.pages++
+	var start int
+	if params.PageState != nil {
+		var err error
+		if start, err = strconv.Atoi(string(params.PageState)); err != nil {
+			return db.MessagePage{}, err
+		}
+	}
+	end := start + params.PageSize
+	if end >= len(x.messages) {
+		return db.MessagePage{Messages: x.messages[start:]}, nil
+	}
+	return db.MessagePage{
+		Messages:      x.messages[start:end],
+		NextPageState: []byte(strconv.Itoa(end)),
+	}, nil
+}
+
+func newMessageRepo(roomID string, n int) *messageRepo {
+	x := &messageRepo{}
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		sender := "ann"
+		if i%2 == 1 {
+			sender = "bob"
+		}
+		x.messages = append(x.messages, db.Message{
+			ID:     gocql.UUIDFromTime(at.Add(time.Duration(i) * time.Second)),
+			Data:   []byte("message " + strconv.Itoa(i)),
+			Type:   "Text",
+			Sender: sender,
+			RoomID: roomID,
+		})
+	}
+	return x
+}
+
+func Test_Export(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Pages", func(t *testing.T) {
+		repo := newMessageRepo("room", 5)
+		var buf bytes.Buffer
+		w, err := NewWriter(JSONL, &buf, "")
+		require.NoError(t, err)
+
+		count, err := Export(ctx, repo, Options{RoomID: "room", PageSize: 2}, w)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, 5, count)
+		require.Equal(t, 3, repo.pages)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 5)
+		for i, line := range lines {
+			var r Record
+			require.NoError(t, json.Unmarshal([]byte(line), &r))
+			require.Equal(t, repo.messages[i].ID.String(), r.ID)
+			require.Equal(t, repo.messages[i].ID.Time().UTC(), r.Time)
+			require.Equal(t, "message "+strconv.Itoa(i), r.Body)
+		}
+	})
+
+	t.Run("Sender across pages", func(t *testing.T) {
+		repo := newMessageRepo("room", 5)
+		var buf bytes.Buffer
+		w, err := NewWriter(JSONL, &buf, "")
+		require.NoError(t, err)
+
+		count, err := Export(ctx, repo, Options{RoomID: "room", Sender: "bob", PageSize: 2}, w)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, 2, count)
+		require.NotContains(t, buf.String(), `"sender":"ann"`)
+	})
+
+	t.Run("Binary body", func(t *testing.T) {
+		repo := newMessageRepo("room", 1)
+		repo.messages[0].Data = []byte{0xff, 0xfe}
+		var buf bytes.Buffer
+		w, err := NewWriter(JSONL, &buf, "")
+		require.NoError(t, err)
+
+		_, err = Export(ctx, repo, Options{RoomID: "room", PageSize: 2}, w)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		var r Record
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &r))
+		require.Equal(t, "base64", r.Encoding)
+		require.Equal(t, "//4=", r.Body)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := Export(ctx, newMessageRepo("room", 1), Options{}, nil)
+		require.ErrorIs(t, err, ErrRoomIDInvalid)
+		_, err = NewWriter("xml", &bytes.Buffer{}, "")
+		require.ErrorIs(t, err, ErrFormatInvalid)
+	})
+}
+
+func Test_Writer_Escaping(t *testing.T) {
+	r := Record{
+		ID:     "id",
+		Time:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Sender: "<b>ann</b>_*",
+		Type:   "Text",
+		Body:   "# not a heading\n<script>alert(1)</script> [link](x) `code` | *bold*",
+	}
+	write := func(t *testing.T, format Format, title string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf, title)
+		require.NoError(t, err)
+		require.NoError(t, w.Write(r))
+		require.NoError(t, w.Close())
+		return buf.String()
+	}
+
+	t.Run("Markdown", func(t *testing.T) {
+		out := write(t, Markdown, "Room *1*")
+		require.True(t, strings.HasPrefix(out, "# Room \\*1\\*\n\n"))
+		require.Contains(t, out, "**&lt;b&gt;ann&lt;/b&gt;\\_\\*** · 2024-01-01 12:00:00Z\n")
+		require.Contains(t, out, "> \\# not a heading\n")
+		require.Contains(t, out, "> &lt;script&gt;alert(1)&lt;/script&gt; \\[link\\](x) \\`code\\` \\| \\*bold\\*\n")
+		require.NotContains(t, out, "<script>")
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		out := write(t, HTML, "Room <1>")
+		require.Contains(t, out, "<title>Room &lt;1&gt;</title>")
+		require.Contains(t, out, "<h1>Room &lt;1&gt;</h1>")
+		require.Contains(t, out, `<span class="sender">&lt;b&gt;ann&lt;/b&gt;_*</span>`)
+		require.Contains(t, out, "&lt;script&gt;alert(1)&lt;/script&gt;")
+		require.NotContains(t, out, "<script>")
+		require.True(t, strings.HasSuffix(out, "</body>\n</html>\n"))
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		out := write(t, CSV, "")
+		lines := strings.SplitN(out, "\n", 2)
+		require.Equal(t, "id,room_id,time,sender,user_id,type,body,encoding", lines[0])
+		require.Contains(t, lines[1], `"# not a heading`+"\n"+`<script>alert(1)</script> [link](x) `+"`code` | *bold*\"")
+	})
+}
@@ -0,0 +1,171 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// timeFormat is the time format of human readable transcripts.
+const timeFormat = "2006-01-02 15:04:05Z07:00"
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (x *jsonlWriter) Write(r Record) error {
+	return x.enc.Encode(r)
+}
+
+func (x *jsonlWriter) Close() error {
+	return x.w.Flush()
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	x := &csvWriter{w: csv.NewWriter(w)}
+	if err := x.w.Write([]string{
+		"id", "room_id", "time", "sender", "user_id", "type", "body", "encoding",
+	}); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *csvWriter) Write(r Record) error {
+	return x.w.Write([]string{
+		r.ID,
+		r.RoomID,
+		r.Time.Format(time.RFC3339Nano),
+		r.Sender,
+		r.UserID,
+		r.Type,
+		r.Body,
+		r.Encoding,
+	})
+}
+
+func (x *csvWriter) Close() error {
+	x.w.Flush()
+	return x.w.Error()
+}
+
+type markdownWriter struct {
+	w *bufio.Writer
+}
+
+func newMarkdownWriter(w io.Writer, title string) (*markdownWriter, error) {
+	x := &markdownWriter{w: bufio.NewWriter(w)}
+	if _, err := fmt.Fprintf(x.w, "# %s\n\n", escapeMarkdown(title)); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *markdownWriter) Write(r Record) error {
+	body := r.Body
+	if r.Encoding != "" {
+		body = "_" + r.Encoding + " encoded " + strings.ToLower(r.Type) + "_\n" + body
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** · %s\n\n", escapeMarkdown(r.Sender), r.Time.Format(timeFormat))
+	for _, line := range strings.Split(body, "\n") {
+		b.WriteString("> ")
+		b.WriteString(escapeMarkdown(line))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	_, err := x.w.WriteString(b.String())
+	return err
+}
+
+func (x *markdownWriter) Close() error {
+	return x.w.Flush()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", "&lt;", ">", "&gt;", "#", `\#`, "|", `\|`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// htmlHeader starts a self-contained HTML transcript.
+// It takes the escaped title twice.
+const htmlHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1d1d1f; }
+h1 { font-size: 1.4rem; }
+.message { padding: .5rem 0; border-bottom: 1px solid #eee; }
+.sender { font-weight: 600; }
+.time { color: #888; font-size: .8rem; margin-left: .5rem; }
+.body { white-space: pre-wrap; word-wrap: break-word; margin-top: .25rem; }
+.encoded { font-family: monospace; color: #888; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+`
+
+const htmlFooter = `</body>
+</html>
+`
+
+type htmlWriter struct {
+	w *bufio.Writer
+}
+
+func newHTMLWriter(w io.Writer, title string) (*htmlWriter, error) {
+	x := &htmlWriter{w: bufio.NewWriter(w)}
+	title = html.EscapeString(title)
+	if _, err := fmt.Fprintf(x.w, htmlHeader, title, title); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *htmlWriter) Write(r Record) error {
+	class := "body"
+	if r.Encoding != "" {
+		class += " encoded"
+	}
+	_, err := fmt.Fprintf(x.w,
+		`<div class="message" id="m-%s"><span class="sender">%s</span><time class="time" datetime="%s">%s</time><div class="%s">%s</div></div>`+"\n",
+		html.EscapeString(r.ID),
+		html.EscapeString(r.Sender),
+		r.Time.Format(time.RFC3339),
+		r.Time.Format(timeFormat),
+		class,
+		html.EscapeString(r.Body),
+	)
+	return err
+}
+
+func (x *htmlWriter) Close() error {
+	if _, err := x.w.WriteString(htmlFooter); err != nil {
+		return err
+	}
+	return x.w.Flush()
+}
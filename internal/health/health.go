@@ -0,0 +1,182 @@
+// Package health tracks the lifecycle of the service and the checks
+// subsystems contribute to its readiness.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusHealthy   = "Healthy"
+	StatusUnhealthy = "Unhealthy"
+	StatusStarting  = "Starting"
+	StatusDraining  = "Draining"
+)
+
+// DefaultCheckTimeout bounds a single check.
+const DefaultCheckTimeout = 2 * time.Second
+
+var (
+	ErrCheckNameInvalid = errors.New("health check name invalid")
+	ErrCheckExists      = errors.New("health check already registered")
+	ErrCheckNil         = errors.New("health check nil")
+)
+
+// Check reports whether a dependency is usable.
+// It must return quickly and honour ctx.
+type Check func(ctx context.Context) error
+
+// Report is the outcome of a liveness or readiness probe.
+type Report struct {
+	Status      string        `json:"status"`
+	ServiceName string        `json:"serviceName"`
+	Timestamp   time.Time     `json:"timestamp"`
+	Uptime      string        `json:"uptime"`
+	Checks      []CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the readiness checks of the service and
+// whether it finished starting or is draining.
+type Registry struct {
+	serviceName string
+	startedAt   time.Time
+	timeout     time.Duration
+
+	started  atomic.Bool
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewRegistry returns a registry in the starting state.
+func NewRegistry(serviceName string) *Registry {
+	return &Registry{
+		serviceName: serviceName,
+		startedAt:   time.Now(),
+		timeout:     DefaultCheckTimeout,
+	}
+}
+
+// Register adds a readiness check. Checks run in registration order.
+func (x *Registry) Register(name string, check Check) error {
+	if name == "" {
+		return ErrCheckNameInvalid
+	}
+	if check == nil {
+		return ErrCheckNil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, c := range x.checks {
+		if c.name == name {
+			return fmt.Errorf("%w: %s", ErrCheckExists, name)
+		}
+	}
+	x.checks = append(x.checks, namedCheck{name: name, check: check})
+	return nil
+}
+
+// MarkStarted marks the end of startup.
+func (x *Registry) MarkStarted() {
+	x.started.Store(true)
+}
+
+// MarkDraining marks the start of shutdown. The service is not
+// ready from then on.
+func (x *Registry) MarkDraining() {
+	x.draining.Store(true)
+}
+
+// Draining reports whether the service is shutting down.
+func (x *Registry) Draining() bool {
+	return x.draining.Load()
+}
+
+// Live reports whether the process is running. It does not
+// check dependencies so that their outages do not restart it.
+func (x *Registry) Live() Report {
+	return x.report(StatusHealthy, nil)
+}
+
+// Ready runs every check concurrently and reports whether
+// the service should receive traffic.
+func (x *Registry) Ready(ctx context.Context) Report {
+	x.mu.RLock()
+	checks := make([]namedCheck, len(x.checks))
+	copy(checks, x.checks)
+	x.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			results[i] = x.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	status := StatusHealthy
+	for _, r := range results {
+		if r.Status != StatusHealthy {
+			status = StatusUnhealthy
+		}
+	}
+	switch {
+	case x.draining.Load():
+		status = StatusDraining
+	case !x.started.Load():
+		status = StatusStarting
+	}
+	return x.report(status, results)
+}
+
+func (x *Registry) run(ctx context.Context, c namedCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, x.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+	result := CheckResult{
+		Name:      c.name,
+		Status:    StatusHealthy,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusUnhealthy
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (x *Registry) report(status string, checks []CheckResult) Report {
+	return Report{
+		Status:      status,
+		ServiceName: x.serviceName,
+		Timestamp:   time.Now().UTC(),
+		Uptime:      time.Since(x.startedAt).Round(time.Second).String(),
+		Checks:      checks,
+	}
+}
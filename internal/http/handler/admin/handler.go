@@ -0,0 +1,653 @@
+// Package admin provides the operator HTTP API.
+// It is served on a separate listener and every endpoint
+// requires an API key with the admin scope.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/internal/chat"
+	"github.com/Salam4nder/chat/internal/event"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RoomsPath is the path prefix of room endpoints.
+	RoomsPath = "/admin/rooms/"
+	// RoomListPath lists the rooms of a node.
+	RoomListPath = "/admin/rooms"
+	// UsersPath is the path prefix of user endpoints.
+	UsersPath = "/admin/users/"
+	// ClusterPath is the path prefix of cluster endpoints.
+	ClusterPath = "/admin/cluster/"
+	// DeadLetterListPath lists the dead letters of a node.
+	DeadLetterListPath = "/admin/deadletters"
+	// DeadLettersPath is the path prefix of dead letter endpoints.
+	DeadLettersPath = "/admin/deadletters/"
+	// EventsPath replays kept events.
+	EventsPath = "/admin/events"
+	// ErasureReportPath verifies the signature of an erasure report.
+	ErasureReportPath = "/admin/erasure-reports/verify"
+	// defaultEventLimit and maxEventLimit bound the events of a replay.
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	// maxBodySize is the maximum accepted request body size in bytes.
+	maxBodySize = 64 << 10
+	// requestTimeout is the maximum duration to handle a request.
+	requestTimeout = 10 * time.Second
+	// erasureTimeout is the maximum duration to erase a user.
+	erasureTimeout = 5 * time.Minute
+)
+
+var (
+	errNotFound         = errors.New("not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errInternal         = errors.New("internal error")
+	errEventStoreOff    = errors.New("event store disabled")
+	errEventLimit       = errors.New("event limit reached")
+)
+
+type Handler struct {
+	auth        *auth.Service
+	admin       *chat.AdminService
+	retention   *chat.RetentionService
+	erasure     *chat.ErasureService
+	deadLetters *event.DeadLetters
+	events      event.Store
+}
+
+// NewHandler creates a new admin handler.
+func NewHandler(
+	authService *auth.Service,
+	admin *chat.AdminService,
+	retention *chat.RetentionService,
+	erasure *chat.ErasureService,
+	deadLetters *event.DeadLetters,
+	events event.Store,
+) *Handler {
+	return &Handler{
+		auth:        authService,
+		admin:       admin,
+		retention:   retention,
+		erasure:     erasure,
+		deadLetters: deadLetters,
+		events:      events,
+	}
+}
+
+// Register registers the admin endpoints on mux.
+func (x *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc(RoomListPath, x.HandleRooms)
+	mux.HandleFunc(RoomsPath, x.HandleRooms)
+	mux.HandleFunc(UsersPath, x.HandleUsers)
+	mux.HandleFunc(ClusterPath, x.HandleCluster)
+	mux.HandleFunc(DeadLetterListPath, x.HandleDeadLetters)
+	mux.HandleFunc(DeadLettersPath, x.HandleDeadLetters)
+	mux.HandleFunc(EventsPath, x.HandleEvents)
+	mux.HandleFunc(ErasureReportPath, x.HandleErasureReport)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type announceRequest struct {
+	Text string `json:"text"`
+}
+
+type disconnectRequest struct {
+	RoomID string `json:"roomID"`
+	Reason string `json:"reason"`
+}
+
+type verifyReportResponse struct {
+	Valid bool `json:"valid"`
+}
+
+type retentionRequest struct {
+	RetentionDays *int `json:"retentionDays"`
+	LegalHold     bool `json:"legalHold"`
+}
+
+// HandleRooms handles /admin/rooms and /admin/rooms/{roomID}/... requests.
+//
+//	GET    /admin/rooms
+//	GET    /admin/rooms/{roomID}/sessions
+//	POST   /admin/rooms/{roomID}/announce
+//	GET    /admin/rooms/{roomID}/retention
+//	PUT    /admin/rooms/{roomID}/retention
+//	GET    /admin/rooms/{roomID}/moderators/{userID}
+//	PUT    /admin/rooms/{roomID}/moderators/{userID}
+//	DELETE /admin/rooms/{roomID}/moderators/{userID}
+func (x *Handler) HandleRooms(w http.ResponseWriter, r *http.Request) {
+	if !x.authorize(w, r) {
+		return
+	}
+
+	var userID string
+	if r.URL.Path == RoomListPath {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, x.admin.Rooms())
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, RoomsPath), "/"), "/")
+	if len(parts) == 3 && parts[1] == "moderators" && parts[2] != "" {
+		parts, userID = parts[:2], parts[2]
+	}
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	roomID, err := uuid.Parse(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, chat.ErrRoomIDInvalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	switch parts[1] {
+	case "sessions":
+		x.handleSessions(w, r, roomID.String())
+	case "announce":
+		x.handleAnnounce(ctx, w, r, roomID.String())
+	case "retention":
+		x.handleRetention(ctx, w, r, roomID.String())
+	case "moderators":
+		x.handleModerator(ctx, w, r, roomID.String(), userID)
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func (x *Handler) handleSessions(w http.ResponseWriter, r *http.Request, roomID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	sessions, err := x.admin.Sessions(roomID)
+	if err != nil {
+		if errors.Is(err, chat.ErrRoomNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		log.Error().Err(err).Msg("admin: listing sessions")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (x *Handler) handleAnnounce(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	roomID string,
+) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	var req announceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).
+		Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := x.admin.Announce(ctx, chat.AnnounceRequest{RoomID: roomID, Text: req.Text})
+	if err != nil {
+		if errors.Is(err, chat.ErrAnnouncementInvalid) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Error().Err(err).Msg("admin: announcing")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (x *Handler) handleRetention(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	roomID string,
+) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req retentionRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).
+			Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := x.retention.SetPolicy(ctx, chat.RetentionPolicy{
+			RoomID:        roomID,
+			RetentionDays: req.RetentionDays,
+			LegalHold:     req.LegalHold,
+		}); err != nil {
+			if errors.Is(err, chat.ErrRetentionDaysInvalid) {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			log.Error().Err(err).Msg("admin: setting retention policy")
+			writeError(w, http.StatusInternalServerError, errInternal)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	policy, err := x.retention.Policy(ctx, roomID)
+	if err != nil {
+		log.Error().Err(err).Msg("admin: reading retention policy")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
+
+func (x *Handler) handleModerator(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	roomID, userID string,
+) {
+	if userID == "" {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	var (
+		role chat.RoleInfo
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		role, err = x.admin.Role(ctx, roomID, userID)
+	case http.MethodPut:
+		role, err = x.admin.SetModerator(ctx, roomID, userID, true)
+	case http.MethodDelete:
+		role, err = x.admin.SetModerator(ctx, roomID, userID, false)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("admin: setting moderator")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, role)
+}
+
+// HandleUsers handles /admin/users/{userID}/... requests.
+//
+//	GET  /admin/users/{userID}/locate
+//	POST /admin/users/{userID}/disconnect
+//	POST /admin/users/{userID}/erase?mode=delete|anonymise
+func (x *Handler) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	if !x.authorize(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, UsersPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	switch parts[1] {
+	case "locate":
+		x.handleLocate(w, r, parts[0])
+	case "disconnect":
+		x.handleDisconnect(w, r, parts[0])
+	case "erase":
+		x.handleErase(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func (x *Handler) handleLocate(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	location, err := x.admin.Locate(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("admin: locating user")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, location)
+}
+
+func (x *Handler) handleDisconnect(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	var req disconnectRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).
+			Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	result, err := x.admin.Disconnect(ctx, chat.DisconnectRequest{
+		UserID: userID,
+		RoomID: req.RoomID,
+		Reason: req.Reason,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("admin: disconnecting user")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (x *Handler) handleErase(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	mode := chat.ErasureMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = chat.ErasureDelete
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), erasureTimeout)
+	defer cancel()
+
+	report, err := x.erasure.Erase(ctx, userID, mode)
+	if err != nil {
+		if errors.Is(err, chat.ErrErasureModeInvalid) ||
+			errors.Is(err, chat.ErrErasureUserIDInvalid) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Error().Err(err).Msg("admin: erasing user")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// HandleCluster handles /admin/cluster/... requests.
+//
+//	GET /admin/cluster/nodes
+//	GET /admin/cluster/rooms
+func (x *Handler) HandleCluster(w http.ResponseWriter, r *http.Request) {
+	if !x.authorize(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, ClusterPath), "/") {
+	case "nodes":
+		writeJSON(w, http.StatusOK, x.admin.Nodes())
+	case "rooms":
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+
+		rooms, err := x.admin.RoomSessions(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("admin: counting sessions")
+			writeError(w, http.StatusInternalServerError, errInternal)
+			return
+		}
+		writeJSON(w, http.StatusOK, rooms)
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+// HandleDeadLetters handles /admin/deadletters and
+// /admin/deadletters/{id}/... requests on the node that serves them.
+//
+//	GET    /admin/deadletters
+//	GET    /admin/deadletters/{id}
+//	DELETE /admin/deadletters/{id}
+//	POST   /admin/deadletters/{id}/replay
+func (x *Handler) HandleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !x.authorize(w, r) {
+		return
+	}
+
+	if r.URL.Path == DeadLetterListPath {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, x.deadLetters.List())
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, DeadLettersPath), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		x.handleDeadLetter(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "replay":
+		x.handleReplay(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+	}
+}
+
+func (x *Handler) handleDeadLetter(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		letter, err := x.deadLetters.Get(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, letter)
+	case http.MethodDelete:
+		if err := x.deadLetters.Delete(id); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+type replayResponse struct {
+	Replayed   bool             `json:"replayed"`
+	DeadLetter event.DeadLetter `json:"deadLetter"`
+}
+
+func (x *Handler) handleReplay(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	letter, err := x.deadLetters.Replay(ctx, id)
+	switch {
+	case errors.Is(err, event.ErrDeadLetterNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, event.ErrHandlerUnknown):
+		log.Error().Err(err).Msg("admin: replaying dead letter")
+		writeError(w, http.StatusInternalServerError, errInternal)
+	default:
+		// A failed replay keeps the letter with its new error.
+		writeJSON(w, http.StatusOK, replayResponse{Replayed: err == nil, DeadLetter: letter})
+	}
+}
+
+type eventResponse struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Key       string        `json:"key,omitempty"`
+	Payload   event.Payload `json:"payload"`
+	OccuredAt time.Time     `json:"occuredAt"`
+}
+
+// HandleEvents replays kept events of one name in a time range.
+// from and to are RFC 3339 times, to defaults to now.
+//
+//	GET /admin/events?name={name}&from={from}&to={to}&limit={limit}
+func (x *Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if !x.authorize(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	if x.events == nil {
+		writeError(w, http.StatusNotFound, errEventStoreOff)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, errors.New("to is before from"))
+		return
+	}
+	limit := defaultEventLimit
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxEventLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxEventLimit))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	events := make([]eventResponse, 0)
+	_, err = chat.ReplayEvents(ctx, x.events, query.Get("name"), from, to, func(evt event.Event) error {
+		if len(events) >= limit {
+			return errEventLimit
+		}
+		events = append(events, eventResponse{
+			ID:        evt.ID,
+			Name:      evt.Name,
+			Key:       evt.Key,
+			Payload:   evt.Payload,
+			OccuredAt: evt.OccuredAt,
+		})
+		return nil
+	})
+	switch {
+	case err == nil, errors.Is(err, errEventLimit):
+		writeJSON(w, http.StatusOK, events)
+	case errors.Is(err, event.ErrInvalidEventType):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		log.Error().Err(err).Msg("admin: replaying events")
+		writeError(w, http.StatusInternalServerError, errInternal)
+	}
+}
+
+// HandleErasureReport checks that an erasure report, as returned by
+// erase, was signed with the report key of this server and not changed.
+//
+//	POST /admin/erasure-reports/verify
+func (x *Handler) HandleErasureReport(w http.ResponseWriter, r *http.Request) {
+	if !x.authorize(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+
+	var report chat.ErasureReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).
+		Decode(&report); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := x.erasure.VerifyReport(report)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, verifyReportResponse{Valid: true})
+	case errors.Is(err, chat.ErrErasureReportInvalid):
+		writeJSON(w, http.StatusOK, verifyReportResponse{Valid: false})
+	default:
+		log.Error().Err(err).Msg("admin: verifying erasure report")
+		writeError(w, http.StatusInternalServerError, errInternal)
+	}
+}
+
+// authorize writes an error response and returns false
+// unless the request carries an admin API key.
+func (x *Handler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	principal, err := x.auth.AuthenticateRequest(r)
+	if err == nil {
+		err = principal.Require(auth.ScopeAdmin, "")
+	}
+	if err != nil {
+		writeError(w, auth.StatusCode(err), err)
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("admin: writing response")
+	}
+}
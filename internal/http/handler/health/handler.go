@@ -3,78 +3,57 @@ package health
 import (
 	"encoding/json"
 	"net/http"
-	"time"
 
-	"github.com/gocql/gocql"
+	"github.com/Salam4nder/chat/internal/health"
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	StatusHealthy   = "Healthy"
-	StatusUnhealthy = "Unhealthy"
-	StatusStarting  = "Starting"
+	// LivePath answers whether the process is running.
+	LivePath = "/livez"
+	// ReadyPath answers whether the service should receive traffic.
+	ReadyPath = "/readyz"
+	// HealthPath is kept for existing probes and answers like ReadyPath.
+	HealthPath = "/health"
 )
 
-type Status struct {
-	Health      string `json:"health"`
-	Timestamp   string `json:"timestamp"`
-	ServiceName string `json:"service_name"`
+type Handler struct {
+	registry *health.Registry
 }
 
-type Handler struct {
-	status     Status
-	scyllaConn *gocql.Session
+func NewHandler(registry *health.Registry) *Handler {
+	return &Handler{registry: registry}
 }
 
-func NewHandler(scyllaConn *gocql.Session) *Handler {
-	return &Handler{
-		status: Status{
-			Health:      "Starting",
-			Timestamp:   time.Now().Format(time.RFC3339),
-			ServiceName: "chat",
-		},
-		scyllaConn: scyllaConn,
-	}
+// Register registers the probe endpoints on mux.
+func (x *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc(LivePath, x.Live)
+	mux.HandleFunc(ReadyPath, x.Ready)
+	mux.HandleFunc(HealthPath, x.Ready)
 }
 
-func (x *Handler) Health(w http.ResponseWriter, _ *http.Request) {
-	status := x.check()
+// Live answers 200 while the process is running.
+func (x *Handler) Live(w http.ResponseWriter, _ *http.Request) {
+	x.write(w, x.registry.Live())
+}
 
+// Ready answers 200 once startup is complete and every check
+// passes, and 503 while starting, draining or unhealthy.
+func (x *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	x.write(w, x.registry.Ready(r.Context()))
+}
+
+func (x *Handler) write(w http.ResponseWriter, report health.Report) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 
-	switch status.Health {
-	case StatusHealthy:
+	if report.Status == health.StatusHealthy {
 		w.WriteHeader(http.StatusOK)
-	case StatusStarting:
-		w.WriteHeader(http.StatusServiceUnavailable)
-	case StatusUnhealthy:
+	} else {
 		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 
-	if err := json.NewEncoder(w).Encode(status); err != nil {
+	if err := json.NewEncoder(w).Encode(report); err != nil {
 		log.Error().Err(err).Msg("health: error writing response")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
 	}
 }
-
-// Check the health of the server.
-// The different statuses are:
-// - Starting (when the server is starting)
-// - Healthy (when the server is ready to accept requests)
-// - Unhealthy (when the server is not ready to accept requests).
-func (x *Handler) check() Status {
-	x.status.Health = StatusHealthy
-
-	if x.scyllaConn == nil || x.scyllaConn.Closed() {
-		x.status.Health = StatusUnhealthy
-	}
-
-	x.status.Timestamp = time.Now().Format(time.RFC3339)
-	log.Info().
-		Str("Health: %s", x.status.Health).
-		Str("Timestamp: %s", x.status.Timestamp).
-		Send()
-
-	return x.status
-}
@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/auth"
+	"github.com/Salam4nder/chat/internal/chat"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ExecutePath is the path prefix of incoming webhook calls.
+	ExecutePath = "/hooks/"
+	// maxBodySize is the maximum accepted request body size in bytes.
+	maxBodySize = 64 << 10
+	// requestTimeout is the maximum duration to handle a request.
+	requestTimeout = 5 * time.Second
+)
+
+type Handler struct {
+	service *chat.WebhookService
+	auth    *auth.Service
+}
+
+// NewHandler creates a new webhook handler.
+func NewHandler(service *chat.WebhookService, authService *auth.Service) *Handler {
+	return &Handler{service: service, auth: authService}
+}
+
+type createRequest struct {
+	RoomID string `json:"roomID"`
+	Name   string `json:"name"`
+}
+
+type createResponse struct {
+	ID     string `json:"id"`
+	RoomID string `json:"roomID"`
+	Name   string `json:"name"`
+	Token  string `json:"token"`
+	URL    string `json:"url"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// HandleCreate handles POST /webhooks.
+// It creates a new incoming webhook for a room and returns its secret URL.
+// The caller needs an API key with the moderate scope for the room.
+func (x *Handler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	principal, err := x.auth.AuthenticateRequest(r)
+	if err != nil {
+		writeError(w, auth.StatusCode(err), err)
+		return
+	}
+
+	var req createRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).
+		Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := principal.Require(auth.ScopeModerate, req.RoomID); err != nil {
+		writeError(w, auth.StatusCode(err), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	webhook, token, err := x.service.CreateWebhook(ctx, req.RoomID, req.Name)
+	if err != nil {
+		if errors.Is(err, chat.ErrRoomIDInvalid) ||
+			errors.Is(err, chat.ErrWebhookNameInvalid) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Error().Err(err).Msg("webhook: creating webhook")
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createResponse{
+		ID:     webhook.ID.String(),
+		RoomID: webhook.RoomID,
+		Name:   webhook.Name,
+		Token:  token,
+		URL:    ExecutePath + webhook.ID.String() + "/" + token,
+	})
+}
+
+// HandleExecute handles POST /hooks/{id}/{token} and DELETE /hooks/{id}/{token}.
+// A POST publishes the payload into the webhook's room,
+// a DELETE removes the webhook.
+func (x *Handler) HandleExecute(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, ExecutePath), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	id, err := uuid.Parse(parts[0])
+	if err != nil {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	token := parts[1]
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodPost:
+		var payload chat.WebhookPayload
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).
+			Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		x.respond(w, x.service.Execute(ctx, id, token, payload))
+
+	case http.MethodDelete:
+		x.respond(w, x.service.DeleteWebhook(ctx, id, token))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (x *Handler) respond(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, chat.ErrWebhookUnauthorized):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, chat.ErrWebhookRateLimited):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, chat.ErrWebhookPayloadEmpty),
+		errors.Is(err, chat.ErrWebhookTextInvalid),
+		errors.Is(err, chat.ErrWebhookUsernameInvalid),
+		errors.Is(err, chat.ErrWebhookAttachmentsInvalid):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		log.Error().Err(err).Msg("webhook: executing webhook")
+		writeError(w, http.StatusInternalServerError, errors.New("internal error"))
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("webhook: writing response")
+	}
+}
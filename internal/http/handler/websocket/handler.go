@@ -1,40 +1,53 @@
 package websocket
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Salam4nder/chat/internal/auth"
 	"github.com/Salam4nder/chat/internal/chat"
 	"github.com/Salam4nder/chat/internal/event"
+	"github.com/Salam4nder/chat/internal/health"
+	"github.com/Salam4nder/chat/pkg/protocol"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 )
 
+// authTimeout is the maximum duration to check the user of a connection.
+const authTimeout = 5 * time.Second
+
 type Handler struct {
 	registry *event.Registry
+	auth     *auth.Service
+	health   *health.Registry
 }
 
 // NewHandler creates a new websocket handler.
-func NewHandler(registry *event.Registry) *Handler {
-	return &Handler{registry: registry}
+// Upgrades are refused once health reports draining.
+func NewHandler(
+	registry *event.Registry,
+	authService *auth.Service,
+	health *health.Registry,
+) *Handler {
+	return &Handler{registry: registry, auth: authService, health: health}
 }
 
 // HandleConnect handles a new /chat connection.
 // It hanldes websocket upgrades and notifies about connection details.
+// Service accounts authenticate with an API key instead of a userID,
+// and their IDs are refused as a userID.
 func (x *Handler) HandleConnect(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-	}
-	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Error().Err(err).Msg("websocket: upgrading connection")
+	if x.health.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
 		return
 	}
-
 	if r.URL == nil {
 		log.Error().Msg("websocket: url is nil")
+		http.Error(w, "url is nil", http.StatusBadRequest)
 		return
 	}
 	query := r.URL.Query()
@@ -43,30 +56,106 @@ func (x *Handler) HandleConnect(w http.ResponseWriter, r *http.Request) {
 		log.Error().
 			Err(err).
 			Msg("websocket: parsing url query for roomID")
-		return
-	}
-	userID, err := uuid.Parse(query.Get("userID"))
-	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("websocket: parsing url query for userID")
+		http.Error(w, "roomID invalid", http.StatusBadRequest)
 		return
 	}
 	username := query.Get("name")
+
+	var (
+		userID    uuid.UUID
+		readOnly  bool
+		principal *auth.Principal
+	)
+	if _, ok := auth.KeyFromRequest(r); ok {
+		p, err := x.auth.AuthenticateRequest(r)
+		if err == nil {
+			err = p.Require(auth.ScopeRoomsRead, roomID.String())
+		}
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("websocket: authenticating api key")
+			http.Error(w, err.Error(), auth.StatusCode(err))
+			return
+		}
+		principal = &p
+		userID = p.AccountID
+		readOnly = !p.Allows(auth.ScopeRoomsPost, roomID.String())
+		if username == "" {
+			username = p.Name
+		}
+	} else {
+		userID, err = uuid.Parse(query.Get("userID"))
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("websocket: parsing url query for userID")
+			http.Error(w, "userID invalid", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), authTimeout)
+		err = x.auth.RequireUser(ctx, userID)
+		cancel()
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("userID", userID.String()).
+				Msg("websocket: checking userID")
+			http.Error(w, err.Error(), auth.StatusCode(err))
+			return
+		}
+	}
 	if username == "" {
 		log.Warn().
 			Msg("websocket: parsing url query for username")
 
 		username = "unknown"
 	}
+	proto := query.Get(protocol.QueryParam)
+	if proto != "" && proto != protocol.JSON {
+		log.Warn().
+			Str("protocol", proto).
+			Msg("websocket: unknown protocol, falling back to raw")
+
+		proto = ""
+	}
+	var cursor uint64
+	if v := query.Get(protocol.CursorParam); v != "" {
+		cursor, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "cursor invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	conn, err := upgrader.Upgrade(w, r, http.Header{
+		protocol.UserIDHeader: []string{userID.String()},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("websocket: upgrading connection")
+		return
+	}
 
-	if err := x.registry.Publish(
-		event.New(chat.SessionConnectedEvent, chat.SessionConnectedPayload{
-			UserID:   userID.String(),
-			RoomID:   roomID.String(),
-			Username: username,
-			Conn:     conn,
-		}),
+	if err := event.Publish(
+		context.Background(),
+		x.registry,
+		chat.SessionConnected,
+		roomID.String(),
+		chat.SessionConnectedPayload{
+			UserID:    userID.String(),
+			RoomID:    roomID.String(),
+			Username:  username,
+			Protocol:  proto,
+			ReadOnly:  readOnly,
+			Principal: principal,
+			Conn:      conn,
+			Cursor:    cursor,
+		},
 	); err != nil {
 		log.Error().
 			Err(err).
@@ -0,0 +1,229 @@
+// Package metrics defines the Prometheus metrics of the service.
+//
+// Series names are stable and labels are limited to bounded sets
+// such as event names and repository methods.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is the path of the metrics endpoint.
+const Path = "/metrics"
+
+const namespace = "chat"
+
+// registry holds the metrics of the service.
+var registry = prometheus.NewRegistry()
+
+var (
+	// WebsocketConnections is the number of open websocket sessions.
+	WebsocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Number of open websocket sessions.",
+	})
+	// Rooms is the number of rooms on this node.
+	Rooms = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rooms",
+		Help:      "Number of rooms on this node.",
+	})
+	// MessagesReceived counts messages read from websocket sessions.
+	MessagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages read from websocket sessions.",
+	})
+	// MessagesBroadcast counts messages broadcast to the sessions of a room.
+	MessagesBroadcast = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_broadcast_total",
+		Help:      "Messages broadcast to the sessions of a room.",
+	})
+	// SessionWriteErrors counts failed writes to websocket sessions.
+	SessionWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_write_errors_total",
+		Help:      "Failed writes to websocket sessions.",
+	})
+	// MessagesDeduplicated counts duplicate messages a room did not broadcast.
+	MessagesDeduplicated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_deduplicated_total",
+		Help:      "Duplicate messages a room did not broadcast.",
+	})
+	// OutboxRelayed counts outbox entries published by the relay.
+	OutboxRelayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_relayed_total",
+		Help:      "Outbox entries published by the relay.",
+	})
+
+	eventHandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_handler_duration_seconds",
+		Help:      "Duration of event registry handlers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"event"})
+	eventHandlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_handler_errors_total",
+		Help:      "Errors returned by event registry handlers.",
+	}, []string{"event"})
+	eventHandlerRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_handler_retries_total",
+		Help:      "Retries of event registry handlers after retryable errors.",
+	}, []string{"event"})
+	eventHandlerPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_handler_panics_total",
+		Help:      "Panics recovered in event registry handlers.",
+	}, []string{"event"})
+	eventDeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_dead_letters_total",
+		Help:      "Events stored as dead letters after their handler failed.",
+	}, []string{"event"})
+
+	scyllaQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scylla_query_duration_seconds",
+		Help:      "Duration of ScyllaDB queries by repository method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	scyllaQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scylla_query_errors_total",
+		Help:      "Failed ScyllaDB queries by repository method.",
+	}, []string{"method"})
+
+	natsPublishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_publish_errors_total",
+		Help:      "Failed NATS publishes by subject, with room IDs left out.",
+	}, []string{"subject"})
+	natsReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_reconnects_total",
+		Help:      "Reconnects to the NATS server.",
+	})
+	natsSlowConsumers = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_slow_consumer_drops_total",
+		Help:      "Times NATS dropped messages because a subscription fell behind.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		WebsocketConnections,
+		Rooms,
+		MessagesReceived,
+		MessagesBroadcast,
+		SessionWriteErrors,
+		MessagesDeduplicated,
+		OutboxRelayed,
+		eventHandlerDuration,
+		eventHandlerErrors,
+		eventHandlerRetries,
+		eventHandlerPanics,
+		eventDeadLetters,
+		scyllaQueryDuration,
+		scyllaQueryErrors,
+		natsPublishErrors,
+		natsReconnects,
+		natsSlowConsumers,
+	)
+}
+
+// Handler returns the HTTP handler of the metrics endpoint.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHandler records the duration and error of an event handler.
+func ObserveHandler(event string, start time.Time, err error) {
+	eventHandlerDuration.WithLabelValues(event).Observe(time.Since(start).Seconds())
+	if err != nil {
+		eventHandlerErrors.WithLabelValues(event).Inc()
+	}
+}
+
+// HandlerRetry counts a retry of an event handler.
+func HandlerRetry(event string) {
+	eventHandlerRetries.WithLabelValues(event).Inc()
+}
+
+// HandlerPanic counts a panic recovered in an event handler.
+func HandlerPanic(event string) {
+	eventHandlerPanics.WithLabelValues(event).Inc()
+}
+
+// DeadLetter counts an event stored as a dead letter.
+func DeadLetter(event string) {
+	eventDeadLetters.WithLabelValues(event).Inc()
+}
+
+// NATSPublishError counts a failed NATS publish on a subject.
+// The subject must not contain unbounded tokens such as room IDs.
+func NATSPublishError(subject string) {
+	natsPublishErrors.WithLabelValues(subject).Inc()
+}
+
+// NATSOptions returns NATS connection options that count
+// reconnects and slow consumer drops.
+func NATSOptions() []nats.Option {
+	return []nats.Option{
+		nats.ReconnectHandler(func(*nats.Conn) {
+			natsReconnects.Inc()
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			if errors.Is(err, nats.ErrSlowConsumer) {
+				natsSlowConsumers.Inc()
+			}
+		}),
+	}
+}
+
+// queryObserver records ScyllaDB queries and batches of a repository method.
+type queryObserver string
+
+// Query returns a gocql observer that records the queries
+// of the given repository method.
+func Query(method string) gocql.QueryObserver {
+	return queryObserver(method)
+}
+
+// Batch returns a gocql observer that records the batches
+// of the given repository method.
+func Batch(method string) gocql.BatchObserver {
+	return queryObserver(method)
+}
+
+func (x queryObserver) ObserveQuery(_ context.Context, q gocql.ObservedQuery) {
+	x.observe(q.End.Sub(q.Start), q.Err)
+}
+
+func (x queryObserver) ObserveBatch(_ context.Context, b gocql.ObservedBatch) {
+	x.observe(b.End.Sub(b.Start), b.Err)
+}
+
+func (x queryObserver) observe(d time.Duration, err error) {
+	scyllaQueryDuration.WithLabelValues(string(x)).Observe(d.Seconds())
+	if err != nil {
+		scyllaQueryErrors.WithLabelValues(string(x)).Inc()
+	}
+}
@@ -0,0 +1,167 @@
+package slackimport
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrArchiveInvalid   = errors.New("slack import: archive invalid")
+	ErrTimestampInvalid = errors.New("slack import: timestamp invalid")
+)
+
+// Channel is a channel of a Slack export.
+type Channel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// User is a user of a Slack export.
+type User struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"profile"`
+}
+
+// DisplayName returns the name shown for the user.
+func (x User) DisplayName() string {
+	for _, name := range []string{x.Profile.DisplayName, x.Profile.RealName, x.RealName, x.Name} {
+		if name != "" {
+			return name
+		}
+	}
+	return x.ID
+}
+
+// Message is a message of a Slack export.
+type Message struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	Username string `json:"username"`
+	Text     string `json:"text"`
+	Ts       string `json:"ts"`
+	Files    []File `json:"files"`
+}
+
+// File is a file shared in a Slack message.
+type File struct {
+	Name       string `json:"name"`
+	Title      string `json:"title"`
+	URLPrivate string `json:"url_private"`
+	Permalink  string `json:"permalink"`
+}
+
+// Archive is an opened Slack export zip.
+type Archive struct {
+	Channels []Channel
+	Users    map[string]User
+
+	files map[string]*zip.File
+}
+
+// OpenArchive reads the channels and users of a Slack export.
+// Private channels from groups.json are included.
+func OpenArchive(r *zip.Reader) (*Archive, error) {
+	x := &Archive{
+		Users: make(map[string]User),
+		files: make(map[string]*zip.File, len(r.File)),
+	}
+	for _, f := range r.File {
+		x.files[f.Name] = f
+	}
+
+	if _, ok := x.files["channels.json"]; !ok {
+		return nil, fmt.Errorf("%w: channels.json missing", ErrArchiveInvalid)
+	}
+	for _, name := range []string{"channels.json", "groups.json"} {
+		var channels []Channel
+		if err := x.decode(name, &channels); err != nil {
+			return nil, err
+		}
+		x.Channels = append(x.Channels, channels...)
+	}
+
+	var users []User
+	if err := x.decode("users.json", &users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		x.Users[user.ID] = user
+	}
+
+	return x, nil
+}
+
+// Days returns the per-day message files of a channel, oldest first.
+func (x *Archive) Days(channel Channel) []string {
+	var days []string
+	for name := range x.files {
+		if path.Dir(name) == channel.Name && path.Ext(name) == ".json" {
+			days = append(days, name)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+// Messages reads a per-day message file.
+func (x *Archive) Messages(name string) ([]Message, error) {
+	var messages []Message
+	if err := x.decode(name, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// decode decodes a JSON file of the archive into v.
+// Missing files leave v unchanged.
+func (x *Archive) decode(name string, v any) error {
+	f, ok := x.files[name]
+	if !ok {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("slack import: opening %s, %w", name, err)
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%w: decoding %s, %s", ErrArchiveInvalid, name, err)
+	}
+	return nil
+}
+
+// ParseTimestamp parses a Slack message timestamp such as "1355517523.000005".
+func ParseTimestamp(ts string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrTimestampInvalid, ts)
+	}
+
+	var micros int64
+	if frac != "" {
+		if len(frac) > 6 {
+			frac = frac[:6]
+		}
+		frac += strings.Repeat("0", 6-len(frac))
+		if micros, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("%w: %s", ErrTimestampInvalid, ts)
+		}
+	}
+
+	return time.Unix(s, micros*int64(time.Microsecond)).UTC(), nil
+}
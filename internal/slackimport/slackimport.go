@@ -0,0 +1,332 @@
+// Package slackimport imports history from Slack export archives.
+//
+// Channels are mapped to rooms and Slack users to user IDs, either
+// through an explicit mapping or by deriving stable UUIDs. Message IDs
+// are derived from the channel and the original timestamp, so that
+// re-running an import overwrites instead of duplicating messages.
+// A checkpoint file records imported days, so that an interrupted
+// import resumes where it stopped.
+package slackimport
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/chat"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// textMessage is the stored type of imported messages.
+const textMessage = "TextMessage"
+
+// uuidEpoch is the number of 100ns intervals between
+// the UUID epoch, 15 Oct 1582, and the Unix epoch.
+const uuidEpoch = 0x01B21DD213814000
+
+// namespace is the namespace of derived room and user IDs.
+var namespace = uuid.MustParse("6f3c9a52-4f3e-4b8e-9d8e-5a1c0c7e2b11")
+
+// ignoredSubtypes are message subtypes that carry no conversation.
+var ignoredSubtypes = map[string]bool{
+	"channel_join":    true,
+	"channel_leave":   true,
+	"channel_topic":   true,
+	"channel_purpose": true,
+	"channel_name":    true,
+	"channel_archive": true,
+	"group_join":      true,
+	"group_leave":     true,
+}
+
+var mention = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|[^>]*)?>`)
+
+// Mapping maps Slack channels and users to room and user IDs.
+// Channels are keyed by ID or name, users by ID. Unmapped channels
+// and users get IDs derived from their Slack IDs.
+type Mapping struct {
+	Channels map[string]string `json:"channels"`
+	Users    map[string]string `json:"users"`
+}
+
+// LoadMapping reads a JSON mapping file.
+func LoadMapping(name string) (Mapping, error) {
+	var m Mapping
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return m, fmt.Errorf("slack import: reading mapping, %w", err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("slack import: decoding mapping, %w", err)
+	}
+	return m, nil
+}
+
+// RoomID returns the room ID of a channel.
+func (x Mapping) RoomID(channel Channel) string {
+	if id, ok := x.Channels[channel.ID]; ok {
+		return id
+	}
+	if id, ok := x.Channels[channel.Name]; ok {
+		return id
+	}
+	return uuid.NewSHA1(namespace, []byte("channel:"+channel.ID)).String()
+}
+
+// UserID returns the user ID of a Slack user.
+func (x Mapping) UserID(slackID string) string {
+	if id, ok := x.Users[slackID]; ok {
+		return id
+	}
+	return uuid.NewSHA1(namespace, []byte("user:"+slackID)).String()
+}
+
+// Checkpoint records the imported day files.
+type Checkpoint struct {
+	path string
+	Done map[string]bool `json:"done"`
+}
+
+// LoadCheckpoint reads a checkpoint file. A missing file is an empty checkpoint.
+func LoadCheckpoint(name string) (*Checkpoint, error) {
+	x := &Checkpoint{path: name, Done: make(map[string]bool)}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return x, nil
+		}
+		return nil, fmt.Errorf("slack import: reading checkpoint, %w", err)
+	}
+	if err := json.Unmarshal(b, x); err != nil {
+		return nil, fmt.Errorf("slack import: decoding checkpoint, %w", err)
+	}
+	if x.Done == nil {
+		x.Done = make(map[string]bool)
+	}
+	return x, nil
+}
+
+// Mark records a day file as imported and saves the checkpoint.
+func (x *Checkpoint) Mark(name string) error {
+	x.Done[name] = true
+
+	b, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	tmp := x.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("slack import: writing checkpoint, %w", err)
+	}
+	if err := os.Rename(tmp, x.path); err != nil {
+		return fmt.Errorf("slack import: writing checkpoint, %w", err)
+	}
+	return nil
+}
+
+// Report summarises an import.
+type Report struct {
+	// Rooms maps channel names to room IDs.
+	Rooms map[string]string `json:"rooms"`
+	// Days is the number of imported day files, Resumed the number
+	// skipped because an earlier run imported them.
+	Days    int `json:"days"`
+	Resumed int `json:"resumed"`
+	// Messages is the number of written messages. Ignored messages carry
+	// no conversation, expired ones are past the room's retention.
+	Messages int `json:"messages"`
+	Ignored  int `json:"ignored"`
+	Expired  int `json:"expired"`
+}
+
+// Importer writes the messages of a Slack archive to rooms.
+type Importer struct {
+	messageRepo db.MessageRepository
+	retention   *chat.RetentionService
+	mapping     Mapping
+}
+
+// NewImporter returns a new instance of Importer.
+func NewImporter(
+	messageRepo db.MessageRepository,
+	retention *chat.RetentionService,
+	mapping Mapping,
+) *Importer {
+	return &Importer{
+		messageRepo: messageRepo,
+		retention:   retention,
+		mapping:     mapping,
+	}
+}
+
+// Import imports every channel of the archive, skipping days recorded
+// in the checkpoint. Messages keep their original timestamps and get
+// the TTL left under the retention of their room.
+func (x *Importer) Import(ctx context.Context, archive *Archive, checkpoint *Checkpoint) (Report, error) {
+	report := Report{Rooms: make(map[string]string, len(archive.Channels))}
+
+	for _, channel := range archive.Channels {
+		roomID := x.mapping.RoomID(channel)
+		report.Rooms[channel.Name] = roomID
+
+		ttl, err := x.retention.TTL(ctx, roomID)
+		if err != nil {
+			return report, fmt.Errorf("slack import: reading retention of %s, %w", channel.Name, err)
+		}
+
+		for _, day := range archive.Days(channel) {
+			if checkpoint.Done[day] {
+				report.Resumed++
+				continue
+			}
+
+			messages, err := archive.Messages(day)
+			if err != nil {
+				return report, err
+			}
+			for _, message := range messages {
+				written, err := x.write(ctx, archive, channel, roomID, ttl, message)
+				if err != nil {
+					return report, fmt.Errorf("slack import: %s, %w", day, err)
+				}
+				switch written {
+				case writeIgnored:
+					report.Ignored++
+				case writeExpired:
+					report.Expired++
+				default:
+					report.Messages++
+				}
+			}
+
+			if err := checkpoint.Mark(day); err != nil {
+				return report, err
+			}
+			report.Days++
+			log.Info().Str("file", day).Int("messages", len(messages)).Msg("slack import: day imported")
+		}
+	}
+
+	return report, nil
+}
+
+type writeResult int
+
+const (
+	writeDone writeResult = iota
+	writeIgnored
+	writeExpired
+)
+
+func (x *Importer) write(
+	ctx context.Context,
+	archive *Archive,
+	channel Channel,
+	roomID string,
+	ttl time.Duration,
+	message Message,
+) (writeResult, error) {
+	if message.Type != "message" || ignoredSubtypes[message.Subtype] {
+		return writeIgnored, nil
+	}
+	body := x.body(archive, message)
+	if body == "" {
+		return writeIgnored, nil
+	}
+
+	t, err := ParseTimestamp(message.Ts)
+	if err != nil {
+		return 0, err
+	}
+	if ttl > 0 {
+		if ttl -= time.Since(t); ttl <= 0 {
+			return writeExpired, nil
+		}
+	}
+
+	params := db.CreateMessageByRoomParams{
+		ID:        messageID(channel.ID, message.Ts, t),
+		Data:      []byte(body),
+		Type:      textMessage,
+		Sender:    message.Username,
+		RoomID:    roomID,
+		Timestamp: t,
+		TTL:       ttl,
+	}
+	if message.User != "" {
+		params.UserID = x.mapping.UserID(message.User)
+		if user, ok := archive.Users[message.User]; ok {
+			params.Sender = user.DisplayName()
+		} else if params.Sender == "" {
+			params.Sender = message.User
+		}
+	}
+	if params.Sender == "" {
+		params.Sender = "slack"
+	}
+
+	if err := x.messageRepo.CreateMessageByRoom(ctx, params); err != nil {
+		return 0, err
+	}
+	return writeDone, nil
+}
+
+// body returns the message text with user mentions resolved
+// and shared files appended as links.
+func (x *Importer) body(archive *Archive, message Message) string {
+	text := mention.ReplaceAllStringFunc(message.Text, func(m string) string {
+		id := mention.FindStringSubmatch(m)[1]
+		if user, ok := archive.Users[id]; ok {
+			return "@" + user.DisplayName()
+		}
+		return "@" + id
+	})
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(text))
+	for _, file := range message.Files {
+		url := file.Permalink
+		if url == "" {
+			url = file.URLPrivate
+		}
+		if url == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		title := file.Title
+		if title == "" {
+			title = file.Name
+		}
+		if title != "" {
+			b.WriteString(title)
+			b.WriteString(": ")
+		}
+		b.WriteString(url)
+	}
+	return b.String()
+}
+
+// messageID derives a time based ID from a channel and a Slack timestamp.
+// The same message always gets the same ID, which keeps imports idempotent.
+func messageID(channelID, ts string, t time.Time) gocql.UUID {
+	sum := sha1.Sum([]byte(channelID + "/" + ts))
+	clock := uint32(sum[0])<<8 | uint32(sum[1])
+	return gocql.TimeUUIDWith(t.UnixNano()/100+uuidEpoch, clock, sum[2:8])
+}
+
+// DefaultCheckpointPath returns the checkpoint path of an archive.
+func DefaultCheckpointPath(archive string) string {
+	return filepath.Clean(archive) + ".checkpoint"
+}
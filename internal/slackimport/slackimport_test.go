@@ -0,0 +1,168 @@
+package slackimport
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/internal/chat"
+	db "github.com/Salam4nder/chat/internal/db/keyspace/chat"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+)
+
+// messageRepo keeps created messages by ID,
+// so that writing a message again overwrites it.
+type messageRepo struct {
+	db.MessageRepository
+	messages map[gocql.UUID]db.CreateMessageByRoomParams
+}
+
+func (x *messageRepo) CreateMessageByRoom(_ context.Context, params db.CreateMessageByRoomParams) error {
+	x.messages[params.ID] = params
+	return nil
+}
+
+// policyRepo has no room policies.
+type policyRepo struct {
+	db.RoomPolicyRepository
+}
+
+func (policyRepo) ReadRoomPolicy(context.Context, string) (db.RoomPolicy, error) {
+	return db.RoomPolicy{}, db.ErrRoomPolicyNotFound
+}
+
+// newArchive returns an archive with the given files encoded as JSON.
+func newArchive(t *testing.T, files map[string]any) *Archive {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, v := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	archive, err := OpenArchive(zr)
+	require.NoError(t, err)
+	return archive
+}
+
+func Test_messageID(t *testing.T) {
+	ts := "1355517523.000005"
+	at, err := ParseTimestamp(ts)
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1355517523, 5000).UTC(), at)
+
+	id := messageID("C1", ts, at)
+	require.Equal(t, id, messageID("C1", ts, at))
+	require.Equal(t, 1, id.Version())
+	require.True(t, at.Equal(id.Time()))
+	require.NotEqual(t, id, messageID("C2", ts, at))
+	require.NotEqual(t, id, messageID("C1", "1355517523.000006", at))
+
+	_, err = ParseTimestamp("not a ts")
+	require.ErrorIs(t, err, ErrTimestampInvalid)
+}
+
+func Test_Mapping(t *testing.T) {
+	mapping := Mapping{
+		Channels: map[string]string{"general": "room-general"},
+		Users:    map[string]string{"U1": "ann"},
+	}
+	require.Equal(t, "room-general", mapping.RoomID(Channel{ID: "C1", Name: "general"}))
+	require.Equal(t, "ann", mapping.UserID("U1"))
+
+	// Unmapped channels and users get stable derived IDs.
+	random := Channel{ID: "C2", Name: "random"}
+	require.Equal(t, mapping.RoomID(random), Mapping{}.RoomID(random))
+	require.NotEqual(t, mapping.RoomID(random), mapping.RoomID(Channel{ID: "C3", Name: "random"}))
+	require.Equal(t, mapping.UserID("U2"), Mapping{}.UserID("U2"))
+	require.NotEqual(t, mapping.UserID("U2"), mapping.UserID("U3"))
+	_, err := gocql.ParseUUID(mapping.UserID("U2"))
+	require.NoError(t, err)
+}
+
+func Test_Importer_Import(t *testing.T) {
+	ctx := context.Background()
+	ts := time.Now().Add(-time.Hour).Unix()
+	stamp := func(i int64) string { return strconv.FormatInt(ts+i, 10) + ".000100" }
+	archive := newArchive(t, map[string]any{
+		"channels.json": []Channel{{ID: "C1", Name: "general"}},
+		"users.json": []map[string]any{
+			{"id": "U1", "name": "ann", "profile": map[string]string{"display_name": "Ann"}},
+			{"id": "U2", "name": "bob"},
+		},
+		"general/2024-01-01.json": []Message{
+			{Type: "message", User: "U1", Text: "hi <@U2> and <@U9|carol>", Ts: stamp(0)},
+			{Type: "message", Subtype: "channel_join", User: "U2", Text: "<@U2> has joined", Ts: stamp(1)},
+			{Type: "message", User: "U2", Ts: stamp(2), Files: []File{
+				{Name: "a.png", Permalink: "https://slack.test/a.png"},
+			}},
+		},
+		"general/2024-01-02.json": []Message{
+			{Type: "message", Username: "deploybot", Text: "deployed", Ts: stamp(3)},
+		},
+	})
+
+	messages := &messageRepo{messages: make(map[gocql.UUID]db.CreateMessageByRoomParams)}
+	mapping := Mapping{Users: map[string]string{"U1": "ann"}}
+	importer := NewImporter(messages, chat.NewRetentionService(policyRepo{}, messages, 0), mapping)
+
+	checkpoint, err := LoadCheckpoint(filepath.Join(t.TempDir(), "checkpoint"))
+	require.NoError(t, err)
+	report, err := importer.Import(ctx, archive, checkpoint)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Days)
+	require.Equal(t, 3, report.Messages)
+	require.Equal(t, 1, report.Ignored)
+	roomID := mapping.RoomID(Channel{ID: "C1"})
+	require.Equal(t, map[string]string{"general": roomID}, report.Rooms)
+
+	at, err := ParseTimestamp(stamp(0))
+	require.NoError(t, err)
+	m := messages.messages[messageID("C1", stamp(0), at)]
+	require.Equal(t, "hi @bob and @U9", string(m.Data))
+	require.Equal(t, "ann", m.UserID)
+	require.Equal(t, "Ann", m.Sender)
+	require.Equal(t, roomID, m.RoomID)
+	require.True(t, at.Equal(m.Timestamp))
+
+	at, err = ParseTimestamp(stamp(2))
+	require.NoError(t, err)
+	m = messages.messages[messageID("C1", stamp(2), at)]
+	require.Equal(t, "a.png: https://slack.test/a.png", string(m.Data))
+	require.Equal(t, mapping.UserID("U2"), m.UserID)
+	require.Equal(t, "bob", m.Sender)
+
+	at, err = ParseTimestamp(stamp(3))
+	require.NoError(t, err)
+	m = messages.messages[messageID("C1", stamp(3), at)]
+	require.Empty(t, m.UserID)
+	require.Equal(t, "deploybot", m.Sender)
+
+	t.Run("Resume", func(t *testing.T) {
+		report, err := importer.Import(ctx, archive, checkpoint)
+		require.NoError(t, err)
+		require.Equal(t, 2, report.Resumed)
+		require.Zero(t, report.Messages)
+	})
+
+	t.Run("Again", func(t *testing.T) {
+		checkpoint, err := LoadCheckpoint(filepath.Join(t.TempDir(), "checkpoint"))
+		require.NoError(t, err)
+		report, err := importer.Import(ctx, archive, checkpoint)
+		require.NoError(t, err)
+		require.Equal(t, 3, report.Messages)
+		require.Len(t, messages.messages, 3)
+	})
+}
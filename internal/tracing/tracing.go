@@ -0,0 +1,181 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace
+// context across broker messages and zerolog log lines.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Salam4nder/chat/internal/broker"
+	"github.com/Salam4nder/chat/internal/config"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters.
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// instrumentation names the tracer of the service.
+const instrumentation = "github.com/Salam4nder/chat"
+
+var ErrExporterInvalid = errors.New("tracing exporter invalid")
+
+// Setup installs the global tracer provider and propagator.
+// It returns a function that flushes and stops the exporter.
+// Without an exporter spans are not recorded but incoming trace
+// context is still propagated.
+func Setup(ctx context.Context, serviceName string, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+	switch cfg.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+
+	case ExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: opening file, %w", err)
+		}
+		closer = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+
+	default:
+		return nil, fmt.Errorf("tracing: %w: %q", ErrExporterInvalid, cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: creating exporter, %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span with the tracer of the service.
+func Start(
+	ctx context.Context,
+	name string,
+	opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx to message headers.
+func Inject(ctx context.Context, header broker.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(header))
+}
+
+// Extract returns ctx with the trace context read from message headers.
+func Extract(ctx context.Context, header broker.Header) context.Context {
+	if header == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(header))
+}
+
+// InjectMap returns the trace context of ctx as a map, for payloads
+// that carry it themselves.
+func InjectMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// ExtractMap returns ctx with the trace context read from a map
+// written by InjectMap.
+func ExtractMap(ctx context.Context, m map[string]string) context.Context {
+	if len(m) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(m))
+}
+
+// headerCarrier adapts message headers to a propagation carrier.
+type headerCarrier broker.Header
+
+func (x headerCarrier) Get(key string) string {
+	return broker.Header(x).Get(key)
+}
+
+func (x headerCarrier) Set(key, value string) {
+	broker.Header(x).Set(key, value)
+}
+
+func (x headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(x))
+	for k := range x {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// LogHook adds the trace and span IDs of the event context
+// to log lines written with zerolog.Event.Ctx.
+type LogHook struct{}
+
+// Run implements zerolog.Hook.
+func (LogHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	sc := trace.SpanContextFromContext(e.GetCtx())
+	if !sc.IsValid() {
+		return
+	}
+	e.Str("traceID", sc.TraceID().String()).
+		Str("spanID", sc.SpanID().String())
+}
@@ -0,0 +1,409 @@
+package wire
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of wire.proto. Zero values are left out, as in
+// proto3, and fields of unknown number or type are skipped.
+
+type protoEncoder []byte
+
+func (x *protoEncoder) varint(num protowire.Number, v uint64) {
+	if v == 0 {
+		return
+	}
+	*x = protowire.AppendTag(*x, num, protowire.VarintType)
+	*x = protowire.AppendVarint(*x, v)
+}
+
+func (x *protoEncoder) bytes(num protowire.Number, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	*x = protowire.AppendTag(*x, num, protowire.BytesType)
+	*x = protowire.AppendBytes(*x, b)
+}
+
+func (x *protoEncoder) string(num protowire.Number, s string) {
+	x.bytes(num, []byte(s))
+}
+
+// element encodes an element of a repeated string field,
+// which is kept even if empty.
+func (x *protoEncoder) element(num protowire.Number, s string) {
+	*x = protowire.AppendTag(*x, num, protowire.BytesType)
+	*x = protowire.AppendString(*x, s)
+}
+
+// message encodes a nested message, even if empty, so that a set
+// oneof field stays set.
+func (x *protoEncoder) message(num protowire.Number, encode func(*protoEncoder)) {
+	var nested protoEncoder
+	encode(&nested)
+	*x = protowire.AppendTag(*x, num, protowire.BytesType)
+	*x = protowire.AppendBytes(*x, nested)
+}
+
+// timestamp encodes a google.protobuf.Timestamp, unless t is zero.
+func (x *protoEncoder) timestamp(num protowire.Number, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	x.message(num, func(ts *protoEncoder) {
+		ts.varint(1, uint64(t.Unix()))
+		ts.varint(2, uint64(t.Nanosecond()))
+	})
+}
+
+func (x *protoEncoder) bool(num protowire.Number, b bool) {
+	if b {
+		x.varint(num, 1)
+	}
+}
+
+func marshalProto(env Envelope) []byte {
+	var x protoEncoder
+	x.varint(1, uint64(env.Version))
+	x.string(2, env.EventID)
+	x.string(3, env.EventName)
+	x.string(4, env.OriginNode)
+	x.timestamp(5, env.OccurredAt)
+	keys := make([]string, 0, len(env.Trace))
+	for key := range env.Trace {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		x.message(6, func(entry *protoEncoder) {
+			entry.string(1, key)
+			entry.string(2, env.Trace[key])
+		})
+	}
+	if m := env.Message; m != nil {
+		x.message(10, func(x *protoEncoder) {
+			x.string(1, m.ID)
+			x.varint(2, uint64(int64(m.Type)))
+			x.string(3, m.RoomID)
+			x.string(4, m.SessionID)
+			x.bytes(5, m.Body)
+			x.string(6, m.Author)
+			x.string(7, m.Timestamp)
+			for _, c := range m.Components {
+				x.message(8, func(x *protoEncoder) {
+					x.string(1, c.Type)
+					x.string(2, c.ID)
+					x.string(3, c.Label)
+					x.string(4, c.Style)
+					x.string(5, c.Placeholder)
+					for _, o := range c.Options {
+						x.message(6, func(x *protoEncoder) {
+							x.string(1, o.Label)
+							x.string(2, o.Value)
+						})
+					}
+				})
+			}
+		})
+	}
+	if i := env.Interaction; i != nil {
+		x.message(11, func(x *protoEncoder) {
+			x.string(1, i.RoomID)
+			x.string(2, i.MessageID)
+			x.string(3, i.ComponentID)
+			for _, v := range i.Values {
+				x.element(4, v)
+			}
+			x.string(5, i.UserID)
+			x.string(6, i.Author)
+			x.string(7, i.OwnerID)
+		})
+	}
+	if hb := env.Heartbeat; hb != nil {
+		x.message(12, func(x *protoEncoder) {
+			x.string(1, hb.NodeID)
+			x.timestamp(2, hb.StartedAt)
+			x.bool(3, hb.Leaving)
+		})
+	}
+	if req := env.ControlRequest; req != nil {
+		x.message(13, func(x *protoEncoder) {
+			x.string(1, req.Op)
+			x.bytes(2, req.Data)
+		})
+	}
+	if reply := env.ControlReply; reply != nil {
+		x.message(14, func(x *protoEncoder) {
+			x.string(1, reply.NodeID)
+			x.string(2, reply.Error)
+			x.bytes(3, reply.Data)
+		})
+	}
+	return x
+}
+
+// protoField is a decoded field. v holds varints, b length-delimited
+// values.
+type protoField struct {
+	num protowire.Number
+	typ protowire.Type
+	v   uint64
+	b   []byte
+}
+
+func (x protoField) varint(num protowire.Number) bool {
+	return x.num == num && x.typ == protowire.VarintType
+}
+
+func (x protoField) bytes(num protowire.Number) bool {
+	return x.num == num && x.typ == protowire.BytesType
+}
+
+// decodeProto calls fn with every field of a message.
+func decodeProto(data []byte, fn func(f protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalProto(data []byte) (Envelope, error) {
+	var env Envelope
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.varint(1):
+			env.Version = int(f.v)
+		case f.bytes(2):
+			env.EventID = string(f.b)
+		case f.bytes(3):
+			env.EventName = string(f.b)
+		case f.bytes(4):
+			env.OriginNode = string(f.b)
+		case f.bytes(5):
+			t, err := unmarshalProtoTimestamp(f.b)
+			if err != nil {
+				return err
+			}
+			env.OccurredAt = t
+		case f.bytes(6):
+			var key, value string
+			if err := decodeProto(f.b, func(f protoField) error {
+				switch {
+				case f.bytes(1):
+					key = string(f.b)
+				case f.bytes(2):
+					value = string(f.b)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if env.Trace == nil {
+				env.Trace = make(map[string]string)
+			}
+			env.Trace[key] = value
+		case f.bytes(10):
+			m, err := unmarshalProtoMessage(f.b)
+			if err != nil {
+				return err
+			}
+			env.clearPayload()
+			env.Message = &m
+		case f.bytes(11):
+			i, err := unmarshalProtoInteraction(f.b)
+			if err != nil {
+				return err
+			}
+			env.clearPayload()
+			env.Interaction = &i
+		case f.bytes(12):
+			hb, err := unmarshalProtoHeartbeat(f.b)
+			if err != nil {
+				return err
+			}
+			env.clearPayload()
+			env.Heartbeat = &hb
+		case f.bytes(13):
+			var req ControlRequest
+			if err := decodeProto(f.b, func(f protoField) error {
+				switch {
+				case f.bytes(1):
+					req.Op = string(f.b)
+				case f.bytes(2):
+					req.Data = append([]byte(nil), f.b...)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			env.clearPayload()
+			env.ControlRequest = &req
+		case f.bytes(14):
+			var reply ControlReply
+			if err := decodeProto(f.b, func(f protoField) error {
+				switch {
+				case f.bytes(1):
+					reply.NodeID = string(f.b)
+				case f.bytes(2):
+					reply.Error = string(f.b)
+				case f.bytes(3):
+					reply.Data = append([]byte(nil), f.b...)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			env.clearPayload()
+			env.ControlReply = &reply
+		}
+		return nil
+	})
+	return env, err
+}
+
+func unmarshalProtoMessage(data []byte) (Message, error) {
+	var m Message
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.bytes(1):
+			m.ID = string(f.b)
+		case f.varint(2):
+			m.Type = int(int32(f.v))
+		case f.bytes(3):
+			m.RoomID = string(f.b)
+		case f.bytes(4):
+			m.SessionID = string(f.b)
+		case f.bytes(5):
+			m.Body = append([]byte(nil), f.b...)
+		case f.bytes(6):
+			m.Author = string(f.b)
+		case f.bytes(7):
+			m.Timestamp = string(f.b)
+		case f.bytes(8):
+			c, err := unmarshalProtoComponent(f.b)
+			if err != nil {
+				return err
+			}
+			m.Components = append(m.Components, c)
+		}
+		return nil
+	})
+	return m, err
+}
+
+func unmarshalProtoComponent(data []byte) (Component, error) {
+	var c Component
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.bytes(1):
+			c.Type = string(f.b)
+		case f.bytes(2):
+			c.ID = string(f.b)
+		case f.bytes(3):
+			c.Label = string(f.b)
+		case f.bytes(4):
+			c.Style = string(f.b)
+		case f.bytes(5):
+			c.Placeholder = string(f.b)
+		case f.bytes(6):
+			var o Option
+			if err := decodeProto(f.b, func(f protoField) error {
+				switch {
+				case f.bytes(1):
+					o.Label = string(f.b)
+				case f.bytes(2):
+					o.Value = string(f.b)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			c.Options = append(c.Options, o)
+		}
+		return nil
+	})
+	return c, err
+}
+
+func unmarshalProtoInteraction(data []byte) (Interaction, error) {
+	var i Interaction
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.bytes(1):
+			i.RoomID = string(f.b)
+		case f.bytes(2):
+			i.MessageID = string(f.b)
+		case f.bytes(3):
+			i.ComponentID = string(f.b)
+		case f.bytes(4):
+			i.Values = append(i.Values, string(f.b))
+		case f.bytes(5):
+			i.UserID = string(f.b)
+		case f.bytes(6):
+			i.Author = string(f.b)
+		case f.bytes(7):
+			i.OwnerID = string(f.b)
+		}
+		return nil
+	})
+	return i, err
+}
+
+func unmarshalProtoTimestamp(data []byte) (time.Time, error) {
+	var sec, nsec uint64
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.varint(1):
+			sec = f.v
+		case f.varint(2):
+			nsec = f.v
+		}
+		return nil
+	})
+	return time.Unix(int64(sec), int64(int32(nsec))).UTC(), err
+}
+
+func unmarshalProtoHeartbeat(data []byte) (Heartbeat, error) {
+	var hb Heartbeat
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.bytes(1):
+			hb.NodeID = string(f.b)
+		case f.bytes(2):
+			t, err := unmarshalProtoTimestamp(f.b)
+			if err != nil {
+				return err
+			}
+			hb.StartedAt = t
+		case f.varint(3):
+			hb.Leaving = f.v != 0
+		}
+		return nil
+	})
+	return hb, err
+}
@@ -0,0 +1,321 @@
+package wire
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// protoTokens splits proto source into identifiers, numbers,
+// strings and punctuation, dropping comments.
+var protoTokens = regexp.MustCompile(`//[^\n]*|[A-Za-z_][A-Za-z0-9_.]*|\d+|"[^"]*"|[{}<>=;,]`)
+
+// protoParser parses the subset of proto3 that wire.proto uses:
+// messages with scalar, message, repeated, map and oneof fields.
+type protoParser struct {
+	tokens []string
+	pkg    string
+}
+
+func (x *protoParser) next() string {
+	if len(x.tokens) == 0 {
+		panic("wire.proto: unexpected end")
+	}
+	token := x.tokens[0]
+	x.tokens = x.tokens[1:]
+	return token
+}
+
+func (x *protoParser) expect(token string) {
+	if got := x.next(); got != token {
+		panic(fmt.Sprintf("wire.proto: expected %q, got %q", token, got))
+	}
+}
+
+func (x *protoParser) file() *descriptorpb.FileDescriptorProto {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("wire.proto"),
+		Syntax: proto.String("proto3"),
+	}
+	for len(x.tokens) > 0 {
+		switch token := x.next(); token {
+		case "syntax":
+			x.expect("=")
+			x.next()
+			x.expect(";")
+		case "package":
+			x.pkg = x.next()
+			file.Package = proto.String(x.pkg)
+			x.expect(";")
+		case "import":
+			file.Dependency = append(file.Dependency, strings.Trim(x.next(), `"`))
+			x.expect(";")
+		case "message":
+			file.MessageType = append(file.MessageType, x.message())
+		default:
+			panic(fmt.Sprintf("wire.proto: unexpected %q", token))
+		}
+	}
+	return file
+}
+
+func (x *protoParser) message() *descriptorpb.DescriptorProto {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(x.next())}
+	x.expect("{")
+	for {
+		switch token := x.next(); token {
+		case "}":
+			return msg
+		case "oneof":
+			index := int32(len(msg.OneofDecl))
+			msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(x.next())})
+			x.expect("{")
+			for len(x.tokens) > 0 && x.tokens[0] != "}" {
+				field := x.field(x.next(), descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL)
+				field.OneofIndex = proto.Int32(index)
+				msg.Field = append(msg.Field, field)
+			}
+			x.expect("}")
+		case "map":
+			x.expect("<")
+			key := x.next()
+			x.expect(",")
+			value := x.next()
+			x.expect(">")
+			field := x.field(".MapEntry", descriptorpb.FieldDescriptorProto_LABEL_REPEATED)
+			name := field.GetName()
+			entry := strings.ToUpper(name[:1]) + name[1:] + "Entry"
+			field.TypeName = proto.String("." + x.pkg + "." + msg.GetName() + "." + entry)
+			msg.Field = append(msg.Field, field)
+			msg.NestedType = append(msg.NestedType, &descriptorpb.DescriptorProto{
+				Name: proto.String(entry),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					x.entryField("key", 1, key),
+					x.entryField("value", 2, value),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			})
+		case "repeated":
+			msg.Field = append(msg.Field, x.field(x.next(), descriptorpb.FieldDescriptorProto_LABEL_REPEATED))
+		default:
+			msg.Field = append(msg.Field, x.field(token, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL))
+		}
+	}
+}
+
+// field parses the name and number of a field of type typ.
+func (x *protoParser) field(typ string, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+	name := x.next()
+	x.expect("=")
+	number, err := strconv.Atoi(x.next())
+	if err != nil {
+		panic(err)
+	}
+	x.expect(";")
+
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(int32(number)),
+		Label:  label.Enum(),
+	}
+	x.setType(field, typ)
+	return field
+}
+
+func (x *protoParser) entryField(name string, number int32, typ string) *descriptorpb.FieldDescriptorProto {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	x.setType(field, typ)
+	return field
+}
+
+func (x *protoParser) setType(field *descriptorpb.FieldDescriptorProto, typ string) {
+	scalars := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+		"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	}
+	if scalar, ok := scalars[typ]; ok {
+		field.Type = scalar.Enum()
+		return
+	}
+	field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	if !strings.Contains(typ, ".") {
+		typ = x.pkg + "." + typ
+	}
+	field.TypeName = proto.String("." + strings.TrimPrefix(typ, "."))
+}
+
+// envelopeDescriptor builds the descriptor of Envelope from wire.proto,
+// so that the hand-written encoding is checked against the schema.
+func envelopeDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	src, err := os.ReadFile("wire.proto")
+	require.NoError(t, err)
+	parser := &protoParser{tokens: protoTokens.FindAllString(string(src), -1)}
+	tokens := parser.tokens[:0]
+	for _, token := range parser.tokens {
+		if !strings.HasPrefix(token, "//") {
+			tokens = append(tokens, token)
+		}
+	}
+	parser.tokens = tokens
+
+	files := new(protoregistry.Files)
+	require.NoError(t, files.RegisterFile(timestamppb.File_google_protobuf_timestamp_proto))
+	file, err := protodesc.NewFile(parser.file(), files)
+	require.NoError(t, err)
+
+	envelope := file.Messages().ByName("Envelope")
+	require.NotNil(t, envelope)
+	return envelope
+}
+
+func Test_Protobuf_Schema(t *testing.T) {
+	desc := envelopeDescriptor(t)
+
+	tests := []struct {
+		name string
+		env  Envelope
+		// json is the envelope in the protobuf JSON mapping.
+		json string
+	}{
+		{
+			name: "Message",
+			env:  testEnvelope(),
+			json: `{
+				"version": 1,
+				"eventId": "event-1",
+				"eventName": "MessageCreatedInRoom",
+				"originNode": "node-a",
+				"occurredAt": "2024-05-01T12:30:00.123456789Z",
+				"trace": {"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+				"message": {
+					"id": "6f1c2a4e-4b1e-4c4f-9a57-3f0c9e0f8b1a",
+					"type": 1,
+					"roomId": "room-1",
+					"sessionId": "user-1",
+					"body": "aGVsbG8=",
+					"author": "Ann",
+					"timestamp": "2024-05-01T12:30:00Z",
+					"components": [{
+						"type": "select",
+						"id": "vote",
+						"options": [{"label": "Yes", "value": "y"}, {"label": "No", "value": "n"}]
+					}]
+				}
+			}`,
+		},
+		{
+			name: "Negative type",
+			env:  Envelope{Message: &Message{ID: "m", Type: -1, Body: []byte("hello")}},
+			json: `{"version": 1, "message": {"id": "m", "type": -1, "body": "aGVsbG8="}}`,
+		},
+		{
+			name: "Interaction",
+			env: Envelope{
+				EventName: "InteractionCreated",
+				Interaction: &Interaction{
+					RoomID:      "room-1",
+					MessageID:   "m",
+					ComponentID: "vote",
+					Values:      []string{"y", ""},
+					UserID:      "user-2",
+					Author:      "Bob",
+					OwnerID:     "user-1",
+				},
+			},
+			json: `{
+				"version": 1,
+				"eventName": "InteractionCreated",
+				"interaction": {
+					"roomId": "room-1",
+					"messageId": "m",
+					"componentId": "vote",
+					"values": ["y", ""],
+					"userId": "user-2",
+					"author": "Bob",
+					"ownerId": "user-1"
+				}
+			}`,
+		},
+		{
+			name: "Heartbeat",
+			env: Envelope{
+				OriginNode: "node-a",
+				Heartbeat: &Heartbeat{
+					NodeID:    "node-a",
+					StartedAt: time.Date(2024, 5, 1, 12, 0, 0, 5, time.UTC),
+					Leaving:   true,
+				},
+			},
+			json: `{
+				"version": 1,
+				"originNode": "node-a",
+				"heartbeat": {"nodeId": "node-a", "startedAt": "2024-05-01T12:00:00.000000005Z", "leaving": true}
+			}`,
+		},
+		{
+			name: "Control request",
+			env:  Envelope{OriginNode: "node-a", ControlRequest: &ControlRequest{Op: "locate", Data: []byte(`"user-1"`)}},
+			json: `{"version": 1, "originNode": "node-a", "controlRequest": {"op": "locate", "data": "InVzZXItMSI="}}`,
+		},
+		{
+			name: "Empty control request",
+			env:  Envelope{OriginNode: "node-b", ControlRequest: &ControlRequest{Op: "roomSessions"}},
+			json: `{"version": 1, "originNode": "node-b", "controlRequest": {"op": "roomSessions"}}`,
+		},
+		{
+			name: "Control reply",
+			env:  Envelope{OriginNode: "node-b", ControlReply: &ControlReply{NodeID: "node-b", Error: "op unknown", Data: []byte("3")}},
+			json: `{"version": 1, "originNode": "node-b", "controlReply": {"nodeId": "node-b", "error": "op unknown", "data": "Mw=="}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := dynamicpb.NewMessage(desc)
+			require.NoError(t, protojson.Unmarshal([]byte(tt.json), want))
+
+			t.Run("Marshal", func(t *testing.T) {
+				data, err := Marshal(Protobuf, tt.env)
+				require.NoError(t, err)
+
+				got := dynamicpb.NewMessage(desc)
+				require.NoError(t, proto.Unmarshal(data, got))
+				require.True(t, proto.Equal(want, got), "got %v", protojson.Format(got))
+			})
+
+			t.Run("Unmarshal", func(t *testing.T) {
+				data, err := proto.MarshalOptions{Deterministic: true}.Marshal(want)
+				require.NoError(t, err)
+
+				got, err := Unmarshal(data)
+				require.NoError(t, err)
+				env := tt.env
+				env.Version = Version
+				require.Equal(t, env, got)
+			})
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Package wire is the schema of room and control-plane traffic
+// between nodes.
+//
+// Every broker message carries an Envelope: event metadata and one
+// payload. Envelopes are encoded as JSON or protobuf, see wire.proto,
+// and decoded from either, so nodes with different formats configured
+// understand each other. Decoders ignore unknown fields: fields are
+// only ever added, and Version is raised for changes that older nodes
+// cannot read.
+package wire
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is the schema version written by this node.
+// Envelopes of a later version are rejected.
+const Version = 1
+
+// Format is the encoding of envelopes.
+type Format string
+
+// Formats.
+const (
+	JSON     Format = "json"
+	Protobuf Format = "protobuf"
+)
+
+var (
+	ErrFormatInvalid      = errors.New("wire: format invalid")
+	ErrVersionUnsupported = errors.New("wire: version unsupported")
+	ErrPayloadMissing     = errors.New("wire: payload missing")
+)
+
+// ParseFormat returns the format named s. Empty is JSON.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", JSON:
+		return JSON, nil
+	case Protobuf:
+		return Protobuf, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrFormatInvalid, s)
+}
+
+// Envelope is a payload with the metadata of the event it comes from.
+// Exactly one payload is set.
+type Envelope struct {
+	Version    int       `json:"version"`
+	EventID    string    `json:"eventID,omitempty"`
+	EventName  string    `json:"eventName,omitempty"`
+	OriginNode string    `json:"originNode,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+	// Trace holds the W3C trace context of the event,
+	// traceparent and tracestate.
+	Trace map[string]string `json:"trace,omitempty"`
+
+	Message        *Message        `json:"message,omitempty"`
+	Interaction    *Interaction    `json:"interaction,omitempty"`
+	Heartbeat      *Heartbeat      `json:"heartbeat,omitempty"`
+	ControlRequest *ControlRequest `json:"controlRequest,omitempty"`
+	ControlReply   *ControlReply   `json:"controlReply,omitempty"`
+}
+
+func (x Envelope) hasPayload() bool {
+	return x.Message != nil ||
+		x.Interaction != nil ||
+		x.Heartbeat != nil ||
+		x.ControlRequest != nil ||
+		x.ControlReply != nil
+}
+
+// clearPayload unsets every payload, so that the last payload
+// decoded wins, as in a protobuf oneof.
+func (x *Envelope) clearPayload() {
+	x.Message, x.Interaction, x.Heartbeat, x.ControlRequest, x.ControlReply = nil, nil, nil, nil, nil
+}
+
+// Message is a chat message.
+type Message struct {
+	ID         string      `json:"id"`
+	Type       int         `json:"type"`
+	RoomID     string      `json:"roomID"`
+	SessionID  string      `json:"sessionID"`
+	Body       []byte      `json:"body"`
+	Author     string      `json:"author"`
+	Timestamp  string      `json:"timestamp"`
+	Components []Component `json:"components,omitempty"`
+}
+
+// Component is an interactive element attached to a message.
+type Component struct {
+	Type        string   `json:"type"`
+	ID          string   `json:"id"`
+	Label       string   `json:"label,omitempty"`
+	Style       string   `json:"style,omitempty"`
+	Placeholder string   `json:"placeholder,omitempty"`
+	Options     []Option `json:"options,omitempty"`
+}
+
+// Option is a single choice of a select component.
+type Option struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// Interaction is a user clicking a component of a message.
+type Interaction struct {
+	RoomID      string   `json:"roomID"`
+	MessageID   string   `json:"messageID"`
+	ComponentID string   `json:"componentID"`
+	Values      []string `json:"values,omitempty"`
+	UserID      string   `json:"userID"`
+	Author      string   `json:"author"`
+	OwnerID     string   `json:"ownerID"`
+}
+
+// Heartbeat announces a node of the cluster.
+type Heartbeat struct {
+	NodeID    string    `json:"nodeID"`
+	StartedAt time.Time `json:"startedAt"`
+	// Leaving is set by a node that shuts down.
+	Leaving bool `json:"leaving,omitempty"`
+}
+
+// ControlRequest is a control-plane request. The sending node
+// is the OriginNode of the envelope.
+type ControlRequest struct {
+	Op string `json:"op"`
+	// Data is the JSON encoded request, empty if there is none.
+	Data []byte `json:"data,omitempty"`
+}
+
+// ControlReply is the reply of a node to a control-plane request.
+type ControlReply struct {
+	NodeID string `json:"nodeID"`
+	Error  string `json:"error,omitempty"`
+	// Data is the JSON encoded reply, empty if there is none.
+	Data []byte `json:"data,omitempty"`
+}
+
+// Marshal encodes env in format f, at the current Version.
+func Marshal(f Format, env Envelope) ([]byte, error) {
+	env.Version = Version
+	switch f {
+	case JSON:
+		data, err := json.Marshal(env)
+		if err != nil {
+			return nil, fmt.Errorf("wire: encoding json, %w", err)
+		}
+		return data, nil
+	case Protobuf:
+		return marshalProto(env), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrFormatInvalid, f)
+}
+
+// Unmarshal decodes an envelope in either format. JSON envelopes are
+// objects, so they start with '{', which no protobuf envelope does.
+func Unmarshal(data []byte) (Envelope, error) {
+	var (
+		env Envelope
+		err error
+	)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &env); err != nil {
+			return Envelope{}, fmt.Errorf("wire: decoding json, %w", err)
+		}
+	} else if env, err = unmarshalProto(data); err != nil {
+		return Envelope{}, fmt.Errorf("wire: decoding protobuf, %w", err)
+	}
+
+	if env.Version < 1 || env.Version > Version {
+		return Envelope{}, fmt.Errorf("%w: %d", ErrVersionUnsupported, env.Version)
+	}
+	if !env.hasPayload() {
+		return Envelope{}, ErrPayloadMissing
+	}
+	return env, nil
+}
@@ -0,0 +1,139 @@
+package wire
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testEnvelope() Envelope {
+	return Envelope{
+		EventID:    "event-1",
+		EventName:  "MessageCreatedInRoom",
+		OriginNode: "node-a",
+		OccurredAt: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		Trace:      map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		Message: &Message{
+			ID:        "6f1c2a4e-4b1e-4c4f-9a57-3f0c9e0f8b1a",
+			Type:      1,
+			RoomID:    "room-1",
+			SessionID: "user-1",
+			Body:      []byte("hello"),
+			Author:    "Ann",
+			Timestamp: "2024-05-01T12:30:00Z",
+			Components: []Component{{
+				Type:    "select",
+				ID:      "vote",
+				Options: []Option{{Label: "Yes", Value: "y"}, {Label: "No", Value: "n"}},
+			}},
+		},
+	}
+}
+
+func Test_RoundTrip(t *testing.T) {
+	interaction := Envelope{
+		EventName: "InteractionCreated",
+		Interaction: &Interaction{
+			RoomID:      "room-1",
+			MessageID:   "6f1c2a4e-4b1e-4c4f-9a57-3f0c9e0f8b1a",
+			ComponentID: "vote",
+			Values:      []string{"y", ""},
+			UserID:      "user-2",
+			Author:      "Bob",
+			OwnerID:     "user-1",
+		},
+	}
+
+	control := []Envelope{
+		{
+			OriginNode: "node-a",
+			Heartbeat: &Heartbeat{
+				NodeID:    "node-a",
+				StartedAt: time.Date(2024, 5, 1, 12, 0, 0, 5, time.UTC),
+				Leaving:   true,
+			},
+		},
+		{OriginNode: "node-a", ControlRequest: &ControlRequest{Op: "locate", Data: []byte(`"user-1"`)}},
+		{OriginNode: "node-b", ControlRequest: &ControlRequest{Op: "roomSessions"}},
+		{OriginNode: "node-b", ControlReply: &ControlReply{NodeID: "node-b", Error: "op unknown"}},
+		{OriginNode: "node-b", ControlReply: &ControlReply{NodeID: "node-b", Data: []byte("3")}},
+	}
+
+	for _, f := range []Format{JSON, Protobuf} {
+		for _, env := range append([]Envelope{testEnvelope(), interaction}, control...) {
+			data, err := Marshal(f, env)
+			require.NoError(t, err)
+
+			got, err := Unmarshal(data)
+			require.NoError(t, err, f)
+			env.Version = Version
+			require.Equal(t, env, got, f)
+		}
+	}
+}
+
+func Test_UnknownFields(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) {
+		data, err := Marshal(JSON, testEnvelope())
+		require.NoError(t, err)
+
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal(data, &fields))
+		fields["priority"] = "high"
+		fields["message"].(map[string]any)["editedAt"] = "2024-05-01T12:31:00Z"
+		data, err = json.Marshal(fields)
+		require.NoError(t, err)
+
+		got, err := Unmarshal(data)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(got.Message.Body))
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		data, err := Marshal(Protobuf, testEnvelope())
+		require.NoError(t, err)
+
+		data = protowire.AppendTag(data, 99, protowire.BytesType)
+		data = protowire.AppendString(data, "unknown")
+		data = protowire.AppendTag(data, 98, protowire.Fixed64Type)
+		data = protowire.AppendFixed64(data, 42)
+		// A known field number with another type is skipped too.
+		data = protowire.AppendTag(data, 2, protowire.VarintType)
+		data = protowire.AppendVarint(data, 7)
+
+		got, err := Unmarshal(data)
+		require.NoError(t, err)
+		require.Equal(t, "event-1", got.EventID)
+		require.Equal(t, "hello", string(got.Message.Body))
+	})
+}
+
+func Test_Unmarshal_Invalid(t *testing.T) {
+	_, err := Unmarshal([]byte(`{"version":2,"message":{"id":"x"}}`))
+	require.ErrorIs(t, err, ErrVersionUnsupported)
+
+	_, err = Unmarshal([]byte(`{"message":{"id":"x"}}`))
+	require.ErrorIs(t, err, ErrVersionUnsupported)
+
+	_, err = Unmarshal([]byte(`{"version":1}`))
+	require.ErrorIs(t, err, ErrPayloadMissing)
+
+	_, err = Unmarshal([]byte{0x0a, 0x05, 'a'})
+	require.Error(t, err)
+}
+
+func Test_ParseFormat(t *testing.T) {
+	f, err := ParseFormat("")
+	require.NoError(t, err)
+	require.Equal(t, JSON, f)
+
+	f, err = ParseFormat("protobuf")
+	require.NoError(t, err)
+	require.Equal(t, Protobuf, f)
+
+	_, err = ParseFormat("gob")
+	require.ErrorIs(t, err, ErrFormatInvalid)
+}
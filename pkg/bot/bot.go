@@ -0,0 +1,311 @@
+// Package bot is a small framework for writing chat bots.
+//
+// A bot joins one or more rooms, reacts to messages, prefixed
+// commands and clicks on the components of its own messages,
+// and posts replies:
+//
+//	b := bot.New(bot.Config{Addr: "localhost:8080", UserID: id, Name: "deploy-bot", APIKey: key})
+//	b.OnCommand("deploy", func(ctx *bot.Context) error {
+//		return ctx.Reply("deploy "+ctx.Text+"?",
+//			protocol.Button("deploy:yes", "Yes"),
+//			protocol.Button("deploy:no", "No"),
+//		)
+//	})
+//	b.OnInteraction("deploy:yes", func(ctx *bot.Context) error {
+//		return ctx.Reply(ctx.Interaction.Author + " confirmed")
+//	})
+//	_ = b.Join(ctx, roomID)
+//	_ = b.Run(ctx)
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Salam4nder/chat/pkg/client"
+	"github.com/Salam4nder/chat/pkg/protocol"
+)
+
+// DefaultCommandPrefix is the default bot command prefix.
+// It differs from the server's "/" so that bot commands
+// are not intercepted as slash commands.
+const DefaultCommandPrefix = "!"
+
+var (
+	ErrRoomNotJoined = errors.New("bot: room not joined")
+)
+
+// Handler handles a message, command or interaction.
+// A returned error is passed to the bot's error handler.
+type Handler func(ctx *Context) error
+
+// Config defines a bot.
+type Config struct {
+	// Addr is the host:port of the chat server.
+	Addr string
+	// Secure selects wss instead of ws.
+	Secure bool
+	// UserID identifies the bot.
+	UserID string
+	// Name is the bot's display name.
+	Name string
+	// APIKey is the service account API key of the bot.
+	APIKey string
+	// CommandPrefix defaults to DefaultCommandPrefix.
+	CommandPrefix string
+	// OnError is called with handler and connection errors.
+	// Errors are dropped if nil.
+	OnError func(roomID string, err error)
+}
+
+// Context is passed to handlers.
+type Context struct {
+	context.Context
+
+	// Bot is the bot that received the frame.
+	Bot *Bot
+	// RoomID is the room the frame was received in.
+	RoomID string
+	// Frame is the received frame.
+	Frame protocol.Frame
+	// Command is the command name without the prefix, if any.
+	Command string
+	// Args are the whitespace separated command arguments.
+	Args []string
+	// Text is the raw text following the command name.
+	Text string
+	// Interaction is set for interaction frames.
+	Interaction *protocol.Interaction
+}
+
+// Reply posts a message to the room the frame was received in.
+func (x *Context) Reply(text string, components ...protocol.Component) error {
+	return x.Bot.Post(x.RoomID, text, components...)
+}
+
+type received struct {
+	roomID string
+	client *client.Client
+	frame  protocol.Frame
+	err    error
+}
+
+// Bot routes frames from the rooms it joined to handlers.
+type Bot struct {
+	cfg Config
+
+	mu           sync.RWMutex
+	clients      map[string]*client.Client
+	onMessage    []Handler
+	commands     map[string]Handler
+	interactions map[string]Handler
+
+	frames chan received
+	done   chan struct{}
+	once   sync.Once
+}
+
+// New returns a new bot. Register handlers, join rooms and call Run.
+func New(cfg Config) *Bot {
+	if cfg.CommandPrefix == "" {
+		cfg.CommandPrefix = DefaultCommandPrefix
+	}
+	return &Bot{
+		cfg:          cfg,
+		clients:      make(map[string]*client.Client),
+		commands:     make(map[string]Handler),
+		interactions: make(map[string]Handler),
+		frames:       make(chan received, 64),
+		done:         make(chan struct{}),
+	}
+}
+
+// OnMessage registers a handler for every message that is not a command
+// and was not sent by the bot itself.
+func (x *Bot) OnMessage(h Handler) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.onMessage = append(x.onMessage, h)
+}
+
+// OnCommand registers a handler for messages starting with
+// the command prefix followed by name.
+func (x *Bot) OnCommand(name string, h Handler) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.commands[strings.ToLower(name)] = h
+}
+
+// OnInteraction registers a handler for clicks on the component with
+// the given ID. The server only routes clicks on the bot's own messages.
+func (x *Bot) OnInteraction(componentID string, h Handler) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.interactions[componentID] = h
+}
+
+// Join connects the bot to a room.
+// Frames are handled once Run is called.
+func (x *Bot) Join(ctx context.Context, roomID string) error {
+	c, err := client.Dial(ctx, client.Config{
+		Addr:   x.cfg.Addr,
+		Secure: x.cfg.Secure,
+		RoomID: roomID,
+		UserID: x.cfg.UserID,
+		Name:   x.cfg.Name,
+		APIKey: x.cfg.APIKey,
+	})
+	if err != nil {
+		return fmt.Errorf("bot: joining room %s, %w", roomID, err)
+	}
+
+	x.mu.Lock()
+	if old, ok := x.clients[roomID]; ok {
+		_ = old.Close()
+	}
+	x.clients[roomID] = c
+	x.mu.Unlock()
+
+	go func() {
+		for {
+			frame, err := c.Read()
+			select {
+			case x.frames <- received{roomID: roomID, client: c, frame: frame, err: err}:
+			case <-x.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Leave disconnects the bot from a room.
+func (x *Bot) Leave(roomID string) error {
+	x.mu.Lock()
+	c, ok := x.clients[roomID]
+	delete(x.clients, roomID)
+	x.mu.Unlock()
+
+	if !ok {
+		return ErrRoomNotJoined
+	}
+	return c.Close()
+}
+
+// Post posts a message with optional components to a joined room.
+func (x *Bot) Post(roomID, text string, components ...protocol.Component) error {
+	x.mu.RLock()
+	c, ok := x.clients[roomID]
+	x.mu.RUnlock()
+
+	if !ok {
+		return ErrRoomNotJoined
+	}
+	return c.Post(text, components...)
+}
+
+// Run handles frames until ctx is done, then leaves all rooms.
+// Handlers run one at a time on the calling goroutine.
+func (x *Bot) Run(ctx context.Context) error {
+	defer x.close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case r := <-x.frames:
+			if r.err != nil {
+				// The room may have been joined again since,
+				// only forget the client that failed.
+				x.mu.Lock()
+				if x.clients[r.roomID] == r.client {
+					delete(x.clients, r.roomID)
+				}
+				x.mu.Unlock()
+				x.error(r.roomID, r.err)
+				continue
+			}
+			if err := x.dispatch(ctx, r); err != nil {
+				x.error(r.roomID, err)
+			}
+		}
+	}
+}
+
+func (x *Bot) dispatch(ctx context.Context, r received) error {
+	frame := r.frame
+	botCtx := &Context{
+		Context: ctx,
+		Bot:     x,
+		RoomID:  r.roomID,
+		Frame:   frame,
+	}
+
+	var handlers []Handler
+	x.mu.RLock()
+	switch frame.Type {
+	case protocol.FrameInteraction:
+		if frame.Interaction == nil {
+			break
+		}
+		botCtx.Interaction = frame.Interaction
+		if h, ok := x.interactions[frame.Interaction.ComponentID]; ok {
+			handlers = append(handlers, h)
+		}
+
+	case protocol.FrameMessage:
+		// The client knows the ID the server accepted the bot
+		// as, which Config.UserID lacks with an API key.
+		if userID := r.client.UserID(); userID != "" && frame.UserID == userID {
+			break
+		}
+		if strings.HasPrefix(frame.Body, x.cfg.CommandPrefix) {
+			text := strings.TrimPrefix(frame.Body, x.cfg.CommandPrefix)
+			name, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+			if h, ok := x.commands[strings.ToLower(name)]; ok {
+				botCtx.Command = strings.ToLower(name)
+				botCtx.Text = strings.TrimSpace(rest)
+				botCtx.Args = strings.Fields(rest)
+				handlers = append(handlers, h)
+				break
+			}
+		}
+		handlers = append(handlers, x.onMessage...)
+	}
+	x.mu.RUnlock()
+
+	var errs []error
+	for _, h := range handlers {
+		errs = append(errs, h(botCtx))
+	}
+	return errors.Join(errs...)
+}
+
+func (x *Bot) error(roomID string, err error) {
+	if x.cfg.OnError != nil {
+		x.cfg.OnError(roomID, err)
+	}
+}
+
+func (x *Bot) close() {
+	x.once.Do(func() { close(x.done) })
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for roomID, c := range x.clients {
+		_ = c.Close()
+		delete(x.clients, roomID)
+	}
+}
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/Salam4nder/chat/pkg/protocol/protocoltest"
+	"github.com/stretchr/testify/require"
+)
+
+// runBot joins a bot authenticated with an API key to room
+// and runs it until the test ends.
+func runBot(t *testing.T, srv *protocoltest.Server, setup func(b *Bot)) (*Bot, chan error) {
+	t.Helper()
+
+	errs := make(chan error, 8)
+	b := New(Config{
+		Addr:    srv.Addr(),
+		Name:    "bot",
+		APIKey:  "key",
+		OnError: func(_ string, err error) { errs <- err },
+	})
+	setup(b)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = b.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return b, errs
+}
+
+func receive[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+		var zero T
+		return zero
+	}
+}
+
+func Test_Bot_Dispatch(t *testing.T) {
+	srv := protocoltest.NewServer(t)
+	messages := make(chan *Context, 8)
+	commands := make(chan *Context, 8)
+	interactions := make(chan *Context, 8)
+	b, _ := runBot(t, srv, func(b *Bot) {
+		b.OnMessage(func(ctx *Context) error {
+			messages <- ctx
+			return nil
+		})
+		b.OnCommand("Deploy", func(ctx *Context) error {
+			commands <- ctx
+			return ctx.Reply("deploying " + ctx.Text)
+		})
+		b.OnInteraction("deploy:yes", func(ctx *Context) error {
+			interactions <- ctx
+			return nil
+		})
+	})
+	require.NoError(t, b.Join(context.Background(), "room-1"))
+	conn, _ := srv.Accept(t)
+
+	for _, frame := range []protocol.Frame{
+		// The bot's own messages are skipped, although
+		// it authenticated without a Config.UserID.
+		{Type: protocol.FrameMessage, ID: "m-1", UserID: protocoltest.ServiceAccountID, Body: "!deploy own"},
+		{Type: protocol.FrameMessage, ID: "m-2", UserID: "u-1", Body: "!deploy api v2"},
+		{Type: protocol.FrameMessage, ID: "m-3", UserID: "u-1", Body: "hello"},
+		{Type: protocol.FrameInteraction, Interaction: &protocol.Interaction{MessageID: "m-4", ComponentID: "deploy:yes"}},
+	} {
+		require.NoError(t, conn.WriteJSON(frame))
+	}
+
+	cmd := receive(t, commands)
+	require.Equal(t, "room-1", cmd.RoomID)
+	require.Equal(t, "deploy", cmd.Command)
+	require.Equal(t, []string{"api", "v2"}, cmd.Args)
+	require.Equal(t, "api v2", cmd.Text)
+
+	var reply protocol.Frame
+	require.NoError(t, conn.ReadJSON(&reply))
+	require.Equal(t, "deploying api v2", reply.Body)
+
+	require.Equal(t, "hello", receive(t, messages).Frame.Body)
+	require.Equal(t, "m-4", receive(t, interactions).Interaction.MessageID)
+	require.Empty(t, commands)
+	require.Empty(t, messages)
+}
+
+func Test_Bot_Rejoin(t *testing.T) {
+	srv := protocoltest.NewServer(t)
+	b, errs := runBot(t, srv, func(*Bot) {})
+
+	require.NoError(t, b.Join(context.Background(), "room-1"))
+	srv.Accept(t)
+	require.NoError(t, b.Join(context.Background(), "room-1"))
+	conn, _ := srv.Accept(t)
+
+	// Joining again closed the first client, whose read error
+	// must not remove the second one.
+	require.Error(t, receive(t, errs))
+	require.NoError(t, b.Post("room-1", "still here"))
+	var frame protocol.Frame
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "still here", frame.Body)
+
+	require.NoError(t, b.Leave("room-1"))
+	require.ErrorIs(t, b.Post("room-1", "gone"), ErrRoomNotJoined)
+}
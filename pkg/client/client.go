@@ -0,0 +1,285 @@
+// Package client is a reusable chat client that speaks the JSON
+// protocol of the /chat websocket.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// writeWait is the maximum duration to wait for a write.
+const writeWait = 10 * time.Second
+
+// seenLimit bounds how many message IDs Read remembers
+// to drop duplicate deliveries.
+const seenLimit = 1024
+
+var (
+	ErrAddrInvalid   = errors.New("client: addr invalid")
+	ErrRoomIDInvalid = errors.New("client: room ID invalid")
+	ErrUserIDInvalid = errors.New("client: user ID invalid")
+)
+
+// Config defines how to connect to a chat room.
+type Config struct {
+	// Addr is the host:port of the chat server.
+	Addr string
+	// Secure selects wss instead of ws.
+	Secure bool
+	// RoomID is the room to join.
+	RoomID string
+	// UserID identifies the user.
+	UserID string
+	// Name is the display name.
+	Name string
+	// APIKey authenticates service accounts such as bots.
+	// It is sent as a bearer token.
+	APIKey string
+	// Cursor resumes after the message with this Seq, see Client.Cursor.
+	Cursor uint64
+}
+
+// Valid returns nil if the config is valid.
+func (x Config) Valid() error {
+	var addrErr, roomIDErr, userIDErr error
+
+	if x.Addr == "" {
+		addrErr = ErrAddrInvalid
+	}
+	if x.RoomID == "" {
+		roomIDErr = ErrRoomIDInvalid
+	}
+	if x.UserID == "" && x.APIKey == "" {
+		userIDErr = ErrUserIDInvalid
+	}
+
+	return errors.Join(addrErr, roomIDErr, userIDErr)
+}
+
+// URL returns the websocket URL for the config.
+func (x Config) URL() string {
+	scheme := "ws"
+	if x.Secure {
+		scheme = "wss"
+	}
+
+	query := url.Values{}
+	query.Set("roomID", x.RoomID)
+	query.Set("userID", x.UserID)
+	query.Set("name", x.Name)
+	query.Set(protocol.QueryParam, protocol.JSON)
+	if x.Cursor > 0 {
+		query.Set(protocol.CursorParam, strconv.FormatUint(x.Cursor, 10))
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     x.Addr,
+		Path:     "/chat",
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// Client is a connection to a single chat room.
+// Reads must happen from a single goroutine,
+// writes are safe to call concurrently.
+type Client struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	cfg    Config
+	userID string
+
+	// seen, seenOrder and cursor are only used by Read.
+	seen      map[string]struct{}
+	seenOrder []string
+	cursor    uint64
+}
+
+// Dial connects to the room described by cfg.
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	if err := cfg.Valid(); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	if cfg.APIKey != "" {
+		header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, cfg.URL(), header)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("client: dialing, %w", err)
+	}
+
+	userID := resp.Header.Get(protocol.UserIDHeader)
+	if userID == "" {
+		userID = cfg.UserID
+	}
+
+	return &Client{
+		conn:   conn,
+		cfg:    cfg,
+		userID: userID,
+		seen:   make(map[string]struct{}),
+		cursor: cfg.Cursor,
+	}, nil
+}
+
+// UserID returns the user ID the server accepted the client as.
+// Clients authenticated with an API key are the service account.
+func (x *Client) UserID() string {
+	return x.userID
+}
+
+// Config returns the config the client was dialed with.
+func (x *Client) Config() Config {
+	return x.cfg
+}
+
+// Send posts a text message to the room.
+func (x *Client) Send(text string) error {
+	return x.Post(text)
+}
+
+// Post posts a text message with optional components to the room.
+func (x *Client) Post(text string, components ...protocol.Component) error {
+	return x.WriteFrame(protocol.Frame{
+		Type:       protocol.FrameMessage,
+		Body:       text,
+		Components: components,
+	})
+}
+
+// PostIdempotent posts a text message like Post, tagged with a key
+// chosen by the caller. Posting again with the same key, for example
+// after a write failed or the client reconnected, stores the message
+// once as long as the server still remembers the key. A dropped resend
+// is answered with a protocol.FrameDuplicate frame.
+func (x *Client) PostIdempotent(key, text string, components ...protocol.Component) error {
+	return x.WriteFrame(protocol.Frame{
+		Type:           protocol.FrameMessage,
+		Body:           text,
+		Components:     components,
+		IdempotencyKey: key,
+	})
+}
+
+// Interact sends a click on a component of the given message.
+func (x *Client) Interact(messageID, componentID string, values ...string) error {
+	return x.WriteFrame(protocol.Frame{
+		Type: protocol.FrameInteraction,
+		Interaction: &protocol.Interaction{
+			MessageID:   messageID,
+			ComponentID: componentID,
+			Values:      values,
+		},
+	})
+}
+
+// WriteFrame writes a raw frame.
+func (x *Client) WriteFrame(frame protocol.Frame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("client: encoding frame, %w", err)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := x.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("client: writing frame, %w", err)
+	}
+	return nil
+}
+
+// Read blocks until the next frame arrives.
+// Binary messages are returned as message frames.
+// Message frames with an ID that was already read are dropped,
+// as the server delivers messages at least once.
+func (x *Client) Read() (protocol.Frame, error) {
+	for {
+		frame, err := x.read()
+		if err != nil {
+			return protocol.Frame{}, err
+		}
+		if frame.Seq > x.cursor {
+			x.cursor = frame.Seq
+		}
+		if frame.Type != protocol.FrameMessage || frame.ID == "" || x.firstSeen(frame.ID) {
+			return frame, nil
+		}
+	}
+}
+
+// Cursor returns the highest message Seq read so far. Dial with it
+// in Config.Cursor to receive the messages missed while disconnected.
+// It must be called from the reading goroutine.
+func (x *Client) Cursor() uint64 {
+	return x.cursor
+}
+
+// firstSeen records a message ID and reports whether
+// it is the first time the client reads it.
+func (x *Client) firstSeen(id string) bool {
+	if _, ok := x.seen[id]; ok {
+		return false
+	}
+	if len(x.seenOrder) >= seenLimit {
+		delete(x.seen, x.seenOrder[0])
+		x.seenOrder = x.seenOrder[1:]
+	}
+	x.seen[id] = struct{}{}
+	x.seenOrder = append(x.seenOrder, id)
+	return true
+}
+
+func (x *Client) read() (protocol.Frame, error) {
+	mType, data, err := x.conn.ReadMessage()
+	if err != nil {
+		return protocol.Frame{}, fmt.Errorf("client: reading frame, %w", err)
+	}
+
+	if mType != websocket.TextMessage {
+		return protocol.Frame{
+			Type:   protocol.FrameMessage,
+			RoomID: x.cfg.RoomID,
+			Body:   string(data),
+		}, nil
+	}
+
+	var frame protocol.Frame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return protocol.Frame{}, fmt.Errorf("client: decoding frame, %w", err)
+	}
+	return frame, nil
+}
+
+// Close sends a normal closure frame and closes the connection.
+func (x *Client) Close() error {
+	x.mu.Lock()
+	err := x.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(writeWait),
+	)
+	x.mu.Unlock()
+
+	if closeErr := x.conn.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/Salam4nder/chat/pkg/protocol/protocoltest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Config(t *testing.T) {
+	require.ErrorIs(t, Config{RoomID: "r", UserID: "u"}.Valid(), ErrAddrInvalid)
+	require.ErrorIs(t, Config{Addr: "a", UserID: "u"}.Valid(), ErrRoomIDInvalid)
+	require.ErrorIs(t, Config{Addr: "a", RoomID: "r"}.Valid(), ErrUserIDInvalid)
+	require.NoError(t, Config{Addr: "a", RoomID: "r", APIKey: "k"}.Valid())
+
+	u, err := url.Parse(Config{Addr: "chat:8080", Secure: true, RoomID: "r", UserID: "u", Name: "Ann", Cursor: 7}.URL())
+	require.NoError(t, err)
+	require.Equal(t, "wss", u.Scheme)
+	require.Equal(t, "/chat", u.Path)
+	query := u.Query()
+	require.Equal(t, "r", query.Get("roomID"))
+	require.Equal(t, "u", query.Get("userID"))
+	require.Equal(t, "Ann", query.Get("name"))
+	require.Equal(t, protocol.JSON, query.Get(protocol.QueryParam))
+	require.Equal(t, "7", query.Get(protocol.CursorParam))
+}
+
+func Test_Dial(t *testing.T) {
+	srv := protocoltest.NewServer(t)
+
+	t.Run("User ID", func(t *testing.T) {
+		c, err := Dial(context.Background(), Config{Addr: srv.Addr(), RoomID: "r", UserID: "u"})
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		_, r := srv.Accept(t)
+		require.Empty(t, r.Header.Get("Authorization"))
+		require.Equal(t, "u", c.UserID())
+	})
+
+	t.Run("API key", func(t *testing.T) {
+		c, err := Dial(context.Background(), Config{Addr: srv.Addr(), RoomID: "r", APIKey: "key"})
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		_, r := srv.Accept(t)
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		require.Equal(t, protocoltest.ServiceAccountID, c.UserID())
+	})
+}
+
+func Test_Client(t *testing.T) {
+	srv := protocoltest.NewServer(t)
+	c, err := Dial(context.Background(), Config{Addr: srv.Addr(), RoomID: "r", UserID: "u"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	conn, _ := srv.Accept(t)
+
+	t.Run("Write", func(t *testing.T) {
+		require.NoError(t, c.Post("hello", protocol.Button("ok", "OK")))
+		require.NoError(t, c.PostIdempotent("key-1", "again"))
+		require.NoError(t, c.Interact("m-1", "ok"))
+
+		var frames []protocol.Frame
+		for i := 0; i < 3; i++ {
+			var frame protocol.Frame
+			require.NoError(t, conn.ReadJSON(&frame))
+			frames = append(frames, frame)
+		}
+		require.Equal(t, "hello", frames[0].Body)
+		require.Len(t, frames[0].Components, 1)
+		require.Equal(t, "key-1", frames[1].IdempotencyKey)
+		require.Equal(t, protocol.FrameInteraction, frames[2].Type)
+		require.Equal(t, "m-1", frames[2].Interaction.MessageID)
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		for _, frame := range []protocol.Frame{
+			{Type: protocol.FrameMessage, ID: "m-1", Body: "one", Seq: 1},
+			{Type: protocol.FrameMessage, ID: "m-1", Body: "one", Seq: 1},
+			{Type: protocol.FrameNotice, Body: "notice"},
+			{Type: protocol.FrameMessage, ID: "m-2", Body: "two", Seq: 2},
+		} {
+			data, err := json.Marshal(frame)
+			require.NoError(t, err)
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, data))
+		}
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("raw")))
+
+		var bodies []string
+		for i := 0; i < 4; i++ {
+			frame, err := c.Read()
+			require.NoError(t, err)
+			bodies = append(bodies, frame.Body)
+		}
+		require.Equal(t, []string{"one", "notice", "two", "raw"}, bodies)
+		require.Equal(t, uint64(2), c.Cursor())
+	})
+}
@@ -0,0 +1,163 @@
+// Package protocol defines the JSON frames exchanged over the /chat
+// websocket when a client connects with protocol=json.
+//
+// Clients that do not ask for the JSON protocol keep sending and
+// receiving raw message bodies.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// QueryParam is the /chat query parameter selecting the protocol.
+	QueryParam = "protocol"
+	// JSON is the QueryParam value selecting JSON frames.
+	JSON = "json"
+	// CursorParam is the /chat query parameter carrying the Seq of the
+	// last message a client read. Messages stored after it are sent
+	// again on connect, if the server keeps room streams.
+	CursorParam = "cursor"
+	// UserIDHeader is the /chat upgrade response header carrying the
+	// user ID the session was accepted as, which for API keys is the
+	// ID of the service account.
+	UserIDHeader = "X-Chat-User-Id"
+)
+
+// Frame types.
+const (
+	// FrameMessage carries a room message.
+	// Sent by clients to post and by the server to deliver.
+	// Delivery is at least once: the same message, with the same
+	// ID, may arrive more than once and should be deduplicated.
+	FrameMessage = "message"
+	// FrameInteraction carries a click on a message component.
+	// Sent by clients when a user interacts, and by the server
+	// to the owner of the message.
+	FrameInteraction = "interaction"
+	// FrameNotice carries a server notice meant for one session only,
+	// such as a command reply.
+	FrameNotice = "notice"
+	// FrameDuplicate is sent by the server to the sessions of a user
+	// that posted a message with an idempotency key already used.
+	// ID is the ID of the message stored under IdempotencyKey.
+	FrameDuplicate = "duplicate"
+)
+
+// Component types.
+const (
+	ComponentButton = "button"
+	ComponentSelect = "select"
+)
+
+const (
+	// MaxComponents is the maximum number of components on a message.
+	MaxComponents = 5
+	// MaxOptions is the maximum number of options on a select component.
+	MaxOptions = 25
+	// MaxIdempotencyKeyLength is the maximum length of Frame.IdempotencyKey.
+	MaxIdempotencyKeyLength = 128
+)
+
+var (
+	ErrComponentsTooMany       = errors.New("too many components")
+	ErrComponentTypeInvalid    = errors.New("component type invalid")
+	ErrComponentIDInvalid      = errors.New("component ID invalid")
+	ErrComponentIDDuplicate    = errors.New("component ID duplicate")
+	ErrComponentOptionsInvalid = errors.New("component options invalid")
+)
+
+// Frame is a single websocket frame of the JSON protocol.
+type Frame struct {
+	Type        string       `json:"type"`
+	ID          string       `json:"id,omitempty"`
+	RoomID      string       `json:"roomID,omitempty"`
+	UserID      string       `json:"userID,omitempty"`
+	Author      string       `json:"author,omitempty"`
+	Body        string       `json:"body,omitempty"`
+	Timestamp   string       `json:"timestamp,omitempty"`
+	Components  []Component  `json:"components,omitempty"`
+	Interaction *Interaction `json:"interaction,omitempty"`
+	// IdempotencyKey is set by clients on message frames they may
+	// send more than once, such as after reconnecting. The server
+	// stores a message once per key and user within a window.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Seq is the stream sequence of a delivered message,
+	// zero if the server does not keep room streams.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Component is an interactive element attached to a message.
+type Component struct {
+	Type string `json:"type"`
+	// ID is chosen by the message author and echoed back
+	// in interactions.
+	ID          string   `json:"id"`
+	Label       string   `json:"label,omitempty"`
+	Style       string   `json:"style,omitempty"`
+	Placeholder string   `json:"placeholder,omitempty"`
+	Options     []Option `json:"options,omitempty"`
+}
+
+// Option is a single choice of a select component.
+type Option struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// Interaction describes a user clicking a component.
+// UserID and Author are filled in by the server.
+type Interaction struct {
+	MessageID   string   `json:"messageID"`
+	ComponentID string   `json:"componentID"`
+	Values      []string `json:"values,omitempty"`
+	UserID      string   `json:"userID,omitempty"`
+	Author      string   `json:"author,omitempty"`
+}
+
+// Button returns a button component.
+func Button(id, label string) Component {
+	return Component{Type: ComponentButton, ID: id, Label: label}
+}
+
+// Select returns a select menu component.
+func Select(id, placeholder string, options ...Option) Component {
+	return Component{
+		Type:        ComponentSelect,
+		ID:          id,
+		Placeholder: placeholder,
+		Options:     options,
+	}
+}
+
+// ValidComponents returns nil if the given components
+// can be attached to a single message.
+func ValidComponents(components []Component) error {
+	if len(components) > MaxComponents {
+		return ErrComponentsTooMany
+	}
+
+	seen := make(map[string]struct{}, len(components))
+	for _, c := range components {
+		if c.ID == "" || len(c.ID) > 100 {
+			return ErrComponentIDInvalid
+		}
+		if _, ok := seen[c.ID]; ok {
+			return fmt.Errorf("%w: %s", ErrComponentIDDuplicate, c.ID)
+		}
+		seen[c.ID] = struct{}{}
+
+		switch c.Type {
+		case ComponentButton:
+		case ComponentSelect:
+			if len(c.Options) == 0 || len(c.Options) > MaxOptions {
+				return fmt.Errorf("%w: %s", ErrComponentOptionsInvalid, c.ID)
+			}
+		default:
+			return fmt.Errorf("%w: %s", ErrComponentTypeInvalid, c.Type)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidComponents(t *testing.T) {
+	option := Option{Label: "Yes", Value: "y"}
+	options := make([]Option, MaxOptions+1)
+	for i := range options {
+		options[i] = option
+	}
+
+	for _, tc := range []struct {
+		name       string
+		components []Component
+		err        error
+	}{
+		{name: "None"},
+		{
+			name:       "Button and select",
+			components: []Component{Button("ok", "OK"), Select("vote", "Vote", option)},
+		},
+		{
+			name: "Too many",
+			components: []Component{
+				Button("1", "1"), Button("2", "2"), Button("3", "3"),
+				Button("4", "4"), Button("5", "5"), Button("6", "6"),
+			},
+			err: ErrComponentsTooMany,
+		},
+		{name: "Empty ID", components: []Component{Button("", "OK")}, err: ErrComponentIDInvalid},
+		{
+			name:       "Long ID",
+			components: []Component{Button(strings.Repeat("a", 101), "OK")},
+			err:        ErrComponentIDInvalid,
+		},
+		{
+			name:       "Duplicate ID",
+			components: []Component{Button("ok", "OK"), Button("ok", "Sure")},
+			err:        ErrComponentIDDuplicate,
+		},
+		{name: "Select without options", components: []Component{Select("vote", "Vote")}, err: ErrComponentOptionsInvalid},
+		{
+			name:       "Select with too many options",
+			components: []Component{Select("vote", "Vote", options...)},
+			err:        ErrComponentOptionsInvalid,
+		},
+		{
+			name:       "Unknown type",
+			components: []Component{{Type: "slider", ID: "volume"}},
+			err:        ErrComponentTypeInvalid,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidComponents(tc.components)
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package protocoltest provides a fake chat server for testing
+// clients of the /chat websocket.
+package protocoltest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Salam4nder/chat/pkg/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// ServiceAccountID is the service account the server accepts
+// API keys as.
+const ServiceAccountID = "svc-1"
+
+// acceptTimeout bounds the wait for a connection in Accept.
+const acceptTimeout = 5 * time.Second
+
+// Server accepts /chat connections like the chat server and hands out
+// their server side. Connections are accepted as the userID query
+// parameter, or as ServiceAccountID if they carry an API key.
+type Server struct {
+	*httptest.Server
+	conns chan accepted
+}
+
+// accepted is the server side of a connection and its upgrade request.
+type accepted struct {
+	conn    *websocket.Conn
+	request *http.Request
+}
+
+// NewServer starts a Server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	x := &Server{conns: make(chan accepted, 8)}
+	upgrader := websocket.Upgrader{}
+	x.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("userID")
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			userID = ServiceAccountID
+		}
+		conn, err := upgrader.Upgrade(w, r, http.Header{protocol.UserIDHeader: []string{userID}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		x.conns <- accepted{conn: conn, request: r}
+	}))
+	t.Cleanup(x.Close)
+	return x
+}
+
+// Addr returns the host and port clients dial.
+func (x *Server) Addr() string {
+	return strings.TrimPrefix(x.URL, "http://")
+}
+
+// Accept returns the server side of the next connection
+// and its upgrade request.
+func (x *Server) Accept(t testing.TB) (*websocket.Conn, *http.Request) {
+	t.Helper()
+
+	select {
+	case a := <-x.conns:
+		return a.conn, a.request
+	case <-time.After(acceptTimeout):
+		t.Fatal("protocoltest: no connection")
+		return nil, nil
+	}
+}